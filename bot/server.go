@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/jaimeteb/chatto/internal/bot"
@@ -32,7 +33,7 @@ func NewServer(path string, port int) *Server {
 // Answer passes a querty.Question into the bot to produce answers
 func (s *Server) Answer(question *query.Question) (answers []query.Answer, err error) {
 	message := messages.Receive{Question: question}
-	answers, err = s.bot.Answer(&message)
+	answers, err = s.bot.Answer(context.Background(), &message)
 	return
 }
 
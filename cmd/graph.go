@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/jaimeteb/chatto/internal/fsm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	graphFormat string
+	graphOutput string
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Draw your Chatto FSM.",
+	Long: `Build the FSM from fsm.yml and print it as a Graphviz DOT or Mermaid diagram,
+with the states as nodes and the transitions labelled with their command, slot and extension.`,
+	Run: chattoGraph,
+}
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+
+	graphCmd.Flags().StringVarP(&chattoPath, "path", "p", ".", "Path to YAML files")
+	graphCmd.Flags().StringVarP(&graphFormat, "format", "f", fsm.GraphDOT, "Graph format, dot or mermaid")
+	graphCmd.Flags().StringVarP(&graphOutput, "output", "o", "", "File to write the graph to, defaults to stdout")
+}
+
+func chattoGraph(cmd *cobra.Command, args []string) {
+	fsmConfig, err := fsm.LoadConfig(chattoPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fsmDomain, err := fsm.NewDomainFromConfig(fsmConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if graphOutput != "" {
+		f, err := os.Create(graphOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := fsm.NewGraph(fsmDomain, fsmConfig.AllTransitions()).WriteGraph(w, graphFormat); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,66 +2,173 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"text/tabwriter"
 
 	"github.com/jaimeteb/chatto/internal/clf"
+	"github.com/jaimeteb/chatto/internal/story"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var (
+	testStories string
+	testFolds   int
+	testSeed    int64
+	testSet     string
+	testOutput  string
+)
+
 var testCmd = &cobra.Command{
 	Use:   "test",
 	Short: "Test your Chatto classifier.",
-	Long:  `Calculate confusion matrix and classification report for your Chatto classifier.`,
-	Run:   chattoTest,
+	Long: `Calculate confusion matrix and classification report for your Chatto classifier,
+with a k-fold cross-validation (--folds) and/or a separate test set (--test-set).
+With --stories, run the conversations of the story files against the bot instead.`,
+	Run: chattoTest,
 }
 
 func init() {
 	rootCmd.AddCommand(testCmd)
 
 	testCmd.Flags().StringVarP(&chattoPath, "path", "p", ".", "Path to YAML files")
+	testCmd.Flags().StringVar(&testStories, "stories", "", "Story file or directory of story files to run")
+	testCmd.Flags().IntVarP(&testFolds, "folds", "k", 0, "Number of folds of a stratified cross-validation")
+	testCmd.Flags().Int64Var(&testSeed, "seed", 1, "Seed used to shuffle the texts into folds")
+	testCmd.Flags().StringVar(&testSet, "test-set", "", "File with texts to test the classifier with, in the format of clf.yml")
+	testCmd.Flags().StringVarP(&testOutput, "output", "o", clf.OutputText, "Report format: text, json, csv or markdown")
 }
 
 func chattoTest(cmd *cobra.Command, args []string) {
-	classifConfig, err := clf.LoadConfig(chattoPath, nil)
+	if testStories != "" {
+		chattoTestStories()
+		return
+	}
+
+	switch testOutput {
+	case clf.OutputText, clf.OutputJSON, clf.OutputCSV, clf.OutputMarkdown:
+	default:
+		log.Fatalf("unknown output format '%s', use text, json, csv or markdown", testOutput)
+	}
+
+	classifConfig, err := clf.LoadConfig(chattoPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	confusionMatrix, _, _ := clf.GetConfusionMatrix(classifConfig)
-	sumI, sumJ, sumIJ, sumTrue := clf.GetSums(confusionMatrix)
-	s := clf.GetScores(confusionMatrix, sumI, sumJ, sumIJ, sumTrue)
+	reports := []*clf.Report{}
+
+	if testFolds == 0 && testSet == "" {
+		log.Warn("Scores are measured on the training texts, use --folds or --test-set to measure them on unseen texts")
+		e := clf.New(classifConfig).Evaluate(classifConfig.Classification, classifConfig.Classification)
+		reports = append(reports, clf.NewReport("training", classifConfig.Classification, e))
+	}
+
+	if testFolds != 0 {
+		folds, err := clf.CrossValidate(classifConfig, testFolds, testSeed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reports = append(reports, clf.NewCrossValidationReport("cross_validation", classifConfig.Classification, folds))
+	}
+
+	if testSet != "" {
+		testData, err := clf.LoadTestSet(testSet, classifConfig.Classification)
+		if err != nil {
+			log.Fatal(err)
+		}
+		e := clf.Evaluate(classifConfig, classifConfig.Classification, testData)
+		reports = append(reports, clf.NewReport("test_set", classifConfig.Classification, e))
+	}
+
+	if testOutput != clf.OutputText {
+		if err := clf.WriteReports(os.Stdout, testOutput, reports); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	for _, report := range reports {
+		printReport(report)
+	}
+}
+
+func printReport(report *clf.Report) {
+	if len(report.Folds) > 0 {
+		log.Infof("==== %d-fold cross-validation ====", len(report.Folds))
+		w := tabwriter.NewWriter(log.New().Writer(), 11, 1, 1, ' ', 0)
+		fmt.Fprintln(w, "Fold\tAccuracy\tPrecision\tRecall\tF1-Score\tSupport")
+		for _, fold := range report.Folds {
+			s := fold.Scores
+			fmt.Fprintf(w, "%d\t%.4f\t%.4f\t%.4f\t%.4f\t%d\n", fold.Fold, s.Accuracy, s.PrecisionAvg, s.RecallAvg, s.F1scoreAvg, fold.Support)
+		}
+		w.Flush()
+	} else if report.Name == "test_set" {
+		log.Infof("==== Test set %s ====", testSet)
+	}
 
-	numClasses := len(classifConfig.Classification)
-	classNames := []string{}
 	longestNameLen := 0
-	for _, class := range classifConfig.Classification {
-		classNames = append(classNames, class.Command)
-		if nameLen := len(class.Command); nameLen > longestNameLen {
+	for _, command := range report.Commands {
+		if nameLen := len(command); nameLen > longestNameLen {
 			longestNameLen = nameLen
 		}
 	}
 
 	w := tabwriter.NewWriter(log.New().Writer(), longestNameLen+1, 1, 1, ' ', 0)
 	log.Infof("---- Confusion matrix ----")
-	fmt.Fprintln(w, " \t"+strings.Join(classNames, "\t"))
-	for i, class := range classifConfig.Classification {
-		predictionRow := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(confusionMatrix[i])), "\t"), "[]")
-		fmt.Fprintln(w, class.Command+"\t"+predictionRow)
+	fmt.Fprintln(w, " \t"+strings.Join(report.Columns(), "\t"))
+	for i, command := range report.Commands {
+		predictionRow := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(report.ConfusionMatrix[i])), "\t"), "[]")
+		fmt.Fprintln(w, command+"\t"+predictionRow)
 	}
 	w.Flush()
 
+	s := report.Scores
 	w = tabwriter.NewWriter(log.New().Writer(), 11, 1, 1, ' ', 0)
 	log.Infof("---- Classification report ----")
 	fmt.Fprintln(w, " \tPrecision\tRecall\tF1-Score\tSupport")
 
-	for i := 0; i < numClasses; i++ {
-		fmt.Fprintln(w, fmt.Sprintf("%s\t%.4f\t%.4f\t%.4f\t%d", classNames[i], s.Precision[i], s.Recall[i], s.F1score[i], sumI[i]))
+	for i, command := range report.Commands {
+		fmt.Fprintln(w, fmt.Sprintf("%s\t%.4f\t%.4f\t%.4f\t%d", command, s.Precision[i], s.Recall[i], s.F1score[i], report.Support[i]))
 	}
-	fmt.Fprintln(w, fmt.Sprintf("Accuracy\t \t \t%.4f\t%d", s.Accuracy, sumIJ))
-	fmt.Fprintln(w, fmt.Sprintf("Macro Avg\t%.4f\t%.4f\t%.4f\t%d", s.PrecisionAvg, s.RecallAvg, s.F1scoreAvg, sumIJ))
-	fmt.Fprintln(w, fmt.Sprintf("Weighted Avg\t%.4f\t%.4f\t%.4f\t%d", s.PrecisionWeightedAvg, s.RecallWeightedAvg, s.F1scoreWeightedAvg, sumIJ))
+	fmt.Fprintln(w, fmt.Sprintf("Accuracy\t \t \t%.4f\t%d", s.Accuracy, report.Total))
+	fmt.Fprintln(w, fmt.Sprintf("Macro Avg\t%.4f\t%.4f\t%.4f\t%d", s.PrecisionAvg, s.RecallAvg, s.F1scoreAvg, report.Total))
+	fmt.Fprintln(w, fmt.Sprintf("Weighted Avg\t%.4f\t%.4f\t%.4f\t%d", s.PrecisionWeightedAvg, s.RecallWeightedAvg, s.F1scoreWeightedAvg, report.Total))
 
 	w.Flush()
 }
+
+func chattoTestStories() {
+	files, err := story.Load(testStories)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runner, err := story.NewRunner(chattoPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passed, failed := 0, 0
+	for _, file := range files {
+		for _, result := range runner.Run(file) {
+			if result.Passed() {
+				passed++
+				fmt.Printf("PASS %s: %s\n", result.File, result.Story)
+				continue
+			}
+
+			failed++
+			fmt.Printf("FAIL %s: %s\n", result.File, result.Story)
+			for _, failure := range result.Failures {
+				fmt.Println(failure)
+			}
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jaimeteb/chatto/internal/validate"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate your Chatto configuration files.",
+	Long: `Load the bot, channels, FSM and classifier configuration files and report
+their problems with file and line. Exits with a non-zero status if there are errors.`,
+	Run: chattoValidate,
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVarP(&chattoPath, "path", "p", ".", "Path to YAML files")
+}
+
+func chattoValidate(cmd *cobra.Command, args []string) {
+	report := validate.Path(chattoPath)
+
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%d error(s), %d warning(s)\n", report.Errors(), report.Warnings())
+
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
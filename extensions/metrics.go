@@ -0,0 +1,61 @@
+package extensions
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of the extension calls
+const (
+	outcomeOK           = "ok"
+	outcomeUnauthorized = "unauthorized"
+	outcomeBadRequest   = "bad_request"
+	outcomeNotFound     = "not_found"
+	outcomeError        = "error"
+)
+
+// listenerMetrics are the Prometheus metrics of an extension server
+type listenerMetrics struct {
+	registry *prometheus.Registry
+	duration *prometheus.HistogramVec
+	calls    *prometheus.CounterVec
+}
+
+func newListenerMetrics() *listenerMetrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	factory := promauto.With(registry)
+
+	return &listenerMetrics{
+		registry: registry,
+		duration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "chatto",
+			Subsystem: "extension_server",
+			Name:      "call_duration_seconds",
+			Help:      "Duration of the extension calls that were executed, by extension.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"extension"}),
+		calls: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "chatto",
+			Subsystem: "extension_server",
+			Name:      "calls_total",
+			Help:      "Extension calls, by extension and outcome.",
+		}, []string{"extension", "outcome"}),
+	}
+}
+
+// observe records the outcome of an extension call, and its duration since
+// start if it was executed. The extension is empty if it was not executed
+func (m *listenerMetrics) observe(extension, outcome string, start time.Time) {
+	m.calls.WithLabelValues(extension, outcome).Inc()
+	if extension != "" {
+		m.duration.WithLabelValues(extension).Observe(time.Since(start).Seconds())
+	}
+}
@@ -1,21 +1,31 @@
 package extensions
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/logger"
+	"github.com/jaimeteb/chatto/internal/tracing"
 	"github.com/jaimeteb/chatto/query"
 	"github.com/jaimeteb/chatto/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -26,6 +36,10 @@ var (
 
 const chattoExtensionsPort int = 8770
 
+// shutdownTimeout is how long an extension server that is stopping has
+// to finish the requests in flight and to flush the spans
+const shutdownTimeout = 10 * time.Second
+
 // ExecuteExtensionRequest contains the instructions for executing a command function
 type ExecuteExtensionRequest struct {
 	FSM       *fsm.FSM        `json:"fsm"`
@@ -34,6 +48,42 @@ type ExecuteExtensionRequest struct {
 	Question  *query.Question `json:"question"`
 	Channel   string          `json:"channel"`
 	Command   string          `json:"command"`
+
+	// TraceContext carries the trace of the bot over RPC, REST uses the headers
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+
+	// TurnID identifies the turn of the conversation in the logs of the bot,
+	// REST also sends it in the X-Request-ID header
+	TurnID string `json:"turn_id,omitempty"`
+
+	ctx context.Context
+}
+
+// Context of the request, with the span of the extension call. Extensions
+// can start their own spans with it to continue the trace of the bot
+func (r *ExecuteExtensionRequest) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// Logger returns a log entry with the turn, sender and channel of the request,
+// so the logs of the extension can be tied to the logs of the bot
+func (r *ExecuteExtensionRequest) Logger() *log.Entry {
+	return logger.Entry(r.Context())
+}
+
+// withTurn returns a copy of ctx with the turn, sender and channel of the request
+func (r *ExecuteExtensionRequest) withTurn(ctx context.Context) context.Context {
+	fields := log.Fields{logger.FieldChannel: r.Channel}
+	if r.Question != nil {
+		fields[logger.FieldSender] = r.Question.Sender
+	}
+	if r.TurnID != "" {
+		ctx = logger.WithTurnID(ctx, r.TurnID)
+	}
+	return logger.WithFields(ctx, fields)
 }
 
 // ExecuteExtensionResponse contains the result of executing a command function
@@ -91,14 +141,31 @@ type ListenerRPC struct {
 	RegisteredExtensions RegisteredExtensions
 }
 
-// ServeRPC serves the registered extension functions over RPC
+// ServeRPC serves the registered extension functions over RPC,
+// until the server gets SIGINT or SIGTERM
 func ServeRPC(registeredExtensions RegisteredExtensions) error {
 	host := flag.String("host", "0.0.0.0", "Host to run extension server on")
 	port := flag.Int("port", chattoExtensionsPort, "Port to run extension server on")
 	debug := flag.Bool("debug", false, "Enable debug logging.")
+	loggerConfig := loggerFlags()
+	tracingConfig := tracingFlags()
 	flag.Parse()
 
 	logger.SetLogger(*debug)
+	if err := logger.Configure(*loggerConfig); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, *tracingConfig, "chatto-extension")
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer flushTracing(shutdownTracing)
 
 	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", *host, *port))
 	if err != nil {
@@ -119,13 +186,37 @@ func ServeRPC(registeredExtensions RegisteredExtensions) error {
 		return err
 	}
 
+	// Accept returns once the listener is closed
+	go func() {
+		<-ctx.Done()
+		inbound.Close()
+	}()
 	rpc.Accept(inbound)
 
+	log.Info("RPC extension server stopped")
+
 	return nil
 }
 
+// flushTracing flushes the spans of the extension server and stops the exporter
+func flushTracing(shutdownTracing func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error(err)
+	}
+}
+
 // ExecuteExtension runs the requested command function and returns the response
-func (l *ListenerRPC) ExecuteExtension(req *ExecuteExtensionRequest, res *ExecuteExtensionResponse) error {
+func (l *ListenerRPC) ExecuteExtension(req *ExecuteExtensionRequest, res *ExecuteExtensionResponse) (err error) {
+	ctx := tracing.Propagator.Extract(context.Background(), propagation.MapCarrier(req.TraceContext))
+	ctx, span := startSpan(ctx, req.Extension)
+	defer func() {
+		tracing.End(span, err)
+	}()
+	req.ctx = req.withTurn(ctx)
+
 	command, ok := l.RegisteredExtensions[req.Extension]
 	if !ok {
 		return fmt.Errorf(invalidExtension, req.Extension)
@@ -135,8 +226,7 @@ func (l *ListenerRPC) ExecuteExtension(req *ExecuteExtensionRequest, res *Execut
 	res.FSM = commandRes.FSM
 	res.Answers = commandRes.Answers
 
-	log.Debugf("ExecuteExtensionRequest:    %v,    %v", req.FSM, req.Extension)
-	log.Debugf("ExecuteExtensionResponse:    %v,    %v", *res.FSM, res.Answers)
+	logExecution(req, res)
 
 	return nil
 }
@@ -162,14 +252,16 @@ func (l *ListenerRPC) GetBuildVersion(_ *GetBuildVersionRequest, res *version.Bu
 type ListenerREST struct {
 	RegisteredExtensions RegisteredExtensions
 	token                string
+	metrics              *listenerMetrics
 }
 
 // NewListenerREST creates a ListenerREST with command functions and a token
 func NewListenerREST(registeredExtensions RegisteredExtensions, token string) *ListenerREST {
-	return &ListenerREST{RegisteredExtensions: registeredExtensions, token: token}
+	return &ListenerREST{RegisteredExtensions: registeredExtensions, token: token, metrics: newListenerMetrics()}
 }
 
-// ServeREST serves the registered extension functions as a REST API
+// ServeREST serves the registered extension functions as a REST API, until
+// the server gets SIGINT or SIGTERM, then the requests in flight can finish
 func ServeREST(registeredExtensions RegisteredExtensions) error {
 	port := flag.Int("port", chattoExtensionsPort, "Port to run extension server on")
 	debug := flag.Bool("debug", false, "Enable debug logging.")
@@ -179,9 +271,26 @@ func ServeREST(registeredExtensions RegisteredExtensions) error {
 
 	token := flag.String("token", "", "Authorization token to be required by Chatto bot.")
 
+	loggerConfig := loggerFlags()
+	tracingConfig := tracingFlags()
+
 	flag.Parse()
 
 	logger.SetLogger(*debug)
+	if err := logger.Configure(*loggerConfig); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, *tracingConfig, "chatto-extension")
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer flushTracing(shutdownTracing)
 
 	l := NewListenerREST(registeredExtensions, *token)
 
@@ -189,6 +298,7 @@ func ServeREST(registeredExtensions RegisteredExtensions) error {
 	r.HandleFunc("/extension", l.ExecuteExtension).Methods("POST")
 	r.HandleFunc("/extensions", l.GetAllExtensions).Methods("GET")
 	r.HandleFunc("/version", l.GetBuildVersion).Methods("GET")
+	r.HandleFunc("/metrics", l.GetMetrics).Methods("GET")
 
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%d", *port),
@@ -196,24 +306,53 @@ func ServeREST(registeredExtensions RegisteredExtensions) error {
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	if *sslKey != "" && *sslCert != "" {
-		log.Infof("REST extension server started with TLS. Using port %d", *port)
-		log.Fatal(server.ListenAndServeTLS(*sslCert, *sslKey))
-	} else {
-		log.Infof("REST extension server started. Using port %d", *port)
-		log.Fatal(server.ListenAndServe())
+	served := make(chan error, 1)
+	go func() {
+		if *sslKey != "" && *sslCert != "" {
+			log.Infof("REST extension server started with TLS. Using port %d", *port)
+			served <- server.ListenAndServeTLS(*sslCert, *sslKey)
+		} else {
+			log.Infof("REST extension server started. Using port %d", *port)
+			served <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-served:
+		log.Error(err)
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error(err)
+		return err
 	}
 
+	log.Info("REST extension server stopped")
+
 	return nil
 }
 
 // ExecuteExtension runs the requested command function and returns the response
 func (l *ListenerREST) ExecuteExtension(w http.ResponseWriter, r *http.Request) {
+	// Every call is counted, with the extension once it is executed
+	var extension string
+	var start time.Time
+	outcome := outcomeError
+	defer func() {
+		l.metrics.observe(extension, outcome, start)
+	}()
+
 	if l.token != "" {
 		reqToken := r.Header.Get("Authorization")
 		reqToken = strings.TrimPrefix(reqToken, "Bearer ")
 
 		if l.token != reqToken {
+			outcome = outcomeUnauthorized
 			httpError(w, ErrorResponse{
 				Code:    http.StatusUnauthorized,
 				Message: invalidAuthToken,
@@ -223,6 +362,7 @@ func (l *ListenerREST) ExecuteExtension(w http.ResponseWriter, r *http.Request)
 	}
 
 	if r.Method != http.MethodPost {
+		outcome = outcomeBadRequest
 		httpError(w, ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: fmt.Sprintf(invalidHTTPMethod, r.Method, http.MethodPost),
@@ -234,6 +374,7 @@ func (l *ListenerREST) ExecuteExtension(w http.ResponseWriter, r *http.Request)
 
 	var req ExecuteExtensionRequest
 	if err := decoder.Decode(&req); err != nil {
+		outcome = outcomeBadRequest
 		httpError(w, ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: err.Error(),
@@ -241,21 +382,36 @@ func (l *ListenerREST) ExecuteExtension(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if req.TurnID == "" {
+		req.TurnID = r.Header.Get("X-Request-ID")
+	}
+
+	ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := startSpan(ctx, req.Extension)
+	defer span.End()
+	req.ctx = req.withTurn(ctx)
+
 	commandFunc, ok := l.RegisteredExtensions[req.Extension]
 	if !ok {
+		outcome = outcomeNotFound
+		span.SetStatus(codes.Error, fmt.Sprintf(invalidExtension, req.Extension))
 		httpError(w, ErrorResponse{
 			Code:    http.StatusNotFound,
 			Message: fmt.Sprintf(invalidExtension, req.Extension),
 		})
 		return
 	}
+	extension = req.Extension
+
+	start = time.Now()
 	res := commandFunc(&req)
 
-	log.Debugf("ExecuteExtensionRequest:    %v,    %v", req.FSM, req.Extension)
-	log.Debugf("ExecuteExtensionResponse:    %v,    %v", *res.FSM, res.Answers)
+	logExecution(&req, res)
 
 	js, err := json.Marshal(res)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		httpError(w, ErrorResponse{
 			Code:    http.StatusInternalServerError,
 			Message: err.Error(),
@@ -266,12 +422,15 @@ func (l *ListenerREST) ExecuteExtension(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json")
 	_, err = w.Write(js)
 	if err != nil {
+		span.RecordError(err)
 		httpError(w, ErrorResponse{
 			Code:    http.StatusInternalServerError,
 			Message: err.Error(),
 		})
 		return
 	}
+
+	outcome = outcomeOK
 }
 
 // GetAllExtensions returns all command functions in RegisteredExtensions as a list of strings
@@ -348,3 +507,61 @@ func (l *ListenerREST) GetBuildVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// GetMetrics returns the metrics of the extension server in the Prometheus format
+func (l *ListenerREST) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	if l.token != "" {
+		reqToken := r.Header.Get("Authorization")
+		reqToken = strings.TrimPrefix(reqToken, "Bearer ")
+
+		if l.token != reqToken {
+			httpError(w, ErrorResponse{
+				Code:    http.StatusUnauthorized,
+				Message: invalidAuthToken,
+			})
+			return
+		}
+	}
+
+	promhttp.HandlerFor(l.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// logExecution logs the request and the response of an extension call, the
+// slots and the texts of the answers are left out if the texts are redacted
+func logExecution(req *ExecuteExtensionRequest, res *ExecuteExtensionResponse) {
+	entry := req.Logger()
+	if !logger.Redacting() {
+		entry.Debugf("ExecuteExtensionRequest:    %v,    %v", req.FSM, req.Extension)
+		entry.Debugf("ExecuteExtensionResponse:    %v,    %v", *res.FSM, res.Answers)
+		return
+	}
+
+	entry.Debugf("ExecuteExtensionRequest:    state %d,    %v", req.FSM.State, req.Extension)
+	entry.Debugf("ExecuteExtensionResponse:    state %d,    %v", res.FSM.State, logger.RedactAnswers(res.Answers))
+}
+
+// loggerFlags defines the flags of the log format and the redaction of the texts
+func loggerFlags() *logger.Config {
+	cfg := &logger.Config{}
+	flag.StringVar(&cfg.Format, "log-format", logger.FormatText, "Log format: text or json.")
+	flag.BoolVar(&cfg.RedactText, "redact-text", false, "Redact the texts of the messages and the slots in the logs.")
+	return cfg
+}
+
+// tracingFlags defines the flags of the tracing exporter, the
+// extension server follows the sampling decision of the bot
+func tracingFlags() *tracing.Config {
+	cfg := &tracing.Config{SampleRatio: 1}
+	flag.StringVar(&cfg.Exporter, "tracing-exporter", "", "Tracing exporter: otlp, stdout or empty to not export traces.")
+	flag.StringVar(&cfg.Endpoint, "tracing-endpoint", "", "OTLP HTTP collector endpoint, defaults to localhost:4318.")
+	flag.BoolVar(&cfg.Insecure, "tracing-insecure", false, "Disable TLS for the OTLP collector.")
+	return cfg
+}
+
+// startSpan starts the server span of an extension call, as a child of the span of the bot if there is one
+func startSpan(ctx context.Context, extension string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "ExecuteExtension",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("chatto.extension.name", extension)),
+	)
+}
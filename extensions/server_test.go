@@ -3,12 +3,14 @@ package extensions_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/jaimeteb/chatto/version"
@@ -17,12 +19,19 @@ import (
 	"github.com/jaimeteb/chatto/extensions"
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/query"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	commandsPath = "/extensions"
 	commandPath  = "/extension"
 	versionPath  = "/version"
+	metricsPath  = "/metrics"
 	greetFunc    = func(req *extensions.ExecuteExtensionRequest) (res *extensions.ExecuteExtensionResponse) {
 		return &extensions.ExecuteExtensionResponse{
 			FSM: req.FSM,
@@ -278,6 +287,53 @@ func TestExtension_ListenerREST_ExecuteExtension(t *testing.T) {
 	}
 }
 
+func TestExtension_ListenerREST_GetMetrics(t *testing.T) {
+	l := extensions.NewListenerREST(RegisteredExtensions, "my-test-token")
+
+	calls := []struct {
+		token    string
+		body     string
+		wantCode int
+	}{
+		{token: "my-test-token", body: `{"extension": "any", "fsm": {"state": 0, "slots": {}}}`, wantCode: http.StatusOK},
+		{token: "wrong-token", body: `{"extension": "any"}`, wantCode: http.StatusUnauthorized},
+		{token: "my-test-token", body: `{"extension": "unknown"}`, wantCode: http.StatusNotFound},
+		{token: "my-test-token", body: `{`, wantCode: http.StatusBadRequest},
+	}
+	for _, call := range calls {
+		w := httptest.NewRecorder()
+		l.ExecuteExtension(w, setBearerToken(httptest.NewRequest("POST", commandPath, bytes.NewBuffer([]byte(call.body))), call.token))
+		if w.Code != call.wantCode {
+			t.Fatalf("Extensions.ListenerREST.ExecuteExtension() code = %d, want %d", w.Code, call.wantCode)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	l.GetMetrics(w, httptest.NewRequest("GET", metricsPath, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Extensions.ListenerREST.GetMetrics() without auth code = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	l.GetMetrics(w, setBearerToken(httptest.NewRequest("GET", metricsPath, nil), "my-test-token"))
+	body, _ := io.ReadAll(w.Result().Body)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Extensions.ListenerREST.GetMetrics() code = %d, want %d", w.Code, http.StatusOK)
+	}
+	for _, want := range []string{
+		`chatto_extension_server_call_duration_seconds_count{extension="any"} 1`,
+		`chatto_extension_server_calls_total{extension="any",outcome="ok"} 1`,
+		`chatto_extension_server_calls_total{extension="",outcome="unauthorized"} 1`,
+		`chatto_extension_server_calls_total{extension="",outcome="not_found"} 1`,
+		`chatto_extension_server_calls_total{extension="",outcome="bad_request"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Extensions.ListenerREST.GetMetrics() = %s, want %q", body, want)
+		}
+	}
+}
+
 func TestExtensionRPCServer(t *testing.T) {
 	listener := extensions.ListenerRPC{RegisteredExtensions: RegisteredExtensions}
 
@@ -299,3 +355,94 @@ func TestExtensionRPCServer(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestExtensionRPCServer_TraceContext(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	var extensionSpan trace.SpanContext
+	listener := extensions.ListenerRPC{RegisteredExtensions: extensions.RegisteredExtensions{
+		"any": func(req *extensions.ExecuteExtensionRequest) *extensions.ExecuteExtensionResponse {
+			extensionSpan = trace.SpanContextFromContext(req.Context())
+			return greetFunc(req)
+		},
+	}}
+
+	ctx, caller := provider.Tracer("test").Start(context.Background(), "caller")
+	req := extensions.ExecuteExtensionRequest{
+		Extension:    "any",
+		FSM:          fsm.NewFSM(),
+		TraceContext: make(map[string]string),
+	}
+	propagation.TraceContext{}.Inject(ctx, propagation.MapCarrier(req.TraceContext))
+
+	if err := listener.ExecuteExtension(&req, new(extensions.ExecuteExtensionResponse)); err != nil {
+		t.Fatal(err)
+	}
+	caller.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "ExecuteExtension" {
+		t.Fatalf("exported spans = %v, want ExecuteExtension and caller", spans)
+	}
+	if got := spans[0].Parent.SpanID(); got != caller.SpanContext().SpanID() {
+		t.Errorf("ExecuteExtension parent = %v, want %v", got, caller.SpanContext().SpanID())
+	}
+	if extensionSpan.SpanID() != spans[0].SpanContext.SpanID() {
+		t.Errorf("ExecuteExtensionRequest.Context() span = %v, want %v", extensionSpan.SpanID(), spans[0].SpanContext.SpanID())
+	}
+}
+
+func TestExtensionRPCServer_TurnID(t *testing.T) {
+	tests := []struct {
+		name string
+		req  extensions.ExecuteExtensionRequest
+		want log.Fields
+	}{
+		{
+			name: "turn of the bot",
+			req: extensions.ExecuteExtensionRequest{
+				Extension: "any",
+				FSM:       fsm.NewFSM(),
+				Question:  &query.Question{Sender: "ana", Text: "hello"},
+				Channel:   "rest",
+				TurnID:    "turn-1",
+			},
+			want: log.Fields{"turn_id": "turn-1", "sender": "ana", "channel": "rest"},
+		},
+		{
+			name: "no turn",
+			req: extensions.ExecuteExtensionRequest{
+				Extension: "any",
+				FSM:       fsm.NewFSM(),
+				Channel:   "rest",
+			},
+			want: log.Fields{"channel": "rest"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got log.Fields
+			listener := extensions.ListenerRPC{RegisteredExtensions: extensions.RegisteredExtensions{
+				"any": func(req *extensions.ExecuteExtensionRequest) *extensions.ExecuteExtensionResponse {
+					got = req.Logger().Data
+					return greetFunc(req)
+				},
+			}}
+
+			if err := listener.ExecuteExtension(&tt.req, new(extensions.ExecuteExtensionResponse)); err != nil {
+				t.Fatal(err)
+			}
+			// The trace ID depends on the tracer provider of the other tests
+			delete(got, "trace_id")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExecuteExtensionRequest.Logger() fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package fsm
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Condition operators supported in transition guards
+const (
+	OperatorEquals    = "equals"
+	OperatorNotEquals = "not_equals"
+	OperatorMatches   = "matches"
+	OperatorPresent   = "present"
+	OperatorAbsent    = "absent"
+	OperatorGreater   = "gt"
+	OperatorGreaterEq = "gte"
+	OperatorLess      = "lt"
+	OperatorLessEq    = "lte"
+)
+
+// Condition is evaluated against a slot of the FSM to
+// decide if a transition can be executed
+type Condition struct {
+	Slot     string `yaml:"slot"`
+	Operator string `yaml:"operator"`
+	Value    string `yaml:"value"`
+}
+
+// Guard reports whether a transition can be executed with the given slots
+type Guard func(slots map[string]string) bool
+
+// NewGuard compiles a list of conditions into a Guard, which passes
+// only if all of the conditions pass. An empty list always passes
+func NewGuard(conditions []Condition) (Guard, error) {
+	checks := make([]func(map[string]string) bool, 0, len(conditions))
+
+	for _, condition := range conditions {
+		check, err := condition.compile()
+		if err != nil {
+			return func(map[string]string) bool { return false }, err
+		}
+		checks = append(checks, check)
+	}
+
+	return func(slots map[string]string) bool {
+		for _, check := range checks {
+			if !check(slots) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func (c Condition) compile() (func(map[string]string) bool, error) {
+	slotName := strings.TrimSpace(c.Slot)
+	if slotName == "" {
+		return nil, fmt.Errorf("condition has no slot")
+	}
+
+	switch strings.TrimSpace(c.Operator) {
+	case OperatorEquals, "":
+		return func(slots map[string]string) bool {
+			v, ok := slots[slotName]
+			return ok && v == c.Value
+		}, nil
+	case OperatorNotEquals:
+		return func(slots map[string]string) bool {
+			return slots[slotName] != c.Value
+		}, nil
+	case OperatorMatches:
+		r, err := regexp.Compile(c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("condition on slot '%s': %w", slotName, err)
+		}
+		return func(slots map[string]string) bool {
+			v, ok := slots[slotName]
+			return ok && r.MatchString(v)
+		}, nil
+	case OperatorPresent:
+		return func(slots map[string]string) bool {
+			return strings.TrimSpace(slots[slotName]) != ""
+		}, nil
+	case OperatorAbsent:
+		return func(slots map[string]string) bool {
+			return strings.TrimSpace(slots[slotName]) == ""
+		}, nil
+	case OperatorGreater, OperatorGreaterEq, OperatorLess, OperatorLessEq:
+		value, err := strconv.ParseFloat(strings.TrimSpace(c.Value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("condition on slot '%s': value '%s' is not a number", slotName, c.Value)
+		}
+		operator := strings.TrimSpace(c.Operator)
+		return func(slots map[string]string) bool {
+			v, err := strconv.ParseFloat(strings.TrimSpace(slots[slotName]), 64)
+			if err != nil {
+				return false
+			}
+			switch operator {
+			case OperatorGreater:
+				return v > value
+			case OperatorGreaterEq:
+				return v >= value
+			case OperatorLess:
+				return v < value
+			default:
+				return v <= value
+			}
+		}, nil
+	default:
+		return nil, fmt.Errorf("condition on slot '%s': unknown operator '%s'", slotName, c.Operator)
+	}
+}
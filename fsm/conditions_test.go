@@ -0,0 +1,121 @@
+package fsm_test
+
+import (
+	"testing"
+
+	"github.com/jaimeteb/chatto/fsm"
+)
+
+func TestNewGuard(t *testing.T) {
+	slots := map[string]string{
+		"name":  "Jaime",
+		"age":   "30",
+		"empty": " ",
+	}
+
+	tests := []struct {
+		name       string
+		conditions []fsm.Condition
+		want       bool
+		wantErr    bool
+	}{
+		{
+			name: "no conditions always pass",
+			want: true,
+		},
+		{
+			name:       "equals",
+			conditions: []fsm.Condition{{Slot: "name", Operator: fsm.OperatorEquals, Value: "Jaime"}},
+			want:       true,
+		},
+		{
+			name:       "equals is the default operator",
+			conditions: []fsm.Condition{{Slot: "name", Value: "Chatto"}},
+			want:       false,
+		},
+		{
+			name:       "not equals",
+			conditions: []fsm.Condition{{Slot: "name", Operator: fsm.OperatorNotEquals, Value: "Chatto"}},
+			want:       true,
+		},
+		{
+			name:       "matches",
+			conditions: []fsm.Condition{{Slot: "name", Operator: fsm.OperatorMatches, Value: "(?i)^jai"}},
+			want:       true,
+		},
+		{
+			name:       "matches missing slot",
+			conditions: []fsm.Condition{{Slot: "city", Operator: fsm.OperatorMatches, Value: ".*"}},
+			want:       false,
+		},
+		{
+			name:       "present",
+			conditions: []fsm.Condition{{Slot: "name", Operator: fsm.OperatorPresent}},
+			want:       true,
+		},
+		{
+			name:       "blank slot is absent",
+			conditions: []fsm.Condition{{Slot: "empty", Operator: fsm.OperatorAbsent}},
+			want:       true,
+		},
+		{
+			name: "numeric comparisons",
+			conditions: []fsm.Condition{
+				{Slot: "age", Operator: fsm.OperatorGreater, Value: "18"},
+				{Slot: "age", Operator: fsm.OperatorGreaterEq, Value: "30"},
+				{Slot: "age", Operator: fsm.OperatorLess, Value: "65.5"},
+				{Slot: "age", Operator: fsm.OperatorLessEq, Value: "30"},
+			},
+			want: true,
+		},
+		{
+			name:       "numeric comparison on a non numeric slot",
+			conditions: []fsm.Condition{{Slot: "name", Operator: fsm.OperatorGreater, Value: "1"}},
+			want:       false,
+		},
+		{
+			name: "all conditions must pass",
+			conditions: []fsm.Condition{
+				{Slot: "name", Operator: fsm.OperatorPresent},
+				{Slot: "age", Operator: fsm.OperatorLess, Value: "18"},
+			},
+			want: false,
+		},
+		{
+			name:       "invalid regex",
+			conditions: []fsm.Condition{{Slot: "name", Operator: fsm.OperatorMatches, Value: "("}},
+			want:       false,
+			wantErr:    true,
+		},
+		{
+			name:       "invalid number",
+			conditions: []fsm.Condition{{Slot: "age", Operator: fsm.OperatorLess, Value: "old"}},
+			want:       false,
+			wantErr:    true,
+		},
+		{
+			name:       "unknown operator",
+			conditions: []fsm.Condition{{Slot: "age", Operator: "between"}},
+			want:       false,
+			wantErr:    true,
+		},
+		{
+			name:       "missing slot",
+			conditions: []fsm.Condition{{Operator: fsm.OperatorPresent}},
+			want:       false,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard, err := fsm.NewGuard(tt.conditions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewGuard() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := guard(slots); got != tt.want {
+				t.Errorf("Guard() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package fsm
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultDisambiguation is the question asked when the
+// classifier cannot tell two commands apart
+const DefaultDisambiguation = "Did you mean {{ .First }} or {{ .Second }}?"
+
+// Disambiguation is kept in the FSM while the bot waits for the user
+// to choose one of the commands it could not tell apart
+type Disambiguation struct {
+	// Text is the user's input that was ambiguous, it is used to
+	// execute the chosen command and to save slots
+	Text     string   `json:"text"`
+	Commands []string `json:"commands"`
+	Labels   []string `json:"labels"`
+}
+
+// DisambiguationData is the data the disambiguation question is rendered with
+type DisambiguationData struct {
+	First  string
+	Second string
+	Labels []string
+}
+
+// parseDisambiguation parses the disambiguation question template,
+// using DefaultDisambiguation if it is empty
+func parseDisambiguation(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		text = DefaultDisambiguation
+	}
+	return template.New("disambiguation").Parse(text)
+}
+
+// Disambiguate keeps the text and the commands that could not be told apart
+// as pending in the FSM, and returns the question to ask the user. The
+// labels are the names of the commands shown to the user, in the same order
+func (m *FSM) Disambiguate(text string, commands, labels []string, defaults Defaults) (string, error) {
+	tmpl, err := parseDisambiguation(defaults.Disambiguation)
+	if err != nil {
+		return "", err
+	}
+
+	data := DisambiguationData{Labels: labels}
+	if len(labels) > 0 {
+		data.First = labels[0]
+	}
+	if len(labels) > 1 {
+		data.Second = labels[1]
+	}
+
+	var question bytes.Buffer
+	if err := tmpl.Execute(&question, data); err != nil {
+		return "", err
+	}
+
+	m.Pending = &Disambiguation{Text: text, Commands: commands, Labels: labels}
+
+	return question.String(), nil
+}
+
+// ResolveDisambiguation clears the pending disambiguation of the FSM and
+// returns the command the user chose with their reply, and the text that
+// was ambiguous. A command is chosen by its label, its name or its position
+// in the question. If the reply chooses none of them, ok is false and the
+// reply should be answered as a new question
+func (m *FSM) ResolveDisambiguation(reply string) (command, text string, ok bool) {
+	pending := m.Pending
+	m.Pending = nil
+
+	if pending == nil {
+		return "", "", false
+	}
+
+	choice := normalizeChoice(reply)
+	for i, cmd := range pending.Commands {
+		if choice == strconv.Itoa(i+1) || choice == normalizeChoice(cmd) {
+			return cmd, pending.Text, true
+		}
+		if i < len(pending.Labels) && choice == normalizeChoice(pending.Labels[i]) {
+			return cmd, pending.Text, true
+		}
+	}
+
+	return "", "", false
+}
+
+// normalizeChoice lowers the text, trims punctuation
+// around it and collapses its whitespace
+func normalizeChoice(text string) string {
+	text = strings.ToLower(strings.Trim(strings.TrimSpace(text), ".,;:!?¿¡\"'"))
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,113 @@
+package fsm_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jaimeteb/chatto/fsm"
+)
+
+func TestFSM_Disambiguate(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults fsm.Defaults
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "default question",
+			want: "Did you mean Turn on or Turn off?",
+		},
+		{
+			name:     "custom question",
+			defaults: fsm.Defaults{Disambiguation: "{{ .First }}? {{ .Second }}? ({{ len .Labels }} options)"},
+			want:     "Turn on? Turn off? (2 options)",
+		},
+		{
+			name:     "invalid question",
+			defaults: fsm.Defaults{Disambiguation: "{{ .First"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := fsm.NewFSM()
+			got, err := m.Disambiguate("on off", []string{"turn_on", "turn_off"}, []string{"Turn on", "Turn off"}, tt.defaults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FSM.Disambiguate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FSM.Disambiguate() = %v, want %v", got, tt.want)
+			}
+
+			var wantPending *fsm.Disambiguation
+			if !tt.wantErr {
+				wantPending = &fsm.Disambiguation{Text: "on off", Commands: []string{"turn_on", "turn_off"}, Labels: []string{"Turn on", "Turn off"}}
+			}
+			if !reflect.DeepEqual(m.Pending, wantPending) {
+				t.Errorf("FSM.Disambiguate() pending = %v, want %v", m.Pending, wantPending)
+			}
+		})
+	}
+}
+
+func TestFSM_ResolveDisambiguation(t *testing.T) {
+	pending := &fsm.Disambiguation{Text: "on off", Commands: []string{"turn_on", "turn_off"}, Labels: []string{"Turn on", "Turn off"}}
+
+	tests := []struct {
+		name        string
+		pending     *fsm.Disambiguation
+		reply       string
+		wantCommand string
+		wantText    string
+		wantOk      bool
+	}{
+		{
+			name:        "label",
+			pending:     pending,
+			reply:       "  turn OFF! ",
+			wantCommand: "turn_off",
+			wantText:    "on off",
+			wantOk:      true,
+		},
+		{
+			name:        "command",
+			pending:     pending,
+			reply:       "turn_on",
+			wantCommand: "turn_on",
+			wantText:    "on off",
+			wantOk:      true,
+		},
+		{
+			name:        "position",
+			pending:     pending,
+			reply:       "2",
+			wantCommand: "turn_off",
+			wantText:    "on off",
+			wantOk:      true,
+		},
+		{
+			name:    "no choice",
+			pending: pending,
+			reply:   "hello",
+		},
+		{
+			name:  "nothing pending",
+			reply: "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := fsm.NewFSM()
+			m.Pending = tt.pending
+
+			gotCommand, gotText, gotOk := m.ResolveDisambiguation(tt.reply)
+			if gotCommand != tt.wantCommand || gotText != tt.wantText || gotOk != tt.wantOk {
+				t.Errorf("FSM.ResolveDisambiguation() = %v, %v, %v, want %v, %v, %v", gotCommand, gotText, gotOk, tt.wantCommand, tt.wantText, tt.wantOk)
+			}
+			if m.Pending != nil {
+				t.Errorf("FSM.ResolveDisambiguation() pending = %v, want nil", m.Pending)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package fsm
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Slot modes, which extract and normalize a value from the user's input
+const (
+	SlotModeWholeText = "whole_text"
+	SlotModeRegex     = "regex"
+	SlotModeNumber    = "number"
+	SlotModeDate      = "date"
+	SlotModeEmail     = "email"
+	SlotModePhone     = "phone"
+	SlotModeURL       = "url"
+	SlotModeEnum      = "enum"
+)
+
+// DateLayout is the format dates are saved to slots with
+const DateLayout = "2006-01-02"
+
+// Entity is a list of values the user can refer to by synonyms,
+// it is extracted by slots with the enum mode
+type Entity struct {
+	Name   string        `yaml:"name"`
+	Values []EntityValue `yaml:"values"`
+
+	// synonyms are compiled by Domain.SetEntities
+	synonyms []enumSynonym
+}
+
+// enumSynonym is a value or a synonym of an entity, with
+// the pattern that matches it as a whole word
+type enumSynonym struct {
+	pattern *regexp.Regexp
+	value   string
+}
+
+// EntityValue is saved to a slot when the user's input
+// contains the value itself or one of its synonyms
+type EntityValue struct {
+	Value    string   `yaml:"value"`
+	Synonyms []string `yaml:"synonyms"`
+}
+
+var (
+	numberRe = regexp.MustCompile(`[-+]?(?:\d{1,3}(?:,\d{3})+|\d+)(?:\.\d+)?`)
+	emailRe  = regexp.MustCompile(`(?i)[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}`)
+	phoneRe  = regexp.MustCompile(`\+?\(?\d[\d\s().\-]{5,}\d`)
+	urlRe    = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+	wordRe   = regexp.MustCompile(`[a-z]+`)
+
+	isoDateRe     = regexp.MustCompile(`\b(\d{4})-(\d{1,2})-(\d{1,2})\b`)
+	monthDayRe    = regexp.MustCompile(`\b([a-z]+)\.? (\d{1,2})(?:st|nd|rd|th)?(?:,? (\d{4}))?\b`)
+	dayMonthRe    = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)? (?:of )?([a-z]+)\.?(?:,? (\d{4}))?\b`)
+	inDurationRe  = regexp.MustCompile(`\bin (\d+|[a-z]+) (day|week|month|year)s?\b`)
+	agoDurationRe = regexp.MustCompile(`\b(\d+|[a-z]+) (day|week|month|year)s? ago\b`)
+	weekdayRe     = regexp.MustCompile(`\b(?:(next|this|on) )?(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`)
+)
+
+var numberWords = map[string]float64{
+	"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+	"thirteen": 13, "fourteen": 14, "fifteen": 15, "sixteen": 16, "seventeen": 17,
+	"eighteen": 18, "nineteen": 19, "twenty": 20, "thirty": 30, "forty": 40,
+	"fifty": 50, "sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+	"hundred": 100,
+}
+
+var months = map[string]time.Month{
+	"january": time.January, "february": time.February, "march": time.March,
+	"april": time.April, "may": time.May, "june": time.June, "july": time.July,
+	"august": time.August, "september": time.September, "october": time.October,
+	"november": time.November, "december": time.December,
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"jun": time.June, "jul": time.July, "aug": time.August, "sep": time.September,
+	"sept": time.September, "oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+	"wednesday": time.Wednesday, "thursday": time.Thursday, "friday": time.Friday,
+	"saturday": time.Saturday,
+}
+
+// Extract returns the value of the slot found in the user's input, normalized
+// according to its mode. Enums are looked up in the entities of the Domain and
+// relative dates are resolved with its clock, the Domain can be nil for the
+// other modes. It reports false if the input has no value for the slot
+func (s Slot) Extract(text string, fsmDomain *Domain) (string, bool) {
+	switch strings.TrimSpace(s.Mode) {
+	case SlotModeRegex:
+		r, err := regexp.Compile(strings.TrimSpace(s.Regex))
+		if err != nil {
+			return "", false
+		}
+		match := r.FindString(text)
+		return match, match != ""
+	case SlotModeNumber:
+		return extractNumber(text)
+	case SlotModeDate:
+		now := time.Now()
+		if fsmDomain != nil && fsmDomain.Now != nil {
+			now = fsmDomain.Now()
+		}
+		return extractDate(text, now)
+	case SlotModeEmail:
+		match := emailRe.FindString(text)
+		return strings.ToLower(match), match != ""
+	case SlotModePhone:
+		return extractPhone(text)
+	case SlotModeURL:
+		return extractURL(text)
+	case SlotModeEnum:
+		if fsmDomain == nil {
+			return "", false
+		}
+		entity, ok := fsmDomain.Entities[strings.TrimSpace(s.Entity)]
+		if !ok {
+			return "", false
+		}
+		return extractEnum(text, entity)
+	default:
+		// Use whole_text by default
+		return text, true
+	}
+}
+
+func extractNumber(text string) (string, bool) {
+	if match := numberRe.FindString(text); match != "" {
+		n, err := strconv.ParseFloat(strings.ReplaceAll(match, ",", ""), 64)
+		if err == nil {
+			return strconv.FormatFloat(n, 'f', -1, 64), true
+		}
+	}
+
+	for _, word := range wordRe.FindAllString(strings.ToLower(text), -1) {
+		if n, ok := numberWords[word]; ok {
+			return strconv.FormatFloat(n, 'f', -1, 64), true
+		}
+	}
+
+	return "", false
+}
+
+// extractPhone returns the digits of the first phone number, keeping
+// a leading plus sign. Phone numbers have between 7 and 15 digits
+func extractPhone(text string) (string, bool) {
+	for _, match := range phoneRe.FindAllString(text, -1) {
+		var digits strings.Builder
+		if strings.HasPrefix(match, "+") {
+			digits.WriteString("+")
+		}
+		n := 0
+		for _, r := range match {
+			if r >= '0' && r <= '9' {
+				digits.WriteRune(r)
+				n++
+			}
+		}
+		if n >= 7 && n <= 15 {
+			return digits.String(), true
+		}
+	}
+
+	return "", false
+}
+
+// extractURL returns the first URL without trailing punctuation,
+// URLs starting with www are given the https scheme
+func extractURL(text string) (string, bool) {
+	match := strings.TrimRight(urlRe.FindString(text), ".,;:!?)")
+	if match == "" {
+		return "", false
+	}
+	if strings.HasPrefix(strings.ToLower(match), "www.") {
+		match = "https://" + match
+	}
+	return match, true
+}
+
+// compileSynonyms returns the values and synonyms of the entity
+// with their patterns, longer synonyms first
+func (e *Entity) compileSynonyms() []enumSynonym {
+	type synonym struct {
+		text  string
+		value string
+	}
+
+	synonyms := make([]synonym, 0, len(e.Values))
+	for _, v := range e.Values {
+		synonyms = append(synonyms, synonym{v.Value, v.Value})
+		for _, s := range v.Synonyms {
+			synonyms = append(synonyms, synonym{s, v.Value})
+		}
+	}
+	sort.SliceStable(synonyms, func(i, j int) bool {
+		return len(synonyms[i].text) > len(synonyms[j].text)
+	})
+
+	compiled := make([]enumSynonym, 0, len(synonyms))
+	for _, s := range synonyms {
+		word := strings.ToLower(strings.TrimSpace(s.text))
+		if word == "" {
+			continue
+		}
+		compiled = append(compiled, enumSynonym{
+			pattern: regexp.MustCompile(`(^|\W)` + regexp.QuoteMeta(word) + `($|\W)`),
+			value:   s.value,
+		})
+	}
+	return compiled
+}
+
+// extractEnum returns the value of the entity whose value or synonyms
+// appear in the text as whole words, longer synonyms are matched first
+func extractEnum(text string, entity Entity) (string, bool) {
+	// Entities that were not set with Domain.SetEntities are compiled now
+	synonyms := entity.synonyms
+	if synonyms == nil {
+		synonyms = entity.compileSynonyms()
+	}
+
+	text = strings.ToLower(text)
+	for _, s := range synonyms {
+		if s.pattern.MatchString(text) {
+			return s.value, true
+		}
+	}
+
+	return "", false
+}
+
+// extractDate returns the first date in the text formatted with DateLayout.
+// Relative dates such as "tomorrow", "in 3 days" or "next friday" are
+// resolved from now
+func extractDate(text string, now time.Time) (string, bool) {
+	text = strings.ToLower(text)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	if date, ok := absoluteDate(text, today); ok {
+		return date.Format(DateLayout), true
+	}
+	if date, ok := relativeDate(text, today); ok {
+		return date.Format(DateLayout), true
+	}
+
+	return "", false
+}
+
+func absoluteDate(text string, today time.Time) (time.Time, bool) {
+	if m := isoDateRe.FindStringSubmatch(text); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		day, _ := strconv.Atoi(m[3])
+		return validDate(year, time.Month(month), day, today.Location())
+	}
+
+	for _, m := range monthDayRe.FindAllStringSubmatch(text, -1) {
+		if month, ok := months[m[1]]; ok {
+			day, _ := strconv.Atoi(m[2])
+			return validDate(yearOf(m[3], today), month, day, today.Location())
+		}
+	}
+
+	for _, m := range dayMonthRe.FindAllStringSubmatch(text, -1) {
+		if month, ok := months[m[2]]; ok {
+			day, _ := strconv.Atoi(m[1])
+			return validDate(yearOf(m[3], today), month, day, today.Location())
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func relativeDate(text string, today time.Time) (time.Time, bool) {
+	switch {
+	case strings.Contains(text, "day after tomorrow"):
+		return today.AddDate(0, 0, 2), true
+	case strings.Contains(text, "day before yesterday"):
+		return today.AddDate(0, 0, -2), true
+	case strings.Contains(text, "tomorrow"):
+		return today.AddDate(0, 0, 1), true
+	case strings.Contains(text, "yesterday"):
+		return today.AddDate(0, 0, -1), true
+	case strings.Contains(text, "today"), strings.Contains(text, "tonight"):
+		return today, true
+	case strings.Contains(text, "next week"):
+		return today.AddDate(0, 0, 7), true
+	case strings.Contains(text, "next month"):
+		return today.AddDate(0, 1, 0), true
+	case strings.Contains(text, "next year"):
+		return today.AddDate(1, 0, 0), true
+	}
+
+	if m := inDurationRe.FindStringSubmatch(text); m != nil {
+		if n, ok := count(m[1]); ok {
+			return addDuration(today, n, m[2]), true
+		}
+	}
+
+	if m := agoDurationRe.FindStringSubmatch(text); m != nil {
+		if n, ok := count(m[1]); ok {
+			return addDuration(today, -n, m[2]), true
+		}
+	}
+
+	// A weekday is its next occurrence, today included unless it is
+	// the "next" weekday
+	if m := weekdayRe.FindStringSubmatch(text); m != nil {
+		days := (int(weekdays[m[2]]) - int(today.Weekday()) + 7) % 7
+		if days == 0 && m[1] == "next" {
+			days = 7
+		}
+		return today.AddDate(0, 0, days), true
+	}
+
+	return time.Time{}, false
+}
+
+// validDate returns the date if the day exists in the month
+func validDate(year int, month time.Month, day int, loc *time.Location) (time.Time, bool) {
+	date := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	if date.Month() != month || date.Day() != day {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// yearOf returns the year in the text, or the current year if there is none
+func yearOf(year string, today time.Time) int {
+	if y, err := strconv.Atoi(year); err == nil {
+		return y
+	}
+	return today.Year()
+}
+
+func count(text string) (int, bool) {
+	if n, err := strconv.Atoi(text); err == nil {
+		return n, true
+	}
+	if text == "a" || text == "an" {
+		return 1, true
+	}
+	n, ok := numberWords[text]
+	return int(n), ok
+}
+
+func addDuration(date time.Time, n int, unit string) time.Time {
+	switch unit {
+	case "week":
+		return date.AddDate(0, 0, 7*n)
+	case "month":
+		return date.AddDate(0, n, 0)
+	case "year":
+		return date.AddDate(n, 0, 0)
+	default:
+		return date.AddDate(0, 0, n)
+	}
+}
@@ -0,0 +1,134 @@
+package fsm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaimeteb/chatto/fsm"
+)
+
+func TestSlot_Extract(t *testing.T) {
+	fsmDomain := newDomain(nil)
+	// Sunday
+	fsmDomain.Now = func() time.Time { return time.Date(2021, time.March, 14, 18, 30, 0, 0, time.UTC) }
+	fsmDomain.SetEntities([]fsm.Entity{{
+		Name: "size",
+		Values: []fsm.EntityValue{
+			{Value: "small", Synonyms: []string{"s", "tiny"}},
+			{Value: "extra large", Synonyms: []string{"xl", "very large"}},
+			{Value: "large", Synonyms: []string{"l", "big"}},
+		},
+	}})
+
+	tests := []struct {
+		name   string
+		slot   fsm.Slot
+		text   string
+		want   string
+		wantOk bool
+	}{
+		{name: "whole text", slot: fsm.Slot{}, text: "anything", want: "anything", wantOk: true},
+		{name: "regex", slot: fsm.Slot{Mode: "regex", Regex: `\d+`}, text: "room 42", want: "42", wantOk: true},
+		{name: "regex no match", slot: fsm.Slot{Mode: "regex", Regex: `\d+`}, text: "room", want: "", wantOk: false},
+		{name: "number", slot: fsm.Slot{Mode: "number"}, text: "about 1,250.50 dollars", want: "1250.5", wantOk: true},
+		{name: "negative number", slot: fsm.Slot{Mode: "number"}, text: "-3", want: "-3", wantOk: true},
+		{name: "number word", slot: fsm.Slot{Mode: "number"}, text: "Three please", want: "3", wantOk: true},
+		{name: "no number", slot: fsm.Slot{Mode: "number"}, text: "a few", want: "", wantOk: false},
+		{name: "iso date", slot: fsm.Slot{Mode: "date"}, text: "on 2021-4-1", want: "2021-04-01", wantOk: true},
+		{name: "month day", slot: fsm.Slot{Mode: "date"}, text: "April 2nd, 2022", want: "2022-04-02", wantOk: true},
+		{name: "day month", slot: fsm.Slot{Mode: "date"}, text: "the 5th of may", want: "2021-05-05", wantOk: true},
+		{name: "invalid day", slot: fsm.Slot{Mode: "date"}, text: "feb 30", want: "", wantOk: false},
+		{name: "today", slot: fsm.Slot{Mode: "date"}, text: "Today", want: "2021-03-14", wantOk: true},
+		{name: "tomorrow", slot: fsm.Slot{Mode: "date"}, text: "tomorrow night", want: "2021-03-15", wantOk: true},
+		{name: "day after tomorrow", slot: fsm.Slot{Mode: "date"}, text: "the day after tomorrow", want: "2021-03-16", wantOk: true},
+		{name: "in days", slot: fsm.Slot{Mode: "date"}, text: "in 3 days", want: "2021-03-17", wantOk: true},
+		{name: "in a week", slot: fsm.Slot{Mode: "date"}, text: "in a week", want: "2021-03-21", wantOk: true},
+		{name: "ago", slot: fsm.Slot{Mode: "date"}, text: "two months ago", want: "2021-01-14", wantOk: true},
+		{name: "weekday", slot: fsm.Slot{Mode: "date"}, text: "on friday", want: "2021-03-19", wantOk: true},
+		{name: "same weekday", slot: fsm.Slot{Mode: "date"}, text: "sunday", want: "2021-03-14", wantOk: true},
+		{name: "next weekday", slot: fsm.Slot{Mode: "date"}, text: "next sunday", want: "2021-03-21", wantOk: true},
+		{name: "no date", slot: fsm.Slot{Mode: "date"}, text: "someday", want: "", wantOk: false},
+		{name: "email", slot: fsm.Slot{Mode: "email"}, text: "it's Jane.Doe@Example.com.", want: "jane.doe@example.com", wantOk: true},
+		{name: "no email", slot: fsm.Slot{Mode: "email"}, text: "jane at example", want: "", wantOk: false},
+		{name: "phone", slot: fsm.Slot{Mode: "phone"}, text: "call +1 (555) 010-9999", want: "+15550109999", wantOk: true},
+		{name: "short phone", slot: fsm.Slot{Mode: "phone"}, text: "call 555-01", want: "", wantOk: false},
+		{name: "url", slot: fsm.Slot{Mode: "url"}, text: "see https://example.com/a?b=c.", want: "https://example.com/a?b=c", wantOk: true},
+		{name: "www url", slot: fsm.Slot{Mode: "url"}, text: "go to www.example.com", want: "https://www.example.com", wantOk: true},
+		{name: "no url", slot: fsm.Slot{Mode: "url"}, text: "example dot com", want: "", wantOk: false},
+		{name: "enum value", slot: fsm.Slot{Mode: "enum", Entity: "size"}, text: "a Large one", want: "large", wantOk: true},
+		{name: "enum synonym", slot: fsm.Slot{Mode: "enum", Entity: "size"}, text: "something tiny", want: "small", wantOk: true},
+		{name: "enum longest synonym", slot: fsm.Slot{Mode: "enum", Entity: "size"}, text: "very large please", want: "extra large", wantOk: true},
+		{name: "enum whole words", slot: fsm.Slot{Mode: "enum", Entity: "size"}, text: "lots", want: "", wantOk: false},
+		{name: "unknown entity", slot: fsm.Slot{Mode: "enum", Entity: "color"}, text: "red", want: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotOk := tt.slot.Extract(tt.text, fsmDomain)
+			if got != tt.want || gotOk != tt.wantOk {
+				t.Errorf("Slot.Extract() = %v, %v, want %v, %v", got, gotOk, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestFSM_ExecuteCmdReprompt(t *testing.T) {
+	fsmDomain := newDomain([]fsm.Transition{
+		{
+			From:    []string{"initial"},
+			Into:    "ask_email",
+			Command: "subscribe",
+			Answers: []fsm.Answer{{Text: "What is your email?"}},
+		},
+		{
+			From:    []string{"ask_email"},
+			Into:    "initial",
+			Command: "any",
+			Slot: fsm.Slot{
+				Name:     "email",
+				Mode:     "email",
+				Reprompt: []fsm.Answer{{Text: "{{ .Sender }}, that is not an email."}},
+			},
+			Answers: []fsm.Answer{{Text: "Subscribed {{ .Slots.email }}."}},
+		},
+	})
+
+	m := fsm.NewFSM()
+	turns := []struct {
+		text      string
+		want      string
+		wantState int
+	}{
+		{text: "subscribe", want: "What is your email?", wantState: fsmDomain.StateTable["ask_email"]},
+		{text: "my email", want: "42, that is not an email.", wantState: fsmDomain.StateTable["ask_email"]},
+		{text: "it is Me@Example.com", want: "Subscribed me@example.com.", wantState: fsm.StateInitial},
+	}
+	for _, turn := range turns {
+		command := "any"
+		if turn.text == "subscribe" {
+			command = "subscribe"
+		}
+		answers, _, err := m.ExecuteCmd(command, turn.text, fsmDomain, fsm.Conversation{Sender: "42"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(answers) != 1 || answers[0].Text != turn.want {
+			t.Errorf("FSM.ExecuteCmd(%q) answers = %v, want %v", turn.text, answers, turn.want)
+		}
+		if m.State != turn.wantState {
+			t.Errorf("FSM.ExecuteCmd(%q) state = %v, want %v", turn.text, m.State, turn.wantState)
+		}
+	}
+}
+
+func TestSlot_ExtractEntityNotSet(t *testing.T) {
+	// Entities added to the Domain without SetEntities are compiled when used
+	fsmDomain := newDomain(nil)
+	fsmDomain.Entities = map[string]fsm.Entity{"size": {
+		Name:   "size",
+		Values: []fsm.EntityValue{{Value: "large", Synonyms: []string{"big"}}},
+	}}
+
+	if got, ok := (fsm.Slot{Mode: "enum", Entity: "size"}).Extract("a big one", fsmDomain); !ok || got != "large" {
+		t.Errorf("Slot.Extract() = %v %v, want %v %v", got, ok, "large", true)
+	}
+}
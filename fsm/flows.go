@@ -0,0 +1,159 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// StateReturn is the state transitions go into to finish
+// the flow they are in and return to the state it was called from
+const StateReturn = -2
+
+// errReturnState is the error of a domain with flows whose
+// transitions or forms have a state named like the return state
+var errReturnState = errors.New("the state 'return' is reserved for the flows of the domain, rename it")
+
+// hasState reports whether the from states or the into state are state
+func hasState(from []string, into, state string) bool {
+	if strings.TrimSpace(into) == state {
+		return true
+	}
+	for _, fr := range from {
+		if strings.TrimSpace(fr) == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Flow is a reusable group of transitions with its own states. A transition
+// calls a flow with Call, the FSM goes into the initial state of the flow,
+// and once a transition of the flow goes into the return state, the FSM goes
+// into the Into state of the transition that called it
+type Flow struct {
+	Name        string       `yaml:"name"`
+	Transitions []Transition `yaml:"transitions"`
+}
+
+// FlowState returns the name of a state of a flow in the StateTable,
+// the states of a flow are namespaced with the name of the flow
+func FlowState(flow, state string) string {
+	return strings.TrimSpace(flow) + "." + strings.TrimSpace(state)
+}
+
+// FlowTransitions returns the transitions of the flows with their states
+// namespaced, see FlowState. The any state in the from states of a flow's
+// transition is every state of the flow, and the return state is not namespaced
+func FlowTransitions(flows []Flow) []Transition {
+	transitions := make([]Transition, 0)
+	for n := range flows {
+		transitions = append(transitions, flows[n].namespaced()...)
+	}
+	return transitions
+}
+
+// namespaced returns the transitions of the Flow with its states namespaced
+func (f *Flow) namespaced() []Transition {
+	states := []string{FlowState(f.Name, "initial")}
+	seen := map[string]bool{states[0]: true}
+	addState := func(state string) {
+		state = strings.TrimSpace(state)
+		if state == "any" || state == "return" {
+			return
+		}
+		if name := FlowState(f.Name, state); !seen[name] {
+			seen[name] = true
+			states = append(states, name)
+		}
+	}
+	for _, transition := range f.Transitions {
+		for _, from := range transition.From {
+			addState(from)
+		}
+		addState(transition.Into)
+	}
+
+	transitions := make([]Transition, len(f.Transitions))
+	for n, transition := range f.Transitions {
+		from := make([]string, 0, len(transition.From))
+		for _, state := range transition.From {
+			if strings.TrimSpace(state) == "any" {
+				from = append(from, states...)
+				continue
+			}
+			from = append(from, FlowState(f.Name, state))
+		}
+		transition.From = from
+
+		if into := strings.TrimSpace(transition.Into); into != "return" {
+			transition.Into = FlowState(f.Name, into)
+		}
+
+		transitions[n] = transition
+	}
+
+	return transitions
+}
+
+// validate checks the name of the Flow and that it
+// has transitions from its initial state
+func (f *Flow) validate() error {
+	name := strings.TrimSpace(f.Name)
+	if name == "" {
+		return fmt.Errorf("flow has no name")
+	}
+	if strings.Contains(name, ".") {
+		return fmt.Errorf("flow name cannot contain '.'")
+	}
+	for _, transition := range f.Transitions {
+		for _, from := range transition.From {
+			if state := strings.TrimSpace(from); state == "initial" || state == "any" {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("flow has no transitions from its initial state")
+}
+
+// NewCallTransitionFunc wraps a TransitionFunc so that it calls a flow: the
+// state the TransitionFunc goes into is kept in the call stack to return to
+// it, and the FSM goes into the initial state of the flow
+func NewCallTransitionFunc(transitionFunc TransitionFunc, flowState int) TransitionFunc {
+	return func(m *FSM) (*Extension, []Answer) {
+		extension, answers := transitionFunc(m)
+		m.Calls = append(m.Calls, m.State)
+		m.State = flowState
+		return extension, answers
+	}
+}
+
+// returnFromFlow goes into the state at the top of the call stack if the FSM
+// is in the return state, or into the initial state if the call stack is empty
+func (m *FSM) returnFromFlow() {
+	for m.State == StateReturn {
+		if len(m.Calls) == 0 {
+			m.State = StateInitial
+			return
+		}
+		m.State = m.Calls[len(m.Calls)-1]
+		m.Calls = m.Calls[:len(m.Calls)-1]
+	}
+}
+
+// ErrInvalidFlow is returned when a flow cannot be added to the Domain
+type ErrInvalidFlow struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+// Error returns the ErrInvalidFlow error message
+func (e *ErrInvalidFlow) Error() string {
+	return fmt.Sprintf("flow %d '%s': %s", e.Index, e.Name, e.Err)
+}
+
+// Unwrap returns the cause of the ErrInvalidFlow
+func (e *ErrInvalidFlow) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,157 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jaimeteb/chatto/fsm"
+)
+
+func newFlowDomain(t *testing.T) *fsm.Domain {
+	t.Helper()
+
+	fsmDomain, err := fsm.NewDomainWithFlows(
+		[]fsm.Transition{
+			{From: []string{"initial"}, Into: "confirm", Command: "order", Call: "collect_address", Answers: []fsm.Answer{{Text: "Where to?"}}},
+			{From: []string{"confirm"}, Into: "initial", Command: "yes", Answers: []fsm.Answer{{Text: "Ordered to {{ .Slots.street }}."}}},
+			{From: []string{"any"}, Into: "initial", Command: "cancel", Answers: []fsm.Answer{{Text: "Cancelled."}}},
+		},
+		nil,
+		[]fsm.Flow{
+			{
+				Name: "collect_address",
+				Transitions: []fsm.Transition{
+					{From: []string{"initial"}, Into: "confirm", Command: "any", Slot: fsm.Slot{Name: "street"}, Answers: []fsm.Answer{{Text: "Is {{ .Slots.street }} right?"}}},
+					{From: []string{"confirm"}, Into: "return", Command: "yes", Call: "collect_phone", Answers: []fsm.Answer{{Text: "And your phone?"}}},
+					{From: []string{"confirm"}, Into: "initial", Command: "no", Answers: []fsm.Answer{{Text: "Where to then?"}}},
+				},
+			},
+			{
+				Name: "collect_phone",
+				Transitions: []fsm.Transition{
+					{From: []string{"initial"}, Into: "confirm", Command: "any", Slot: fsm.Slot{Name: "phone"}, Answers: []fsm.Answer{{Text: "Is {{ .Slots.phone }} right?"}}},
+					{From: []string{"confirm"}, Into: "return", Command: "yes", Answers: []fsm.Answer{{Text: "Thanks."}}},
+					{From: []string{"any"}, Into: "initial", Command: "no", Answers: []fsm.Answer{{Text: "Your phone then?"}}},
+				},
+			},
+		},
+		defaultResponses,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsmDomain
+}
+
+func TestFSM_ExecuteCmdFlow(t *testing.T) {
+	type turn struct {
+		command   string
+		text      string
+		want      string
+		wantState string
+		wantCalls int
+	}
+	tests := []struct {
+		name  string
+		turns []turn
+	}{
+		{
+			name: "call and return",
+			turns: []turn{
+				{command: "order", text: "order", want: "Where to?", wantState: "collect_address.initial", wantCalls: 1},
+				{command: "", text: "Main St", want: "Is Main St right?", wantState: "collect_address.confirm", wantCalls: 1},
+				{command: "no", text: "no", want: "Where to then?", wantState: "collect_address.initial", wantCalls: 1},
+				{command: "", text: "High St", want: "Is High St right?", wantState: "collect_address.confirm", wantCalls: 1},
+				{command: "yes", text: "yes", want: "And your phone?", wantState: "collect_phone.initial", wantCalls: 2},
+				{command: "", text: "555", want: "Is 555 right?", wantState: "collect_phone.confirm", wantCalls: 2},
+				{command: "no", text: "no", want: "Your phone then?", wantState: "collect_phone.initial", wantCalls: 2},
+				{command: "", text: "556", want: "Is 556 right?", wantState: "collect_phone.confirm", wantCalls: 2},
+				{command: "yes", text: "yes", want: "Thanks.", wantState: "confirm", wantCalls: 0},
+				{command: "yes", text: "yes", want: "Ordered to High St.", wantState: "initial", wantCalls: 0},
+			},
+		},
+		{
+			name: "leave the flows",
+			turns: []turn{
+				{command: "order", text: "order", want: "Where to?", wantState: "collect_address.initial", wantCalls: 1},
+				{command: "", text: "Main St", want: "Is Main St right?", wantState: "collect_address.confirm", wantCalls: 1},
+				{command: "yes", text: "yes", want: "And your phone?", wantState: "collect_phone.initial", wantCalls: 2},
+				{command: "cancel", text: "cancel", want: "Cancelled.", wantState: "initial", wantCalls: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsmDomain := newFlowDomain(t)
+			m := fsm.NewFSM()
+			for _, turn := range tt.turns {
+				answers, _, err := m.ExecuteCmd(turn.command, turn.text, fsmDomain, fsm.Conversation{})
+				if err != nil {
+					t.Fatalf("FSM.ExecuteCmd(%q) error = %v", turn.text, err)
+				}
+				if len(answers) != 1 || answers[0].Text != turn.want {
+					t.Errorf("FSM.ExecuteCmd(%q) answers = %v, want %v", turn.text, answers, turn.want)
+				}
+				if got := fsmDomain.StateTable.Name(m.State); got != turn.wantState {
+					t.Errorf("FSM.ExecuteCmd(%q) state = %v, want %v", turn.text, got, turn.wantState)
+				}
+				if len(m.Calls) != turn.wantCalls {
+					t.Errorf("FSM.ExecuteCmd(%q) calls = %v, want %v calls", turn.text, m.Calls, turn.wantCalls)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDomainWithFlows_Invalid(t *testing.T) {
+	start := []fsm.Transition{{From: []string{"initial"}, Into: "return", Command: "any"}}
+
+	tests := []struct {
+		name        string
+		transitions []fsm.Transition
+		forms       []fsm.Form
+		flows       []fsm.Flow
+		wantErr     interface{}
+	}{
+		{name: "no name", flows: []fsm.Flow{{Transitions: start}}, wantErr: new(*fsm.ErrInvalidFlow)},
+		{name: "namespaced name", flows: []fsm.Flow{{Name: "a.b", Transitions: start}}, wantErr: new(*fsm.ErrInvalidFlow)},
+		{name: "no initial state", flows: []fsm.Flow{{Name: "f", Transitions: []fsm.Transition{{From: []string{"ask"}, Into: "return", Command: "any"}}}}, wantErr: new(*fsm.ErrInvalidFlow)},
+		{name: "duplicate", flows: []fsm.Flow{{Name: "f", Transitions: start}, {Name: "f", Transitions: start}}, wantErr: new(*fsm.ErrInvalidFlow)},
+		{name: "unknown flow", transitions: []fsm.Transition{{From: []string{"initial"}, Into: "done", Command: "go", Call: "g"}}, flows: []fsm.Flow{{Name: "f", Transitions: start}}, wantErr: new(*fsm.ErrInvalidTransition)},
+		{name: "call without into", transitions: []fsm.Transition{{From: []string{"initial"}, Command: "go", Call: "f"}}, flows: []fsm.Flow{{Name: "f", Transitions: start}}, wantErr: new(*fsm.ErrInvalidTransition)},
+		{name: "return state of a transition", transitions: []fsm.Transition{{From: []string{"initial"}, Into: "return", Command: "back"}}, flows: []fsm.Flow{{Name: "f", Transitions: start}}, wantErr: new(*fsm.ErrInvalidTransition)},
+		{name: "return state of a form", forms: []fsm.Form{{Name: "signup", From: []string{"return"}, Into: "initial", Command: "signup", Slots: []fsm.FormSlot{{Name: "name"}}}}, flows: []fsm.Flow{{Name: "f", Transitions: start}}, wantErr: new(*fsm.ErrInvalidForm)},
+		{name: "invalid flow transition", flows: []fsm.Flow{{Name: "f", Transitions: []fsm.Transition{{From: []string{"initial"}, Into: "return", Command: "any", Answers: []fsm.Answer{{Text: "{{ .Slots"}}}}}}, wantErr: new(*fsm.ErrInvalidFlow)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fsm.NewDomainWithFlows(tt.transitions, tt.forms, tt.flows, defaultResponses)
+			if !errors.As(err, tt.wantErr) {
+				t.Errorf("NewDomainWithFlows() error = %v, want a %T", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFSM_ExecuteCmdReturnState(t *testing.T) {
+	// Without flows, return is a state like any other
+	fsmDomain, err := fsm.NewDomain([]fsm.Transition{
+		{From: []string{"initial"}, Into: "return", Command: "back", Answers: []fsm.Answer{{Text: "Welcome back"}}},
+		{From: []string{"return"}, Into: "initial", Command: "bye", Answers: []fsm.Answer{{Text: "Bye"}}},
+	}, defaultResponses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := fsm.NewFSM()
+	if _, _, err := m.ExecuteCmd("back", "back", fsmDomain, fsm.Conversation{}); err != nil {
+		t.Fatal(err)
+	}
+	if m.State == fsm.StateReturn || fsmDomain.StateTable.Name(m.State) != "return" {
+		t.Fatalf("FSM.ExecuteCmd() state = %v, want the state 'return'", m.State)
+	}
+	answers, _, err := m.ExecuteCmd("bye", "bye", fsmDomain, fsm.Conversation{})
+	if err != nil || len(answers) != 1 || answers[0].Text != "Bye" {
+		t.Errorf("FSM.ExecuteCmd() = %v, %v, want %v", answers, err, "Bye")
+	}
+}
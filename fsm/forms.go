@@ -0,0 +1,221 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jaimeteb/chatto/query"
+)
+
+// Form collects several slots from the user. It starts when its Command is
+// predicted in one of its From states, and the FSM keeps asking for the
+// slots that are still missing. Once all of them are saved the FSM goes into
+// the Into state and sends the Answers, or executes the Extension
+type Form struct {
+	Name      string     `yaml:"name"`
+	From      []string   `yaml:"from"`
+	Into      string     `yaml:"into"`
+	Command   string     `yaml:"command"`
+	Slots     []FormSlot `yaml:"slots"`
+	Cancel    FormCancel `yaml:"cancel"`
+	Extension Extension  `yaml:"extension"`
+	Answers   []Answer   `yaml:"answers"`
+}
+
+// FormSlot is a slot of a Form, the Prompt answers ask for it and the
+// Reprompt answers ask again if the user's input has no value for it
+type FormSlot struct {
+	Name     string   `yaml:"name"`
+	Mode     string   `yaml:"mode"`
+	Regex    string   `yaml:"regex"`
+	Entity   string   `yaml:"entity"`
+	Prompt   []Answer `yaml:"prompt"`
+	Reprompt []Answer `yaml:"reprompt"`
+}
+
+// FormCancel stops filling a Form when its Command is predicted, the FSM
+// goes into the Into state, or stays in its state if it is empty
+type FormCancel struct {
+	Command string   `yaml:"command"`
+	Into    string   `yaml:"into"`
+	Answers []Answer `yaml:"answers"`
+}
+
+// Slot returns the FormSlot as a Slot
+func (s *FormSlot) Slot() Slot {
+	return Slot{Name: s.Name, Mode: s.Mode, Regex: s.Regex, Entity: s.Entity, Reprompt: s.Reprompt}
+}
+
+// FormTransitions returns the transitions a Form can make: from its From
+// states into its Into state with its Command, and into the cancel state
+// with the cancel command. They are used to register the states of the
+// forms and to draw them, forms are not executed as transitions
+func FormTransitions(forms []Form) []Transition {
+	transitions := make([]Transition, 0, len(forms)*2)
+	for n := range forms {
+		form := &forms[n]
+		slots := make([]string, len(form.Slots))
+		for i := range form.Slots {
+			slots[i] = strings.TrimSpace(form.Slots[i].Name)
+		}
+		transitions = append(transitions, Transition{
+			From:      form.From,
+			Into:      form.Into,
+			Command:   form.Command,
+			Slot:      Slot{Name: strings.Join(slots, ", ")},
+			Extension: form.Extension,
+		})
+		if form.Cancel.Command != "" && form.Cancel.Into != "" {
+			transitions = append(transitions, Transition{
+				From:    form.From,
+				Into:    form.Cancel.Into,
+				Command: form.Cancel.Command,
+			})
+		}
+	}
+	return transitions
+}
+
+// compileForm returns a copy of the Form with its answer templates parsed
+func compileForm(form *Form) (*Form, error) {
+	if strings.TrimSpace(form.Name) == "" {
+		return nil, fmt.Errorf("form has no name")
+	}
+	if len(form.Slots) == 0 {
+		return nil, fmt.Errorf("form has no slots")
+	}
+
+	compiled := *form
+
+	var err error
+	if compiled.Answers, err = compileAnswers(form.Answers); err != nil {
+		return nil, err
+	}
+	if compiled.Cancel.Answers, err = compileAnswers(form.Cancel.Answers); err != nil {
+		return nil, err
+	}
+
+	compiled.Slots = make([]FormSlot, len(form.Slots))
+	for i := range form.Slots {
+		slot := form.Slots[i]
+		if strings.TrimSpace(slot.Name) == "" {
+			return nil, fmt.Errorf("slot %d has no name", i)
+		}
+		if len(slot.Prompt) == 0 {
+			return nil, fmt.Errorf("slot '%s' has no prompt", slot.Name)
+		}
+		if slot.Prompt, err = compileAnswers(slot.Prompt); err != nil {
+			return nil, err
+		}
+		if slot.Reprompt, err = compileAnswers(slot.Reprompt); err != nil {
+			return nil, err
+		}
+		compiled.Slots[i] = slot
+	}
+
+	return &compiled, nil
+}
+
+// startForm starts filling the Form, the slots of the Form
+// are cleared and filled with the values found in the input
+func (m *FSM) startForm(form *Form, classifiedText string, fsmDomain *Domain, conversation Conversation) ([]query.Answer, *Extension, error) {
+	m.Form = form.Name
+	for i := range form.Slots {
+		m.ClearSlot(strings.TrimSpace(form.Slots[i].Name))
+	}
+
+	return m.fillForm(form, classifiedText, false, fsmDomain, conversation)
+}
+
+// continueForm cancels the Form if the command is its cancel command,
+// otherwise the input is the answer to the first missing slot
+func (m *FSM) continueForm(form *Form, command, classifiedText string, fsmDomain *Domain, conversation Conversation) ([]query.Answer, *Extension, error) {
+	if cancel := strings.TrimSpace(form.Cancel.Command); cancel != "" && cancel == strings.TrimSpace(command) {
+		m.Form = ""
+		if into := strings.TrimSpace(form.Cancel.Into); into != "" {
+			m.State = fsmDomain.StateTable[into]
+			m.returnFromFlow()
+		}
+		answers, err := renderAnswers(form.Cancel.Answers, m.templateData(conversation))
+		return answers, nil, err
+	}
+
+	return m.fillForm(form, classifiedText, true, fsmDomain, conversation)
+}
+
+// fillForm saves the slots of the Form found in the input. Slots with the
+// whole_text mode are only saved if they were asked for, since they take any
+// input. If the slot asked for has no value it is asked again, otherwise the
+// next missing slot is asked for, or the Form is completed
+func (m *FSM) fillForm(form *Form, classifiedText string, asked bool, fsmDomain *Domain, conversation Conversation) ([]query.Answer, *Extension, error) {
+	current := m.missingFormSlot(form)
+	invalid := false
+
+	for i := range form.Slots {
+		slot := &form.Slots[i]
+		if _, ok := m.Slots[strings.TrimSpace(slot.Name)]; ok {
+			continue
+		}
+
+		isAsked := asked && slot == current
+		mode := strings.TrimSpace(slot.Mode)
+		if !isAsked && (mode == "" || mode == SlotModeWholeText) {
+			continue
+		}
+
+		if !m.ExtractSlot(classifiedText, slot.Slot(), fsmDomain) && isAsked {
+			invalid = true
+		}
+	}
+
+	data := m.templateData(conversation)
+
+	if next := m.missingFormSlot(form); next != nil {
+		prompt := next.Prompt
+		if invalid && next == current && len(next.Reprompt) > 0 {
+			prompt = next.Reprompt
+		}
+		answers, err := renderAnswers(prompt, data)
+		return answers, nil, err
+	}
+
+	// The form is complete
+	m.Form = ""
+	m.State = fsmDomain.StateTable[strings.TrimSpace(form.Into)]
+	m.returnFromFlow()
+
+	if form.Extension != (Extension{}) {
+		extension := form.Extension
+		return nil, &extension, nil
+	}
+
+	answers, err := renderAnswers(form.Answers, data)
+	return answers, nil, err
+}
+
+// missingFormSlot returns the first slot of the Form that is not saved
+func (m *FSM) missingFormSlot(form *Form) *FormSlot {
+	for i := range form.Slots {
+		if _, ok := m.Slots[strings.TrimSpace(form.Slots[i].Name)]; !ok {
+			return &form.Slots[i]
+		}
+	}
+	return nil
+}
+
+// ErrInvalidForm is returned when a form cannot be added to the Domain
+type ErrInvalidForm struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+// Error returns the ErrInvalidForm error message
+func (e *ErrInvalidForm) Error() string {
+	return fmt.Sprintf("form %d '%s': %s", e.Index, e.Name, e.Err)
+}
+
+// Unwrap returns the cause of the ErrInvalidForm
+func (e *ErrInvalidForm) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,193 @@
+package fsm_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jaimeteb/chatto/fsm"
+)
+
+func newFormDomain(t *testing.T, extension fsm.Extension) *fsm.Domain {
+	t.Helper()
+
+	fsmDomain, err := fsm.NewDomainWithForms(
+		[]fsm.Transition{{From: []string{"initial"}, Into: "initial", Command: "signup", Answers: []fsm.Answer{{Text: "Shadowed by the form."}}}},
+		[]fsm.Form{{
+			Name:    "signup",
+			From:    []string{"initial"},
+			Into:    "signed_up",
+			Command: "signup",
+			Slots: []fsm.FormSlot{
+				{Name: "name", Prompt: []fsm.Answer{{Text: "What is your name?"}}},
+				{Name: "email", Mode: "email", Prompt: []fsm.Answer{{Text: "What is your email?"}}, Reprompt: []fsm.Answer{{Text: "That is not an email."}}},
+				{Name: "guests", Mode: "number", Prompt: []fsm.Answer{{Text: "How many guests?"}}},
+			},
+			Cancel:    fsm.FormCancel{Command: "cancel", Into: "cancelled", Answers: []fsm.Answer{{Text: "Cancelled."}}},
+			Extension: extension,
+			Answers:   []fsm.Answer{{Text: "{{ .Slots.name }}, {{ .Slots.email }}, {{ .Slots.guests }}."}},
+		}},
+		defaultResponses,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsmDomain
+}
+
+func TestFSM_ExecuteCmdForm(t *testing.T) {
+	type turn struct {
+		command   string
+		text      string
+		want      string
+		wantState string
+	}
+	tests := []struct {
+		name      string
+		extension fsm.Extension
+		turns     []turn
+		wantSlots map[string]string
+		wantExt   *fsm.Extension
+	}{
+		{
+			name: "one slot at a time",
+			turns: []turn{
+				{command: "signup", text: "sign me up", want: "What is your name?", wantState: "initial"},
+				{command: "", text: "Jane", want: "What is your email?", wantState: "initial"},
+				{command: "greet", text: "hello", want: "That is not an email.", wantState: "initial"},
+				{command: "", text: "jane@example.com", want: "How many guests?", wantState: "initial"},
+				{command: "", text: "two", want: "Jane, jane@example.com, 2.", wantState: "signed_up"},
+			},
+			wantSlots: map[string]string{"name": "Jane", "email": "jane@example.com", "guests": "2"},
+		},
+		{
+			name: "several slots in one input",
+			turns: []turn{
+				{command: "signup", text: "sign up jane@example.com", want: "What is your name?", wantState: "initial"},
+				{command: "", text: "Jane", want: "How many guests?", wantState: "initial"},
+				{command: "", text: "3", want: "Jane, jane@example.com, 3.", wantState: "signed_up"},
+			},
+			wantSlots: map[string]string{"name": "Jane", "email": "jane@example.com", "guests": "3"},
+		},
+		{
+			name: "typed slots after the asked one",
+			turns: []turn{
+				{command: "signup", text: "sign up", want: "What is your name?", wantState: "initial"},
+				{command: "", text: "Jane, jane@example.com, 4 guests", want: "Jane, jane@example.com, 4 guests, jane@example.com, 4.", wantState: "signed_up"},
+			},
+			wantSlots: map[string]string{"name": "Jane, jane@example.com, 4 guests", "email": "jane@example.com", "guests": "4"},
+		},
+		{
+			name: "cancel",
+			turns: []turn{
+				{command: "signup", text: "sign up", want: "What is your name?", wantState: "initial"},
+				{command: "cancel", text: "cancel", want: "Cancelled.", wantState: "cancelled"},
+			},
+			wantSlots: map[string]string{},
+		},
+		{
+			name:      "extension",
+			extension: fsm.Extension{Server: "ext", Name: "signup"},
+			turns: []turn{
+				{command: "signup", text: "sign up jane@example.com 1", want: "What is your name?", wantState: "initial"},
+				{command: "", text: "Jane", want: "", wantState: "signed_up"},
+			},
+			wantSlots: map[string]string{"name": "Jane", "email": "jane@example.com", "guests": "1"},
+			wantExt:   &fsm.Extension{Server: "ext", Name: "signup"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsmDomain := newFormDomain(t, tt.extension)
+			m := fsm.NewFSM()
+
+			var gotExt *fsm.Extension
+			for _, turn := range tt.turns {
+				answers, ext, err := m.ExecuteCmd(turn.command, turn.text, fsmDomain, fsm.Conversation{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := ""
+				if len(answers) > 0 {
+					got = answers[0].Text
+				}
+				if got != turn.want {
+					t.Errorf("FSM.ExecuteCmd(%q) answers = %v, want %v", turn.text, got, turn.want)
+				}
+				if state := fsmDomain.StateTable.Name(m.State); state != turn.wantState {
+					t.Errorf("FSM.ExecuteCmd(%q) state = %v, want %v", turn.text, state, turn.wantState)
+				}
+				gotExt = ext
+			}
+
+			if m.Form != "" {
+				t.Errorf("FSM.Form = %v, want no form", m.Form)
+			}
+			if !reflect.DeepEqual(m.Slots, tt.wantSlots) {
+				t.Errorf("FSM.Slots = %v, want %v", m.Slots, tt.wantSlots)
+			}
+			if !reflect.DeepEqual(gotExt, tt.wantExt) {
+				t.Errorf("FSM.ExecuteCmd() extension = %v, want %v", gotExt, tt.wantExt)
+			}
+		})
+	}
+}
+
+func TestNewDomainWithForms_Invalid(t *testing.T) {
+	prompt := []fsm.Answer{{Text: "?"}}
+
+	tests := []struct {
+		name  string
+		forms []fsm.Form
+	}{
+		{name: "no name", forms: []fsm.Form{{Slots: []fsm.FormSlot{{Name: "a", Prompt: prompt}}}}},
+		{name: "no slots", forms: []fsm.Form{{Name: "f"}}},
+		{name: "no prompt", forms: []fsm.Form{{Name: "f", Slots: []fsm.FormSlot{{Name: "a"}}}}},
+		{name: "invalid template", forms: []fsm.Form{{Name: "f", Slots: []fsm.FormSlot{{Name: "a", Prompt: []fsm.Answer{{Text: "{{ .Slots"}}}}}}},
+		{name: "duplicate", forms: []fsm.Form{
+			{Name: "f", Slots: []fsm.FormSlot{{Name: "a", Prompt: prompt}}},
+			{Name: "f", Slots: []fsm.FormSlot{{Name: "a", Prompt: prompt}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fsm.NewDomainWithForms(nil, tt.forms, defaultResponses)
+			var invalidErr *fsm.ErrInvalidForm
+			if !errors.As(err, &invalidErr) {
+				t.Errorf("NewDomainWithForms() error = %v, want an ErrInvalidForm", err)
+			}
+		})
+	}
+}
+
+func TestNewDomainWithForms_StateTable(t *testing.T) {
+	transitions := []fsm.Transition{
+		{From: []string{"initial"}, Into: "on", Command: "turn_on"},
+		{From: []string{"on"}, Into: "off", Command: "turn_off"},
+	}
+
+	withoutForms, err := fsm.NewDomain(transitions, defaultResponses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withForms, err := fsm.NewDomainWithForms(transitions, []fsm.Form{{
+		Name:    "signup",
+		From:    []string{"initial"},
+		Into:    "signed_up",
+		Command: "signup",
+		Slots:   []fsm.FormSlot{{Name: "name", Prompt: []fsm.Answer{{Text: "What is your name?"}}}},
+		Cancel:  fsm.FormCancel{Command: "cancel", Into: "cancelled"},
+	}}, defaultResponses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for state, id := range withoutForms.StateTable {
+		if got := withForms.StateTable[state]; got != id {
+			t.Errorf("NewDomainWithForms() state %s = %v, want %v", state, got, id)
+		}
+	}
+	if _, ok := withForms.StateTable["signed_up"]; !ok {
+		t.Error("NewDomainWithForms() did not add the state of the form")
+	}
+}
@@ -1,8 +1,12 @@
 package fsm
 
 import (
-	"regexp"
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/jaimeteb/chatto/query"
 )
@@ -28,33 +32,55 @@ type Extension struct {
 // (from one state into another) if the functions command
 // is executed
 type Transition struct {
-	From      []string  `yaml:"from"`
-	Into      string    `yaml:"into"`
-	Command   string    `yaml:"command"`
-	Slot      Slot      `yaml:"slot"`
-	Extension Extension `yaml:"extension"`
-	Answers   []Answer  `yaml:"answers"`
+	From       []string    `yaml:"from"`
+	Into       string      `yaml:"into"`
+	Command    string      `yaml:"command"`
+	Call       string      `yaml:"call"`
+	Slot       Slot        `yaml:"slot"`
+	Conditions []Condition `yaml:"conditions"`
+	Extension  Extension   `yaml:"extension"`
+	Answers    []Answer    `yaml:"answers"`
+	Variants   []Variant   `yaml:"variants"`
+	Selection  string      `yaml:"selection"`
+	// ID identifies the transition in the turns of its round_robin variants,
+	// by default they are identified by its states, command and variants
+	ID string `yaml:"id"`
+	// ClearSlots and SetSlots change the slots when the transition
+	// is executed, before its answers are rendered
+	ClearSlots []string               `yaml:"clear_slots" mapstructure:"clear_slots"`
+	SetSlots   map[string]interface{} `yaml:"set_slots" mapstructure:"set_slots"`
 }
 
-// Slot is used to save information from the user's input
+// Slot is used to save information from the user's input. The Mode sets
+// how the value is extracted, and if the input has no value for the slot
+// the Reprompt answers are sent instead of executing the transition
 type Slot struct {
-	Name  string `yaml:"name"`
-	Mode  string `yaml:"mode"`
-	Regex string `yaml:"regex"`
+	Name     string   `yaml:"name"`
+	Mode     string   `yaml:"mode"`
+	Regex    string   `yaml:"regex"`
+	Entity   string   `yaml:"entity"`
+	Reprompt []Answer `yaml:"reprompt"`
 }
 
 // Defaults set the messages that will be returned when
-// Unknown, Unsure or Error events happen during FSM execution
+// Unknown, Unsure or Error events happen during FSM execution.
+// Disambiguation is the question template used when the
+// classifier cannot tell two commands apart
 type Defaults struct {
-	Unknown string `yaml:"unknown" json:"unknown"`
-	Unsure  string `yaml:"unsure" json:"unsure"`
-	Error   string `yaml:"error" json:"error"`
+	Unknown        string `yaml:"unknown" json:"unknown"`
+	Unsure         string `yaml:"unsure" json:"unsure"`
+	Error          string `yaml:"error" json:"error"`
+	Disambiguation string `yaml:"disambiguation" json:"disambiguation"`
 }
 
-// Answer that is sent when a transition is executed
+// Answer that is sent when a transition is executed, both
+// Text and Image are rendered as templates with TemplateData
 type Answer struct {
 	Text  string `yaml:"text"`
 	Image string `yaml:"image"`
+
+	textTemplate  *template.Template
+	imageTemplate *template.Template
 }
 
 // StateTable contains a mapping of state names to state ids
@@ -63,12 +89,21 @@ type StateTable map[string]int
 
 // NewStateTable initializes a new StateTable
 func NewStateTable(transitions []Transition) StateTable {
+	return newStateTable(transitions, false)
+}
+
+// newStateTable initializes a new StateTable, the return state of the
+// flows is only added if the transitions include the ones of flows
+func newStateTable(transitions []Transition, flows bool) StateTable {
 	stateTableDefaultSize := 2
 
 	stateTable := make(StateTable, len(transitions)+stateTableDefaultSize)
 
 	stateTable["any"] = StateAny         // Add state "any" ID
 	stateTable["initial"] = StateInitial // Add state "initial" ID
+	if flows {
+		stateTable["return"] = StateReturn // Add state "return" ID
+	}
 
 	// Starting state ID
 	stateID := 1
@@ -98,45 +133,138 @@ func NewStateTable(transitions []Transition) StateTable {
 	return stateTable
 }
 
-// TransitionTable contains the mapping of state tuples to transition functions
-// TODO: Document how the TransitionTable works
-type TransitionTable map[CmdStateTuple]TransitionFunc
+// Name returns the name of the state with the given id
+// or an empty string if the id is not in the StateTable
+func (s StateTable) Name(stateID int) string {
+	for name, id := range s {
+		if id == stateID {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// TransitionTable contains the mapping of state tuples to the transitions
+// that can be executed from them. Transitions sharing a CmdStateTuple are
+// kept in the order they were declared, and the first one whose guard
+// passes is executed
+type TransitionTable map[CmdStateTuple][]GuardedTransition
+
+// GuardedTransition is a TransitionFunc that is only executed if its
+// Guard passes once its Slot has been saved, its SlotActions are applied
+// with it. Form is set instead of the TransitionFunc if the transition
+// starts a form. Flow is the name of the flow the transition belongs to,
+// empty for the transitions of fsm.yml
+type GuardedTransition struct {
+	Guard          Guard
+	Slot           Slot
+	TransitionFunc TransitionFunc
+	SlotActions    *SlotActions
+	Form           *Form
+	Flow           string
+}
+
+// NewTransitionTable initializes a new TransitionTable, it returns an error
+// if the conditions, variants or answer templates of a transition are invalid,
+// or if it calls a flow that is not in the StateTable.
+// The random source is used to pick answer variants
+func NewTransitionTable(transitions []Transition, stateTable StateTable, random *Random) (TransitionTable, error) {
+	return newTransitionTable(transitions, "", stateTable, random)
+}
 
-// NewTransitionTable initializes a new TransitionTable
-func NewTransitionTable(transitions []Transition, stateTable StateTable) TransitionTable {
+// newTransitionTable initializes the TransitionTable of a flow, see NewTransitionTable
+func newTransitionTable(transitions []Transition, flow string, stateTable StateTable, random *Random) (TransitionTable, error) {
 	transitionTable := make(TransitionTable, len(transitions))
 
 	for n := range transitions {
 		transition := transitions[n]
 
+		extension := &transition.Extension
+
+		if transition.Extension == (Extension{}) {
+			extension = nil
+		}
+
+		guard, err := NewGuard(transition.Conditions)
+		if err != nil {
+			return nil, &ErrInvalidTransition{Index: n, Command: transition.Command, Err: err}
+		}
+
+		selection, err := variantsSelection(&transition)
+		if err != nil {
+			return nil, &ErrInvalidTransition{Index: n, Command: transition.Command, Err: err}
+		}
+
+		answers, err := compileAnswers(transition.Answers)
+		if err != nil {
+			return nil, &ErrInvalidTransition{Index: n, Command: transition.Command, Err: err}
+		}
+
+		variants := make([][]Answer, len(transition.Variants))
+		for i := range transition.Variants {
+			if variants[i], err = compileAnswers(transition.Variants[i].Answers); err != nil {
+				return nil, &ErrInvalidTransition{Index: n, Command: transition.Command, Err: err}
+			}
+		}
+
+		transitionFunc := NewTransitionFunc(stateTable[transition.Into], extension, answers)
+		if len(variants) > 0 {
+			transitionFunc = NewVariantsTransitionFunc(stateTable[transition.Into], extension, rotationKey(&transition, flow), selection, variants, random)
+		}
+
+		if call := strings.TrimSpace(transition.Call); call != "" {
+			flowState, ok := stateTable[FlowState(call, "initial")]
+			if !ok {
+				return nil, &ErrInvalidTransition{Index: n, Command: transition.Command, Err: fmt.Errorf("unknown flow '%s'", call)}
+			}
+			if strings.TrimSpace(transition.Into) == "" {
+				return nil, &ErrInvalidTransition{Index: n, Command: transition.Command, Err: fmt.Errorf("a transition that calls a flow needs an into state to return to")}
+			}
+			transitionFunc = NewCallTransitionFunc(transitionFunc, flowState)
+		}
+
+		slot := transition.Slot
+		if slot.Reprompt, err = compileAnswers(slot.Reprompt); err != nil {
+			return nil, &ErrInvalidTransition{Index: n, Command: transition.Command, Err: err}
+		}
+
+		slotActions, err := NewSlotActions(transition.ClearSlots, transition.SetSlots)
+		if err != nil {
+			return nil, &ErrInvalidTransition{Index: n, Command: transition.Command, Err: err}
+		}
+
+		guardedTransition := GuardedTransition{
+			Guard:          guard,
+			Slot:           slot,
+			TransitionFunc: transitionFunc,
+			SlotActions:    slotActions,
+			Flow:           flow,
+		}
+
 		for _, from := range transition.From {
 			cmdStateTuple := CmdStateTuple{
 				Cmd:   transition.Command,
 				State: stateTable[from],
 			}
 
-			extension := &transition.Extension
-
-			if transition.Extension == (Extension{}) {
-				extension = nil
-			}
-
-			transitionTable[cmdStateTuple] = NewTransitionFunc(
-				stateTable[transition.Into],
-				extension,
-				transition.Answers,
-			)
+			transitionTable[cmdStateTuple] = append(transitionTable[cmdStateTuple], guardedTransition)
 		}
 	}
 
-	return transitionTable
+	return transitionTable, nil
 }
 
 // SlotTable contains the mapping of state tuples to slots
-// TODO: Document how the SlotTable works
+//
+// Deprecated: the Slot of every transition is in its GuardedTransition,
+// transitions with the same CmdStateTuple can save different slots
 type SlotTable map[CmdStateTuple]Slot
 
-// NewSlotTable initializes a new SlotTable
+// NewSlotTable initializes a new SlotTable, if several transitions share a
+// CmdStateTuple the slot of the last one is kept
+//
+// Deprecated: use the Slot of the GuardedTransition in the TransitionTable
 func NewSlotTable(transitions []Transition, stateTable StateTable) SlotTable {
 	slotTable := make(SlotTable, len(transitions))
 
@@ -149,7 +277,7 @@ func NewSlotTable(transitions []Transition, stateTable StateTable) SlotTable {
 				State: stateTable[from],
 			}
 
-			if transition.Slot != (Slot{}) {
+			if transition.Slot.Name != "" {
 				slotTable[cmdStateTuple] = transition.Slot
 			}
 		}
@@ -158,6 +286,18 @@ func NewSlotTable(transitions []Transition, stateTable StateTable) SlotTable {
 	return slotTable
 }
 
+// compileAnswers returns a copy of the answers with their templates parsed
+func compileAnswers(answers []Answer) ([]Answer, error) {
+	compiled := make([]Answer, len(answers))
+	for i := range answers {
+		compiled[i] = answers[i]
+		if err := compiled[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
 // BaseDomain contains the data required for a minimally functioning FSM
 type BaseDomain struct {
 	StateTable      StateTable `json:"state_table"`
@@ -169,18 +309,139 @@ type BaseDomain struct {
 type Domain struct {
 	BaseDomain
 	TransitionTable TransitionTable
-	SlotTable       SlotTable
+	Random          *Random
+	// Forms are the forms of the Domain, by name
+	Forms map[string]*Form
+	// Entities are the enums slots can extract, by name
+	Entities map[string]Entity
+	// Now is the clock relative dates are resolved with
+	Now func() time.Time
+}
+
+// NewDomain initializes a new Domain, it returns an error if a transition
+// is invalid, see NewTransitionTable
+func NewDomain(transitions []Transition, defaults Defaults) (*Domain, error) {
+	return NewDomainWithForms(transitions, nil, defaults)
 }
 
-// NewDomain initializes a new Domain
-func NewDomain(transitions []Transition, defaults Defaults) *Domain {
+// MustNewDomain is like NewDomain but panics if a transition is invalid,
+// it keeps the signature NewDomain had before it returned an error
+//
+// Deprecated: use NewDomain and handle the error
+func MustNewDomain(transitions []Transition, defaults Defaults) *Domain {
+	fsmDomain, err := NewDomain(transitions, defaults)
+	if err != nil {
+		panic(err)
+	}
+	return fsmDomain
+}
+
+// NewDomainWithForms initializes a new Domain with forms, which
+// start before the transitions with the same command and state
+func NewDomainWithForms(transitions []Transition, forms []Form, defaults Defaults) (*Domain, error) {
+	return NewDomainWithFlows(transitions, forms, nil, defaults)
+}
+
+// NewDomainWithFlows initializes a new Domain with forms and flows,
+// the states of the flows are namespaced, see FlowTransitions
+func NewDomainWithFlows(transitions []Transition, forms []Form, flows []Flow, defaults Defaults) (*Domain, error) {
+	names := make(map[string]bool, len(flows))
+	for n := range flows {
+		if err := flows[n].validate(); err != nil {
+			return nil, &ErrInvalidFlow{Index: n, Name: flows[n].Name, Err: err}
+		}
+		name := strings.TrimSpace(flows[n].Name)
+		if names[name] {
+			return nil, &ErrInvalidFlow{Index: n, Name: name, Err: fmt.Errorf("flow is declared more than once")}
+		}
+		names[name] = true
+	}
+
+	// The return state of the flows cannot be a state of the transitions
+	// or forms, it would finish a flow instead of going into that state
+	if len(flows) > 0 {
+		for n := range transitions {
+			if hasState(transitions[n].From, transitions[n].Into, "return") {
+				return nil, &ErrInvalidTransition{Index: n, Command: transitions[n].Command, Err: errReturnState}
+			}
+		}
+		for n := range forms {
+			if hasState(forms[n].From, forms[n].Into, "return") || strings.TrimSpace(forms[n].Cancel.Into) == "return" {
+				return nil, &ErrInvalidForm{Index: n, Name: forms[n].Name, Err: errReturnState}
+			}
+		}
+	}
+
 	fsmDomain := &Domain{}
 	fsmDomain.DefaultMessages = defaults
-	fsmDomain.StateTable = NewStateTable(transitions)
-	fsmDomain.TransitionTable = NewTransitionTable(transitions, fsmDomain.StateTable)
-	fsmDomain.SlotTable = NewSlotTable(transitions, fsmDomain.StateTable)
+	// The states of the transitions are numbered first, so adding
+	// forms or flows does not renumber the states of saved FSMs
+	allTransitions := make([]Transition, 0, len(transitions)+len(forms)*2)
+	allTransitions = append(allTransitions, transitions...)
+	allTransitions = append(allTransitions, FormTransitions(forms)...)
+	fsmDomain.StateTable = newStateTable(append(allTransitions, FlowTransitions(flows)...), len(flows) > 0)
+	fsmDomain.Random = NewRandom()
+	fsmDomain.Entities = make(map[string]Entity)
+	fsmDomain.Now = time.Now
+
+	if _, err := parseDisambiguation(defaults.Disambiguation); err != nil {
+		return nil, err
+	}
 
-	return fsmDomain
+	transitionTable, err := NewTransitionTable(transitions, fsmDomain.StateTable, fsmDomain.Random)
+	if err != nil {
+		return nil, err
+	}
+	fsmDomain.TransitionTable = transitionTable
+
+	for n := range flows {
+		name := strings.TrimSpace(flows[n].Name)
+		flowTable, err := newTransitionTable(flows[n].namespaced(), name, fsmDomain.StateTable, fsmDomain.Random)
+		if err != nil {
+			return nil, &ErrInvalidFlow{Index: n, Name: name, Err: err}
+		}
+		for cmdStateTuple, flowTransitions := range flowTable {
+			transitionTable[cmdStateTuple] = append(transitionTable[cmdStateTuple], flowTransitions...)
+		}
+	}
+
+	fsmDomain.Forms = make(map[string]*Form, len(forms))
+	starts := make(TransitionTable, len(forms))
+	for n := range forms {
+		form, err := compileForm(&forms[n])
+		if err != nil {
+			return nil, &ErrInvalidForm{Index: n, Name: forms[n].Name, Err: err}
+		}
+		form.Name = strings.TrimSpace(form.Name)
+		if _, ok := fsmDomain.Forms[form.Name]; ok {
+			return nil, &ErrInvalidForm{Index: n, Name: form.Name, Err: fmt.Errorf("form is declared more than once")}
+		}
+		fsmDomain.Forms[form.Name] = form
+
+		for _, from := range form.From {
+			cmdStateTuple := CmdStateTuple{
+				Cmd:   strings.TrimSpace(form.Command),
+				State: fsmDomain.StateTable[strings.TrimSpace(from)],
+			}
+			starts[cmdStateTuple] = append(starts[cmdStateTuple], GuardedTransition{Form: form})
+		}
+	}
+
+	for cmdStateTuple, start := range starts {
+		transitionTable[cmdStateTuple] = append(start, transitionTable[cmdStateTuple]...)
+	}
+
+	return fsmDomain, nil
+}
+
+// SetEntities replaces the entities the slots of the Domain can extract,
+// the patterns of their values and synonyms are compiled once
+func (d *Domain) SetEntities(entities []Entity) {
+	d.Entities = make(map[string]Entity, len(entities))
+	for _, entity := range entities {
+		entity.synonyms = entity.compileSynonyms()
+		d.Entities[strings.TrimSpace(entity.Name)] = entity
+	}
 }
 
 // NoFuncs returns a Domain without TransitionFunc items in order
@@ -214,6 +475,22 @@ func NewTransitionFunc(state int, extension *Extension, answers []Answer) Transi
 type FSM struct {
 	State int               `json:"state"`
 	Slots map[string]string `json:"slots"`
+	// Rotations keeps the next variant to answer with for
+	// transitions with round_robin selection
+	Rotations map[string]int `json:"rotations,omitempty"`
+	// Pending is the disambiguation the user has to answer
+	// before the next command is executed
+	Pending *Disambiguation `json:"pending,omitempty"`
+	// Form is the name of the form being filled
+	Form string `json:"form,omitempty"`
+	// Values keeps the JSON value of the slots that are not strings,
+	// Slots has their JSON text so they can be read as strings
+	Values map[string]json.RawMessage `json:"values,omitempty"`
+	// SlotHistory keeps the previous values of every slot, oldest first
+	SlotHistory map[string][]json.RawMessage `json:"slot_history,omitempty"`
+	// Calls is the call stack of flows, the states
+	// to return to when the flows finish
+	Calls []int `json:"calls,omitempty"`
 }
 
 // NewFSM instantiates a new FSM
@@ -221,10 +498,70 @@ func NewFSM() *FSM {
 	return &FSM{State: StateInitial, Slots: make(map[string]string)}
 }
 
+// Clone returns a copy of the FSM that can be changed without changing it
+func (m *FSM) Clone() *FSM {
+	clone := m.cloneSlots()
+	clone.Form = m.Form
+
+	if m.Slots == nil {
+		clone.Slots = nil
+	}
+
+	if m.Rotations != nil {
+		clone.Rotations = make(map[string]int, len(m.Rotations))
+		for k, v := range m.Rotations {
+			clone.Rotations[k] = v
+		}
+	}
+	if m.Pending != nil {
+		pending := *m.Pending
+		pending.Commands = append([]string(nil), m.Pending.Commands...)
+		pending.Labels = append([]string(nil), m.Pending.Labels...)
+		clone.Pending = &pending
+	}
+	if m.Calls != nil {
+		clone.Calls = append([]int{}, m.Calls...)
+	}
+
+	return clone
+}
+
+// MergeExtension sets the FSM to the FSM changed by an extension. Extensions
+// change the state and the slots, the fields the bot keeps for itself are
+// kept if the extension does not send them back, and so is the slot history
+func (m *FSM) MergeExtension(changed *FSM) {
+	if changed == nil {
+		return
+	}
+
+	previous := *m
+	*m = *changed
+
+	if m.Rotations == nil {
+		m.Rotations = previous.Rotations
+	}
+	if m.Pending == nil {
+		m.Pending = previous.Pending
+	}
+	if m.Form == "" {
+		m.Form = previous.Form
+	}
+	if m.Calls == nil {
+		m.Calls = previous.Calls
+	}
+	m.KeepSlotHistory(&previous)
+}
+
 // ExecuteCmd executes a state transition in the FSM based on
 // the function command provided and if configured will save
 // the classified text to a slot
-func (m *FSM) ExecuteCmd(command, classifiedText string, fsmDomain *Domain) (answers []query.Answer, extension *Extension, err error) {
+func (m *FSM) ExecuteCmd(command, classifiedText string, fsmDomain *Domain, conversation Conversation) (answers []query.Answer, extension *Extension, err error) {
+	// Every input is an answer to the form being filled
+	if form, ok := fsmDomain.Forms[m.Form]; ok && m.Form != "" {
+		return m.continueForm(form, command, classifiedText, fsmDomain, conversation)
+	}
+	m.Form = ""
+
 	// fromAnyState means we can transition from any state
 	fromAnyState := CmdStateTuple{command, StateAny}
 	// cmdAnyState transition between any two states
@@ -232,24 +569,10 @@ func (m *FSM) ExecuteCmd(command, classifiedText string, fsmDomain *Domain) (ans
 	// normalState transition from one existing state to another
 	normalState := CmdStateTuple{command, m.State}
 
-	var cmdStateTuple CmdStateTuple
-
-	if fsmDomain.TransitionTable[fromAnyState] != nil {
-		// Special state any can go from any state into another
-		cmdStateTuple = fromAnyState
-	} else if fsmDomain.TransitionTable[cmdAnyState] != nil {
-		// Special command any is used to transition between two states,
-		// regardless of the command predicted. Useful for taking in any
-		// user input for searches
-		cmdStateTuple = cmdAnyState
-	} else {
-		cmdStateTuple = normalState
-	}
-
 	// Function command was not found by the classifier
 	isBelowThreshold := strings.TrimSpace(command) == ""
 
-	if isBelowThreshold && cmdStateTuple != cmdAnyState {
+	if isBelowThreshold && len(fsmDomain.TransitionTable[cmdAnyState]) == 0 {
 		if fsmDomain.DefaultMessages.Unsure == "" {
 			return nil, nil, nil
 		}
@@ -257,38 +580,94 @@ func (m *FSM) ExecuteCmd(command, classifiedText string, fsmDomain *Domain) (ans
 		return nil, nil, &ErrUnsureCommand{Msg: fsmDomain.DefaultMessages.Unsure}
 	}
 
-	transitionFunc := fsmDomain.TransitionTable[cmdStateTuple]
+	// Special state any can go from any state into another, special
+	// command any is used to transition between two states regardless
+	// of the command predicted, which is useful for taking in any user
+	// input for searches. Otherwise transition from one existing state
+	// to another
+	cmdStateTuples := []CmdStateTuple{fromAnyState, cmdAnyState, normalState}
+	if isBelowThreshold {
+		cmdStateTuples = []CmdStateTuple{cmdAnyState}
+	}
+
+	// Answers to send if no transition is executed because
+	// the input has no value for the slot of a transition
+	var reprompt []Answer
+
+	for _, cmdStateTuple := range cmdStateTuples {
+		for _, transition := range fsmDomain.TransitionTable[cmdStateTuple] {
+			// Save information from the user's input into the slot,
+			// but only keep it if the transition's guard passes
+			candidate := m.cloneSlots()
+			if !candidate.ExtractSlot(classifiedText, transition.Slot, fsmDomain) && len(transition.Slot.Reprompt) > 0 {
+				if reprompt == nil {
+					reprompt = transition.Slot.Reprompt
+				}
+				continue
+			}
+
+			if transition.Guard != nil && !transition.Guard(candidate.Slots) {
+				continue
+			}
+
+			m.Slots, m.Values, m.SlotHistory = candidate.Slots, candidate.Values, candidate.SlotHistory
+
+			// Transitions of fsm.yml leave the flows being executed,
+			// for example when they are executed from the any state
+			if transition.Flow == "" {
+				m.Calls = nil
+			}
+
+			if transition.Form != nil {
+				return m.startForm(transition.Form, classifiedText, fsmDomain, conversation)
+			}
 
-	// Save information from the user's input into the slot
-	m.SaveToSlot(classifiedText, fsmDomain.SlotTable[cmdStateTuple])
+			// Transition FSM state and get answers or extension to execute
+			return m.TransitionState(transition.TransitionFunc, transition.SlotActions, fsmDomain.DefaultMessages, conversation)
+		}
+	}
 
-	// Transition FSM state and get answers or extension to execute
-	return m.TransitionState(transitionFunc, fsmDomain.DefaultMessages)
+	if reprompt != nil {
+		answers, err := renderAnswers(reprompt, m.templateData(conversation))
+		return answers, nil, err
+	}
+
+	return m.TransitionState(nil, nil, fsmDomain.DefaultMessages, conversation)
 }
 
 // SaveToSlot saves information from the user's input/question
 func (m *FSM) SaveToSlot(classifiedText string, slot Slot) {
+	m.ExtractSlot(classifiedText, slot, nil)
+}
+
+// ExtractSlot saves the value of the slot found in the user's input, see
+// Slot.Extract. It reports false if the slot has a name but the input has
+// no value for it, in which case the slot is left unchanged
+func (m *FSM) ExtractSlot(classifiedText string, slot Slot, fsmDomain *Domain) bool {
 	slotName := strings.TrimSpace(slot.Name)
-	slotRegex := strings.TrimSpace(slot.Regex)
-
-	if slotName != "" {
-		switch strings.TrimSpace(slot.Mode) {
-		case "regex":
-			if r, err := regexp.Compile(slotRegex); err == nil {
-				match := r.FindAllString(classifiedText, 1)
-				if len(match) > 0 {
-					m.Slots[slotName] = match[0]
-				}
-			}
-		default:
-			// Use whole_text by default
-			m.Slots[slotName] = classifiedText
+	if slotName == "" {
+		return true
+	}
+
+	value, ok := slot.Extract(classifiedText, fsmDomain)
+	if !ok {
+		return false
+	}
+
+	// Numbers are saved as JSON numbers
+	if strings.TrimSpace(slot.Mode) == SlotModeNumber {
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return m.SetSlot(slotName, n) == nil
 		}
 	}
+
+	return m.SetSlot(slotName, value) == nil
 }
 
 // TransitionState FSM state and return the query answers or extension to execute.
-func (m *FSM) TransitionState(transitionFunc TransitionFunc, defaults Defaults) (answers []query.Answer, extension *Extension, err error) {
+// The slot actions are applied first, if they fail the FSM is left unchanged.
+// The answers are rendered with the slots of the FSM and the conversation
+func (m *FSM) TransitionState(transitionFunc TransitionFunc, slotActions *SlotActions, defaults Defaults, conversation Conversation) (answers []query.Answer, extension *Extension, err error) {
 	// Function command was found by the classifier but state transition is unknown or not valid
 	if transitionFunc == nil {
 		if defaults.Unknown == "" {
@@ -298,21 +677,40 @@ func (m *FSM) TransitionState(transitionFunc TransitionFunc, defaults Defaults)
 		return nil, nil, &ErrUnknownCommand{Msg: defaults.Unknown}
 	}
 
+	if err := slotActions.apply(m, conversation); err != nil {
+		return nil, nil, err
+	}
+
 	// Execute transition
 	extension, messages := transitionFunc(m)
+	m.returnFromFlow()
 
 	// Tell the bot to execute an extension to get the answer
 	if extension != nil {
 		return nil, extension, nil
 	}
 
-	for n := range messages {
-		answers = append(answers, query.Answer{Text: messages[n].Text, Image: messages[n].Image})
+	answers, err = renderAnswers(messages, m.templateData(conversation))
+	if err != nil {
+		return nil, nil, err
 	}
 
 	return answers, nil, nil
 }
 
+// renderAnswers renders the text and image templates of the answers
+func renderAnswers(messages []Answer, data *TemplateData) ([]query.Answer, error) {
+	var answers []query.Answer
+	for n := range messages {
+		text, image, err := messages[n].Render(data)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, query.Answer{Text: text, Image: image})
+	}
+	return answers, nil
+}
+
 // ErrUnsureCommand is returned by the FSM when no function
 // command was found by the classifier
 type ErrUnsureCommand struct {
@@ -335,3 +733,21 @@ type ErrUnknownCommand struct {
 func (e *ErrUnknownCommand) Error() string {
 	return e.Msg
 }
+
+// ErrInvalidTransition is returned when a transition
+// cannot be added to the Domain
+type ErrInvalidTransition struct {
+	Index   int
+	Command string
+	Err     error
+}
+
+// Error returns the ErrInvalidTransition error message
+func (e *ErrInvalidTransition) Error() string {
+	return fmt.Sprintf("transition %d with command '%s': %s", e.Index, e.Command, e.Err)
+}
+
+// Unwrap returns the cause of the ErrInvalidTransition
+func (e *ErrInvalidTransition) Unwrap() error {
+	return e.Err
+}
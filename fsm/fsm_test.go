@@ -87,6 +87,47 @@ var (
 			},
 		},
 	}
+
+	// Guarded test
+	guardedFunctions = []fsm.Transition{
+		{
+			From:    []string{"initial"},
+			Into:    "question",
+			Command: "start",
+			Answers: []fsm.Answer{{
+				Text: "What is the capital of France?",
+			}},
+		},
+		{
+			From:    []string{"question"},
+			Into:    "initial",
+			Command: "any",
+			Slot: fsm.Slot{
+				Name: "answer",
+				Mode: "whole_text",
+			},
+			Conditions: []fsm.Condition{{
+				Slot:     "answer",
+				Operator: fsm.OperatorMatches,
+				Value:    "(?i)^paris$",
+			}},
+			Answers: []fsm.Answer{{
+				Text: "Correct!",
+			}},
+		},
+		{
+			From:    []string{"question"},
+			Into:    "question",
+			Command: "any",
+			Conditions: []fsm.Condition{{
+				Slot:     "tries",
+				Operator: fsm.OperatorAbsent,
+			}},
+			Answers: []fsm.Answer{{
+				Text: "Try again.",
+			}},
+		},
+	}
 	defaultResponses = fsm.Defaults{
 		Unknown: "Can't do that.",
 		Unsure:  "???",
@@ -94,6 +135,14 @@ var (
 	}
 )
 
+func newDomain(transitions []fsm.Transition) *fsm.Domain {
+	fsmDomain, err := fsm.NewDomain(transitions, defaultResponses)
+	if err != nil {
+		panic(err)
+	}
+	return fsmDomain
+}
+
 func TestFSM_ExecuteCmd(t *testing.T) {
 	type fields struct {
 		State int
@@ -123,7 +172,7 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 			args: args{
 				command:   "ruhrow",
 				txt:       "blah blah blah",
-				fsmDomain: fsm.NewDomain(onOffFunctions, defaultResponses),
+				fsmDomain: newDomain(onOffFunctions),
 			},
 			wantAnswers:   nil,
 			wantExtension: nil,
@@ -140,7 +189,7 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 			args: args{
 				command:   "",
 				txt:       "blah blah blah",
-				fsmDomain: fsm.NewDomain(onOffFunctions, defaultResponses),
+				fsmDomain: newDomain(onOffFunctions),
 			},
 			wantAnswers:   nil,
 			wantExtension: nil,
@@ -157,7 +206,7 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 			args: args{
 				command:   "hey_friend",
 				txt:       "hey there",
-				fsmDomain: fsm.NewDomain(helloFunctions, defaultResponses),
+				fsmDomain: newDomain(helloFunctions),
 			},
 			wantAnswers: []query.Answer{{
 				Text: "Hey friend!",
@@ -176,7 +225,7 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 			args: args{
 				command:   "any",
 				txt:       "pikachu",
-				fsmDomain: fsm.NewDomain(pokemonFunctions, defaultResponses),
+				fsmDomain: newDomain(pokemonFunctions),
 			},
 			wantAnswers: nil,
 			wantExtension: &fsm.Extension{
@@ -196,7 +245,7 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 			args: args{
 				command:   "turn_on",
 				txt:       "turn it on",
-				fsmDomain: fsm.NewDomain(onOffFunctions, defaultResponses),
+				fsmDomain: newDomain(onOffFunctions),
 			},
 			wantAnswers: []query.Answer{{
 				Text: "Turning on.",
@@ -215,7 +264,7 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 			args: args{
 				command:   "turn_off",
 				txt:       "turn it off",
-				fsmDomain: fsm.NewDomain(onOffFunctions, defaultResponses),
+				fsmDomain: newDomain(onOffFunctions),
 			},
 			wantAnswers: []query.Answer{
 				{
@@ -230,6 +279,61 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 			wantSlots:     map[string]string{"off": "turn it off"},
 			wantErr:       false,
 		},
+		{
+			name: "guarded transition should run when its condition passes",
+			fields: fields{
+				State: 1,
+				Slots: make(map[string]string),
+			},
+			args: args{
+				command:   "",
+				txt:       "Paris",
+				fsmDomain: newDomain(guardedFunctions),
+			},
+			wantAnswers: []query.Answer{{
+				Text: "Correct!",
+			}},
+			wantExtension: nil,
+			wantState:     fsm.StateInitial,
+			wantSlots:     map[string]string{"answer": "Paris"},
+			wantErr:       false,
+		},
+		{
+			name: "guarded transition should fall through to the next candidate",
+			fields: fields{
+				State: 1,
+				Slots: make(map[string]string),
+			},
+			args: args{
+				command:   "start",
+				txt:       "London",
+				fsmDomain: newDomain(guardedFunctions),
+			},
+			wantAnswers: []query.Answer{{
+				Text: "Try again.",
+			}},
+			wantExtension: nil,
+			wantState:     1,
+			wantSlots:     map[string]string{},
+			wantErr:       false,
+		},
+		{
+			name: "no guarded transition passing should be unknown",
+			fields: fields{
+				State: 1,
+				Slots: map[string]string{"tries": "1"},
+			},
+			args: args{
+				command:   "start",
+				txt:       "London",
+				fsmDomain: newDomain(guardedFunctions),
+			},
+			wantAnswers:   nil,
+			wantExtension: nil,
+			wantState:     1,
+			wantSlots:     map[string]string{"tries": "1"},
+			wantErr:       true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -237,7 +341,7 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 				State: tt.fields.State,
 				Slots: tt.fields.Slots,
 			}
-			gotAnswers, gotExtension, err := m.ExecuteCmd(tt.args.command, tt.args.txt, tt.args.fsmDomain)
+			gotAnswers, gotExtension, err := m.ExecuteCmd(tt.args.command, tt.args.txt, tt.args.fsmDomain, fsm.Conversation{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("FSM.ExecuteCmd() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -248,9 +352,226 @@ func TestFSM_ExecuteCmd(t *testing.T) {
 			if !reflect.DeepEqual(gotExtension, tt.wantExtension) {
 				t.Errorf("FSM.ExecuteCmd() gotExtension = %v, want %v", gotExtension, tt.wantExtension)
 			}
+			if m.State != tt.wantState {
+				t.Errorf("FSM.State gotState = %v, want %v", m.State, tt.wantState)
+			}
 			if !reflect.DeepEqual(m.Slots, tt.wantSlots) {
 				t.Errorf("FSM.Slot gotSlot = %v, want %v", m.Slots, tt.wantSlots)
 			}
 		})
 	}
 }
+
+func TestFSM_ExecuteCmdVariants(t *testing.T) {
+	variants := []fsm.Variant{
+		{Answers: []fsm.Answer{{Text: "Hello!"}}},
+		{Answers: []fsm.Answer{{Text: "Hi!"}, {Text: "How are you?"}}},
+		{Answers: []fsm.Answer{{Text: "Hey {{.Sender}}!"}}},
+	}
+
+	tests := []struct {
+		name      string
+		selection string
+		want      []string
+	}{
+		{
+			name:      "round robin",
+			selection: fsm.SelectionRoundRobin,
+			want:      []string{"Hello!", "Hi!", "Hey 42!", "Hello!"},
+		},
+		{
+			name:      "random",
+			selection: fsm.SelectionRandom,
+			want:      []string{"Hey 42!", "Hey 42!", "Hey 42!", "Hello!"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsmDomain := newDomain([]fsm.Transition{{
+				From:      []string{"any"},
+				Into:      "initial",
+				Command:   "greet",
+				Variants:  variants,
+				Selection: tt.selection,
+			}})
+			fsmDomain.Random.Seed(42)
+
+			m := fsm.NewFSM()
+
+			for i, want := range tt.want {
+				gotAnswers, _, err := m.ExecuteCmd("greet", "hello", fsmDomain, fsm.Conversation{Sender: "42"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(gotAnswers) == 0 || gotAnswers[0].Text != want {
+					t.Errorf("FSM.ExecuteCmd() turn %d gotAnswers = %v, want %v", i, gotAnswers, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFSM_ExecuteCmdVariantsRotationKey(t *testing.T) {
+	greet := fsm.Transition{
+		From:      []string{"any"},
+		Into:      "initial",
+		Command:   "greet",
+		Variants:  []fsm.Variant{{Answers: []fsm.Answer{{Text: "Hello!"}}}, {Answers: []fsm.Answer{{Text: "Hi!"}}}},
+		Selection: fsm.SelectionRoundRobin,
+	}
+	other := fsm.Transition{From: []string{"initial"}, Into: "initial", Command: "other", Answers: []fsm.Answer{{Text: "Other"}}}
+
+	changed := greet
+	changed.Variants = []fsm.Variant{{Answers: []fsm.Answer{{Text: "Hello there!"}}}, greet.Variants[1]}
+
+	withID, changedWithID := greet, changed
+	withID.ID, changedWithID.ID = "greeting", "greeting"
+
+	tests := []struct {
+		name   string
+		before []fsm.Transition
+		after  []fsm.Transition
+		want   string
+	}{
+		{
+			name:   "transition added before",
+			before: []fsm.Transition{greet},
+			after:  []fsm.Transition{other, greet},
+			want:   "Hi!",
+		},
+		{
+			name:   "variants changed with an id",
+			before: []fsm.Transition{withID},
+			after:  []fsm.Transition{changedWithID},
+			want:   "Hi!",
+		},
+		{
+			name:   "variants changed without an id",
+			before: []fsm.Transition{greet},
+			after:  []fsm.Transition{changed},
+			want:   "Hello there!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := fsm.NewFSM()
+			if _, _, err := m.ExecuteCmd("greet", "hello", newDomain(tt.before), fsm.Conversation{Sender: "42"}); err != nil {
+				t.Fatal(err)
+			}
+
+			gotAnswers, _, err := m.ExecuteCmd("greet", "hello", newDomain(tt.after), fsm.Conversation{Sender: "42"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gotAnswers) == 0 || gotAnswers[0].Text != tt.want {
+				t.Errorf("FSM.ExecuteCmd() gotAnswers = %v, want %v", gotAnswers, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDomain_InvalidVariants(t *testing.T) {
+	tests := []struct {
+		name       string
+		transition fsm.Transition
+	}{
+		{
+			name: "answers and variants",
+			transition: fsm.Transition{
+				From:     []string{"initial"},
+				Into:     "initial",
+				Command:  "greet",
+				Answers:  []fsm.Answer{{Text: "Hello!"}},
+				Variants: []fsm.Variant{{Answers: []fsm.Answer{{Text: "Hi!"}}}},
+			},
+		},
+		{
+			name: "unknown selection",
+			transition: fsm.Transition{
+				From:      []string{"initial"},
+				Into:      "initial",
+				Command:   "greet",
+				Variants:  []fsm.Variant{{Answers: []fsm.Answer{{Text: "Hi!"}}}},
+				Selection: "shuffle",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := fsm.NewDomain([]fsm.Transition{tt.transition}, defaultResponses); err == nil {
+				t.Errorf("NewDomain() error = %v, want an error", err)
+			}
+		})
+	}
+}
+
+func TestFSM_Clone(t *testing.T) {
+	machine := &fsm.FSM{
+		State:     2,
+		Slots:     map[string]string{"name": "Ana"},
+		Rotations: map[string]int{"1:hey_friend": 1},
+		Pending:   &fsm.Disambiguation{Text: "hey", Commands: []string{"a", "b"}, Labels: []string{"A", "B"}},
+		Form:      "order",
+		Calls:     []int{1},
+	}
+
+	clone := machine.Clone()
+	if !reflect.DeepEqual(clone, machine) {
+		t.Fatalf("FSM.Clone() = %+v, want %+v", clone, machine)
+	}
+
+	clone.Slots["name"] = "Bea"
+	clone.Rotations["1:hey_friend"] = 2
+	clone.Pending.Commands[0] = "c"
+	clone.Calls[0] = 2
+	if machine.Slots["name"] != "Ana" || machine.Rotations["1:hey_friend"] != 1 ||
+		machine.Pending.Commands[0] != "a" || machine.Calls[0] != 1 {
+		t.Errorf("FSM.Clone() changed the FSM: %+v", machine)
+	}
+
+	if clone := (&fsm.FSM{}).Clone(); clone.Slots != nil {
+		t.Errorf("FSM.Clone() slots = %v, want %v", clone.Slots, nil)
+	}
+}
+
+func TestNewSlotTable(t *testing.T) {
+	transitions := []fsm.Transition{
+		{
+			From:    []string{"initial"},
+			Into:    "ask_name",
+			Command: "greet",
+		},
+		{
+			From:    []string{"ask_name"},
+			Into:    "initial",
+			Command: "any",
+			Slot:    fsm.Slot{Name: "name", Mode: "whole_text"},
+		},
+	}
+	stateTable := fsm.NewStateTable(transitions)
+
+	want := fsm.SlotTable{
+		{Cmd: "any", State: stateTable["ask_name"]}: {Name: "name", Mode: "whole_text"},
+	}
+	if got := fsm.NewSlotTable(transitions, stateTable); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewSlotTable() = %v, want %v", got, want)
+	}
+}
+
+func TestMustNewDomain(t *testing.T) {
+	if fsmDomain := fsm.MustNewDomain(helloFunctions, defaultResponses); fsmDomain.TransitionTable == nil {
+		t.Error("MustNewDomain() has no TransitionTable")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MustNewDomain() with an invalid template did not panic")
+		}
+	}()
+	fsm.MustNewDomain([]fsm.Transition{{
+		From:    []string{"initial"},
+		Into:    "initial",
+		Command: "greet",
+		Answers: []fsm.Answer{{Text: "{{.Slots.name"}},
+	}}, defaultResponses)
+}
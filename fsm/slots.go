@@ -0,0 +1,330 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// SlotHistorySize is the number of previous values kept for every slot
+const SlotHistorySize = 10
+
+// ClearAllSlots is the clear_slots name that clears every slot
+const ClearAllSlots = "all"
+
+// SlotActions clear and set slots when a transition is executed
+type SlotActions struct {
+	clearAll bool
+	clear    []string
+	set      []slotSetter
+}
+
+// slotSetter sets a slot to a value, string values are templates
+type slotSetter struct {
+	name     string
+	value    interface{}
+	template *template.Template
+}
+
+// NewSlotActions returns the actions that clear the slots, or all of them
+// if ClearAllSlots is one of the names, and then set the slots to the
+// values. String values are rendered as templates with TemplateData, other
+// values are saved with their types. It returns nil if there are no actions
+func NewSlotActions(clear []string, set map[string]interface{}) (*SlotActions, error) {
+	if len(clear) == 0 && len(set) == 0 {
+		return nil, nil
+	}
+
+	actions := &SlotActions{}
+	for _, name := range clear {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			return nil, fmt.Errorf("clear_slots has an empty slot name")
+		case ClearAllSlots:
+			actions.clearAll = true
+		default:
+			actions.clear = append(actions.clear, name)
+		}
+	}
+
+	for name, value := range set {
+		setter := slotSetter{name: strings.TrimSpace(name), value: yamlToJSON(value)}
+		if setter.name == "" {
+			return nil, fmt.Errorf("set_slots has an empty slot name")
+		}
+
+		if text, ok := value.(string); ok {
+			tmpl, err := newTemplate(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid set_slots template '%s': %w", text, err)
+			}
+			setter.template = tmpl
+		} else if _, err := marshalSlot(setter.value); err != nil {
+			return nil, fmt.Errorf("invalid set_slots value for '%s': %w", setter.name, err)
+		}
+
+		actions.set = append(actions.set, setter)
+	}
+	sort.Slice(actions.set, func(i, j int) bool { return actions.set[i].name < actions.set[j].name })
+
+	return actions, nil
+}
+
+// yamlToJSON converts the maps decoded from YAML, which can
+// have keys of any type, into maps that can be encoded as JSON
+func yamlToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[fmt.Sprint(key)] = yamlToJSON(elem)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = yamlToJSON(elem)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, elem := range v {
+			s[i] = yamlToJSON(elem)
+		}
+		return s
+	default:
+		return value
+	}
+}
+
+// apply clears and sets the slots of the FSM. The templates are rendered
+// with the slots before any of them is changed, and if one of them fails
+// the FSM is left unchanged
+func (a *SlotActions) apply(m *FSM, conversation Conversation) error {
+	if a == nil {
+		return nil
+	}
+
+	data := m.templateData(conversation)
+	values := make([]interface{}, len(a.set))
+	for i, setter := range a.set {
+		if setter.template == nil {
+			values[i] = setter.value
+			continue
+		}
+		var buf bytes.Buffer
+		if err := setter.template.Execute(&buf, data); err != nil {
+			return err
+		}
+		values[i] = buf.String()
+	}
+
+	candidate := m.cloneSlots()
+	if a.clearAll {
+		for name := range m.Slots {
+			candidate.ClearSlot(name)
+		}
+	}
+	for _, name := range a.clear {
+		candidate.ClearSlot(name)
+	}
+	for i, setter := range a.set {
+		if err := candidate.SetSlot(setter.name, values[i]); err != nil {
+			return err
+		}
+	}
+
+	m.Slots, m.Values, m.SlotHistory = candidate.Slots, candidate.Values, candidate.SlotHistory
+
+	return nil
+}
+
+// SetSlot saves a value to a slot, keeping its previous value in the slot
+// history. Strings are saved to Slots as they are. Other values, such as
+// numbers, booleans, lists or objects, are saved as JSON to Values and as
+// JSON text to Slots, so they can still be read as strings
+func (m *FSM) SetSlot(name string, value interface{}) error {
+	raw, err := marshalSlot(value)
+	if err != nil {
+		return err
+	}
+
+	m.pushSlotHistory(name)
+
+	if m.Slots == nil {
+		m.Slots = make(map[string]string)
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		m.Slots[name] = s
+		delete(m.Values, name)
+		return nil
+	}
+
+	if m.Values == nil {
+		m.Values = make(map[string]json.RawMessage)
+	}
+	m.Slots[name] = string(raw)
+	m.Values[name] = raw
+
+	return nil
+}
+
+// ClearSlot removes a slot, keeping its value in the slot history
+func (m *FSM) ClearSlot(name string) {
+	m.pushSlotHistory(name)
+	delete(m.Slots, name)
+	delete(m.Values, name)
+}
+
+// SlotValue returns the value of a slot with its type: string, float64,
+// bool, []interface{} or map[string]interface{}. If the slot was changed
+// as a string in Slots, for example by an extension that only knows about
+// strings, the string is returned
+func (m *FSM) SlotValue(name string) (interface{}, bool) {
+	raw, ok := m.rawSlot(name)
+	if !ok {
+		return nil, false
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return m.Slots[name], true
+	}
+
+	return value, true
+}
+
+// SlotValues returns the values of all the slots with their types, see SlotValue
+func (m *FSM) SlotValues() map[string]interface{} {
+	values := make(map[string]interface{}, len(m.Slots))
+	for name := range m.Slots {
+		values[name], _ = m.SlotValue(name)
+	}
+	return values
+}
+
+// PreviousSlotValues returns the previous values of a slot with their
+// types, oldest first. At most SlotHistorySize values are kept
+func (m *FSM) PreviousSlotValues(name string) []interface{} {
+	history := m.SlotHistory[name]
+	values := make([]interface{}, 0, len(history))
+	for _, raw := range history {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err == nil {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// KeepSlotHistory adds the slots changed since the previous FSM to the slot
+// history. It is used after an extension changes the FSM. Extensions that do
+// not know about typed values return Values and SlotHistory empty and change
+// the slots as strings, so those of the previous FSM are kept in that case
+func (m *FSM) KeepSlotHistory(previous *FSM) {
+	kept := previous.cloneSlots()
+	if m.Values == nil {
+		m.Values = kept.Values
+	}
+	if m.SlotHistory == nil {
+		m.SlotHistory = kept.SlotHistory
+	}
+
+	for name := range previous.Slots {
+		raw, _ := previous.rawSlot(name)
+		if current, ok := m.rawSlot(name); ok && bytes.Equal(current, raw) {
+			continue
+		}
+
+		// The extension kept the history itself
+		history := m.SlotHistory[name]
+		if len(history) > 0 && bytes.Equal(history[len(history)-1], raw) {
+			continue
+		}
+
+		m.pushRawSlotHistory(name, raw)
+	}
+}
+
+// rawSlot returns the JSON value of a slot, the value in Values
+// is only used if it matches the string in Slots
+func (m *FSM) rawSlot(name string) (json.RawMessage, bool) {
+	s, ok := m.Slots[name]
+	if !ok {
+		return nil, false
+	}
+
+	if raw, ok := m.Values[name]; ok && string(raw) == s {
+		return raw, true
+	}
+
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return nil, false
+	}
+	return raw, true
+}
+
+// pushSlotHistory adds the current value of a slot to its history
+func (m *FSM) pushSlotHistory(name string) {
+	if raw, ok := m.rawSlot(name); ok {
+		m.pushRawSlotHistory(name, raw)
+	}
+}
+
+// pushRawSlotHistory adds a JSON value to the history of a slot
+func (m *FSM) pushRawSlotHistory(name string, raw json.RawMessage) {
+	if m.SlotHistory == nil {
+		m.SlotHistory = make(map[string][]json.RawMessage)
+	}
+
+	history := append(m.SlotHistory[name], raw)
+	if len(history) > SlotHistorySize {
+		history = history[len(history)-SlotHistorySize:]
+	}
+	m.SlotHistory[name] = history
+}
+
+// cloneSlots returns a copy of the FSM whose slots, values
+// and slot history can be changed without changing the FSM
+func (m *FSM) cloneSlots() *FSM {
+	clone := &FSM{
+		State: m.State,
+		Slots: make(map[string]string, len(m.Slots)+1),
+	}
+	for k, v := range m.Slots {
+		clone.Slots[k] = v
+	}
+	if m.Values != nil {
+		clone.Values = make(map[string]json.RawMessage, len(m.Values))
+		for k, v := range m.Values {
+			clone.Values[k] = v
+		}
+	}
+	if m.SlotHistory != nil {
+		clone.SlotHistory = make(map[string][]json.RawMessage, len(m.SlotHistory))
+		for k, v := range m.SlotHistory {
+			clone.SlotHistory[k] = v[:len(v):len(v)]
+		}
+	}
+	return clone
+}
+
+// marshalSlot encodes a slot value as compact JSON
+func marshalSlot(value interface{}) (json.RawMessage, error) {
+	if raw, ok := value.(json.RawMessage); ok {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, err
+		}
+		return compact.Bytes(), nil
+	}
+
+	return json.Marshal(value)
+}
@@ -0,0 +1,266 @@
+package fsm_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/jaimeteb/chatto/fsm"
+)
+
+func TestFSM_SetSlot(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		wantSlot  string
+		wantValue interface{}
+	}{
+		{name: "string", value: "pepperoni", wantSlot: "pepperoni", wantValue: "pepperoni"},
+		{name: "number", value: 2.5, wantSlot: "2.5", wantValue: 2.5},
+		{name: "bool", value: true, wantSlot: "true", wantValue: true},
+		{name: "list", value: []string{"ham", "cheese"}, wantSlot: `["ham","cheese"]`, wantValue: []interface{}{"ham", "cheese"}},
+		{name: "object", value: map[string]int{"large": 2}, wantSlot: `{"large":2}`, wantValue: map[string]interface{}{"large": 2.0}},
+		{name: "raw json", value: json.RawMessage(`[ 1, 2 ]`), wantSlot: `[1,2]`, wantValue: []interface{}{1.0, 2.0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := fsm.NewFSM()
+			if err := m.SetSlot("pizza", tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Slots["pizza"]; got != tt.wantSlot {
+				t.Errorf("FSM.Slots[pizza] = %v, want %v", got, tt.wantSlot)
+			}
+			if got, ok := m.SlotValue("pizza"); !ok || !reflect.DeepEqual(got, tt.wantValue) {
+				t.Errorf("FSM.SlotValue(pizza) = %#v, want %#v", got, tt.wantValue)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		m := fsm.NewFSM()
+		if err := m.SetSlot("pizza", func() {}); err == nil {
+			t.Error("FSM.SetSlot() error = nil, want an error")
+		}
+		if _, ok := m.SlotValue("pizza"); ok {
+			t.Error("FSM.SlotValue(pizza) ok = true, want false")
+		}
+	})
+}
+
+func TestFSM_SlotValueChangedString(t *testing.T) {
+	m := fsm.NewFSM()
+	if err := m.SetSlot("count", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Extensions that only know about strings change Slots
+	m.Slots["count"] = "three"
+	if got, _ := m.SlotValue("count"); got != "three" {
+		t.Errorf("FSM.SlotValue(count) = %#v, want %#v", got, "three")
+	}
+	if got := m.SlotValues(); !reflect.DeepEqual(got, map[string]interface{}{"count": "three"}) {
+		t.Errorf("FSM.SlotValues() = %#v", got)
+	}
+}
+
+func TestFSM_PreviousSlotValues(t *testing.T) {
+	m := fsm.NewFSM()
+	for i := 0; i < fsm.SlotHistorySize+2; i++ {
+		if err := m.SetSlot("count", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetSlot("count", "many"); err != nil {
+		t.Fatal(err)
+	}
+	m.ClearSlot("count")
+
+	want := make([]interface{}, 0, fsm.SlotHistorySize)
+	for i := 3; i < fsm.SlotHistorySize+2; i++ {
+		want = append(want, float64(i))
+	}
+	want = append(want, "many")
+
+	if got := m.PreviousSlotValues("count"); !reflect.DeepEqual(got, want) {
+		t.Errorf("FSM.PreviousSlotValues(count) = %v, want %v", got, want)
+	}
+	if _, ok := m.SlotValue("count"); ok {
+		t.Error("FSM.SlotValue(count) ok = true, want false")
+	}
+}
+
+func TestFSM_ExecuteCmdTypedSlots(t *testing.T) {
+	fsmDomain := newDomain([]fsm.Transition{
+		{
+			From:    []string{"initial"},
+			Into:    "initial",
+			Command: "any",
+			Slot:    fsm.Slot{Name: "count", Mode: "number"},
+			Answers: []fsm.Answer{{Text: "{{ .Values.count }} pizzas, {{ len .Values }} slot."}},
+		},
+	})
+
+	m := fsm.NewFSM()
+	for _, text := range []string{"two pizzas", "make it 3"} {
+		if _, _, err := m.ExecuteCmd("any", text, fsmDomain, fsm.Conversation{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, _ := m.SlotValue("count"); got != 3.0 {
+		t.Errorf("FSM.SlotValue(count) = %#v, want %#v", got, 3.0)
+	}
+	if got := m.PreviousSlotValues("count"); !reflect.DeepEqual(got, []interface{}{2.0}) {
+		t.Errorf("FSM.PreviousSlotValues(count) = %v, want %v", got, []interface{}{2.0})
+	}
+
+	answers, _, err := m.ExecuteCmd("any", "10", fsmDomain, fsm.Conversation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "10 pizzas, 1 slot."; len(answers) != 1 || answers[0].Text != want {
+		t.Errorf("FSM.ExecuteCmd() answers = %v, want %v", answers, want)
+	}
+}
+
+func TestFSM_KeepSlotHistory(t *testing.T) {
+	newPrevious := func() *fsm.FSM {
+		m := fsm.NewFSM()
+		for _, name := range []string{"size", "count"} {
+			if err := m.SetSlot(name, "unknown"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.SetSlot("count", 2); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	t.Run("extension with strings", func(t *testing.T) {
+		previous := newPrevious()
+		m := &fsm.FSM{Slots: map[string]string{"size": "large", "count": "2"}}
+		m.KeepSlotHistory(previous)
+
+		if got, _ := m.SlotValue("count"); got != 2.0 {
+			t.Errorf("FSM.SlotValue(count) = %#v, want %#v", got, 2.0)
+		}
+		if got := m.PreviousSlotValues("size"); !reflect.DeepEqual(got, []interface{}{"unknown"}) {
+			t.Errorf("FSM.PreviousSlotValues(size) = %v, want %v", got, []interface{}{"unknown"})
+		}
+		if got := m.PreviousSlotValues("count"); !reflect.DeepEqual(got, []interface{}{"unknown"}) {
+			t.Errorf("FSM.PreviousSlotValues(count) = %v, want %v", got, []interface{}{"unknown"})
+		}
+	})
+
+	t.Run("extension with typed values", func(t *testing.T) {
+		previous := newPrevious()
+		m := newPrevious()
+		if err := m.SetSlot("count", 3); err != nil {
+			t.Fatal(err)
+		}
+		m.KeepSlotHistory(previous)
+
+		if got := m.PreviousSlotValues("count"); !reflect.DeepEqual(got, []interface{}{"unknown", 2.0}) {
+			t.Errorf("FSM.PreviousSlotValues(count) = %v, want %v", got, []interface{}{"unknown", 2.0})
+		}
+		if previous.Slots["count"] != "2" {
+			t.Errorf("previous FSM.Slots[count] = %v, want %v", previous.Slots["count"], "2")
+		}
+	})
+}
+
+func TestFSM_ExecuteCmdSlotActions(t *testing.T) {
+	fsmDomain := newDomain([]fsm.Transition{
+		{
+			From:       []string{"initial"},
+			Into:       "playing",
+			Command:    "play",
+			ClearSlots: []string{"answer", "score"},
+			SetSlots: map[string]interface{}{
+				"score":  0,
+				"player": "{{ .Sender | title }}",
+				"prefs":  map[interface{}]interface{}{"level": "easy"},
+			},
+			Answers: []fsm.Answer{{Text: "Good luck {{ .Slots.player }}, score {{ .Slots.score }}."}},
+		},
+		{
+			From:       []string{"playing"},
+			Into:       "initial",
+			Command:    "restart",
+			ClearSlots: []string{"all"},
+			SetSlots:   map[string]interface{}{"last_score": "{{ .Slots.score }}"},
+			Answers:    []fsm.Answer{{Text: "Last score {{ .Slots.last_score }}."}},
+		},
+	})
+
+	m := fsm.NewFSM()
+	m.Slots = map[string]string{"answer": "b", "score": "3", "other": "x"}
+
+	answers, _, err := m.ExecuteCmd("play", "play", fsmDomain, fsm.Conversation{Sender: "jane"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Good luck Jane, score 0."; len(answers) != 1 || answers[0].Text != want {
+		t.Errorf("FSM.ExecuteCmd(play) answers = %v, want %v", answers, want)
+	}
+	wantSlots := map[string]string{"score": "0", "player": "Jane", "prefs": `{"level":"easy"}`, "other": "x"}
+	if !reflect.DeepEqual(m.Slots, wantSlots) {
+		t.Errorf("FSM.Slots = %v, want %v", m.Slots, wantSlots)
+	}
+	if got, _ := m.SlotValue("score"); got != 0.0 {
+		t.Errorf("FSM.SlotValue(score) = %#v, want %#v", got, 0.0)
+	}
+	if got := m.PreviousSlotValues("answer"); !reflect.DeepEqual(got, []interface{}{"b"}) {
+		t.Errorf("FSM.PreviousSlotValues(answer) = %v, want %v", got, []interface{}{"b"})
+	}
+
+	answers, _, err = m.ExecuteCmd("restart", "restart", fsmDomain, fsm.Conversation{Sender: "jane"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Last score 0."; len(answers) != 1 || answers[0].Text != want {
+		t.Errorf("FSM.ExecuteCmd(restart) answers = %v, want %v", answers, want)
+	}
+	if want := map[string]string{"last_score": "0"}; !reflect.DeepEqual(m.Slots, want) {
+		t.Errorf("FSM.Slots = %v, want %v", m.Slots, want)
+	}
+}
+
+func TestFSM_TransitionStateSlotActionsFail(t *testing.T) {
+	slotActions, err := fsm.NewSlotActions([]string{"all"}, map[string]interface{}{"name": "{{ .Slots.name.first }}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := fsm.NewFSM()
+	m.Slots["name"] = "jane"
+	transitionFunc := fsm.NewTransitionFunc(1, nil, nil)
+	if _, _, err := m.TransitionState(transitionFunc, slotActions, fsm.Defaults{}, fsm.Conversation{}); err == nil {
+		t.Error("FSM.TransitionState() error = nil, want an error")
+	}
+	if m.State != fsm.StateInitial || m.Slots["name"] != "jane" {
+		t.Errorf("FSM = %v %v, want it unchanged", m.State, m.Slots)
+	}
+}
+
+func TestNewSlotActions_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		clear []string
+		set   map[string]interface{}
+	}{
+		{name: "empty clear name", clear: []string{" "}},
+		{name: "empty set name", set: map[string]interface{}{"": "x"}},
+		{name: "invalid template", set: map[string]interface{}{"name": "{{ .Slots.name"}},
+		{name: "invalid value", set: map[string]interface{}{"name": func() {}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := fsm.NewSlotActions(tt.clear, tt.set); err == nil {
+				t.Error("NewSlotActions() error = nil, want an error")
+			}
+		})
+	}
+}
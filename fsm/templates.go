@@ -0,0 +1,121 @@
+package fsm
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Conversation identifies who the FSM is talking to
+type Conversation struct {
+	Sender  string
+	Channel string
+	Bot     string
+}
+
+// TemplateData is the data available when rendering the answers of a
+// transition, for example {{.Slots.name}} or {{.Sender}}. Values has
+// the slots with their types, for example {{join ", " .Values.toppings}}
+type TemplateData struct {
+	Conversation
+	Slots  map[string]string
+	Values map[string]interface{}
+}
+
+// templateData returns the data to render answers with for the FSM
+func (m *FSM) templateData(conversation Conversation) *TemplateData {
+	return &TemplateData{Conversation: conversation, Slots: m.Slots, Values: m.SlotValues()}
+}
+
+// templateFuncs are the helper functions available to answer templates
+var templateFuncs = template.FuncMap{
+	"default": templateDefault,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"title":   templateTitle,
+	"trim":    strings.TrimSpace,
+	"join":    templateJoin,
+}
+
+// templateDefault returns def if the value is empty
+func templateDefault(def string, value interface{}) string {
+	if value == nil {
+		return def
+	}
+	if s := fmt.Sprint(value); strings.TrimSpace(s) != "" {
+		return s
+	}
+	return def
+}
+
+// templateTitle capitalizes the first letter of every word
+func templateTitle(s string) string {
+	runes := []rune(s)
+	for i := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToTitle(runes[i])
+		}
+	}
+	return string(runes)
+}
+
+// templateJoin joins the elements of a list with a separator
+func templateJoin(sep string, list interface{}) string {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Sprint(list)
+	}
+
+	elems := make([]string, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		elems[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(elems, sep)
+}
+
+// newTemplate parses an answer template, a slot that is not
+// saved renders as an empty string instead of "<no value>"
+func newTemplate(text string) (*template.Template, error) {
+	return template.New("answer").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// compile parses the text and image templates of the Answer, a literal
+// "{{" is written as {{"{{"}} in the templates
+func (a *Answer) compile() error {
+	var err error
+
+	if a.textTemplate, err = newTemplate(a.Text); err != nil {
+		return fmt.Errorf("invalid text template '%s' (write {{\"{{\"}} for a literal {{): %w", a.Text, err)
+	}
+	if a.imageTemplate, err = newTemplate(a.Image); err != nil {
+		return fmt.Errorf("invalid image template '%s' (write {{\"{{\"}} for a literal {{): %w", a.Image, err)
+	}
+
+	return nil
+}
+
+// Render executes the text and image templates of the Answer
+func (a Answer) Render(data *TemplateData) (text, image string, err error) {
+	if a.textTemplate == nil || a.imageTemplate == nil {
+		if err := a.compile(); err != nil {
+			return "", "", err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := a.textTemplate.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	text = buf.String()
+
+	buf.Reset()
+	if err := a.imageTemplate.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	image = buf.String()
+
+	return text, image, nil
+}
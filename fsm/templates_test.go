@@ -0,0 +1,147 @@
+package fsm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaimeteb/chatto/fsm"
+)
+
+func TestAnswer_Render(t *testing.T) {
+	data := &fsm.TemplateData{
+		Conversation: fsm.Conversation{
+			Sender:  "42",
+			Channel: "rest",
+			Bot:     "chatto",
+		},
+		Slots: map[string]string{
+			"name":  "jaime teb",
+			"blank": "",
+		},
+		Values: map[string]interface{}{
+			"name": "jaime teb",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		answer    fsm.Answer
+		wantText  string
+		wantImage string
+		wantErr   bool
+	}{
+		{
+			name:     "static text",
+			answer:   fsm.Answer{Text: "Hello!"},
+			wantText: "Hello!",
+		},
+		{
+			name:     "slot and conversation",
+			answer:   fsm.Answer{Text: "I'm {{.Bot}}, nice to meet you {{.Slots.name}} on {{.Channel}}"},
+			wantText: "I'm chatto, nice to meet you jaime teb on rest",
+		},
+		{
+			name:     "helpers",
+			answer:   fsm.Answer{Text: "{{.Slots.name | title}} {{upper .Sender}} {{.Slots.missing | default \"friend\"}} {{default \"nobody\" .Slots.blank}}"},
+			wantText: "Jaime Teb 42 friend nobody",
+		},
+		{
+			name:     "missing slot",
+			answer:   fsm.Answer{Text: "Hello {{.Slots.missing}}!"},
+			wantText: "Hello !",
+		},
+		{
+			name:     "missing value",
+			answer:   fsm.Answer{Text: "Hello {{default \"friend\" .Values.missing}}!"},
+			wantText: "Hello friend!",
+		},
+		{
+			name:      "image",
+			answer:    fsm.Answer{Text: "{{.Slots.name | upper}}", Image: "https://example.com/{{.Sender}}.png"},
+			wantText:  "JAIME TEB",
+			wantImage: "https://example.com/42.png",
+		},
+		{
+			name:    "invalid template",
+			answer:  fsm.Answer{Text: "{{.Slots.name"},
+			wantErr: true,
+		},
+		{
+			name:    "literal braces",
+			answer:  fsm.Answer{Text: "Write {{ name }} in your answers"},
+			wantErr: true,
+		},
+		{
+			name:     "escaped braces",
+			answer:   fsm.Answer{Text: "Write {{\"{{\"}} name }} in your answers, {{\"{{\"}}.Slots.name}} is {{.Slots.name}}"},
+			wantText: "Write {{ name }} in your answers, {{.Slots.name}} is jaime teb",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotText, gotImage, err := tt.answer.Render(data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Answer.Render() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotText != tt.wantText {
+				t.Errorf("Answer.Render() gotText = %v, want %v", gotText, tt.wantText)
+			}
+			if gotImage != tt.wantImage {
+				t.Errorf("Answer.Render() gotImage = %v, want %v", gotImage, tt.wantImage)
+			}
+		})
+	}
+}
+
+func TestNewDomain_InvalidTemplate(t *testing.T) {
+	transitions := []fsm.Transition{
+		{
+			From:    []string{"initial"},
+			Into:    "greeted",
+			Command: "greet",
+			Answers: []fsm.Answer{{Text: "Hello {{.Slots.name"}},
+		},
+	}
+
+	if _, err := fsm.NewDomain(transitions, fsm.Defaults{}); err == nil {
+		t.Errorf("NewDomain() error = %v, want an error", err)
+	}
+
+	// An answer with a literal {{ is a template too, and needs the braces escaped
+	transitions[0].Answers = []fsm.Answer{{Text: "Use {{ name }} in the card"}}
+	if _, err := fsm.NewDomain(transitions, fsm.Defaults{}); err == nil || !strings.Contains(err.Error(), `{{"{{"}}`) {
+		t.Errorf("NewDomain() error = %v, want an error with the escape", err)
+	}
+	transitions[0].Answers = []fsm.Answer{{Text: `Use {{"{{"}} name }} in the card`}}
+	if _, err := fsm.NewDomain(transitions, fsm.Defaults{}); err != nil {
+		t.Errorf("NewDomain() error = %v, want no error", err)
+	}
+}
+
+func TestFSM_ExecuteCmdTemplate(t *testing.T) {
+	fsmDomain, err := fsm.NewDomain([]fsm.Transition{
+		{
+			From:    []string{"ask_name"},
+			Into:    "initial",
+			Command: "any",
+			Slot:    fsm.Slot{Name: "name", Mode: "whole_text"},
+			Answers: []fsm.Answer{{Text: "Nice to meet you, {{.Slots.name}}. I'm {{.Bot}}."}},
+		},
+	}, fsm.Defaults{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &fsm.FSM{State: fsmDomain.StateTable["ask_name"], Slots: map[string]string{}}
+
+	answers, _, err := m.ExecuteCmd("greet", "Jaime", fsmDomain, fsm.Conversation{Bot: "chatto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Nice to meet you, Jaime. I'm chatto."
+	if len(answers) != 1 || answers[0].Text != want {
+		t.Errorf("FSM.ExecuteCmd() = %v, want %v", answers, want)
+	}
+}
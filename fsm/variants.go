@@ -0,0 +1,117 @@
+package fsm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Selection modes for answer variants
+const (
+	SelectionRandom     = "random"
+	SelectionRoundRobin = "round_robin"
+)
+
+// rotationKey returns the key of the turns of the variants of a transition in
+// FSM.Rotations. It does not depend on the position of the transition, so
+// adding or moving transitions keeps the turns of the saved FSMs
+func rotationKey(transition *Transition, flow string) string {
+	key := strings.TrimSpace(transition.ID)
+	if key == "" {
+		from := make([]string, len(transition.From))
+		for i := range transition.From {
+			from[i] = strings.TrimSpace(transition.From[i])
+		}
+		sort.Strings(from)
+
+		h := fnv.New32a()
+		for _, variant := range transition.Variants {
+			for _, answer := range variant.Answers {
+				fmt.Fprintf(h, "%q%q", answer.Text, answer.Image)
+			}
+			h.Write([]byte{0})
+		}
+
+		key = fmt.Sprintf("%s:%s:%08x", strings.Join(from, ","), strings.TrimSpace(transition.Command), h.Sum32())
+	}
+
+	if flow != "" {
+		key = flow + ":" + key
+	}
+	return key
+}
+
+// Variant is an alternative group of answers for a transition
+type Variant struct {
+	Answers []Answer `yaml:"answers"`
+}
+
+// Random is a source of random numbers used to pick answer
+// variants, it is safe for concurrent use
+type Random struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// NewRandom returns a Random seeded with the current time
+func NewRandom() *Random {
+	return &Random{r: rand.New(rand.NewSource(time.Now().UnixNano()))} //nolint:gosec // answer variants do not need a secure source
+}
+
+// Seed makes the Random deterministic, which is useful for tests
+func (r *Random) Seed(seed int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.r = rand.New(rand.NewSource(seed)) //nolint:gosec // answer variants do not need a secure source
+}
+
+// Intn returns a random number in [0,n)
+func (r *Random) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+// NewVariantsTransitionFunc generates a new transition function that will
+// transition the FSM into the specified state and return the extension and
+// one of the variants, picked at random or in turns for every FSM
+func NewVariantsTransitionFunc(state int, extension *Extension, key, selection string, variants [][]Answer, random *Random) TransitionFunc {
+	return func(m *FSM) (*Extension, []Answer) {
+		m.State = state
+
+		if selection == SelectionRoundRobin {
+			if m.Rotations == nil {
+				m.Rotations = make(map[string]int)
+			}
+			i := m.Rotations[key] % len(variants)
+			m.Rotations[key] = (i + 1) % len(variants)
+			return extension, variants[i]
+		}
+
+		return extension, variants[random.Intn(len(variants))]
+	}
+}
+
+// variantsSelection validates the selection mode of the variants of a transition
+func variantsSelection(transition *Transition) (string, error) {
+	if len(transition.Variants) == 0 {
+		return "", nil
+	}
+
+	if len(transition.Answers) > 0 {
+		return "", fmt.Errorf("answers and variants cannot be used together")
+	}
+
+	switch selection := strings.TrimSpace(transition.Selection); selection {
+	case "", SelectionRandom:
+		return SelectionRandom, nil
+	case SelectionRoundRobin:
+		return SelectionRoundRobin, nil
+	default:
+		return "", fmt.Errorf("unknown variants selection '%s'", selection)
+	}
+}
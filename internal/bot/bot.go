@@ -1,49 +1,212 @@
 package bot
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/channels"
 	"github.com/jaimeteb/chatto/internal/channels/messages"
-	"github.com/jaimeteb/chatto/internal/clf"
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
 	"github.com/jaimeteb/chatto/internal/extension"
 	store "github.com/jaimeteb/chatto/internal/fsm/store"
+	"github.com/jaimeteb/chatto/internal/history"
+	"github.com/jaimeteb/chatto/internal/logger"
+	"github.com/jaimeteb/chatto/internal/metrics"
+	"github.com/jaimeteb/chatto/internal/tracing"
 	"github.com/jaimeteb/chatto/query"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Bot models a bot with a Classifier and an FSM
+// Bot models a bot with a Classifier and an FSM, which are
+// kept in a Bundle so they can be reloaded at any time
 type Bot struct {
 	Name       string
 	Store      store.Store
-	Domain     *fsm.Domain
-	Classifier *clf.Classifier
 	Extensions extension.ServerMap
 	Channels   *channels.Channels
 	Config     *Config
 	Router     *mux.Router
+
+	bundle     atomic.Pointer[Bundle]
+	reloadMu   sync.Mutex
+	lastReload *ReloadResult
+	locks      senderLocks
+
+	shutdownTracing func(context.Context) error
+}
+
+// maxAttempts is the number of times a turn is answered
+// before giving up because the conversation keeps changing
+const maxAttempts = 5
+
+// Answer takes a user input and executes a transition on the FSM if possible.
+// Turns of the same sender are answered one at a time, and the FSM is only
+// saved if no other replica saved it during the turn, otherwise the turn is
+// answered again with the new FSM, unless an extension was already executed,
+// in which case its answers are returned and the FSM is not saved.
+// If the store fails, the turn is answered with the error message. The turn
+// is logged with the turn ID of ctx, a new one is generated if ctx has none
+func (b *Bot) Answer(ctx context.Context, receiveMsg *messages.Receive) (answers []query.Answer, err error) {
+	sender := receiveMsg.Conversation()
+	metrics.Messages.WithLabelValues(receiveMsg.Channel).Inc()
+
+	ctx = turnContext(ctx, receiveMsg)
+	ctx, span := tracing.Start(ctx, "Bot.Answer", trace.WithAttributes(
+		attribute.String("chatto.channel", receiveMsg.Channel),
+		attribute.String("chatto.turn_id", logger.TurnID(ctx)),
+	))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	unlock := b.locks.lock(sender)
+	defer unlock()
+
+	// The whole turn is answered with the same Bundle, even if it is reloaded
+	bundle := b.Bundle()
+
+	turn := history.NewTurn(sender, receiveMsg.Channel, receiveMsg.Question)
+	defer func() {
+		if err != nil {
+			return
+		}
+		turn.Answers = answers
+		turn.AnsweredAt = time.Now()
+		if err := b.Store.AppendTurn(ctx, sender, turn); err != nil {
+			logger.Entry(ctx).Errorf("Cannot save the turn of sender %s: %v", sender, err)
+		}
+	}()
+
+	// Only the last attempt is counted in the metrics
+	var out outcome
+	defer func() {
+		out.record(turn)
+	}()
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		machine, version, storeErr := b.Store.GetVersion(ctx, sender)
+		if storeErr != nil {
+			return b.storeError(ctx, bundle, sender, true, storeErr), nil
+		}
+
+		span.SetAttributes(attribute.Int("chatto.attempts", attempt))
+
+		var save *fsm.FSM
+		out = outcome{}
+		answers, save, err = b.answer(ctx, bundle, receiveMsg, turn, machine, &out)
+		if save == nil {
+			return answers, err
+		}
+
+		saved, storeErr := b.Store.CompareAndSet(ctx, sender, save, version)
+		if storeErr != nil {
+			return b.storeError(ctx, bundle, sender, machine != nil, storeErr), nil
+		}
+		if saved {
+			return answers, err
+		}
+
+		// The extension may have side effects, so it is not executed again:
+		// its answers are sent and the FSM of the turn is not saved
+		if out.extension {
+			logger.Entry(ctx).Warnf("FSM | Conversation with sender %s changed during the turn after extension %s was executed, the FSM of the turn was not saved", sender, turn.Extension.Name)
+			return answers, err
+		}
+
+		logger.Entry(ctx).Debugf("FSM | Conversation with sender %s changed during the turn, attempt %d", sender, attempt)
+	}
+
+	return nil, &ErrConflict{Sender: sender}
+}
+
+// storeError logs a failure of the store and returns the answers of the turn
+func (b *Bot) storeError(ctx context.Context, bundle *Bundle, sender string, isExistingConversation bool, err error) []query.Answer {
+	logger.Entry(ctx).Errorf("Cannot answer sender %s, the store failed: %v", sender, err)
+
+	if b.Config.ShouldReplyError(isExistingConversation) {
+		return []query.Answer{{Text: bundle.Domain.DefaultMessages.Error}}
+	}
+	return []query.Answer{}
+}
+
+// outcome of an attempt at answering a turn, recorded in the metrics
+// once the turn is answered
+type outcome struct {
+	predicted bool
+	fallback  string
+	// transitioned is true if a transition was executed
+	transitioned bool
+	// extension is true if an extension was executed
+	extension bool
+}
+
+// record observes the outcome of the turn in the metrics
+func (o outcome) record(turn *history.Turn) {
+	if o.predicted {
+		metrics.ObservePrediction(turn.Command, turn.Probability)
+	}
+	if o.fallback != "" {
+		metrics.Fallbacks.WithLabelValues(o.fallback).Inc()
+	}
+	if o.transitioned {
+		metrics.Transitions.WithLabelValues(turn.PreviousState, turn.NextState).Inc()
+	}
 }
 
-// Answer takes a user input and executes a transition on the FSM if possible
-func (b *Bot) Answer(receiveMsg *messages.Receive) ([]query.Answer, error) {
+// answer answers a turn with the FSM of the sender, nil if it has none. It
+// returns the FSM to save, which is nil if the FSM did not change
+func (b *Bot) answer(ctx context.Context, bundle *Bundle, receiveMsg *messages.Receive, turn *history.Turn, machine *fsm.FSM, out *outcome) ([]query.Answer, *fsm.FSM, error) {
 	sender := receiveMsg.Conversation()
+	entry := logger.Entry(ctx)
 
-	isExistingConversation := b.Store.Exists(sender)
+	isExistingConversation := machine != nil
+	changed := !isExistingConversation
 
 	if !isExistingConversation {
-		b.Store.Set(sender, fsm.NewFSM())
-		log.Debugf("FSM | New conversation with sender %s", sender)
+		machine = fsm.NewFSM()
+		entry.Debugf("FSM | New conversation with sender %s", sender)
 	} else {
-		log.Debugf("FSM | Existing conversation with sender %s", sender)
+		entry.Debugf("FSM | Existing conversation with sender %s", sender)
 	}
 
-	cmd, _ := b.Classifier.Model.Predict(receiveMsg.Question.Text, b.Classifier.Pipeline)
+	previousState := machine.State
+	turn.PreviousState = bundle.Domain.StateTable.Name(previousState)
+	turn.NextState = turn.PreviousState
+	turn.Extension = nil
 
-	machine := b.Store.Get(sender)
+	// Resolve the user's choice if the bot asked which command they meant,
+	// the chosen command is executed with the text that was ambiguous
+	text := receiveMsg.Question.Text
+	changed = changed || machine.Pending != nil
+	cmd, resolvedText, resolved := machine.ResolveDisambiguation(text)
 
-	previousState := machine.State
+	var prob float32 = 1
+	if resolved {
+		text = resolvedText
+		entry.Debugf("FSM | Disambiguation resolved to command '%s'", cmd)
+	} else {
+		var ranking prediction.Ranking
+		cmd, prob, ranking = bundle.Classifier.Classify(ctx, text)
+		if question, ok := b.disambiguate(ctx, bundle, machine, text, ranking); ok {
+			return []query.Answer{{Text: question}}, machine, nil
+		}
+		out.predicted = true
+		entry.Debugf("CLF | Predicted command '%s' with a probability of %.2f", cmd, prob)
+	}
+	turn.Command, turn.Probability = cmd, prob
+
+	// The FSM as it is saved if the turn fails
+	var unchanged *fsm.FSM
+	if changed {
+		unchanged = machine.Clone()
+	}
 
 	// Set existing conversation to false if in the initial state
 	// because initial state means this is a new conversation
@@ -51,42 +214,113 @@ func (b *Bot) Answer(receiveMsg *messages.Receive) ([]query.Answer, error) {
 		isExistingConversation = false
 	}
 
-	answers, ext, err := machine.ExecuteCmd(cmd, receiveMsg.Question.Text, b.Domain)
+	conversation := fsm.Conversation{Sender: receiveMsg.Question.Sender, Channel: receiveMsg.Channel, Bot: b.Name}
+
+	answers, ext, err := machine.ExecuteCmd(cmd, text, bundle.Domain, conversation)
 	if err != nil {
 		switch e := err.(type) {
 		case *fsm.ErrUnsureCommand:
+			out.fallback = metrics.FallbackUnsure
 			if b.Config.ShouldReplyUnsure(isExistingConversation) {
-				return []query.Answer{{Text: e.Error()}}, nil
+				return []query.Answer{{Text: e.Error()}}, unchanged, nil
 			}
 
-			return []query.Answer{}, nil
+			return []query.Answer{}, unchanged, nil
 		case *fsm.ErrUnknownCommand:
+			out.fallback = metrics.FallbackUnknown
 			if b.Config.ShouldReplyUnknown(isExistingConversation) {
-				return []query.Answer{{Text: e.Error()}}, nil
+				return []query.Answer{{Text: e.Error()}}, unchanged, nil
 			}
 
-			return []query.Answer{}, nil
+			return []query.Answer{}, unchanged, nil
 		default:
-			return nil, err
+			return nil, unchanged, err
 		}
 	}
 
-	log.Debugf("FSM | State transitioned from '%d' -> '%d'", previousState, machine.State)
+	entry.Debugf("FSM | State transitioned from '%d' -> '%d'", previousState, machine.State)
+
+	turn.NextState = bundle.Domain.StateTable.Name(machine.State)
+	turn.Extension = ext
 
 	if ext != nil {
 		if _, ok := b.Extensions[ext.Server]; !ok {
-			return nil, &ErrUnknownExtension{Extension: ext.Server}
+			return nil, unchanged, &ErrUnknownExtension{Extension: ext.Server}
 		}
 
-		answers, err = b.Extensions[ext.Server].ExecuteExtension(receiveMsg.Question, ext.Name, receiveMsg.Channel, cmd, b.Domain, machine)
+		out.extension = true
+		start := time.Now()
+		answers, err = b.Extensions[ext.Server].ExecuteExtension(ctx, receiveMsg.Question, ext.Name, receiveMsg.Channel, cmd, bundle.Domain, machine)
+		metrics.ObserveExtension(ext.Server, ext.Name, start, err)
 		if err != nil {
-			return []query.Answer{{Text: b.Domain.DefaultMessages.Error}}, nil
+			return []query.Answer{{Text: bundle.Domain.DefaultMessages.Error}}, unchanged, nil
+		}
+		turn.NextState = bundle.Domain.StateTable.Name(machine.State)
+	}
+
+	out.transitioned = true
+
+	return answers, machine, nil
+}
+
+// disambiguate asks the user which command they meant if the classifier
+// cannot tell the most probable commands of the ranking of the text apart,
+// only the commands with a transition from the current state are candidates.
+// States that take any input and forms do not ask, since every text is
+// expected there
+func (b *Bot) disambiguate(ctx context.Context, bundle *Bundle, machine *fsm.FSM, text string, ranking prediction.Ranking) (question string, ok bool) {
+	if machine.Form != "" || len(bundle.Domain.TransitionTable[fsm.CmdStateTuple{Cmd: "any", State: machine.State}]) > 0 {
+		return "", false
+	}
+
+	candidates, ok := bundle.Classifier.Ambiguous(executable(ranking, bundle.Domain, machine.State))
+	if !ok {
+		return "", false
+	}
+
+	commands := make([]string, len(candidates))
+	labels := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		commands[i] = candidate.Command
+		labels[i] = bundle.Classifier.Label(candidate.Command)
+	}
+
+	question, err := machine.Disambiguate(text, commands, labels, bundle.Domain.DefaultMessages)
+	if err != nil {
+		logger.Entry(ctx).Error("Error asking for disambiguation:", err)
+		return "", false
+	}
+
+	logger.Entry(ctx).Debugf("FSM | Asking to disambiguate between commands %v", commands)
+
+	return question, true
+}
+
+// executable returns the candidates of the ranking that have
+// a transition from the state, or from any state
+func executable(ranking prediction.Ranking, domain *fsm.Domain, state int) prediction.Ranking {
+	candidates := make(prediction.Ranking, 0, len(ranking))
+	for _, candidate := range ranking {
+		if len(domain.TransitionTable[fsm.CmdStateTuple{Cmd: candidate.Command, State: state}]) > 0 ||
+			len(domain.TransitionTable[fsm.CmdStateTuple{Cmd: candidate.Command, State: fsm.StateAny}]) > 0 {
+			candidates = append(candidates, candidate)
 		}
 	}
+	return candidates
+}
 
-	b.Store.Set(sender, machine)
+// turnContext returns a copy of ctx with the log fields of the turn, and
+// a new turn ID if ctx has none
+func turnContext(ctx context.Context, receiveMsg *messages.Receive) context.Context {
+	if logger.TurnID(ctx) == "" {
+		ctx = logger.WithTurnID(ctx, logger.NewTurnID())
+	}
 
-	return answers, nil
+	fields := log.Fields{logger.FieldChannel: receiveMsg.Channel}
+	if receiveMsg.Question != nil {
+		fields[logger.FieldSender] = receiveMsg.Question.Sender
+	}
+	return logger.WithFields(ctx, fields)
 }
 
 // ErrUnknownExtension is returned by the Bot when
@@ -99,3 +333,14 @@ type ErrUnknownExtension struct {
 func (e *ErrUnknownExtension) Error() string {
 	return fmt.Sprintf("cannot answer: extension %s is unknown", e.Extension)
 }
+
+// ErrConflict is returned if the conversation of the sender changed
+// every time the turn was answered, because other replicas saved it
+type ErrConflict struct {
+	Sender string
+}
+
+// Error returns the ErrConflict error message
+func (e *ErrConflict) Error() string {
+	return fmt.Sprintf("cannot answer: the conversation of %s keeps changing", e.Sender)
+}
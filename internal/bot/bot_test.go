@@ -2,35 +2,56 @@ package bot_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/jaimeteb/chatto/extensions"
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/bot"
 	"github.com/jaimeteb/chatto/internal/channels"
 	"github.com/jaimeteb/chatto/internal/channels/messages"
 	"github.com/jaimeteb/chatto/internal/channels/mockchannels"
 	"github.com/jaimeteb/chatto/internal/clf"
+	"github.com/jaimeteb/chatto/internal/clf/dataset"
+	"github.com/jaimeteb/chatto/internal/clf/pipeline"
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
 	"github.com/jaimeteb/chatto/internal/extension"
 	fsmint "github.com/jaimeteb/chatto/internal/fsm"
 	store "github.com/jaimeteb/chatto/internal/fsm/store"
 	"github.com/jaimeteb/chatto/internal/fsm/store/config"
+	"github.com/jaimeteb/chatto/internal/history"
+	"github.com/jaimeteb/chatto/internal/logger"
 	"github.com/jaimeteb/chatto/internal/testutils"
+	"github.com/jaimeteb/chatto/internal/tracing"
 	"github.com/jaimeteb/chatto/query"
+	"github.com/jaimeteb/chatto/version"
 	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var ctx = context.Background()
+
 func TestBot_channelHandler(t *testing.T) {
-	testBot, restChnl, twilioChnl, telegramChnl, slackChnl, err := newTestBot(t)
-	if err != nil {
-		t.Fatal(err)
-	}
+	testBot := newTestBot(t)
 
 	ts := httptest.NewServer(testBot.Router)
 	defer ts.Close()
@@ -51,49 +72,49 @@ func TestBot_channelHandler(t *testing.T) {
 	}{
 		{
 			name: "rest endpoint test",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				endpoint:     fmt.Sprintf("%s/channels/rest", ts.URL),
 				message:      []byte(`{"sender": "42", "text": "on"}`),
-				mockReceive:  restChnl.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "on"}}, nil),
-				mockSend:     restChnl.EXPECT().SendMessage(gomock.Any()).Return(nil),
-				mockValidate: restChnl.EXPECT().ValidateCallback(gomock.Any()).Return(true),
+				mockReceive:  testBot.REST.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "on"}}, nil),
+				mockSend:     testBot.REST.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(nil),
+				mockValidate: testBot.REST.EXPECT().ValidateCallback(gomock.Any()).Return(true),
 			},
 			want: `[{"text":"Turning on."}]`,
 		},
 		{
 			name: "twilio endpoint test",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				endpoint:     fmt.Sprintf("%s/channels/twilio", ts.URL),
 				message:      []byte(`{"sender": "42", "text": "off"}`),
-				mockReceive:  twilioChnl.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "off"}}, nil),
-				mockSend:     twilioChnl.EXPECT().SendMessage(gomock.Any()).Return(nil),
-				mockValidate: twilioChnl.EXPECT().ValidateCallback(gomock.Any()).Return(true),
+				mockReceive:  testBot.Twilio.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "off"}}, nil),
+				mockSend:     testBot.Twilio.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(nil),
+				mockValidate: testBot.Twilio.EXPECT().ValidateCallback(gomock.Any()).Return(true),
 			},
 			want: `[{"text":"Turning off."},{"text":"❌"}]`,
 		},
 		{
 			name: "telegram endpoint test",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				endpoint:     fmt.Sprintf("%s/channels/telegram", ts.URL),
 				message:      []byte(`{"sender": "42", "text": "on"}`),
-				mockReceive:  telegramChnl.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "on"}}, nil),
-				mockSend:     telegramChnl.EXPECT().SendMessage(gomock.Any()).Return(nil),
-				mockValidate: telegramChnl.EXPECT().ValidateCallback(gomock.Any()).Return(true),
+				mockReceive:  testBot.Telegram.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "on"}}, nil),
+				mockSend:     testBot.Telegram.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(nil),
+				mockValidate: testBot.Telegram.EXPECT().ValidateCallback(gomock.Any()).Return(true),
 			},
 			want: `[{"text":"Turning on."}]`,
 		},
 		{
 			name: "slack endpoint test",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				endpoint:     fmt.Sprintf("%s/channels/slack", ts.URL),
 				message:      []byte(`{"sender": "42", "text": "on"}`),
-				mockReceive:  slackChnl.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "on"}}, nil),
-				mockSend:     slackChnl.EXPECT().SendMessage(gomock.Any()).Return(nil),
-				mockValidate: slackChnl.EXPECT().ValidateCallback(gomock.Any()).Return(true),
+				mockReceive:  testBot.Slack.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "on"}}, nil),
+				mockSend:     testBot.Slack.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(nil),
+				mockValidate: testBot.Slack.EXPECT().ValidateCallback(gomock.Any()).Return(true),
 			},
 			want: `[{"text":"Can't do that."}]`,
 		},
@@ -134,16 +155,18 @@ func TestBot_Extensions(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if ext, ok := bc.Extensions["any"]; ok {
-		ext.URL = fmt.Sprintf("http://127.0.0.1:%s", extensionPort)
-	}
+	extConfig := bc.Extensions["test"]
+	extConfig.URL = fmt.Sprintf("http://127.0.0.1:%s", extensionPort)
+	bc.Extensions["test"] = extConfig
 
-	testBot, _, _, _, _, err := newTestBot(t)
+	testBot := newTestBot(t)
+	testBot.Config.Extensions = bc.Extensions
+	testBot.Extensions, err = extension.New(bc.Extensions)
 	if err != nil {
-		t.Fatalf("failed to load bot: %s", err)
+		t.Fatal(err)
 	}
 
-	_, err = testBot.Answer(&messages.Receive{
+	_, err = testBot.Answer(ctx, &messages.Receive{
 		Question: &query.Question{
 			Sender: "tester",
 			Text:   "hello",
@@ -161,10 +184,7 @@ func TestBot_Extensions(t *testing.T) {
 }
 
 func TestBot_Answer(t *testing.T) {
-	testBot, _, _, _, _, err := newTestBot(t)
-	if err != nil {
-		t.Fatal(err)
-	}
+	testBot := newTestBot(t)
 
 	type args struct {
 		receive *messages.Receive
@@ -178,7 +198,7 @@ func TestBot_Answer(t *testing.T) {
 	}{
 		{
 			name: "turn on the thing",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				receive: &messages.Receive{
 					Question: &query.Question{
@@ -198,7 +218,7 @@ func TestBot_Answer(t *testing.T) {
 		},
 		{
 			name: "turn off the thing",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				receive: &messages.Receive{
 					Question: &query.Question{
@@ -224,7 +244,7 @@ func TestBot_Answer(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.bot.Answer(tt.args.receive)
+			got, err := tt.bot.Answer(ctx, tt.args.receive)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Bot.Answer() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -236,11 +256,298 @@ func TestBot_Answer(t *testing.T) {
 	}
 }
 
-func TestBot_Predict(t *testing.T) {
-	testBot, _, _, _, _, err := newTestBot(t)
+func TestBot_AnswerConcurrent(t *testing.T) {
+	testBot := newTestBot(t)
+
+	// A second replica of the bot that shares the store
+	replica := &bot.Bot{Name: testBot.Name, Store: testBot.Store, Config: testBot.Config, Extensions: testBot.Extensions}
+	replica.SetBundle(testBot.Bundle())
+
+	const turns = 40
+	var wg sync.WaitGroup
+	for i := 0; i < turns; i++ {
+		b, text := testBot.Bot, "on"
+		if i%2 == 1 {
+			b, text = replica, "off"
+		}
+		wg.Add(1)
+		go func(b *bot.Bot, text string) {
+			defer wg.Done()
+			if _, err := b.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "racer", Text: text}}); err != nil {
+				t.Error(err)
+			}
+		}(b, text)
+	}
+	wg.Wait()
+
+	got, err := testBot.Store.GetHistory(ctx, "racer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != turns {
+		t.Fatalf("Bot.Answer() saved %v turns, want %v", len(got), turns)
+	}
+
+	// Every turn starts where the previous one ended
+	state := "initial"
+	for i, turn := range got {
+		if turn.PreviousState != state {
+			t.Fatalf("Bot.Answer() turn %d started in %v, want %v", i, turn.PreviousState, state)
+		}
+		state = turn.NextState
+	}
+	if machine, _ := testBot.Store.Get(ctx, "racer"); testBot.Bundle().Domain.StateTable.Name(machine.State) != state {
+		t.Errorf("Bot.Answer() state = %v, want %v", machine.State, state)
+	}
+}
+
+// conflictingStore is a store where the FSM of every sender always changed
+type conflictingStore struct {
+	store.Store
+}
+
+func (conflictingStore) CompareAndSet(context.Context, string, *fsm.FSM, int64) (bool, error) {
+	return false, nil
+}
+
+func TestBot_AnswerConflict(t *testing.T) {
+	testBot := newTestBot(t)
+	testBot.Store = conflictingStore{testBot.Store}
+
+	_, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "loser", Text: "on"}})
+	var conflictErr *bot.ErrConflict
+	if !errors.As(err, &conflictErr) || conflictErr.Sender != "loser" {
+		t.Fatalf("Bot.Answer() error = %v, want %T", err, conflictErr)
+	}
+	if got, _ := testBot.Store.GetHistory(ctx, "loser"); len(got) != 0 {
+		t.Errorf("Bot.Answer() saved %v turns, want %v", len(got), 0)
+	}
+}
+
+// countingExtension counts how many times its extensions are executed
+type countingExtension struct {
+	extension.Extension
+	executed int
+}
+
+func (e *countingExtension) ExecuteExtension(context.Context, *query.Question, string, string, string, *fsm.Domain, *fsm.FSM) ([]query.Answer, error) {
+	e.executed++
+	return []query.Answer{{Text: "Hello universe"}}, nil
+}
+
+func TestBot_AnswerConflictAfterExtension(t *testing.T) {
+	testBot := newTestBot(t)
+	testBot.Store = conflictingStore{testBot.Store}
+
+	ext := &countingExtension{}
+	testBot.Extensions = extension.ServerMap{"test": ext}
+
+	answers, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "loser", Text: "hello"}})
 	if err != nil {
+		t.Fatalf("Bot.Answer() error = %v, want the answers of the extension", err)
+	}
+	if want := []query.Answer{{Text: "Hello universe"}}; !reflect.DeepEqual(answers, want) {
+		t.Errorf("Bot.Answer() = %v, want %v", answers, want)
+	}
+	if ext.executed != 1 {
+		t.Errorf("Bot.Answer() executed the extension %v times, want %v", ext.executed, 1)
+	}
+	if machine, _ := testBot.Store.Get(ctx, "loser"); machine != nil {
+		t.Errorf("Bot.Answer() saved the FSM %v, want it lost", machine)
+	}
+}
+
+// failingStore is a store that cannot be reached
+type failingStore struct {
+	store.Store
+}
+
+func (failingStore) GetVersion(context.Context, string) (*fsm.FSM, int64, error) {
+	return nil, 0, errors.New("connection refused")
+}
+
+func TestBot_AnswerStoreError(t *testing.T) {
+	testBot := newTestBot(t)
+	testBot.Store = failingStore{testBot.Store}
+
+	got, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "unlucky", Text: "on"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []query.Answer{{Text: "Error"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Bot.Answer() = %v, want %v", got, want)
+	}
+
+	testBot.Config.Conversation.Existing.ReplyError = false
+	if got, err = testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "unlucky", Text: "on"}}); err != nil || len(got) != 0 {
+		t.Errorf("Bot.Answer() = %v %v, want no answers", got, err)
+	}
+}
+
+func TestBot_Senders(t *testing.T) {
+	testBot := newTestBot(t)
+
+	ts := httptest.NewServer(testBot.Router)
+	defer ts.Close()
+
+	for _, sender := range []string{"carl", "alice", "bob"} {
+		if _, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: sender, Text: "on"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getSenders := func(query string) bot.Senders {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/bot/senders" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var senders bot.Senders
+		if err := json.NewDecoder(res.Body).Decode(&senders); err != nil {
+			t.Fatal(err)
+		}
+		return senders
+	}
+
+	if got, want := getSenders("?limit=2"), (bot.Senders{Senders: []string{"alice", "bob"}, Next: "bob"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Bot.sendersHandler() = %+v, want %+v", got, want)
+	}
+	if got, want := getSenders("?limit=2&cursor=bob"), (bot.Senders{Senders: []string{"carl"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Bot.sendersHandler() = %+v, want %+v", got, want)
+	}
+
+	res, err := http.Get(ts.URL + "/bot/senders?limit=-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Bot.sendersHandler() status = %v, want %v", res.StatusCode, http.StatusBadRequest)
+	}
+
+	deleteSender := func(sender string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodDelete, ts.URL+"/bot/senders/"+sender, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := deleteSender("bob"); code != http.StatusNoContent {
+		t.Errorf("Bot.deleteSenderHandler() status = %v, want %v", code, http.StatusNoContent)
+	}
+	if code := deleteSender("bob"); code != http.StatusNotFound {
+		t.Errorf("Bot.deleteSenderHandler() status = %v, want %v", code, http.StatusNotFound)
+	}
+	if got := getSenders(""); !reflect.DeepEqual(got.Senders, []string{"alice", "carl"}) {
+		t.Errorf("Bot.sendersHandler() = %+v, want %v", got, "[alice carl]")
+	}
+	if turns, _ := testBot.Store.GetHistory(ctx, "bob"); len(turns) != 0 {
+		t.Errorf("Bot.deleteSenderHandler() kept %v turns", len(turns))
+	}
+}
+
+// ambiguousModel is a clf.Model that cannot tell turn_on, hello_universe and turn_off apart
+type ambiguousModel struct{}
+
+func (ambiguousModel) Learn(dataset.DataSet, *pipeline.Config) float32 { return 1 }
+
+func (ambiguousModel) Predict(string, *pipeline.Config) (string, float32) { return "turn_on", 0.45 }
+
+func (ambiguousModel) Rank(string, *pipeline.Config) prediction.Ranking {
+	return prediction.Ranking{
+		{Command: "turn_on", Probability: 0.45},
+		{Command: "hello_universe", Probability: 0.4},
+		{Command: "turn_off", Probability: 0.4},
+	}
+}
+
+func (ambiguousModel) Save(string) error { return nil }
+
+func TestBot_AnswerDisambiguation(t *testing.T) {
+	testBot := newTestBot(t)
+	bundle := *testBot.Bundle()
+	bundle.Classifier = &clf.Classifier{
+		Model:    ambiguousModel{},
+		Pipeline: &pipeline.Config{Threshold: 0.8, Margin: 0.1},
+		Labels:   map[string]string{"turn_on": "Turn on", "turn_off": "Turn off", "hello_universe": "Say hello"},
+	}
+	testBot.SetBundle(&bundle)
+
+	// Only the commands with a transition from the current state are offered
+	tests := []struct {
+		text      string
+		want      []query.Answer
+		wantState string
+	}{
+		{text: "on off", want: []query.Answer{{Text: "Did you mean Turn on or Say hello?"}}, wantState: "initial"},
+		{text: "Turn on", want: []query.Answer{{Text: "Turning on."}}, wantState: "on"},
+		{text: "on off", want: []query.Answer{{Text: "Did you mean Say hello or Turn off?"}}, wantState: "on"},
+		{text: "2", want: []query.Answer{{Text: "Turning off."}, {Text: "❌"}}, wantState: "initial"},
+		{text: "on off", want: []query.Answer{{Text: "Did you mean Turn on or Say hello?"}}, wantState: "initial"},
+		{text: "neither", want: []query.Answer{{Text: "Did you mean Turn on or Say hello?"}}, wantState: "initial"},
+	}
+	for _, tt := range tests {
+		got, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "disambiguation", Text: tt.text}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Bot.Answer(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+		machine, _ := testBot.Store.Get(ctx, "disambiguation")
+		if state := testBot.Bundle().Domain.StateTable.Name(machine.State); state != tt.wantState {
+			t.Errorf("Bot.Answer(%q) state = %v, want %v", tt.text, state, tt.wantState)
+		}
+	}
+
+	turns, _ := testBot.Store.GetHistory(ctx, "disambiguation")
+	if len(turns) != len(tests) || turns[1].Command != "turn_on" || turns[3].Command != "turn_off" {
+		t.Errorf("Bot.Answer() history = %+v, want the chosen commands", turns)
+	}
+}
+
+// countingModel counts how many times the model runs
+type countingModel struct {
+	ambiguousModel
+	runs int
+}
+
+func (m *countingModel) Predict(text string, pipe *pipeline.Config) (string, float32) {
+	m.runs++
+	return m.ambiguousModel.Predict(text, pipe)
+}
+
+func (m *countingModel) Rank(text string, pipe *pipeline.Config) prediction.Ranking {
+	m.runs++
+	return m.ambiguousModel.Rank(text, pipe)
+}
+
+func TestBot_AnswerRunsModelOnce(t *testing.T) {
+	testBot := newTestBot(t)
+	model := &countingModel{}
+	bundle := *testBot.Bundle()
+	bundle.Classifier = &clf.Classifier{Model: model, Pipeline: &pipeline.Config{Threshold: 0.8, Margin: 0.1}}
+	testBot.SetBundle(&bundle)
+
+	if _, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "once", Text: "on off"}}); err != nil {
 		t.Fatal(err)
 	}
+	if model.runs != 1 {
+		t.Errorf("Bot.Answer() ran the model %v times, want %v", model.runs, 1)
+	}
+}
+
+func TestBot_Predict(t *testing.T) {
+	testBot := newTestBot(t)
 
 	ts := httptest.NewServer(testBot.Router)
 	defer ts.Close()
@@ -249,6 +556,7 @@ func TestBot_Predict(t *testing.T) {
 
 	type args struct {
 		inputText []byte
+		query     string
 	}
 	tests := []struct {
 		name    string
@@ -259,24 +567,42 @@ func TestBot_Predict(t *testing.T) {
 	}{
 		{
 			name: "test on",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				inputText: []byte(`{"text": "on"}`),
 			},
-			want: `{"original":"on","predicted":"turn_on","probability":1}`,
+			want: `{"original":"on","predicted":"turn_on","probability":1,"ranking":[{"command":"turn_on","probability":1},{"command":"turn_off","probability":1.5e-11},{"command":"hello_universe","probability":1e-11}]}`,
 		},
 		{
 			name: "test off",
-			bot:  testBot,
+			bot:  testBot.Bot,
+			args: args{
+				inputText: []byte(`{"text": "off"}`),
+			},
+			want: `{"original":"off","predicted":"turn_off","probability":1,"ranking":[{"command":"turn_off","probability":1},{"command":"turn_on","probability":1.5e-11},{"command":"hello_universe","probability":1e-11}]}`,
+		},
+		{
+			name: "test top 1",
+			bot:  testBot.Bot,
+			args: args{
+				inputText: []byte(`{"text": "off"}`),
+				query:     "?top=1",
+			},
+			want: `{"original":"off","predicted":"turn_off","probability":1,"ranking":[{"command":"turn_off","probability":1}]}`,
+		},
+		{
+			name: "test invalid top",
+			bot:  testBot.Bot,
 			args: args{
 				inputText: []byte(`{"text": "off"}`),
+				query:     "?top=all",
 			},
-			want: `{"original":"off","predicted":"turn_off","probability":1}`,
+			want: "top must be a positive number\n",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			res, err := http.Post(predictEndpoint, "application/json", bytes.NewBuffer(tt.args.inputText))
+			res, err := http.Post(predictEndpoint+tt.args.query, "application/json", bytes.NewBuffer(tt.args.inputText))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Bot.predictHandler() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -297,17 +623,16 @@ func TestBot_Predict(t *testing.T) {
 }
 
 func TestBot_Details(t *testing.T) {
-	testBot, _, _, _, _, err := newTestBot(t)
-	if err != nil {
-		t.Fatal(err)
-	}
+	testBot := newTestBot(t)
 
 	ts := httptest.NewServer(testBot.Router)
 	defer ts.Close()
 
 	detailsEndpoint := fmt.Sprintf("%s/bot/senders", ts.URL)
 
-	testBot.Store.Set("marcopolo", &fsm.FSM{})
+	if err := testBot.Store.Set(ctx, "marcopolo", &fsm.FSM{}); err != nil {
+		t.Fatal(err)
+	}
 
 	type args struct {
 		sender string
@@ -321,7 +646,7 @@ func TestBot_Details(t *testing.T) {
 	}{
 		{
 			name: "test unknown",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				sender: "atlantis",
 			},
@@ -330,7 +655,7 @@ func TestBot_Details(t *testing.T) {
 		},
 		{
 			name: "test known",
-			bot:  testBot,
+			bot:  testBot.Bot,
 			args: args{
 				sender: "marcopolo",
 			},
@@ -359,6 +684,592 @@ func TestBot_Details(t *testing.T) {
 	}
 }
 
+func TestBot_History(t *testing.T) {
+	testBot := newTestBot(t)
+
+	ts := httptest.NewServer(testBot.Router)
+	defer ts.Close()
+
+	_, err := testBot.Answer(ctx, &messages.Receive{
+		Question: &query.Question{Sender: "historian", Text: "on"},
+		Channel:  "rest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s/bot/senders/%s/history", ts.URL, "atlantis"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Bot.historyHandler() status = %v, want %v", res.StatusCode, http.StatusNotFound)
+	}
+
+	res, err = http.Get(fmt.Sprintf("%s/bot/senders/%s/history", ts.URL, "historian"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var turns []history.Turn
+	if err := json.NewDecoder(res.Body).Decode(&turns); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(turns) != 1 {
+		t.Fatalf("Bot.historyHandler() = %v turns, want %v", len(turns), 1)
+	}
+
+	got := turns[0]
+	if got.Channel != "rest" || got.Question != "on" || got.Command != "turn_on" ||
+		got.PreviousState != "initial" || got.NextState != "on" {
+		t.Errorf("Bot.historyHandler() = %+v", got)
+	}
+	if !reflect.DeepEqual(got.Answers, []query.Answer{{Text: "Turning on."}}) {
+		t.Errorf("Bot.historyHandler() answers = %v, want %v", got.Answers, "Turning on.")
+	}
+}
+
+// blockingStore is a store whose reads wait until they are released
+type blockingStore struct {
+	store.Store
+	entered chan struct{}
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (s blockingStore) GetVersion(ctx context.Context, user string) (*fsm.FSM, int64, error) {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.Store.GetVersion(ctx, user)
+}
+
+func (s blockingStore) Close() error {
+	close(s.closed)
+	return s.Store.Close()
+}
+
+func TestBot_Serve(t *testing.T) {
+	testBot := newTestBot(t)
+
+	blocking := blockingStore{
+		Store:   testBot.Store,
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	testBot.Store = blocking
+
+	testBot.REST.EXPECT().ValidateCallback(gomock.Any()).Return(true)
+	testBot.REST.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "42", Text: "on"}}, nil)
+	testBot.REST.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(nil)
+	testBot.Slack.EXPECT().ReceiveMessages(gomock.Any(), gomock.Any()).Do(func(ctx context.Context, receiveChan chan messages.Receive) {
+		<-ctx.Done()
+		close(receiveChan)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("http://%s/channels/rest", listener.Addr())
+
+	serveCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- testBot.Serve(serveCtx, listener)
+	}()
+
+	type response struct {
+		body string
+		err  error
+	}
+	inFlight := make(chan response, 1)
+	go func() {
+		resp, err := http.Post(url, "application/json", bytes.NewBufferString(`{"sender": "42", "text": "on"}`))
+		if err != nil {
+			inFlight <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		inFlight <- response{body: string(body), err: err}
+	}()
+
+	<-blocking.entered
+	stop()
+
+	select {
+	case err := <-serveErr:
+		t.Fatalf("Bot.Serve() = %v before the request in flight finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(blocking.release)
+
+	if got := <-inFlight; got.err != nil || got.body != `[{"text":"Turning on."}]` {
+		t.Errorf("request in flight = %q %v, want %q", got.body, got.err, `[{"text":"Turning on."}]`)
+	}
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			t.Errorf("Bot.Serve() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Bot.Serve() did not return after ctx was done")
+	}
+
+	select {
+	case <-blocking.closed:
+	default:
+		t.Error("Bot.Serve() did not close the store")
+	}
+
+	if _, err := http.Post(url, "application/json", bytes.NewBufferString(`{}`)); err == nil {
+		t.Error("Bot.Serve() accepted a request after it returned")
+	}
+}
+
+func TestBot_Metrics(t *testing.T) {
+	testBot := newTestBot(t)
+	testBot.Store = store.Instrument(testBot.Store)
+	testBot.Config.Auth.Token = "metrics-token"
+
+	// The second "on" has no transition from the "on" state
+	for _, text := range []string{"on", "on"} {
+		if _, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "metrics", Text: text}, Channel: "rest"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts := httptest.NewServer(testBot.Router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/bot/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /bot/metrics without token = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/bot/metrics", nil)
+	req.Header.Set("Authorization", "Bearer metrics-token")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, want := range []string{
+		`chatto_bot_messages_total{channel="rest"}`,
+		`chatto_classifier_predictions_total{command="turn_on"}`,
+		`chatto_classifier_prediction_probability_bucket{command="turn_on",le="1"}`,
+		`chatto_fsm_transitions_total{from="initial",into="on"}`,
+		`chatto_fsm_fallbacks_total{reason="unknown"}`,
+		`chatto_store_operation_duration_seconds_count{operation="compare_and_set"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("GET /bot/metrics is missing %s", want)
+		}
+	}
+}
+
+func TestBot_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	testBot := newTestBot(t)
+	testBot.Store = store.Instrument(testBot.Store)
+
+	// "hello" calls the "any" extension of the "test" server
+	listener := extensions.NewListenerREST(extensions.RegisteredExtensions{
+		"any": func(req *extensions.ExecuteExtensionRequest) *extensions.ExecuteExtensionResponse {
+			return &extensions.ExecuteExtensionResponse{FSM: req.FSM, Answers: []query.Answer{{Text: "Hello Universe"}}}
+		},
+	}, "")
+	router := mux.NewRouter()
+	router.HandleFunc("/extension", listener.ExecuteExtension).Methods("POST")
+	router.HandleFunc("/extensions", listener.GetAllExtensions).Methods("GET")
+	extensionServer := httptest.NewServer(router)
+	defer extensionServer.Close()
+
+	ext, err := extension.New(extension.ConfigMap{"test": {Type: "REST", URL: extensionServer.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBot.Extensions = ext
+
+	testBot.REST.EXPECT().ValidateCallback(gomock.Any()).Return(true)
+	testBot.REST.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "tracer", Text: "hello"}, Channel: "rest"}, nil)
+	testBot.REST.EXPECT().SendMessage(gomock.Any(), gomock.Any()).Return(nil)
+
+	ts := httptest.NewServer(testBot.Router)
+	defer ts.Close()
+
+	callerCtx, caller := provider.Tracer("test").Start(ctx, "caller")
+	req, _ := http.NewRequest("POST", ts.URL+"/channels/rest", bytes.NewBufferString(`{"sender": "tracer", "text": "hello"}`))
+	tracing.Propagator.Inject(callerCtx, propagation.HeaderCarrier(req.Header))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	caller.End()
+
+	if want := `[{"text":"Hello Universe"}]`; string(body) != want {
+		t.Fatalf("POST /channels/rest = %s, want %s", body, want)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() != caller.SpanContext().TraceID() {
+			t.Errorf("span %s is not in the trace of the caller", span.Name)
+		}
+		// The extension call has a client and a server span
+		if span.Name == "ExecuteExtension" && span.SpanKind != trace.SpanKindServer {
+			t.Errorf("span %s has kind %v, want %v", span.Name, span.SpanKind, trace.SpanKindServer)
+		}
+		spans[span.Name] = span
+	}
+
+	parents := map[string]string{
+		"ChannelHandler":             "caller",
+		"Bot.Answer":                 "ChannelHandler",
+		"Classifier.Predict":         "Bot.Answer",
+		"Store.GetVersion":           "Bot.Answer",
+		"Store.CompareAndSet":        "Bot.Answer",
+		"Store.AppendTurn":           "Bot.Answer",
+		"Extension.ExecuteExtension": "Bot.Answer",
+		"ExecuteExtension":           "Extension.ExecuteExtension",
+	}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("span %s was not exported", name)
+			continue
+		}
+		if got, want := span.Parent.SpanID(), spans[parent].SpanContext.SpanID(); got != want {
+			t.Errorf("span %s has parent %v, want %s %v", name, got, parent, want)
+		}
+	}
+}
+
+func TestBot_PredictTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	testBot := newTestBot(t)
+	ts := httptest.NewServer(testBot.Router)
+	defer ts.Close()
+
+	callerCtx, caller := provider.Tracer("test").Start(ctx, "caller")
+	req, _ := http.NewRequest("POST", ts.URL+"/bot/predict", bytes.NewBufferString(`{"text": "on"}`))
+	tracing.Propagator.Inject(callerCtx, propagation.HeaderCarrier(req.Header))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	caller.End()
+
+	var predict *tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "Classifier.Predict" {
+			span := span
+			predict = &span
+		}
+	}
+	if predict == nil {
+		t.Fatal("POST /bot/predict exported no Classifier.Predict span")
+	}
+	if got, want := predict.Parent.SpanID(), caller.SpanContext().SpanID(); got != want {
+		t.Errorf("span Classifier.Predict has parent %v, want caller %v", got, want)
+	}
+	for _, attr := range predict.Attributes {
+		if attr.Key == "chatto.command" && attr.Value.AsString() != "turn_on" {
+			t.Errorf("span Classifier.Predict command = %v, want %v", attr.Value.AsString(), "turn_on")
+		}
+	}
+}
+
+func TestBot_TurnID(t *testing.T) {
+	hook := logtest.NewGlobal()
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetLevel(level)
+		logger.SetRedactText(false)
+	})
+
+	tests := []struct {
+		name      string
+		requestID string
+		redact    bool
+	}{
+		{
+			name:      "request ID of the caller",
+			requestID: "turn-42",
+		},
+		{
+			name: "generated turn ID",
+		},
+		{
+			name:      "redacted texts",
+			requestID: "turn-43",
+			redact:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook.Reset()
+			logger.SetRedactText(tt.redact)
+
+			testBot := newTestBot(t)
+
+			// "hello" calls the "any" extension of the "test" server
+			var extensionReq *extensions.ExecuteExtensionRequest
+			var extensionHeader string
+			listener := extensions.NewListenerREST(extensions.RegisteredExtensions{
+				"any": func(req *extensions.ExecuteExtensionRequest) *extensions.ExecuteExtensionResponse {
+					extensionReq = req
+					req.Logger().Info("Extension called")
+					return &extensions.ExecuteExtensionResponse{FSM: req.FSM, Answers: []query.Answer{{Text: "Hello Universe"}}}
+				},
+			}, "")
+			router := mux.NewRouter()
+			router.HandleFunc("/extension", func(w http.ResponseWriter, r *http.Request) {
+				extensionHeader = r.Header.Get("X-Request-ID")
+				listener.ExecuteExtension(w, r)
+			}).Methods("POST")
+			router.HandleFunc("/extensions", listener.GetAllExtensions).Methods("GET")
+			extensionServer := httptest.NewServer(router)
+			defer extensionServer.Close()
+
+			ext, err := extension.New(extension.ConfigMap{"test": {Type: "REST", URL: extensionServer.URL}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			testBot.Extensions = ext
+
+			var sendTurnID string
+			testBot.REST.EXPECT().ValidateCallback(gomock.Any()).Return(true)
+			testBot.REST.EXPECT().ReceiveMessage(gomock.Any()).Return(&messages.Receive{Question: &query.Question{Sender: "logger", Text: "hello"}, Channel: "rest"}, nil)
+			testBot.REST.EXPECT().SendMessage(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ *messages.Response) error {
+				sendTurnID = logger.TurnID(ctx)
+				return nil
+			})
+
+			ts := httptest.NewServer(testBot.Router)
+			defer ts.Close()
+
+			req, _ := http.NewRequest("POST", ts.URL+"/channels/rest", bytes.NewBufferString(`{"sender": "logger", "text": "hello"}`))
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			turnID := resp.Header.Get("X-Request-ID")
+			if turnID == "" || (tt.requestID != "" && turnID != tt.requestID) {
+				t.Fatalf("X-Request-ID = %q, want %q", turnID, tt.requestID)
+			}
+			if sendTurnID != turnID {
+				t.Errorf("SendMessage() turn ID = %q, want %q", sendTurnID, turnID)
+			}
+			if extensionReq == nil || extensionReq.TurnID != turnID || extensionHeader != turnID {
+				t.Fatalf("extension turn ID = %+v, header %q, want %q", extensionReq, extensionHeader, turnID)
+			}
+
+			var turnEntries int
+			for _, entry := range hook.AllEntries() {
+				if entry.Data[logger.FieldTurnID] != turnID {
+					continue
+				}
+				turnEntries++
+				if entry.Data[logger.FieldSender] != "logger" || entry.Data[logger.FieldChannel] != "rest" {
+					t.Errorf("log %q has fields %v, want the sender and the channel", entry.Message, entry.Data)
+				}
+				if tt.redact && strings.Contains(entry.Message, "Hello Universe") {
+					t.Errorf("log %q has the text of the answer", entry.Message)
+				}
+			}
+			if turnEntries == 0 {
+				t.Errorf("no log has the turn ID %s", turnID)
+			}
+		})
+	}
+}
+
+func TestBot_Ready(t *testing.T) {
+	testBot := newTestBot(t)
+
+	listener := extensions.NewListenerREST(extensions.RegisteredExtensions{}, "")
+	router := mux.NewRouter()
+	router.HandleFunc("/extensions", listener.GetAllExtensions).Methods("GET")
+	router.HandleFunc("/version", listener.GetBuildVersion).Methods("GET")
+	upServer := httptest.NewServer(router)
+	defer upServer.Close()
+	stoppedServer := httptest.NewServer(router)
+
+	// "up" and "stopped" are connected, "stopped" stops
+	// afterwards and "unreachable" never started
+	extensionConfigs := extension.ConfigMap{
+		"up":          {Type: "REST", URL: upServer.URL},
+		"stopped":     {Type: "REST", URL: stoppedServer.URL},
+		"unreachable": {Type: "REST", URL: "http://localhost:" + testutils.GetFreePort(t)},
+	}
+	connected, err := extension.New(extension.ConfigMap{"up": extensionConfigs["up"], "stopped": extensionConfigs["stopped"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stoppedServer.Close()
+	testBot.Extensions = connected
+
+	ts := httptest.NewServer(testBot.Router)
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		storeType  string
+		extensions []string
+		optional   []string
+		token      string
+		want       map[string]string
+		wantCode   int
+		wantErrors bool
+	}{
+		{
+			name:       "ready",
+			extensions: []string{"up"},
+			want:       map[string]string{"store": bot.StatusUp, "classifier": bot.StatusUp, "extensions.up": bot.StatusUp},
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "extension stopped",
+			extensions: []string{"up", "stopped"},
+			want:       map[string]string{"store": bot.StatusUp, "classifier": bot.StatusUp, "extensions.up": bot.StatusUp, "extensions.stopped": bot.StatusDown},
+			wantCode:   http.StatusServiceUnavailable,
+			wantErrors: true,
+		},
+		{
+			name:       "extension not connected at startup",
+			extensions: []string{"unreachable"},
+			want:       map[string]string{"store": bot.StatusUp, "classifier": bot.StatusUp, "extensions.unreachable": bot.StatusDown},
+			wantCode:   http.StatusServiceUnavailable,
+			wantErrors: true,
+		},
+		{
+			name:       "optional extensions down",
+			extensions: []string{"stopped", "unreachable"},
+			optional:   []string{"extensions.stopped", "Extensions.Unreachable"},
+			want:       map[string]string{"store": bot.StatusUp, "classifier": bot.StatusUp, "extensions.stopped": bot.StatusDown, "extensions.unreachable": bot.StatusDown},
+			wantCode:   http.StatusOK,
+			wantErrors: true,
+		},
+		{
+			name:       "store is not the configured one",
+			storeType:  "REDIS",
+			want:       map[string]string{"store": bot.StatusDown, "classifier": bot.StatusUp},
+			wantCode:   http.StatusServiceUnavailable,
+			wantErrors: true,
+		},
+		{
+			name:       "optional store down",
+			storeType:  "sql",
+			optional:   []string{"store"},
+			want:       map[string]string{"store": bot.StatusDown, "classifier": bot.StatusUp},
+			wantCode:   http.StatusOK,
+			wantErrors: true,
+		},
+		{
+			name:       "unauthorized request",
+			extensions: []string{"unreachable"},
+			token:      "secret",
+			want:       map[string]string{"store": bot.StatusUp, "classifier": bot.StatusUp, "extensions.unreachable": bot.StatusDown},
+			wantCode:   http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testBot.Config.Store.Type = tt.storeType
+			testBot.Config.Readiness = bot.ReadinessConfig{Optional: tt.optional, Timeout: 500 * time.Millisecond}
+			testBot.Config.Auth.Token = tt.token
+			testBot.Config.Extensions = extension.ConfigMap{}
+			for _, server := range tt.extensions {
+				testBot.Config.Extensions[server] = extensionConfigs[server]
+			}
+			// Only the extensions of the test are connected
+			testBot.Extensions = extension.ServerMap{}
+			for _, server := range tt.extensions {
+				if client, ok := connected[server]; ok {
+					testBot.Extensions[server] = client
+				}
+			}
+
+			resp, err := http.Get(ts.URL + "/bot/readyz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("GET /bot/readyz status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+
+			var readiness bot.Readiness
+			if err := json.NewDecoder(resp.Body).Decode(&readiness); err != nil {
+				t.Fatal(err)
+			}
+			if readiness.Ready != (tt.wantCode == http.StatusOK) {
+				t.Errorf("GET /bot/readyz ready = %v, want %v", readiness.Ready, tt.wantCode == http.StatusOK)
+			}
+
+			got := make(map[string]string, len(readiness.Dependencies))
+			var gotErrors bool
+			for name, dependency := range readiness.Dependencies {
+				got[name] = dependency.Status
+				gotErrors = gotErrors || dependency.Error != ""
+				if dependency.Optional != testBot.Config.Readiness.IsOptional(name) {
+					t.Errorf("dependency %s optional = %v", name, dependency.Optional)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GET /bot/readyz dependencies = %v, want %v", got, tt.want)
+			}
+			if gotErrors != tt.wantErrors {
+				t.Errorf("GET /bot/readyz has errors = %v, want %v: %+v", gotErrors, tt.wantErrors, readiness.Dependencies)
+			}
+
+			if up, ok := readiness.Dependencies["extensions.up"]; ok && (up.Version == nil || *up.Version != version.Build()) {
+				t.Errorf("extensions.up version = %v, want %v", up.Version, version.Build())
+			}
+		})
+	}
+}
+
 func TestBot_Run(t *testing.T) {
 	botPort, err := strconv.Atoi(testutils.GetFreePort(t))
 	if err != nil {
@@ -381,20 +1292,22 @@ func TestBot_Run(t *testing.T) {
 	})
 }
 
-func newTestBot(t *testing.T) (*bot.Bot, *mockchannels.MockChannel, *mockchannels.MockChannel,
-	*mockchannels.MockChannel, *mockchannels.MockChannel, error) {
+// botFixture is a Bot of the examples with mock channels
+type botFixture struct {
+	*bot.Bot
+	REST, Twilio, Telegram, Slack *mockchannels.MockChannel
+}
+
+// newTestBot loads the bot of the examples with mock channels and without
+// extension servers, tests of extensions connect the bot to their servers
+func newTestBot(t *testing.T) *botFixture {
+	t.Helper()
 	t.Cleanup(func() {
 		testutils.RemoveFiles("gob")
 	})
 
 	botConfig := &bot.Config{
-		Name: "chatto",
-		Extensions: map[string]extension.Config{
-			"test": {
-				Type: "REST",
-				URL:  "http://localhost:8770",
-			},
-		},
+		Name:  "chatto",
 		Store: config.StoreConfig{},
 		Port:  0,
 		Path:  "../" + testutils.Examples00TestPath,
@@ -412,57 +1325,56 @@ func newTestBot(t *testing.T) (*bot.Bot, *mockchannels.MockChannel, *mockchannel
 		},
 	}
 
+	machines, err := store.New(&botConfig.Store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	b := &bot.Bot{
-		Name:   botConfig.Name,
-		Store:  store.New(&botConfig.Store),
-		Config: botConfig,
+		Name:       botConfig.Name,
+		Store:      machines,
+		Config:     botConfig,
+		Extensions: extension.ServerMap{},
 	}
 
 	// Load Channels
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	b.Channels = &channels.Channels{}
-
-	restChnl := mockchannels.NewMockChannel(ctrl)
-	b.Channels.REST = restChnl
-
-	twilioChnl := mockchannels.NewMockChannel(ctrl)
-	b.Channels.Twilio = twilioChnl
-
-	telegramChnl := mockchannels.NewMockChannel(ctrl)
-	b.Channels.Telegram = telegramChnl
+	f := &botFixture{
+		Bot:      b,
+		REST:     mockchannels.NewMockChannel(ctrl),
+		Twilio:   mockchannels.NewMockChannel(ctrl),
+		Telegram: mockchannels.NewMockChannel(ctrl),
+		Slack:    mockchannels.NewMockChannel(ctrl),
+	}
 
-	slackChnl := mockchannels.NewMockChannel(ctrl)
-	b.Channels.Slack = slackChnl
+	b.Channels = &channels.Channels{
+		REST:     f.REST,
+		Twilio:   f.Twilio,
+		Telegram: f.Telegram,
+		Slack:    f.Slack,
+	}
 
-	// Load FSM
-	fsmReloadChan := make(chan fsmint.Config)
-	fsmConfig, err := fsmint.LoadConfig(botConfig.Path, fsmReloadChan)
+	// Load FSM and Classifier
+	fsmConfig, err := fsmint.LoadConfig(botConfig.Path)
 	if err != nil {
-		return nil, nil, nil, nil, nil, err
+		t.Fatal(err)
 	}
-	b.Domain = fsmint.NewDomainFromConfig(fsmConfig)
 
-	// Load Classifier
-	classifReloadChan := make(chan clf.Config)
-	classifConfig, err := clf.LoadConfig(botConfig.Path, classifReloadChan)
+	classifConfig, err := clf.LoadConfig(botConfig.Path)
 	if err != nil {
-		return nil, nil, nil, nil, nil, err
+		t.Fatal(err)
 	}
-	b.Classifier = clf.New(classifConfig)
 
-	// Load Extensions
-	ext, err := extension.New(botConfig.Extensions)
+	bundle, err := bot.NewBundle(fsmConfig, classifConfig)
 	if err != nil {
-		return nil, nil, nil, nil, nil, err
+		t.Fatal(err)
 	}
-	b.Extensions = ext
+	b.SetBundle(bundle)
 
 	// Register HTTP handlers
 	b.RegisterRoutes()
 
-	log.Infof("My name is '%v'", b.Name)
-
-	return b, restChnl, twilioChnl, telegramChnl, slackChnl, nil
+	return f
 }
@@ -0,0 +1,199 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/clf"
+	fsmint "github.com/jaimeteb/chatto/internal/fsm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Bundle is a version of the FSM Domain and the Classifier, with the
+// configurations they were built from. The bot answers every turn with
+// a single Bundle, and a reload swaps it as a whole for a new one
+type Bundle struct {
+	Version       int64
+	LoadedAt      time.Time
+	Domain        *fsm.Domain
+	Classifier    *clf.Classifier
+	FSMConfig     *fsmint.Config
+	ClassifConfig *clf.Config
+}
+
+// NewBundle builds the FSM Domain and trains the Classifier. It fails if
+// either configuration is invalid or the Classifier cannot be trained,
+// transitions with commands the Classifier doesn't know are only logged
+func NewBundle(fsmConfig *fsmint.Config, classifConfig *clf.Config) (*Bundle, error) {
+	return newBundle(fsmConfig, classifConfig, nil)
+}
+
+// newBundle builds a Bundle, see NewBundle. The Classifier is only
+// trained if classifier is nil, otherwise it was trained from classifConfig
+func newBundle(fsmConfig *fsmint.Config, classifConfig *clf.Config, classifier *clf.Classifier) (*Bundle, error) {
+	if fsmConfig == nil || classifConfig == nil {
+		return nil, errors.New("the FSM and classifier configurations are required")
+	}
+	if len(classifConfig.Classification) == 0 && !classifConfig.Model.Load {
+		return nil, errors.New("the classifier has no commands")
+	}
+
+	fsmDomain, err := fsmint.NewDomainFromConfig(fsmConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FSM configuration: %w", err)
+	}
+
+	if classifier == nil {
+		if classifier, err = clf.Train(classifConfig); err != nil {
+			return nil, fmt.Errorf("cannot train the classifier: %w", err)
+		}
+	}
+	fsmDomain.SetEntities(classifier.Entities)
+
+	commands := make(map[string]bool, len(classifConfig.Classification))
+	for _, class := range classifConfig.Classification {
+		commands[strings.TrimSpace(class.Command)] = true
+	}
+	for _, transition := range fsmConfig.AllTransitions() {
+		if command := strings.TrimSpace(transition.Command); command != "any" && !commands[command] {
+			log.Warnf("Command '%s' of a transition is not a command of the classifier", command)
+		}
+	}
+
+	return &Bundle{
+		Domain:        fsmDomain,
+		Classifier:    classifier,
+		FSMConfig:     fsmConfig,
+		ClassifConfig: classifConfig,
+	}, nil
+}
+
+// ReloadResult is the outcome of the last reload of the bot
+type ReloadResult struct {
+	At      time.Time `json:"at"`
+	Version int64     `json:"version"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Status is the version of the Bundle the bot answers with
+type Status struct {
+	Version    int64         `json:"version"`
+	LoadedAt   time.Time     `json:"loaded_at"`
+	LastReload *ReloadResult `json:"last_reload,omitempty"`
+}
+
+// Bundle returns the Bundle the bot answers with
+func (b *Bot) Bundle() *Bundle {
+	return b.bundle.Load()
+}
+
+// SetBundle makes the bot answer with a copy of the Bundle
+// from now on, the copy gets the next version
+func (b *Bot) SetBundle(bundle *Bundle) *Bundle {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	return b.swap(bundle)
+}
+
+// swap swaps a copy of the Bundle with the next version for the current one
+func (b *Bot) swap(bundle *Bundle) *Bundle {
+	next := *bundle
+	next.Version = 1
+	if current := b.bundle.Load(); current != nil {
+		next.Version = current.Version + 1
+	}
+	next.LoadedAt = time.Now()
+	b.bundle.Store(&next)
+
+	return &next
+}
+
+// Reload builds a new Bundle and swaps it for the current one, a nil
+// configuration keeps the one of the current Bundle. The Domain is always
+// rebuilt, so the entities of a new Classifier are never set on the Domain
+// turns are being answered with, while the Classifier is only trained if
+// its configuration is given. If the new Bundle fails, the current one is
+// kept and the error is returned
+func (b *Bot) Reload(fsmConfig *fsmint.Config, classifConfig *clf.Config) (*Bundle, error) {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	var classifier *clf.Classifier
+	if current := b.bundle.Load(); current != nil {
+		if fsmConfig == nil {
+			fsmConfig = current.FSMConfig
+		}
+		if classifConfig == nil {
+			classifConfig, classifier = current.ClassifConfig, current.Classifier
+		}
+	}
+
+	bundle, err := newBundle(fsmConfig, classifConfig, classifier)
+	if err != nil {
+		b.failReload(err)
+		return nil, err
+	}
+
+	bundle = b.swap(bundle)
+	b.lastReload = &ReloadResult{At: bundle.LoadedAt, Version: bundle.Version}
+	log.Infof("Reloaded the configuration, version %d", bundle.Version)
+
+	return bundle, nil
+}
+
+// ReloadConfig loads the FSM and classifier configurations
+// from the bot's path and reloads them, see Reload
+func (b *Bot) ReloadConfig() (*Bundle, error) {
+	fsmConfig, err := fsmint.LoadConfig(b.Config.Path)
+	if err != nil {
+		return nil, b.failLoad(fmt.Errorf("cannot load the FSM configuration: %w", err))
+	}
+
+	classifConfig, err := clf.LoadConfig(b.Config.Path)
+	if err != nil {
+		return nil, b.failLoad(fmt.Errorf("cannot load the classifier configuration: %w", err))
+	}
+
+	return b.Reload(fsmConfig, classifConfig)
+}
+
+// failLoad records a configuration that cannot be loaded as a failed reload
+func (b *Bot) failLoad(err error) error {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	b.failReload(err)
+	return err
+}
+
+// failReload records a failed reload, the current Bundle is kept.
+// The reload lock must be held
+func (b *Bot) failReload(err error) {
+	b.lastReload = &ReloadResult{At: time.Now(), Error: err.Error()}
+	if current := b.bundle.Load(); current != nil {
+		b.lastReload.Version = current.Version
+	}
+	log.Errorf("Keeping version %d of the configuration: %v", b.lastReload.Version, err)
+}
+
+// Status returns the version of the Bundle the bot
+// answers with and the outcome of the last reload
+func (b *Bot) Status() Status {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	status := Status{}
+	if bundle := b.bundle.Load(); bundle != nil {
+		status.Version, status.LoadedAt = bundle.Version, bundle.LoadedAt
+	}
+	if b.lastReload != nil {
+		result := *b.lastReload
+		status.LastReload = &result
+	}
+
+	return status
+}
@@ -0,0 +1,145 @@
+package bot_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/bot"
+	"github.com/jaimeteb/chatto/internal/channels/messages"
+	"github.com/jaimeteb/chatto/query"
+)
+
+func TestBot_Reload(t *testing.T) {
+	testBot := newTestBot(t)
+	current := testBot.Bundle()
+	if current.Version != 1 {
+		t.Fatalf("Bot.Bundle() version = %v, want 1", current.Version)
+	}
+
+	invalid := *current.FSMConfig
+	invalid.Transitions = []fsm.Transition{{From: []string{"initial"}, Into: "on", Command: "turn_on", Answers: []fsm.Answer{{Text: "{{ .Slots"}}}}
+	if _, err := testBot.Reload(&invalid, nil); err == nil {
+		t.Error("Bot.Reload() error = nil, want an error")
+	}
+	if got := testBot.Bundle(); got != current {
+		t.Errorf("Bot.Bundle() = version %v, want the current version %v", got.Version, current.Version)
+	}
+	if status := testBot.Status(); status.Version != 1 || status.LastReload == nil || status.LastReload.Error == "" || status.LastReload.Version != 1 {
+		t.Errorf("Bot.Status() = %+v, want version 1 with a failed reload", status)
+	}
+
+	changed := *current.FSMConfig
+	changed.Transitions = append([]fsm.Transition{}, changed.Transitions...)
+	changed.Transitions[0].Answers = []fsm.Answer{{Text: "Switching on."}}
+	bundle, err := testBot.Reload(&changed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Version != 2 || testBot.Bundle() != bundle || bundle.Classifier != current.Classifier {
+		t.Errorf("Bot.Reload() = version %v, want version 2 with the current classifier", bundle.Version)
+	}
+	if status := testBot.Status(); status.Version != 2 || status.LastReload.Error != "" {
+		t.Errorf("Bot.Status() = %+v, want version 2", status)
+	}
+
+	got, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: "reload", Text: "on"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []query.Answer{{Text: "Switching on."}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Bot.Answer() = %v, want %v", got, want)
+	}
+}
+
+func TestBot_ReloadConcurrent(t *testing.T) {
+	testBot := newTestBot(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := testBot.Reload(nil, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			sender := string(rune('a' + i))
+			for _, text := range []string{"on", "off"} {
+				if _, err := testBot.Answer(ctx, &messages.Receive{Question: &query.Question{Sender: sender, Text: text}}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := testBot.Bundle().Version; got != 5 {
+		t.Errorf("Bot.Bundle() version = %v, want 5", got)
+	}
+}
+
+func TestBot_ReloadHandler(t *testing.T) {
+	testBot := newTestBot(t)
+
+	ts := httptest.NewServer(testBot.Router)
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		wantCode    int
+		wantVersion int64
+	}{
+		{name: "status", method: http.MethodGet, path: "/bot/status", wantCode: http.StatusOK, wantVersion: 1},
+		{name: "reload", method: http.MethodPost, path: "/bot/reload", wantCode: http.StatusOK, wantVersion: 2},
+		{name: "status after reload", method: http.MethodGet, path: "/bot/status", wantCode: http.StatusOK, wantVersion: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("%s %s code = %v, want %v", tt.method, tt.path, resp.StatusCode, tt.wantCode)
+			}
+
+			var status bot.Status
+			if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+				t.Fatal(err)
+			}
+			if status.Version != tt.wantVersion {
+				t.Errorf("%s %s version = %v, want %v", tt.method, tt.path, status.Version, tt.wantVersion)
+			}
+		})
+	}
+
+	testBot.Config.Path = t.TempDir()
+	resp, err := http.Post(ts.URL+"/bot/reload", "application/json", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var status bot.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnprocessableEntity || status.Version != 2 || status.LastReload == nil || status.LastReload.Error == "" {
+		t.Errorf("POST /bot/reload without configuration = %v %+v, want version 2 with a failed reload", resp.StatusCode, status)
+	}
+}
@@ -1,7 +1,9 @@
 package bot
 
 import (
+	"context"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jaimeteb/chatto/internal/channels"
@@ -10,12 +12,12 @@ import (
 	"github.com/jaimeteb/chatto/internal/fsm"
 	store "github.com/jaimeteb/chatto/internal/fsm/store"
 	storeconfig "github.com/jaimeteb/chatto/internal/fsm/store/config"
+	"github.com/jaimeteb/chatto/internal/logger"
+	"github.com/jaimeteb/chatto/internal/tracing"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-var watchSleep = 5 * time.Second
-
 // ConversationConfig for the bot
 type ConversationConfig struct {
 	ReplyUnsure  bool `mapstructure:"reply_unsure"`
@@ -44,6 +46,15 @@ type Config struct {
 	Conversation   Conversation `mapstructure:"conversation"`
 	Auth           Auth         `mapstructure:"auth"`
 	EnableRESTCORS bool         `mapstructure:"enable_rest_cors"`
+
+	Logging logger.Config  `mapstructure:"logging"`
+	Tracing tracing.Config `mapstructure:"tracing"`
+
+	Readiness ReadinessConfig `mapstructure:"readiness"`
+
+	// ShutdownTimeout is how long the requests in flight have to finish
+	// when the bot stops, 0 waits for them without a limit
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
 }
 
 // ShouldReplyUnsure depending on the conversational settings lets
@@ -93,7 +104,13 @@ func LoadConfig(path string, port int) (*Config, error) {
 	config.SetDefault("conversation.existing.reply_error", true)
 	config.SetDefault("store.ttl", "-1s")
 	config.SetDefault("store.purge", "-1s")
+	config.SetDefault("store.history", 100)
 	config.SetDefault("store.enable_rest_cors", false)
+	config.SetDefault("shutdown_timeout", "20s")
+	config.SetDefault("logging.format", logger.FormatText)
+	config.SetDefault("logging.redact_text", false)
+	config.SetDefault("tracing.sample_ratio", 1)
+	config.SetDefault("readiness.timeout", "2s")
 
 	if err := config.ReadInConfig(); err != nil {
 		switch err.(type) {
@@ -127,9 +144,19 @@ func loadName(name string) string {
 
 // New initializes and returns a new Bot
 func New(botConfig *Config) (*Bot, error) {
+	// Log in the format of bot.yml from now on
+	if err := logger.Configure(botConfig.Logging); err != nil {
+		return nil, err
+	}
+
+	machines, err := store.New(&botConfig.Store)
+	if err != nil {
+		return nil, err
+	}
+
 	b := &Bot{
 		Name:   loadName(botConfig.Name),
-		Store:  store.New(&botConfig.Store),
+		Store:  store.Instrument(machines),
 		Config: botConfig,
 	}
 
@@ -140,21 +167,29 @@ func New(botConfig *Config) (*Bot, error) {
 	}
 	b.Channels = channels.New(channelsConfig)
 
-	// Load FSM Domain
-	fsmReloadChan := make(chan fsm.Config)
-	fsmConfig, err := fsm.LoadConfig(botConfig.Path, fsmReloadChan)
+	// Export the traces of the bot
+	shutdownTracing, err := tracing.Setup(context.Background(), botConfig.Tracing, b.Name)
 	if err != nil {
 		return nil, err
 	}
-	b.Domain = fsm.NewDomainFromConfig(fsmConfig)
+	b.shutdownTracing = shutdownTracing
 
-	// Load Classifier
-	classifReloadChan := make(chan clf.Config)
-	classifConfig, err := clf.LoadConfig(botConfig.Path, classifReloadChan)
+	// Load FSM Domain and Classifier
+	fsmConfig, err := fsm.LoadConfig(botConfig.Path)
 	if err != nil {
 		return nil, err
 	}
-	b.Classifier = clf.New(classifConfig)
+
+	classifConfig, err := clf.LoadConfig(botConfig.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := NewBundle(fsmConfig, classifConfig)
+	if err != nil {
+		return nil, err
+	}
+	b.SetBundle(bundle)
 
 	// Load Extensions
 	extensionMap, err := extension.New(botConfig.Extensions)
@@ -166,27 +201,41 @@ func New(botConfig *Config) (*Bot, error) {
 	// Register HTTP handlers
 	b.RegisterRoutes()
 
-	// Reload the FSM Domain and CLF Classifier if the configs change
-	receiveAndReload(b, fsmReloadChan, classifReloadChan)
-
 	log.Infof("My name is '%v'", b.Name)
 
 	return b, nil
 }
 
-func receiveAndReload(b *Bot, fsmReloadChan chan fsm.Config, classifReloadChan chan clf.Config) {
+// watchConfig reloads the bot every time the FSM or classifier
+// configuration changes until ctx is done, see Bot.Reload
+func (b *Bot) watchConfig(ctx context.Context, wg *sync.WaitGroup) error {
+	fsmReloadChan := make(chan fsm.Config)
+	if err := fsm.WatchConfig(ctx, b.Config.Path, fsmReloadChan); err != nil {
+		return err
+	}
+
+	classifReloadChan := make(chan clf.Config)
+	if err := clf.WatchConfig(ctx, b.Config.Path, classifReloadChan); err != nil {
+		return err
+	}
+
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
 		for {
+			// Failed reloads are logged and keep the current Bundle
 			select {
+			case <-ctx.Done():
+				return
 			case fsmConfig := <-fsmReloadChan:
-				b.Domain = fsm.NewDomainFromConfig(&fsmConfig)
+				_, _ = b.Reload(&fsmConfig, nil)
 			case classifConfig := <-classifReloadChan:
-				b.Classifier = clf.New(&classifConfig)
-			default:
-				time.Sleep(watchSleep)
+				_, _ = b.Reload(nil, &classifConfig)
 			}
 		}
 	}()
+
+	return nil
 }
 
 const smileyFace = "😊"
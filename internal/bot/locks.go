@@ -0,0 +1,43 @@
+package bot
+
+import "sync"
+
+// senderLocks serializes the turns of each sender within the process,
+// the lock of a sender is dropped once nobody holds or waits for it
+type senderLocks struct {
+	mu    sync.Mutex
+	locks map[string]*senderLock
+}
+
+// senderLock is the lock of a sender and the number of turns using it
+type senderLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the sender and returns the function that unlocks it
+func (l *senderLocks) lock(sender string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*senderLock)
+	}
+	lock, ok := l.locks[sender]
+	if !ok {
+		lock = &senderLock{}
+		l.locks[sender] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, sender)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	store "github.com/jaimeteb/chatto/internal/fsm/store"
+	"github.com/jaimeteb/chatto/version"
+)
+
+// Dependencies checked by Ready, extension servers are
+// named DependencyExtensions followed by the server name
+const (
+	DependencyStore      = "store"
+	DependencyClassifier = "classifier"
+	DependencyExtensions = "extensions."
+)
+
+// Statuses of the dependencies
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// defaultReadinessTimeout is used when the readiness timeout is not positive
+const defaultReadinessTimeout = 2 * time.Second
+
+// ReadinessConfig configures the checks of the dependencies of the bot
+type ReadinessConfig struct {
+	// Optional dependencies don't make the bot unready when they are down:
+	// "store", "classifier" or "extensions.<server>"
+	Optional []string `mapstructure:"optional"`
+	// Timeout of the check of each dependency
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// IsOptional reports whether the dependency is optional
+func (c *ReadinessConfig) IsOptional(dependency string) bool {
+	for _, optional := range c.Optional {
+		if strings.EqualFold(strings.TrimSpace(optional), dependency) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dependency is the outcome of the check of a dependency of the bot
+type Dependency struct {
+	Status   string `json:"status"`
+	Optional bool   `json:"optional"`
+	// Type of the store or the classifier
+	Type string `json:"type,omitempty"`
+	// Version of the extension server
+	Version *version.BuildResponse `json:"version,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// Readiness of the bot, it is ready if none of its required dependencies is down
+type Readiness struct {
+	Ready        bool                  `json:"ready"`
+	Dependencies map[string]Dependency `json:"dependencies"`
+}
+
+// Summary returns the Readiness with only the status of the dependencies
+func (r Readiness) Summary() Readiness {
+	summary := Readiness{Ready: r.Ready, Dependencies: make(map[string]Dependency, len(r.Dependencies))}
+	for name, dependency := range r.Dependencies {
+		summary.Dependencies[name] = Dependency{Status: dependency.Status, Optional: dependency.Optional}
+	}
+	return summary
+}
+
+// Ready checks the store, the classifier and every configured extension
+// server at the same time, each check is given the readiness timeout
+func (b *Bot) Ready(ctx context.Context) Readiness {
+	timeout := b.Config.Readiness.Timeout
+	if timeout <= 0 {
+		timeout = defaultReadinessTimeout
+	}
+
+	checks := map[string]func(context.Context) Dependency{
+		DependencyStore:      b.checkStore,
+		DependencyClassifier: b.checkClassifier,
+	}
+	for _, server := range b.extensionServers() {
+		server := server
+		checks[DependencyExtensions+server] = func(ctx context.Context) Dependency {
+			return b.checkExtension(ctx, server)
+		}
+	}
+
+	readiness := Readiness{Ready: true, Dependencies: make(map[string]Dependency, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		name, check := name, check
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			dependency := check(checkCtx)
+			dependency.Optional = b.Config.Readiness.IsOptional(name)
+
+			mu.Lock()
+			defer mu.Unlock()
+			readiness.Dependencies[name] = dependency
+			if dependency.Status != StatusUp && !dependency.Optional {
+				readiness.Ready = false
+			}
+		}()
+	}
+	wg.Wait()
+
+	return readiness
+}
+
+// extensionServers returns the names of the configured extension
+// servers and of the servers the bot is connected to
+func (b *Bot) extensionServers() []string {
+	servers := make([]string, 0, len(b.Extensions))
+	for server := range b.Config.Extensions {
+		servers = append(servers, server)
+	}
+	for server := range b.Extensions {
+		if _, ok := b.Config.Extensions[server]; !ok {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// checkStore pings the store, which is down if it is not the configured one
+func (b *Bot) checkStore(ctx context.Context) Dependency {
+	storeType := store.Type(b.Store)
+
+	switch configured := strings.ToLower(b.Config.Store.Type); configured {
+	case store.TypeRedis, store.TypeSQL:
+		if storeType != configured {
+			return down(storeType, fmt.Errorf("the %s store is configured, but the bot uses the %s store", configured, storeType))
+		}
+	}
+
+	if err := b.Store.Ping(ctx); err != nil {
+		return down(storeType, err)
+	}
+	return Dependency{Status: StatusUp, Type: storeType}
+}
+
+// checkClassifier checks that the bot has a trained classifier
+func (b *Bot) checkClassifier(_ context.Context) Dependency {
+	bundle := b.Bundle()
+	if bundle == nil || bundle.Classifier == nil || bundle.Classifier.Model == nil {
+		return down("", errors.New("the classifier is not loaded"))
+	}
+
+	var classifierType string
+	if bundle.ClassifConfig != nil {
+		classifierType = bundle.ClassifConfig.Model.Classifier
+	}
+	return Dependency{Status: StatusUp, Type: classifierType}
+}
+
+// checkExtension gets the version of the extension server, which is down
+// if the bot could not connect to it when it started
+func (b *Bot) checkExtension(ctx context.Context, server string) Dependency {
+	client, ok := b.Extensions[server]
+	if !ok {
+		return down("", errors.New("the extension server could not be connected to when the bot started"))
+	}
+
+	build, err := client.GetBuildVersion(ctx)
+	if err != nil {
+		return down("", err)
+	}
+	return Dependency{Status: StatusUp, Version: build}
+}
+
+func down(dependencyType string, err error) Dependency {
+	return Dependency{Status: StatusDown, Type: dependencyType, Error: err.Error()}
+}
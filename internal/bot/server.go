@@ -1,30 +1,46 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jaimeteb/chatto/internal/channels"
 	"github.com/jaimeteb/chatto/internal/channels/messages"
 	"github.com/jaimeteb/chatto/internal/channels/slack"
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
+	"github.com/jaimeteb/chatto/internal/logger"
+	"github.com/jaimeteb/chatto/internal/metrics"
+	"github.com/jaimeteb/chatto/internal/tracing"
 	"github.com/jaimeteb/chatto/query"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ErrValidationFailed happens when a channel cannot validate an incoming callback
 var ErrValidationFailed = errors.New("the callback token is invalid")
 
-// Prediction models a classifier prediction and its original string
+// Prediction models a classifier prediction and its original string,
+// with every command ranked by its probability
 type Prediction struct {
-	Original    string  `json:"original"`
-	Predicted   string  `json:"predicted"`
-	Probability float32 `json:"probability"`
+	Original    string             `json:"original"`
+	Predicted   string             `json:"predicted"`
+	Probability float32            `json:"probability"`
+	Ranking     prediction.Ranking `json:"ranking"`
 }
 
 func (b *Bot) restChannelHandler(w http.ResponseWriter, r *http.Request) {
@@ -60,8 +76,49 @@ func (b *Bot) healthzHandler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// ChannelHandler takes an incoming http.Request and passes it to a channel for it to respond
+// readyzHandler responds with status 503 if a required dependency is down. It
+// doesn't need the authorization token, but the errors and the versions of
+// the dependencies are only shown to authorized requests
+func (b *Bot) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := b.Ready(r.Context())
+
+	code := http.StatusOK
+	if !readiness.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	if err := b.authorize(r); err != nil {
+		readiness = readiness.Summary()
+	}
+
+	js, err := json.Marshal(readiness)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(js); err != nil {
+		log.Error(err)
+	}
+}
+
+// ChannelHandler takes an incoming http.Request and passes it to a channel for it to respond,
+// continuing the trace of the request if it has one. The turn is logged with the ID of the
+// X-Request-ID header, or with a new one, which is sent back in the X-Request-ID header
 func (b *Bot) ChannelHandler(w http.ResponseWriter, r *http.Request, chnl channels.Channel) {
+	turnID := r.Header.Get("X-Request-ID")
+	if turnID == "" {
+		turnID = logger.NewTurnID()
+	}
+	w.Header().Set("X-Request-ID", turnID)
+
+	ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx = logger.WithTurnID(ctx, turnID)
+	ctx, span := tracing.Start(ctx, "ChannelHandler", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	if !chnl.ValidateCallback(r) {
 		http.Error(w, ErrValidationFailed.Error(), http.StatusUnauthorized)
 		return
@@ -69,7 +126,7 @@ func (b *Bot) ChannelHandler(w http.ResponseWriter, r *http.Request, chnl channe
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Error(err)
+		logger.Entry(ctx).Error(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -81,11 +138,11 @@ func (b *Bot) ChannelHandler(w http.ResponseWriter, r *http.Request, chnl channe
 			w.Header().Set("Content-Type", "application/json")
 			_, err = w.Write(e.Challenge)
 			if err != nil {
-				log.Error(err)
+				logger.Entry(ctx).Error(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 		default:
-			log.Error(err)
+			logger.Entry(ctx).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
@@ -94,17 +151,19 @@ func (b *Bot) ChannelHandler(w http.ResponseWriter, r *http.Request, chnl channe
 	if receiveMsg.Question == nil || (*receiveMsg.Question == query.Question{}) {
 		return
 	}
+	span.SetAttributes(attribute.String("chatto.channel", receiveMsg.Channel))
+	ctx = turnContext(ctx, receiveMsg)
 
-	answers, err := b.Answer(receiveMsg)
+	answers, err := b.Answer(ctx, receiveMsg)
 	if err != nil {
-		log.Error(err)
+		logger.Entry(ctx).Error(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = chnl.SendMessage(&messages.Response{Answers: answers, ReplyOpts: receiveMsg.ReplyOpts})
+	err = chnl.SendMessage(ctx, &messages.Response{Answers: answers, ReplyOpts: receiveMsg.ReplyOpts})
 	if err != nil {
-		log.Error(err)
+		logger.Entry(ctx).Error(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -112,25 +171,30 @@ func (b *Bot) ChannelHandler(w http.ResponseWriter, r *http.Request, chnl channe
 	writeAnswer(w, answers)
 }
 
-func (b *Bot) slackChannelEvents() {
-	if b.Channels.Slack != nil {
+// slackChannelEvents answers the Slack socket mode messages until ctx is
+// done, the message being answered when ctx is done is still answered
+func (b *Bot) slackChannelEvents(ctx context.Context, wg *sync.WaitGroup) {
+	if b.Channels != nil && b.Channels.Slack != nil {
 		receiveChan := make(chan messages.Receive)
 
-		go b.Channels.Slack.ReceiveMessages(receiveChan)
+		go b.Channels.Slack.ReceiveMessages(ctx, receiveChan)
 
+		wg.Add(1)
 		go func() {
+			defer wg.Done()
 			for receiveMsg := range receiveChan {
 				r := receiveMsg
+				turnCtx := turnContext(context.Background(), &r)
 
-				answers, err := b.Answer(&r)
+				answers, err := b.Answer(turnCtx, &r)
 				if err != nil {
-					log.Error(err)
+					logger.Entry(turnCtx).Error(err)
 					continue
 				}
 
-				err = b.Channels.Slack.SendMessage(&messages.Response{Answers: answers, ReplyOpts: receiveMsg.ReplyOpts})
+				err = b.Channels.Slack.SendMessage(turnCtx, &messages.Response{Answers: answers, ReplyOpts: receiveMsg.ReplyOpts})
 				if err != nil {
-					log.Error(err)
+					logger.Entry(turnCtx).Error(err)
 					continue
 				}
 			}
@@ -151,14 +215,18 @@ func (b *Bot) detailsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !b.Store.Exists(vars["sender"]) {
+	senderObj, err := b.Store.Get(r.Context(), vars["sender"])
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if senderObj == nil {
 		log.Errorf("sender does not exist: %s", vars["sender"])
 		http.Error(w, "sender does not exist", http.StatusNotFound)
 		return
 	}
 
-	senderObj := b.Store.Get(vars["sender"])
-
 	js, err := json.Marshal(senderObj)
 	if err != nil {
 		log.Error(err)
@@ -175,6 +243,131 @@ func (b *Bot) detailsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (b *Bot) historyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := b.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	if vars == nil {
+		log.Errorf("unable to get sender from request uri: %s", r.URL.RawPath)
+		http.Error(w, "unable to get sender from request uri", http.StatusInternalServerError)
+		return
+	}
+
+	exists, err := b.Store.Exists(r.Context(), vars["sender"])
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		log.Errorf("sender does not exist: %s", vars["sender"])
+		http.Error(w, "sender does not exist", http.StatusNotFound)
+		return
+	}
+
+	turns, err := b.Store.GetHistory(r.Context(), vars["sender"])
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(turns)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(js)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Senders is a page of the senders with a conversation, Next is
+// the cursor of the next page and is empty after the last page
+type Senders struct {
+	Senders []string `json:"senders"`
+	Next    string   `json:"next,omitempty"`
+}
+
+// defaultSendersLimit is the number of senders of a page if no limit is given
+const defaultSendersLimit = 100
+
+func (b *Bot) sendersHandler(w http.ResponseWriter, r *http.Request) {
+	if err := b.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	limit := defaultSendersLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	senders, next, err := b.Store.List(r.Context(), r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(Senders{Senders: senders, Next: next})
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		log.Error(err)
+	}
+}
+
+func (b *Bot) deleteSenderHandler(w http.ResponseWriter, r *http.Request) {
+	if err := b.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	sender := mux.Vars(r)["sender"]
+
+	// A turn being answered would save the FSM again
+	unlock := b.locks.lock(sender)
+	defer unlock()
+
+	exists, err := b.Store.Exists(r.Context(), sender)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "sender does not exist", http.StatusNotFound)
+		return
+	}
+
+	if err := b.Store.Delete(r.Context(), sender); err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (b *Bot) predictHandler(w http.ResponseWriter, r *http.Request) {
 	if err := b.authorize(r); err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -192,9 +385,21 @@ func (b *Bot) predictHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	top := 0
+	if t := r.URL.Query().Get("top"); t != "" {
+		if top, err = strconv.Atoi(t); err != nil || top < 0 {
+			http.Error(w, "top must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The prediction continues the trace of the caller
+	ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
 	inputText := question.Text
-	prediction, prob := b.Classifier.Model.Predict(inputText, b.Classifier.Pipeline)
-	answer := Prediction{inputText, prediction, prob}
+	classifier := b.Bundle().Classifier
+	predicted, prob, ranking := classifier.Classify(ctx, inputText)
+	answer := Prediction{inputText, predicted, prob, ranking.Top(top)}
 
 	js, err := json.Marshal(answer)
 	if err != nil {
@@ -212,6 +417,53 @@ func (b *Bot) predictHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (b *Bot) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := b.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	code := http.StatusOK
+	if _, err := b.ReloadConfig(); err != nil {
+		code = http.StatusUnprocessableEntity
+	}
+
+	writeStatus(w, code, b.Status())
+}
+
+func (b *Bot) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if err := b.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	writeStatus(w, http.StatusOK, b.Status())
+}
+
+func (b *Bot) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := b.authorize(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	metrics.Handler().ServeHTTP(w, r)
+}
+
+func writeStatus(w http.ResponseWriter, code int, status Status) {
+	js, err := json.Marshal(status)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(js); err != nil {
+		log.Error(err)
+	}
+}
+
 func (b *Bot) authorize(r *http.Request) error {
 	if b.Config.Auth.Token != "" {
 		reqToken := r.Header.Get("Authorization")
@@ -224,21 +476,108 @@ func (b *Bot) authorize(r *http.Request) error {
 	return nil
 }
 
-// Run starts the bot which is a long running process
+// Run starts the bot which is a long running process,
+// it shuts down gracefully on SIGINT or SIGTERM, see Serve
 func (b *Bot) Run() {
-	// Start event listeners
-	b.slackChannelEvents()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", b.Config.Port))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info(smileyFace)
+	log.Infof("Bot server listening on port %d ...", b.Config.Port)
+
+	if err := b.Serve(ctx, listener); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("Bot server stopped")
+}
+
+// Serve answers the HTTP requests of the listener and the channel events,
+// and reloads the configuration when it changes, until ctx is done. Then it
+// stops accepting requests, waits up to Config.ShutdownTimeout for the
+// requests in flight, stops the channel receivers and the configuration
+// watchers, and closes the store and extension clients
+func (b *Bot) Serve(ctx context.Context, listener net.Listener) error {
+	loopsCtx, stopLoops := context.WithCancel(context.Background())
+	defer stopLoops()
+
+	var loops sync.WaitGroup
+
+	if err := b.watchConfig(loopsCtx, &loops); err != nil {
+		return err
+	}
+	b.slackChannelEvents(loopsCtx, &loops)
 
-	// Start web server
 	server := &http.Server{
-		Addr:              fmt.Sprintf(":%d", b.Config.Port),
 		Handler:           b.Router,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	log.Info(smileyFace)
-	log.Infof("Bot server listening on port %d ...", b.Config.Port)
-	log.Fatal(server.ListenAndServe())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		log.Info("Shutting down, waiting for the requests in flight...")
+		err = b.shutdown(server)
+		<-serveErr
+	}
+
+	stopLoops()
+	loops.Wait()
+
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// shutdown stops the server once its requests in flight are done
+func (b *Bot) shutdown(server *http.Server) error {
+	ctx := context.Background()
+	if b.Config.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Config.ShutdownTimeout)
+		defer cancel()
+	}
+
+	return server.Shutdown(ctx)
+}
+
+// Close closes the store and the extension clients of the bot, and flushes its traces
+func (b *Bot) Close() error {
+	var err error
+
+	if b.Store != nil {
+		if storeErr := b.Store.Close(); storeErr != nil {
+			log.Errorf("Error closing the store: %v", storeErr)
+			err = storeErr
+		}
+	}
+
+	if b.Extensions != nil {
+		if extErr := b.Extensions.Close(); extErr != nil && err == nil {
+			err = extErr
+		}
+	}
+
+	if b.shutdownTracing != nil {
+		if tracingErr := b.shutdownTracing(context.Background()); tracingErr != nil && err == nil {
+			err = tracingErr
+		}
+	}
+
+	return err
 }
 
 // RegisterRoutes with the bot router
@@ -270,8 +609,15 @@ func (b *Bot) RegisterRoutes() {
 
 	// Other bot endpoints
 	r.HandleFunc("/bot/healthz", b.healthzHandler).Methods("GET")
+	r.HandleFunc("/bot/readyz", b.readyzHandler).Methods("GET")
 	r.HandleFunc("/bot/predict", b.predictHandler).Methods("POST")
+	r.HandleFunc("/bot/reload", b.reloadHandler).Methods("POST")
+	r.HandleFunc("/bot/status", b.statusHandler).Methods("GET")
+	r.HandleFunc("/bot/metrics", b.metricsHandler).Methods("GET")
+	r.HandleFunc("/bot/senders", b.sendersHandler).Methods("GET")
 	r.HandleFunc("/bot/senders/{sender}", b.detailsHandler).Methods("GET")
+	r.HandleFunc("/bot/senders/{sender}", b.deleteSenderHandler).Methods("DELETE")
+	r.HandleFunc("/bot/senders/{sender}/history", b.historyHandler).Methods("GET")
 
 	b.Router = r
 }
@@ -3,6 +3,7 @@ package channels
 //go:generate mockgen -source=channels.go -destination=mockchannels/mockchannels.go -package=mockchannels
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
@@ -35,10 +36,10 @@ type Channels struct {
 type Channel interface {
 	// ReceiveMessage from the channel
 	ReceiveMessage(body []byte) (*messages.Receive, error)
-	// ReceiveMessages from the channel. Starts a long running process, receives questions and sends them to the receiveChan
-	ReceiveMessages(receiveChan chan messages.Receive)
-	// SendMessage to the channel
-	SendMessage(response *messages.Response) error
+	// ReceiveMessages from the channel. Starts a long running process, receives questions and sends them to the receiveChan until ctx is done
+	ReceiveMessages(ctx context.Context, receiveChan chan messages.Receive)
+	// SendMessage to the channel, ctx has the log fields of the turn
+	SendMessage(ctx context.Context, response *messages.Response) error
 	// ValidateCallback validates a callback to the channel
 	ValidateCallback(r *http.Request) bool
 	// String returns the channel's name
@@ -5,6 +5,7 @@
 package mockchannels
 
 import (
+	context "context"
 	http "net/http"
 	reflect "reflect"
 
@@ -51,29 +52,29 @@ func (mr *MockChannelMockRecorder) ReceiveMessage(body interface{}) *gomock.Call
 }
 
 // ReceiveMessages mocks base method.
-func (m *MockChannel) ReceiveMessages(receiveChan chan messages.Receive) {
+func (m *MockChannel) ReceiveMessages(ctx context.Context, receiveChan chan messages.Receive) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "ReceiveMessages", receiveChan)
+	m.ctrl.Call(m, "ReceiveMessages", ctx, receiveChan)
 }
 
 // ReceiveMessages indicates an expected call of ReceiveMessages.
-func (mr *MockChannelMockRecorder) ReceiveMessages(receiveChan interface{}) *gomock.Call {
+func (mr *MockChannelMockRecorder) ReceiveMessages(ctx, receiveChan interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceiveMessages", reflect.TypeOf((*MockChannel)(nil).ReceiveMessages), receiveChan)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceiveMessages", reflect.TypeOf((*MockChannel)(nil).ReceiveMessages), ctx, receiveChan)
 }
 
 // SendMessage mocks base method.
-func (m *MockChannel) SendMessage(response *messages.Response) error {
+func (m *MockChannel) SendMessage(ctx context.Context, response *messages.Response) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SendMessage", response)
+	ret := m.ctrl.Call(m, "SendMessage", ctx, response)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SendMessage indicates an expected call of SendMessage.
-func (mr *MockChannelMockRecorder) SendMessage(response interface{}) *gomock.Call {
+func (mr *MockChannelMockRecorder) SendMessage(ctx, response interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockChannel)(nil).SendMessage), response)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockChannel)(nil).SendMessage), ctx, response)
 }
 
 // String mocks base method.
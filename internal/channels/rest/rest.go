@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strings"
@@ -31,7 +32,7 @@ func New(config Config) *Channel {
 }
 
 // SendMessage for REST
-func (c *Channel) SendMessage(response *messages.Response) error {
+func (c *Channel) SendMessage(_ context.Context, response *messages.Response) error {
 	// Not implemented
 	return nil
 }
@@ -56,7 +57,7 @@ func (c *Channel) ReceiveMessage(body []byte) (*messages.Receive, error) {
 }
 
 // ReceiveMessages uses event queues to receive messages. Starts a long running process
-func (c *Channel) ReceiveMessages(receiveChan chan messages.Receive) {
+func (c *Channel) ReceiveMessages(ctx context.Context, receiveChan chan messages.Receive) {
 	// Not implemented
 }
 
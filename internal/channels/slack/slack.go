@@ -3,11 +3,13 @@ package slack
 //go:generate mockgen -source=slack.go -destination=mockslack/mockslack.go -package=mockslack
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"time"
 
 	"github.com/jaimeteb/chatto/internal/channels/messages"
+	"github.com/jaimeteb/chatto/internal/logger"
 	"github.com/jaimeteb/chatto/query"
 	log "github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
@@ -73,8 +75,11 @@ func New(config Config) *Channel {
 }
 
 // SendMessage to Slack with the bots response
-func (c *Channel) SendMessage(response *messages.Response) error {
-	for _, answer := range response.Answers {
+func (c *Channel) SendMessage(ctx context.Context, response *messages.Response) error {
+	entry := logger.Entry(ctx)
+	redacted := logger.RedactAnswers(response.Answers)
+
+	for i, answer := range response.Answers {
 		slackMsgOptions := []slack.MsgOption{}
 
 		if answer.Image != "" {
@@ -95,13 +100,13 @@ func (c *Channel) SendMessage(response *messages.Response) error {
 			slackMsgOptions = append(slackMsgOptions, slack.MsgOptionTS(response.ReplyOpts.Slack.TS))
 		}
 
-		log.Debugf("Sending Slack message: %+v", answer)
+		entry.Debugf("Sending Slack message: %+v", redacted[i])
 		ret, _, err := c.Client.PostMessage(response.ReplyOpts.Slack.Channel, slackMsgOptions...)
 		if err != nil {
-			log.Errorf("%s: %+v", err, ret)
+			entry.Errorf("%s: %+v", err, ret)
 			return err
 		}
-		log.Debugf("Slack response: %s", ret)
+		entry.Debugf("Slack response: %s", ret)
 
 		time.Sleep(c.delay)
 	}
@@ -130,8 +135,12 @@ func (c *Channel) ReceiveMessage(body []byte) (*messages.Receive, error) {
 		return &messages.Receive{}, nil
 	}
 
+	event := slackMsg.Event
+	if logger.Redacting() {
+		event = slack.Msg{Type: event.Type, Channel: event.Channel, User: event.User, Text: logger.Redacted, Timestamp: event.Timestamp, ThreadTimestamp: event.ThreadTimestamp}
+	}
 	log.Debug(slackMsg.Type)
-	log.Debugf("%+v", slackMsg.Event)
+	log.Debugf("%+v", event)
 
 	ts := slackMsg.Event.Timestamp
 	if slackMsg.Event.ThreadTimestamp != "" {
@@ -155,106 +164,130 @@ func (c *Channel) ReceiveMessage(body []byte) (*messages.Receive, error) {
 	return receive, nil
 }
 
-// ReceiveMessages uses event queues to receive messages. Starts a long running process
-func (c *Channel) ReceiveMessages(receiveChan chan messages.Receive) {
+// ReceiveMessages uses event queues to receive messages. Starts a long running
+// process that stops forwarding messages and closes receiveChan when ctx is done
+func (c *Channel) ReceiveMessages(ctx context.Context, receiveChan chan messages.Receive) {
 	defer close(receiveChan)
 
 	if c.SocketClient == nil {
 		return
 	}
 
+	// The socket mode client cannot be stopped, it ends with the process
 	go func() {
-		for evt := range c.SocketClientEvents {
-			switch evt.Type {
-			case socketmode.EventTypeHello, socketmode.EventTypeInteractive, socketmode.EventTypeSlashCommand:
-				// Ignore
-			case socketmode.EventTypeInvalidAuth:
-				log.Error("Invalid auth when connecting to Slack...")
-			case socketmode.EventTypeIncomingError:
-				log.Error("Event type incoming error from Slack...")
-			case socketmode.EventTypeErrorWriteFailed:
-				log.Error("Writing event message to Slack failed...")
-			case socketmode.EventTypeErrorBadMessage:
-				log.Error("Bad event message from Slack...")
-			case socketmode.EventTypeDisconnect:
-				log.Warn("Disconnected from Slack...")
-			case socketmode.EventTypeConnecting:
-				log.Info("Connecting to Slack with Socket Mode...")
-			case socketmode.EventTypeConnectionError:
-				log.Error("Connection to Slack failed. Retrying later...")
-			case socketmode.EventTypeConnected:
-				log.Info("Connected to Slack with Socket Mode")
-			case socketmode.EventTypeEventsAPI:
-				eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
-				if !ok {
-					log.Warnf("Ignored %+v", evt)
-					continue
-				}
-				c.SocketClient.Ack(*evt.Request)
-
-				switch eventsAPIEvent.Type {
-				case slackevents.CallbackEvent:
-					innerEvent := eventsAPIEvent.InnerEvent
-					switch ev := innerEvent.Data.(type) {
-					case *slackevents.MessageEvent:
-						if ev.BotID != "" {
-							continue
-						}
-
-						ts := ev.TimeStamp
-						if ev.ThreadTimeStamp != "" {
-							ts = ev.ThreadTimeStamp
-						}
-
-						receiveChan <- messages.Receive{
-							Question: &query.Question{
-								Text:   ev.Text,
-								Sender: ev.User,
-							},
-							ReplyOpts: &messages.ReplyOpts{
-								Slack: messages.SlackReplyOpts{
-									Channel: ev.Channel,
-									TS:      ts,
-								},
-							},
-							Channel: c.String(),
-						}
-					case *slackevents.AppMentionEvent:
-						if ev.BotID != "" {
-							continue
-						}
-
-						ts := ev.TimeStamp
-						if ev.ThreadTimeStamp != "" {
-							ts = ev.ThreadTimeStamp
-						}
-
-						receiveChan <- messages.Receive{
-							Question: &query.Question{
-								Text:   ev.Text,
-								Sender: ev.User,
+		if err := c.SocketClient.Run(); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	for {
+		var evt socketmode.Event
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-c.SocketClientEvents:
+			if !ok {
+				return
+			}
+			evt = e
+		}
+
+		switch evt.Type {
+		case socketmode.EventTypeHello, socketmode.EventTypeInteractive, socketmode.EventTypeSlashCommand:
+			// Ignore
+		case socketmode.EventTypeInvalidAuth:
+			log.Error("Invalid auth when connecting to Slack...")
+		case socketmode.EventTypeIncomingError:
+			log.Error("Event type incoming error from Slack...")
+		case socketmode.EventTypeErrorWriteFailed:
+			log.Error("Writing event message to Slack failed...")
+		case socketmode.EventTypeErrorBadMessage:
+			log.Error("Bad event message from Slack...")
+		case socketmode.EventTypeDisconnect:
+			log.Warn("Disconnected from Slack...")
+		case socketmode.EventTypeConnecting:
+			log.Info("Connecting to Slack with Socket Mode...")
+		case socketmode.EventTypeConnectionError:
+			log.Error("Connection to Slack failed. Retrying later...")
+		case socketmode.EventTypeConnected:
+			log.Info("Connected to Slack with Socket Mode")
+		case socketmode.EventTypeEventsAPI:
+			eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
+			if !ok {
+				log.Warnf("Ignored %+v", evt)
+				continue
+			}
+			c.SocketClient.Ack(*evt.Request)
+
+			switch eventsAPIEvent.Type {
+			case slackevents.CallbackEvent:
+				innerEvent := eventsAPIEvent.InnerEvent
+				switch ev := innerEvent.Data.(type) {
+				case *slackevents.MessageEvent:
+					if ev.BotID != "" {
+						continue
+					}
+
+					ts := ev.TimeStamp
+					if ev.ThreadTimeStamp != "" {
+						ts = ev.ThreadTimeStamp
+					}
+
+					receive := messages.Receive{
+						Question: &query.Question{
+							Text:   ev.Text,
+							Sender: ev.User,
+						},
+						ReplyOpts: &messages.ReplyOpts{
+							Slack: messages.SlackReplyOpts{
+								Channel: ev.Channel,
+								TS:      ts,
 							},
-							ReplyOpts: &messages.ReplyOpts{
-								Slack: messages.SlackReplyOpts{
-									Channel: ev.Channel,
-									TS:      ts,
-								},
+						},
+						Channel: c.String(),
+					}
+
+					select {
+					case receiveChan <- receive:
+					case <-ctx.Done():
+						return
+					}
+				case *slackevents.AppMentionEvent:
+					if ev.BotID != "" {
+						continue
+					}
+
+					ts := ev.TimeStamp
+					if ev.ThreadTimeStamp != "" {
+						ts = ev.ThreadTimeStamp
+					}
+
+					receive := messages.Receive{
+						Question: &query.Question{
+							Text:   ev.Text,
+							Sender: ev.User,
+						},
+						ReplyOpts: &messages.ReplyOpts{
+							Slack: messages.SlackReplyOpts{
+								Channel: ev.Channel,
+								TS:      ts,
 							},
-							Channel: c.String(),
-						}
+						},
+						Channel: c.String(),
+					}
+
+					select {
+					case receiveChan <- receive:
+					case <-ctx.Done():
+						return
 					}
-				default:
-					log.Debugf("Unsupported Events API event received")
 				}
 			default:
-				log.Debugf("Unexpected event type received: %s", evt.Type)
+				log.Debugf("Unsupported Events API event received")
 			}
+		default:
+			log.Debugf("Unexpected event type received: %s", evt.Type)
 		}
-	}()
-
-	err := c.SocketClient.Run()
-	if err != nil {
-		log.Error(err)
 	}
 }
 
@@ -1,6 +1,7 @@
 package slack_test
 
 import (
+	"context"
 	"reflect"
 	"testing"
 	"time"
@@ -59,7 +60,7 @@ func TestChannel_SendMessage(t *testing.T) {
 			c := &slack.Channel{
 				Client: tt.fields.Client,
 			}
-			if err := c.SendMessage(tt.args.response); (err != nil) != tt.wantErr {
+			if err := c.SendMessage(context.Background(), tt.args.response); (err != nil) != tt.wantErr {
 				t.Errorf("Channel.SendMessage() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -139,9 +140,8 @@ func TestChannel_ReceiveMessages(t *testing.T) {
 				SocketClient:       socketClient,
 				SocketClientEvents: make(chan socketmode.Event),
 				mockAck:            socketClient.EXPECT().Ack(gomock.Any()).Return(),
-				mockRun: socketClient.EXPECT().Run().Do(func() {
-					time.Sleep(5 * time.Second)
-				}),
+				// Run is started in the background and may not be called before ctx is done
+				mockRun: socketClient.EXPECT().Run().AnyTimes(),
 			},
 			args: args{
 				receiveChan: make(chan messages.Receive),
@@ -183,7 +183,10 @@ func TestChannel_ReceiveMessages(t *testing.T) {
 				SocketClientEvents: tt.fields.SocketClientEvents,
 			}
 
-			go c.ReceiveMessages(tt.args.receiveChan)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go c.ReceiveMessages(ctx, tt.args.receiveChan)
 
 			tt.fields.SocketClientEvents <- tt.args.slackEvent
 
@@ -194,6 +197,17 @@ func TestChannel_ReceiveMessages(t *testing.T) {
 
 				break
 			}
+
+			cancel()
+
+			select {
+			case _, ok := <-tt.args.receiveChan:
+				if ok {
+					t.Error("Channel.ReceiveMessages() sent a message after ctx was done")
+				}
+			case <-time.After(time.Second):
+				t.Error("Channel.ReceiveMessages() did not close receiveChan after ctx was done")
+			}
 		})
 	}
 }
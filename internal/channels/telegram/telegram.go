@@ -3,6 +3,7 @@ package telegram
 //go:generate mockgen -source=telegram.go -destination=mocktelegram/mocktelegram.go -package=mocktelegram
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/jaimeteb/chatto/internal/channels/messages"
+	"github.com/jaimeteb/chatto/internal/logger"
 	"github.com/jaimeteb/chatto/query"
 	"github.com/kimrgrey/go-telegram"
 	log "github.com/sirupsen/logrus"
@@ -63,8 +65,11 @@ func New(config Config) *Channel {
 }
 
 // SendMessage for Telegram
-func (c *Channel) SendMessage(response *messages.Response) error {
-	for _, answer := range response.Answers {
+func (c *Channel) SendMessage(ctx context.Context, response *messages.Response) error {
+	entry := logger.Entry(ctx)
+	redacted := logger.RedactAnswers(response.Answers)
+
+	for i, answer := range response.Answers {
 		respValues := url.Values{}
 		respValues.Add("chat_id", response.ReplyOpts.Telegram.Recipient)
 		respValues.Add("parse_mode", "Markdown")
@@ -81,9 +86,11 @@ func (c *Channel) SendMessage(response *messages.Response) error {
 		}
 
 		apiResp := new(interface{})
-		log.Debugf("Sending Telegram message: %+v", answer)
+		entry.Debugf("Sending Telegram message: %+v", redacted[i])
 		c.Client.Call(method, respValues, apiResp)
-		log.Debugf("Telegram response: %+v", apiResp)
+		if !logger.Redacting() {
+			entry.Debugf("Telegram response: %+v", apiResp)
+		}
 
 		time.Sleep(c.delay)
 	}
@@ -118,7 +125,7 @@ func (c *Channel) ReceiveMessage(body []byte) (*messages.Receive, error) {
 }
 
 // ReceiveMessages uses event queues to receive messages. Starts a long running process
-func (c *Channel) ReceiveMessages(receiveChan chan messages.Receive) {
+func (c *Channel) ReceiveMessages(ctx context.Context, receiveChan chan messages.Receive) {
 	// Not implemented
 }
 
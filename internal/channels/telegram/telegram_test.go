@@ -1,6 +1,7 @@
 package telegram_test
 
 import (
+	"context"
 	"net/url"
 	"reflect"
 	"testing"
@@ -61,7 +62,7 @@ func TestChannel_SendMessage(t *testing.T) {
 			c := &telegram.Channel{
 				Client: tt.fields.Client,
 			}
-			if err := c.SendMessage(tt.args.response); (err != nil) != tt.wantErr {
+			if err := c.SendMessage(context.Background(), tt.args.response); (err != nil) != tt.wantErr {
 				t.Errorf("Channel.SendMessage() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -4,12 +4,14 @@ package twilio
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/ajg/form"
 	"github.com/jaimeteb/chatto/internal/channels/messages"
+	"github.com/jaimeteb/chatto/internal/logger"
 	"github.com/jaimeteb/chatto/query"
 	"github.com/kevinburke/twilio-go"
 	log "github.com/sirupsen/logrus"
@@ -66,8 +68,11 @@ func New(config Config) *Channel {
 }
 
 // SendMessage for Twilio
-func (c *Channel) SendMessage(response *messages.Response) error {
-	for _, answer := range response.Answers {
+func (c *Channel) SendMessage(ctx context.Context, response *messages.Response) error {
+	entry := logger.Entry(ctx)
+	redacted := logger.RedactAnswers(response.Answers)
+
+	for i, answer := range response.Answers {
 		var imageURL []*url.URL
 
 		if answer.Image != "" {
@@ -75,12 +80,14 @@ func (c *Channel) SendMessage(response *messages.Response) error {
 			imageURL = append(imageURL, u)
 		}
 
-		log.Debugf("Sending Twilio message: %+v", answer)
+		entry.Debugf("Sending Twilio message: %+v", redacted[i])
 		apiResp, err := c.Client.SendMessage(c.Number, response.ReplyOpts.Twilio.Recipient, answer.Text, imageURL)
 		if err != nil {
 			return err
 		}
-		log.Debugf("Twilio response: %+v", apiResp)
+		if !logger.Redacting() {
+			entry.Debugf("Twilio response: %+v", apiResp)
+		}
 
 		time.Sleep(c.delay)
 	}
@@ -116,7 +123,7 @@ func (c *Channel) ReceiveMessage(body []byte) (*messages.Receive, error) {
 }
 
 // ReceiveMessages uses event queues to receive messages. Starts a long running process
-func (c *Channel) ReceiveMessages(receiveChan chan messages.Receive) {
+func (c *Channel) ReceiveMessages(ctx context.Context, receiveChan chan messages.Receive) {
 	// Not implemented
 }
 
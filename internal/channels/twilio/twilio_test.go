@@ -1,6 +1,7 @@
 package twilio_test
 
 import (
+	"context"
 	"net/url"
 	"reflect"
 	"testing"
@@ -60,7 +61,7 @@ func TestChannel_SendMessage(t *testing.T) {
 				Client: tt.fields.Client,
 				Number: tt.fields.Number,
 			}
-			if err := c.SendMessage(tt.args.response); (err != nil) != tt.wantErr {
+			if err := c.SendMessage(context.Background(), tt.args.response); (err != nil) != tt.wantErr {
 				t.Errorf("Channel.SendMessage() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
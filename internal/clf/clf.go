@@ -1,19 +1,29 @@
 package clf
 
 import (
+	"context"
+	"fmt"
 	"os"
 
+	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/clf/dataset"
 	"github.com/jaimeteb/chatto/internal/clf/knn"
 	"github.com/jaimeteb/chatto/internal/clf/naivebayes"
 	"github.com/jaimeteb/chatto/internal/clf/pipeline"
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
+	"github.com/jaimeteb/chatto/internal/tracing"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Classifier models a classifier and its classes
 type Classifier struct {
 	Model    Model
 	Pipeline *pipeline.Config
+	// Labels maps the commands to the names shown to the user
+	Labels map[string]string
+	// Entities are the enums the slots of the FSM can extract
+	Entities []fsm.Entity
 }
 
 // Model interface contains the basic functions for a model to have
@@ -25,22 +35,49 @@ type Model interface {
 	// Predict makes a class prediction based on the trained model
 	Predict(text string, pipe *pipeline.Config) (predictedClass string, proba float32)
 
+	// Rank returns every class ranked by its probability for the text,
+	// without applying the threshold of the pipeline
+	Rank(text string, pipe *pipeline.Config) prediction.Ranking
+
 	// Save persists the model to a file
 	Save(directory string) error
 }
 
-// New returns a trained Classifier
+// New returns a trained Classifier, errors are only logged, see Train
 func New(config *Config) *Classifier {
+	classifier, err := Train(config)
+	if err != nil {
+		log.Error(err)
+	}
+	return classifier
+}
+
+// Train returns a trained Classifier, or the loaded one if the model is
+// loaded. It fails if the saved model cannot be loaded, in which case the
+// returned Classifier has no model
+func Train(config *Config) (*Classifier, error) {
 	pipe := config.Pipeline
 
 	log.Info("Pipeline:")
 	log.Infof("* RemoveSymbols: %v", pipe.RemoveSymbols)
 	log.Infof("* Lower:         %v", pipe.Lower)
 	log.Infof("* Threshold:     %v", pipe.Threshold)
+	log.Infof("* Margin:        %v", pipe.Margin)
 
+	labels := make(map[string]string)
 	log.Info("Loaded commands for classifier:")
 	for i, c := range config.Classification {
 		log.Infof("%2d %v", i, c.Command)
+		if c.Label != "" {
+			labels[c.Command] = c.Label
+		}
+	}
+
+	if len(config.Entities) > 0 {
+		log.Info("Loaded entities:")
+		for _, e := range config.Entities {
+			log.Infof("* %v (%d values)", e.Name, len(e.Values))
+		}
 	}
 
 	log.Infof("Using %s classifier with parameters:", config.Model.Classifier)
@@ -49,8 +86,9 @@ func New(config *Config) *Classifier {
 	}
 
 	var (
-		model Model
-		err   error
+		model   Model
+		err     error
+		loadErr error
 	)
 	switch config.Model.Classifier {
 	case "knn":
@@ -64,8 +102,7 @@ func New(config *Config) *Classifier {
 			// Load model
 			log.Info("Loading model...")
 			if model, err = knn.Load(config.Model.Directory); err != nil {
-				log.Error("Failed to load model:", err)
-				// TODO: exit?
+				model, loadErr = nil, fmt.Errorf("failed to load model: %w", err)
 			} else {
 				log.Info("Model loaded successfully.")
 			}
@@ -83,8 +120,7 @@ func New(config *Config) *Classifier {
 			// Load model
 			log.Info("Loading model...")
 			if model, err = naivebayes.Load(config.Model.Directory); err != nil {
-				log.Error("Failed to load model:", err)
-				// TODO: exit?
+				model, loadErr = nil, fmt.Errorf("failed to load model: %w", err)
 			} else {
 				log.Info("Model loaded successfully.")
 			}
@@ -113,7 +149,60 @@ func New(config *Config) *Classifier {
 	return &Classifier{
 		Model:    model,
 		Pipeline: &pipe,
+		Labels:   labels,
+		Entities: config.Entities,
+	}, loadErr
+}
+
+// Label returns the name of the command shown to the user,
+// which is the command itself if it has no label
+func (c *Classifier) Label(command string) string {
+	if label, ok := c.Labels[command]; ok {
+		return label
 	}
+	return command
+}
+
+// Classify ranks the commands for the text and predicts the most probable
+// one, the model is only run once for both. The predicted command is empty,
+// with a probability of -1, if it is under the threshold of the pipeline.
+// The prediction is traced in the "Classifier.Predict" span
+func (c *Classifier) Classify(ctx context.Context, text string) (command string, probability float32, ranking prediction.Ranking) {
+	_, span := tracing.Start(ctx, "Classifier.Predict")
+	defer func() {
+		span.SetAttributes(attribute.String("chatto.command", command), attribute.Float64("chatto.probability", float64(probability)))
+		span.End()
+	}()
+
+	ranking = c.Model.Rank(text, c.Pipeline)
+
+	best := ranking.Best()
+	if best.Command == "" || float64(best.Probability) < c.Pipeline.Threshold {
+		return "", -1.0, ranking
+	}
+	return best.Command, best.Probability, ranking
+}
+
+// Ambiguous returns the two most probable commands of the ranking if the
+// difference between their probabilities is under the margin of the
+// pipeline. Together they must reach the threshold of the pipeline, so
+// texts unlike any command are not treated as ambiguous
+func (c *Classifier) Ambiguous(ranking prediction.Ranking) (prediction.Ranking, bool) {
+	if c.Pipeline.Margin <= 0 {
+		return nil, false
+	}
+
+	top := ranking.Top(2)
+	if len(top) < 2 {
+		return nil, false
+	}
+
+	first, second := float64(top[0].Probability), float64(top[1].Probability)
+	if first-second >= c.Pipeline.Margin || first+second < c.Pipeline.Threshold {
+		return nil, false
+	}
+
+	return top, true
 }
 
 func checkDir(directory string) {
@@ -1,19 +1,24 @@
 package clf
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/clf/dataset"
 	"github.com/jaimeteb/chatto/internal/clf/pipeline"
 	"github.com/jaimeteb/chatto/internal/clf/wordvectors"
+	"github.com/jaimeteb/chatto/internal/configdir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-// Config models a classification yaml file
+// Config models a classification yaml file, the entities
+// are the enums that FSM slots can extract
 type Config struct {
 	Classification dataset.DataSet `yaml:"classification"`
+	Entities       []fsm.Entity    `yaml:"entities"`
 	Pipeline       pipeline.Config `yaml:"pipeline"`
 	Model          ModelConfig     `yaml:"model"`
 }
@@ -40,45 +45,80 @@ type ModelConfig struct {
 	WordVectorsConfig wordvectors.Config `mapstructure:"word_vectors"`
 }
 
-// LoadConfig loads classification configuration from yaml
-func LoadConfig(path string, reloadChan chan Config) (*Config, error) {
+// LoadConfig loads the classification configuration from clf.yml and
+// the files of the clf.d directory, see configdir.Files
+func LoadConfig(path string) (*Config, error) {
+	return loadConfig(path)
+}
+
+// WatchConfig loads the classification configuration into reloadChan every
+// time one of its files changes, until the context is done. A configuration that
+// cannot be loaded is logged and not sent
+func WatchConfig(ctx context.Context, path string, reloadChan chan<- Config) error {
+	reload := func() {
+		log.Info("Reloading CLF configuration.")
+
+		classifConfig, err := loadConfig(path)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		select {
+		case reloadChan <- *classifConfig:
+		case <-ctx.Done():
+		}
+	}
+
+	return configdir.Watch(ctx, path, "clf", reload)
+}
+
+// loadConfig merges the classification configuration files and
+// checks that commands and entities are declared only once
+func loadConfig(path string) (*Config, error) {
+	files, err := configdir.Files(path, "clf")
+	if err != nil {
+		return nil, err
+	}
+
 	config := viper.New()
-	config.SetConfigName("clf")
-	config.AddConfigPath(path)
 	config.SetDefault("pipeline.remove_symbols", true)
 	config.SetDefault("pipeline.lower", true)
 	config.SetDefault("pipeline.threshold", 0.1)
 	config.SetDefault("model.directory", "./model")
 	config.SetDefault("model.word_vectors.truncate", 1.0)
 
-	config.WatchConfig()
-	config.OnConfigChange(func(in fsnotify.Event) {
-		if in.Op == fsnotify.Create || in.Op == fsnotify.Write {
-			log.Info("Reloading CLF configuration.")
-
-			if err := config.ReadInConfig(); err != nil {
-				log.Error(err)
-				return
-			}
+	origins, err := configdir.Read(config, files)
+	if err != nil {
+		return nil, err
+	}
 
-			var classifConfig Config
-			if err := config.Unmarshal(&classifConfig); err != nil {
-				log.Error(err)
-				return
-			}
+	var classifConfig Config
+	if err := config.Unmarshal(&classifConfig); err != nil {
+		return nil, err
+	}
 
-			reloadChan <- classifConfig
+	commands := make(map[string]string, len(classifConfig.Classification))
+	for n, class := range classifConfig.Classification {
+		command := strings.TrimSpace(class.Command)
+		file := origins.File("classification", n)
+		if other, ok := commands[command]; ok && other != file {
+			return nil, fmt.Errorf("command '%s' is declared in %s and %s", command, other, file)
 		}
-	})
-
-	if err := config.ReadInConfig(); err != nil {
-		return nil, err
+		commands[command] = file
 	}
 
-	var classifConfig Config
-	err := config.Unmarshal(&classifConfig)
+	entities := make(map[string]string, len(classifConfig.Entities))
+	for n, entity := range classifConfig.Entities {
+		name := strings.TrimSpace(entity.Name)
+		file := origins.File("entities", n)
+		if other, ok := entities[name]; ok && other != file {
+			return nil, fmt.Errorf("entity '%s' is declared in %s and %s", name, other, file)
+		}
+		entities[name] = file
+	}
 
-	return &classifConfig, err
+	return &classifConfig, nil
 }
 
 func parametersToSlice(params map[string]interface{}) []string {
@@ -90,3 +130,32 @@ func parametersToSlice(params map[string]interface{}) []string {
 	}
 	return s
 }
+
+// LoadTestSet loads the classification texts of a test set file, which has the
+// same format as clf.yml. Every command must be one of the classification
+func LoadTestSet(file string, classification dataset.DataSet) (dataset.DataSet, error) {
+	config := viper.New()
+	config.SetConfigFile(file)
+
+	if err := config.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var testConfig Config
+	if err := config.Unmarshal(&testConfig); err != nil {
+		return nil, err
+	}
+
+	commands := make(map[string]bool, len(classification))
+	for _, class := range classification {
+		commands[class.Command] = true
+	}
+
+	for _, class := range testConfig.Classification {
+		if !commands[class.Command] {
+			return nil, fmt.Errorf("test set command '%s' is not in the classification", class.Command)
+		}
+	}
+
+	return testConfig.Classification, nil
+}
@@ -1,7 +1,10 @@
 package clf_test
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -72,8 +75,7 @@ func TestLoadConfig(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			classifReloadChan := make(chan clf.Config)
-			got, err := clf.LoadConfig(tt.args.path, classifReloadChan)
+			got, err := clf.LoadConfig(tt.args.path)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -84,3 +86,81 @@ func TestLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfig_Directory(t *testing.T) {
+	const greet = `classification:
+  - command: greet
+    texts:
+      - hello
+`
+	tests := []struct {
+		name         string
+		files        map[string]string
+		wantCommands []string
+		wantErr      []string
+	}{
+		{
+			name: "merged",
+			files: map[string]string{
+				"clf.yml":       greet + "pipeline:\n  threshold: 0.3\n",
+				"clf.d/bye.yml": "classification:\n  - command: bye\n    texts:\n      - bye\n",
+				"clf.d/size.yml": `entities:
+  - name: size
+    values:
+      - value: small
+`,
+			},
+			wantCommands: []string{"greet", "bye"},
+		},
+		{
+			name:    "duplicate command",
+			files:   map[string]string{"clf.yml": greet, "clf.d/greet.yml": greet},
+			wantErr: []string{"command 'greet'", "clf.yml", "greet.yml"},
+		},
+		{
+			name: "duplicate entity",
+			files: map[string]string{
+				"clf.d/a.yml": greet + "entities:\n  - name: size\n",
+				"clf.d/b.yml": "entities:\n  - name: size\n",
+			},
+			wantErr: []string{"entity 'size'", "a.yml", "b.yml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(path, name), []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := clf.LoadConfig(path)
+			if tt.wantErr != nil {
+				for _, want := range tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("LoadConfig() error = %v, want an error with %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			commands := make([]string, len(got.Classification))
+			for i, class := range got.Classification {
+				commands[i] = class.Command
+			}
+			if !reflect.DeepEqual(commands, tt.wantCommands) {
+				t.Errorf("LoadConfig() commands = %v, want %v", commands, tt.wantCommands)
+			}
+			if got.Pipeline.Threshold != 0.3 || !got.Pipeline.Lower {
+				t.Errorf("LoadConfig() pipeline = %+v, want the threshold of clf.yml and the defaults", got.Pipeline)
+			}
+		})
+	}
+}
@@ -1,10 +1,75 @@
 package dataset
 
+import (
+	"fmt"
+	"math/rand"
+)
+
 // DataSet contains multiple dataclasses
 type DataSet []DataClass
 
-// DataClass models texts used for training the classifier
+// DataClass models texts used for training the classifier,
+// the Label is the name of the command shown to the user
 type DataClass struct {
 	Command string   `yaml:"command"`
+	Label   string   `yaml:"label"`
 	Texts   []string `yaml:"texts"`
 }
+
+// Fold is a split of a DataSet into texts to train and texts to test with
+type Fold struct {
+	Train DataSet
+	Test  DataSet
+}
+
+// StratifiedKFold splits the DataSet into k folds, every text is tested in
+// exactly one fold and used for training in the others. The texts of every
+// command are shuffled with the seed and spread evenly across the folds, so
+// each fold keeps the proportions of the commands in the DataSet
+func (d DataSet) StratifiedKFold(k int, seed int64) ([]Fold, error) {
+	if k < 2 {
+		return nil, fmt.Errorf("cross-validation needs at least 2 folds, got %d", k)
+	}
+
+	total := 0
+	for _, class := range d {
+		total += len(class.Texts)
+	}
+	if total < k {
+		return nil, fmt.Errorf("cannot split %d texts into %d folds", total, k)
+	}
+
+	folds := make([]Fold, k)
+	for f := range folds {
+		folds[f].Train = make(DataSet, len(d))
+		folds[f].Test = make(DataSet, len(d))
+		for i, class := range d {
+			folds[f].Train[i] = DataClass{Command: class.Command, Texts: []string{}}
+			folds[f].Test[i] = DataClass{Command: class.Command, Texts: []string{}}
+		}
+	}
+
+	r := rand.New(rand.NewSource(seed)) //nolint:gosec // shuffling texts does not need a secure source
+
+	// Keep counting across commands so commands with fewer texts
+	// than folds do not all end up in the first folds
+	next := 0
+	for i, class := range d {
+		texts := append([]string{}, class.Texts...)
+		r.Shuffle(len(texts), func(a, b int) { texts[a], texts[b] = texts[b], texts[a] })
+
+		for _, text := range texts {
+			testFold := next % k
+			for f := range folds {
+				if f == testFold {
+					folds[f].Test[i].Texts = append(folds[f].Test[i].Texts, text)
+				} else {
+					folds[f].Train[i].Texts = append(folds[f].Train[i].Texts, text)
+				}
+			}
+			next++
+		}
+	}
+
+	return folds, nil
+}
@@ -0,0 +1,81 @@
+package dataset_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/jaimeteb/chatto/internal/clf/dataset"
+)
+
+func TestDataSet_StratifiedKFold(t *testing.T) {
+	data := dataset.DataSet{
+		{Command: "greet", Texts: []string{"hi", "hello", "hey", "howdy", "good morning", "good evening"}},
+		{Command: "bye", Texts: []string{"bye", "goodbye", "see you"}},
+		{Command: "thanks", Texts: []string{"thanks"}},
+	}
+
+	tests := []struct {
+		name      string
+		k         int
+		wantSizes [][]int
+		wantErr   bool
+	}{
+		{
+			name:      "three folds",
+			k:         3,
+			wantSizes: [][]int{{2, 1, 1}, {2, 1, 0}, {2, 1, 0}},
+		},
+		{
+			name:    "one fold",
+			k:       1,
+			wantErr: true,
+		},
+		{
+			name:    "more folds than texts",
+			k:       11,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folds, err := data.StratifiedKFold(tt.k, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DataSet.StratifiedKFold() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			for f, fold := range folds {
+				for i, class := range data {
+					if got := len(fold.Test[i].Texts); got != tt.wantSizes[f][i] {
+						t.Errorf("fold %d command %s has %d test texts, want %d", f, class.Command, got, tt.wantSizes[f][i])
+					}
+					if got, want := len(fold.Train[i].Texts), len(class.Texts)-tt.wantSizes[f][i]; got != want {
+						t.Errorf("fold %d command %s has %d train texts, want %d", f, class.Command, got, want)
+					}
+				}
+			}
+
+			// Every text is tested exactly once
+			for i, class := range data {
+				tested := []string{}
+				for _, fold := range folds {
+					tested = append(tested, fold.Test[i].Texts...)
+				}
+				want := append([]string{}, class.Texts...)
+				sort.Strings(tested)
+				sort.Strings(want)
+				if len(tested) != len(want) {
+					t.Fatalf("command %s tested %v, want %v", class.Command, tested, want)
+				}
+				for j := range want {
+					if tested[j] != want[j] {
+						t.Errorf("command %s tested %v, want %v", class.Command, tested, want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"math"
 	"os"
 	"sort"
+
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
 )
 
 // EuclideanDistance calculates euclidean distance between two points
@@ -67,8 +69,8 @@ func (knn *KNN) PredictMany(x [][]float64) (predictedLabels []string, probabilit
 	return
 }
 
-// PredictOne performs a classification on one input vector
-func (knn *KNN) PredictOne(x []float64) (predictedLabel string, probability float64) {
+// nearest returns the K nearest neighbors of an input vector, closest first
+func (knn *KNN) nearest(x []float64) []neighbor {
 	neighs := make([]neighbor, len(knn.Data))
 
 	for i := 0; i < len(knn.Data); i++ {
@@ -82,7 +84,17 @@ func (knn *KNN) PredictOne(x []float64) (predictedLabel string, probability floa
 		return neighs[i].distance < neighs[j].distance
 	})
 
-	nearest := neighs[:knn.K]
+	k := knn.K
+	if k > len(neighs) {
+		k = len(neighs)
+	}
+
+	return neighs[:k]
+}
+
+// PredictOne performs a classification on one input vector
+func (knn *KNN) PredictOne(x []float64) (predictedLabel string, probability float64) {
+	nearest := knn.nearest(x)
 
 	labelFreq := map[string]int{}
 	for _, nn := range nearest {
@@ -105,3 +117,33 @@ func (knn *KNN) PredictOne(x []float64) (predictedLabel string, probability floa
 	probability = float64(labelSort[0].count) / float64(knn.K)
 	return predictedLabel, probability
 }
+
+// Rank returns every label ranked by its share of the K nearest neighbors
+// of an input vector, labels with the same share are ranked by their
+// closest neighbor and labels with no neighbors come last
+func (knn *KNN) Rank(x []float64) prediction.Ranking {
+	labelFreq := map[string]int{}
+	labels := []string{}
+	for _, nn := range knn.nearest(x) {
+		if labelFreq[nn.label] == 0 {
+			labels = append(labels, nn.label)
+		}
+		labelFreq[nn.label]++
+	}
+	for _, label := range knn.Labels {
+		if _, ok := labelFreq[label]; !ok {
+			labelFreq[label] = 0
+			labels = append(labels, label)
+		}
+	}
+
+	candidates := make([]prediction.Candidate, len(labels))
+	for i, label := range labels {
+		candidates[i] = prediction.Candidate{
+			Command:     label,
+			Probability: float32(labelFreq[label]) / float32(knn.K),
+		}
+	}
+
+	return prediction.NewRanking(candidates)
+}
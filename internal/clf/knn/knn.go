@@ -7,7 +7,9 @@ import (
 
 	"github.com/jaimeteb/chatto/internal/clf/dataset"
 	"github.com/jaimeteb/chatto/internal/clf/pipeline"
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
 	"github.com/jaimeteb/chatto/internal/clf/wordvectors"
+	"github.com/jaimeteb/chatto/internal/logger"
 	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
 )
@@ -124,7 +126,7 @@ func (c *Classifier) Predict(text string, pipe *pipeline.Config) (predictedClass
 
 	pred, prob := c.KNN.PredictOne(embeddingsX)
 
-	log.Debugf("CLF | Text '%s' classified as command '%s' with a probability of %.2f", text, pred, prob)
+	log.Debugf("CLF | Text '%s' classified as command '%s' with a probability of %.2f", logger.Redact(text), pred, prob)
 	if prob < pipe.Threshold {
 		return "", -1.0
 	}
@@ -132,6 +134,12 @@ func (c *Classifier) Predict(text string, pipe *pipeline.Config) (predictedClass
 	return pred, float32(prob)
 }
 
+// Rank returns every class ranked by its probability for a given text
+func (c *Classifier) Rank(text string, pipe *pipeline.Config) prediction.Ranking {
+	x := pipeline.Pipeline(text, pipe)
+	return c.KNN.Rank(c.VectorMap.AverageVectors(c.VectorMap.Vectors(x)))
+}
+
 // Save persists the model to a file
 func (c *Classifier) Save(directory string) error {
 	// save Classifier
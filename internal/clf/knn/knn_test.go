@@ -8,13 +8,13 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/jaimeteb/chatto/internal/clf"
 	"github.com/jaimeteb/chatto/internal/clf/knn"
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
 	"github.com/jaimeteb/chatto/internal/clf/wordvectors"
 	"github.com/jaimeteb/chatto/internal/testutils"
 )
 
 func TestClassifier(t *testing.T) {
-	reload := make(chan clf.Config)
-	cfg, err := clf.LoadConfig(path.Join("../../", testutils.Examples01MoodbotPath), reload)
+	cfg, err := clf.LoadConfig(path.Join("../../", testutils.Examples01MoodbotPath))
 	if err != nil {
 		t.Fatalf("failed to load clf config: %v", err)
 	}
@@ -84,7 +84,7 @@ func TestClassifier(t *testing.T) {
 }
 
 func TestSaveAndLoad(t *testing.T) {
-	classifConfig, err := clf.LoadConfig("../../"+testutils.Examples00TestPath, nil)
+	classifConfig, err := clf.LoadConfig("../../" + testutils.Examples00TestPath)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -111,3 +111,43 @@ func TestSaveAndLoad(t *testing.T) {
 		testutils.RemoveFiles("gob")
 	})
 }
+
+func TestKNN_Rank(t *testing.T) {
+	model := &knn.KNN{
+		K:      3,
+		Data:   [][]float64{{0, 0}, {0, 1}, {5, 5}, {9, 9}},
+		Labels: []string{"greet", "greet", "bye", "thanks"},
+	}
+
+	tests := []struct {
+		name string
+		x    []float64
+		want prediction.Ranking
+	}{
+		{
+			name: "majority",
+			x:    []float64{0, 0},
+			want: prediction.Ranking{
+				{Command: "greet", Probability: 2.0 / 3},
+				{Command: "bye", Probability: 1.0 / 3},
+				{Command: "thanks", Probability: 0},
+			},
+		},
+		{
+			name: "tie by closest neighbor",
+			x:    []float64{9, 9},
+			want: prediction.Ranking{
+				{Command: "thanks", Probability: 1.0 / 3},
+				{Command: "bye", Probability: 1.0 / 3},
+				{Command: "greet", Probability: 1.0 / 3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := model.Rank(tt.x); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KNN.Rank() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
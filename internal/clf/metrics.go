@@ -1,5 +1,11 @@
 package clf
 
+import (
+	"math"
+
+	"github.com/jaimeteb/chatto/internal/clf/dataset"
+)
+
 type Scores struct {
 	Precision []float64
 	Recall    []float64
@@ -16,40 +22,86 @@ type Scores struct {
 	Accuracy float64
 }
 
-func GetConfusionMatrix(classifConfig *Config) (confusionMatrix [][]int, yTrue, yPred []int) {
-	classif := New(classifConfig)
+// Misclassification is a test text whose predicted command is not its
+// command. The predicted command is empty if it was below the threshold
+type Misclassification struct {
+	Text        string  `json:"text"`
+	Command     string  `json:"command"`
+	Predicted   string  `json:"predicted"`
+	Probability float32 `json:"probability"`
+}
+
+// Unsure is the name of the last column of the confusion matrix, with
+// the texts whose prediction was below the threshold
+const Unsure = "unsure"
+
+// Evaluation contains the predictions of a Classifier for a test DataSet
+type Evaluation struct {
+	ConfusionMatrix [][]int
+	YTrue, YPred    []int
+	Misclassified   []Misclassification
+}
 
-	predict := func(text string) string {
-		pred, _ := classif.Model.Predict(text, classif.Pipeline)
-		return pred
+// Evaluate predicts the texts of the test DataSet and returns the confusion
+// matrix of the predictions, whose rows and columns follow the commands of
+// the classification. The matrix has an extra last column, Unsure, for the
+// predictions below the threshold, they are len(classification) in YPred.
+// Test commands that are not in the classification are skipped
+func (c *Classifier) Evaluate(classification, test dataset.DataSet) *Evaluation {
+	numClasses := len(classification)
+	e := &Evaluation{
+		ConfusionMatrix: make([][]int, numClasses),
+		YTrue:           []int{},
+		YPred:           []int{},
+		Misclassified:   []Misclassification{},
 	}
 
-	numClasses := len(classifConfig.Classification)
-	confusionMatrix = make([][]int, numClasses)
 	classIndices := map[string]int{}
-	for i, class := range classifConfig.Classification {
+	for i, class := range classification {
 		classIndices[class.Command] = i
-		confusionMatrix[i] = make([]int, numClasses)
+		e.ConfusionMatrix[i] = make([]int, numClasses+1)
 	}
 
-	yTrue, yPred = []int{}, []int{}
-	for i, class := range classifConfig.Classification {
-		trueClassIdx := classIndices[class.Command]
+	for _, class := range test {
+		trueClassIdx, ok := classIndices[class.Command]
+		if !ok {
+			continue
+		}
 		for _, text := range class.Texts {
-			predictedClassIdx := classIndices[predict(text)]
-			confusionMatrix[i][predictedClassIdx]++
-			yTrue, yPred = append(yTrue, trueClassIdx), append(yPred, predictedClassIdx)
+			pred, proba := c.Model.Predict(text, c.Pipeline)
+			predictedClassIdx, ok := classIndices[pred]
+			if !ok {
+				predictedClassIdx = numClasses
+			}
+			e.ConfusionMatrix[trueClassIdx][predictedClassIdx]++
+			e.YTrue, e.YPred = append(e.YTrue, trueClassIdx), append(e.YPred, predictedClassIdx)
+
+			if pred != class.Command {
+				e.Misclassified = append(e.Misclassified, Misclassification{Text: text, Command: class.Command, Predicted: pred, Probability: proba})
+			}
 		}
 	}
 
-	return
+	return e
 }
 
+func GetConfusionMatrix(classifConfig *Config) (confusionMatrix [][]int, yTrue, yPred []int) {
+	e := New(classifConfig).Evaluate(classifConfig.Classification, classifConfig.Classification)
+	return e.ConfusionMatrix, e.YTrue, e.YPred
+}
+
+// GetSums returns the sums of the rows (sumI), of the columns (sumJ), of the
+// whole confusion matrix and of its diagonal. The Unsure column is summed
+// with the rows, so the texts below the threshold count in the support
 func GetSums(confusionMatrix [][]int) (sumI, sumJ []int, sumIJ, sumTrue int) {
 	numClasses := len(confusionMatrix)
-	sumI, sumJ, sumIJ, sumTrue = make([]int, numClasses), make([]int, numClasses), 0, 0
+	numColumns := 0
+	if numClasses > 0 {
+		numColumns = len(confusionMatrix[0])
+	}
+	sumI, sumJ, sumIJ, sumTrue = make([]int, numClasses), make([]int, numColumns), 0, 0
 	for i := 0; i < numClasses; i++ {
-		for j := 0; j < numClasses; j++ {
+		for j := 0; j < numColumns; j++ {
 			sumI[i] += confusionMatrix[i][j]
 			sumJ[j] += confusionMatrix[i][j]
 			sumIJ += confusionMatrix[i][j]
@@ -61,32 +113,139 @@ func GetSums(confusionMatrix [][]int) (sumI, sumJ []int, sumIJ, sumTrue int) {
 	return
 }
 
+// GetScores calculates the scores of every class and their averages. The rows
+// of the confusion matrix are the true classes and the columns the predicted
+// ones: the precision divides by the sum of a column (sumJ), the recall by the
+// sum of a row (sumI), which also weights the averages. A score that cannot be
+// calculated is NaN, like the one of a class with no texts, and is left out
+// of the averages
 func GetScores(confusionMatrix [][]int, sumI, sumJ []int, sumIJ, sumTrue int) (s Scores) {
 	numClasses := len(confusionMatrix)
 	s.Precision, s.Recall, s.F1score = make([]float64, numClasses), make([]float64, numClasses), make([]float64, numClasses)
 
+	var precisionN, recallN, f1scoreN, precisionW, recallW, f1scoreW float64
 	for i := 0; i < numClasses; i++ {
-		s.Precision[i] = float64(confusionMatrix[i][i]) / float64(sumI[i])
-		s.Recall[i] = float64(confusionMatrix[i][i]) / float64(sumJ[i])
+		s.Precision[i] = float64(confusionMatrix[i][i]) / float64(sumJ[i])
+		s.Recall[i] = float64(confusionMatrix[i][i]) / float64(sumI[i])
 		s.F1score[i] = 2 * s.Precision[i] * s.Recall[i] / (s.Precision[i] + s.Recall[i])
 
-		s.PrecisionAvg += s.Precision[i]
-		s.RecallAvg += s.Recall[i]
-		s.F1scoreAvg += s.F1score[i]
-
-		s.PrecisionWeightedAvg += s.Precision[i] * float64(sumI[i])
-		s.RecallWeightedAvg += s.Recall[i] * float64(sumI[i])
-		s.F1scoreWeightedAvg += s.F1score[i] * float64(sumI[i])
+		weight := float64(sumI[i])
+		if !math.IsNaN(s.Precision[i]) {
+			s.PrecisionAvg += s.Precision[i]
+			s.PrecisionWeightedAvg += s.Precision[i] * weight
+			precisionN++
+			precisionW += weight
+		}
+		if !math.IsNaN(s.Recall[i]) {
+			s.RecallAvg += s.Recall[i]
+			s.RecallWeightedAvg += s.Recall[i] * weight
+			recallN++
+			recallW += weight
+		}
+		if !math.IsNaN(s.F1score[i]) {
+			s.F1scoreAvg += s.F1score[i]
+			s.F1scoreWeightedAvg += s.F1score[i] * weight
+			f1scoreN++
+			f1scoreW += weight
+		}
 	}
-	s.PrecisionAvg /= float64(numClasses)
-	s.RecallAvg /= float64(numClasses)
-	s.F1scoreAvg /= float64(numClasses)
+	s.PrecisionAvg /= precisionN
+	s.RecallAvg /= recallN
+	s.F1scoreAvg /= f1scoreN
 
-	s.PrecisionWeightedAvg /= float64(sumIJ)
-	s.RecallWeightedAvg /= float64(sumIJ)
-	s.F1scoreWeightedAvg /= float64(sumIJ)
+	s.PrecisionWeightedAvg /= precisionW
+	s.RecallWeightedAvg /= recallW
+	s.F1scoreWeightedAvg /= f1scoreW
 
 	s.Accuracy = float64(sumTrue) / float64(sumIJ)
 
 	return
 }
+
+// Evaluate trains a Classifier with the configuration of classifConfig on the
+// train DataSet, and evaluates its predictions of the test DataSet. The rows
+// and columns of the confusion matrix follow the commands of
+// classifConfig.Classification, the saved model is neither loaded nor saved
+func Evaluate(classifConfig *Config, train, test dataset.DataSet) *Evaluation {
+	evalConfig := *classifConfig
+	evalConfig.Classification = train
+	evalConfig.Model.Load = false
+	evalConfig.Model.Save = false
+
+	return New(&evalConfig).Evaluate(classifConfig.Classification, test)
+}
+
+// FoldResult contains the evaluation of one fold of a cross-validation
+type FoldResult struct {
+	*Evaluation
+	Scores  Scores
+	Support int
+}
+
+// CrossValidate runs a stratified k-fold cross-validation of the classifier,
+// training a new model for every fold and testing it with the texts left out
+func CrossValidate(classifConfig *Config, k int, seed int64) ([]FoldResult, error) {
+	folds, err := classifConfig.Classification.StratifiedKFold(k, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]FoldResult, len(folds))
+	for f, fold := range folds {
+		e := Evaluate(classifConfig, fold.Train, fold.Test)
+		sumI, sumJ, sumIJ, sumTrue := GetSums(e.ConfusionMatrix)
+
+		results[f] = FoldResult{
+			Evaluation: e,
+			Scores:     GetScores(e.ConfusionMatrix, sumI, sumJ, sumIJ, sumTrue),
+			Support:    sumIJ,
+		}
+	}
+
+	return results, nil
+}
+
+// AverageScores returns the mean of every score. Scores that could not be
+// calculated (NaN), like those of a command with no texts in a fold, are
+// left out of the mean
+func AverageScores(scores []Scores) (s Scores) {
+	if len(scores) == 0 {
+		return
+	}
+
+	numClasses := len(scores[0].Precision)
+	s.Precision, s.Recall, s.F1score = make([]float64, numClasses), make([]float64, numClasses), make([]float64, numClasses)
+
+	for i := 0; i < numClasses; i++ {
+		s.Precision[i] = mean(scores, func(s *Scores) float64 { return s.Precision[i] })
+		s.Recall[i] = mean(scores, func(s *Scores) float64 { return s.Recall[i] })
+		s.F1score[i] = mean(scores, func(s *Scores) float64 { return s.F1score[i] })
+	}
+
+	s.PrecisionAvg = mean(scores, func(s *Scores) float64 { return s.PrecisionAvg })
+	s.RecallAvg = mean(scores, func(s *Scores) float64 { return s.RecallAvg })
+	s.F1scoreAvg = mean(scores, func(s *Scores) float64 { return s.F1scoreAvg })
+
+	s.PrecisionWeightedAvg = mean(scores, func(s *Scores) float64 { return s.PrecisionWeightedAvg })
+	s.RecallWeightedAvg = mean(scores, func(s *Scores) float64 { return s.RecallWeightedAvg })
+	s.F1scoreWeightedAvg = mean(scores, func(s *Scores) float64 { return s.F1scoreWeightedAvg })
+
+	s.Accuracy = mean(scores, func(s *Scores) float64 { return s.Accuracy })
+
+	return
+}
+
+// mean of a score across Scores, ignoring NaN values
+func mean(scores []Scores, score func(s *Scores) float64) float64 {
+	sum, n := 0.0, 0
+	for i := range scores {
+		if v := score(&scores[i]); !math.IsNaN(v) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return sum / float64(n)
+}
@@ -1,11 +1,15 @@
 package clf_test
 
 import (
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/jaimeteb/chatto/internal/clf"
 	"github.com/jaimeteb/chatto/internal/clf/dataset"
+	"github.com/jaimeteb/chatto/internal/clf/pipeline"
 )
 
 func TestGetConfusionMatrix(t *testing.T) {
@@ -41,7 +45,7 @@ func TestGetConfusionMatrix(t *testing.T) {
 				},
 			},
 			want: want{
-				confusionMatrix: [][]int{{2, 0}, {0, 2}},
+				confusionMatrix: [][]int{{2, 0, 0}, {0, 2, 0}},
 				yTrue:           []int{0, 0, 1, 1},
 				yPred:           []int{0, 0, 1, 1},
 			},
@@ -66,16 +70,46 @@ func TestGetConfusionMatrix(t *testing.T) {
 				},
 			},
 			want: want{
-				confusionMatrix: [][]int{{3, 0}, {2, 1}},
-				yTrue:           []int{0, 0, 0, 0, 0, 1},
-				yPred:           []int{0, 0, 0, 1, 1, 1},
+				confusionMatrix: [][]int{{3, 0, 0}, {2, 1, 0}},
+				yTrue:           []int{0, 0, 0, 1, 1, 1},
+				yPred:           []int{0, 0, 0, 0, 0, 1},
+			},
+		},
+		{
+			// The predictions below the threshold are in the unsure column,
+			// not in the one of the first command
+			name: "unsure confusion matrix",
+			args: args{
+				cfg: &clf.Config{
+					Classification: dataset.DataSet{
+						dataset.DataClass{
+							Command: "on",
+							Texts:   []string{"on", "turn_on"},
+						},
+						dataset.DataClass{
+							Command: "off",
+							Texts:   []string{"off", "turn_off"},
+						},
+					},
+					Pipeline: pipeline.Config{
+						Threshold: 1.1,
+					},
+					Model: clf.ModelConfig{
+						Classifier: "naive_bayes",
+					},
+				},
+			},
+			want: want{
+				confusionMatrix: [][]int{{0, 0, 2}, {0, 0, 2}},
+				yTrue:           []int{0, 0, 1, 1},
+				yPred:           []int{2, 2, 2, 2},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := want{}
-			got.confusionMatrix, got.yPred, got.yTrue = clf.GetConfusionMatrix(tt.args.cfg)
+			got.confusionMatrix, got.yTrue, got.yPred = clf.GetConfusionMatrix(tt.args.cfg)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("New() = %v, want %v", got, tt.want)
 			}
@@ -127,6 +161,21 @@ func TestGetSums(t *testing.T) {
 				sumTrue: 15,
 			},
 		},
+		{
+			name: "confusion matrix with unsure predictions",
+			args: args{
+				confusionMatrix: [][]int{
+					{1, 0, 1},
+					{0, 2, 0},
+				},
+			},
+			want: want{
+				sumI:    []int{2, 2},
+				sumJ:    []int{1, 2, 1},
+				sumIJ:   4,
+				sumTrue: 3,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -187,17 +236,72 @@ func TestScores(t *testing.T) {
 			},
 			want: want{
 				s: clf.Scores{
-					Precision: []float64{0.5, 1.0},
-					Recall:    []float64{1.0, 0.6666666666666666},
+					Precision: []float64{1.0, 0.6666666666666666},
+					Recall:    []float64{0.5, 1.0},
 					F1score:   []float64{0.6666666666666666, 0.8},
 
+					PrecisionAvg: 0.8333333333333333,
+					RecallAvg:    0.75,
+					F1scoreAvg:   0.7333333333333334,
+
+					PrecisionWeightedAvg: 0.8333333333333333,
+					RecallWeightedAvg:    0.75,
+					F1scoreWeightedAvg:   0.7333333333333334,
+
+					Accuracy: 0.75,
+				},
+			},
+		},
+		{
+			// An unsure prediction lowers the recall, not the precision
+			name: "confusion matrix with unsure predictions",
+			args: args{
+				confusionMatrix: [][]int{
+					{1, 0, 1},
+					{0, 2, 0},
+				},
+			},
+			want: want{
+				s: clf.Scores{
+					Precision: []float64{1.0, 1.0},
+					Recall:    []float64{0.5, 1.0},
+					F1score:   []float64{0.6666666666666666, 1.0},
+
+					PrecisionAvg: 1.0,
+					RecallAvg:    0.75,
+					F1scoreAvg:   0.8333333333333333,
+
+					PrecisionWeightedAvg: 1.0,
+					RecallWeightedAvg:    0.75,
+					F1scoreWeightedAvg:   0.8333333333333333,
+
+					Accuracy: 0.75,
+				},
+			},
+		},
+		{
+			// The rows are the true commands and the columns the predicted
+			// ones, the supports of the commands are 3 and 1
+			name: "asymmetric confusion matrix",
+			args: args{
+				confusionMatrix: [][]int{
+					{2, 1},
+					{0, 1},
+				},
+			},
+			want: want{
+				s: clf.Scores{
+					Precision: []float64{1.0, 0.5},
+					Recall:    []float64{0.6666666666666666, 1.0},
+					F1score:   []float64{0.8, 0.6666666666666666},
+
 					PrecisionAvg: 0.75,
 					RecallAvg:    0.8333333333333333,
 					F1scoreAvg:   0.7333333333333334,
 
-					PrecisionWeightedAvg: 0.75,
-					RecallWeightedAvg:    0.8333333333333333,
-					F1scoreWeightedAvg:   0.7333333333333334,
+					PrecisionWeightedAvg: 0.875,
+					RecallWeightedAvg:    0.75,
+					F1scoreWeightedAvg:   0.7666666666666667,
 
 					Accuracy: 0.75,
 				},
@@ -214,3 +318,130 @@ func TestScores(t *testing.T) {
 		})
 	}
 }
+
+func TestScores_NaN(t *testing.T) {
+	// The second class is never predicted, so its precision is NaN
+	confusionMatrix := [][]int{
+		{1, 0},
+		{1, 0},
+	}
+
+	sumI, sumJ, sumIJ, sumTrue := clf.GetSums(confusionMatrix)
+	got := clf.GetScores(confusionMatrix, sumI, sumJ, sumIJ, sumTrue)
+
+	if !math.IsNaN(got.Precision[1]) || !math.IsNaN(got.F1score[1]) {
+		t.Fatalf("GetScores() precision = %v, f1 = %v, want NaN for the second class", got.Precision, got.F1score)
+	}
+
+	averages := []float64{got.PrecisionAvg, got.RecallAvg, got.F1scoreAvg, got.PrecisionWeightedAvg, got.RecallWeightedAvg, got.F1scoreWeightedAvg}
+	want := []float64{0.5, 0.5, 0.6666666666666666, 0.5, 0.5, 0.6666666666666666}
+	if !reflect.DeepEqual(averages, want) {
+		t.Errorf("GetScores() averages = %v, want %v", averages, want)
+	}
+}
+
+func TestAverageScores(t *testing.T) {
+	scores := []clf.Scores{
+		{
+			Precision: []float64{1.0, math.NaN()},
+			Recall:    []float64{0.5, 1.0},
+			F1score:   []float64{0.6, math.NaN()},
+			Accuracy:  0.5,
+		},
+		{
+			Precision: []float64{0.5, 1.0},
+			Recall:    []float64{1.0, 0.0},
+			F1score:   []float64{0.8, 0.0},
+			Accuracy:  1.0,
+		},
+	}
+
+	got := clf.AverageScores(scores)
+
+	if want := []float64{0.75, 1.0}; !reflect.DeepEqual(got.Precision, want) {
+		t.Errorf("AverageScores() precision = %v, want %v", got.Precision, want)
+	}
+	if want := []float64{0.75, 0.5}; !reflect.DeepEqual(got.Recall, want) {
+		t.Errorf("AverageScores() recall = %v, want %v", got.Recall, want)
+	}
+	if want := []float64{0.7, 0.0}; !reflect.DeepEqual(got.F1score, want) {
+		t.Errorf("AverageScores() f1 = %v, want %v", got.F1score, want)
+	}
+	if got.Accuracy != 0.75 {
+		t.Errorf("AverageScores() accuracy = %v, want %v", got.Accuracy, 0.75)
+	}
+}
+
+func TestCrossValidate(t *testing.T) {
+	cfg := &clf.Config{
+		Classification: dataset.DataSet{
+			{Command: "on", Texts: []string{"on", "turn on", "switch on", "lights on"}},
+			{Command: "off", Texts: []string{"off", "turn off", "switch off", "lights off"}},
+		},
+		Model: clf.ModelConfig{
+			Classifier: "naive_bayes",
+		},
+	}
+
+	folds, err := clf.CrossValidate(cfg, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folds) != 2 {
+		t.Fatalf("CrossValidate() = %d folds, want 2", len(folds))
+	}
+
+	for f, fold := range folds {
+		if fold.Support != 4 {
+			t.Errorf("fold %d support = %d, want 4", f, fold.Support)
+		}
+		if fold.Scores.Accuracy != 1.0 {
+			t.Errorf("fold %d accuracy = %v, want 1", f, fold.Scores.Accuracy)
+		}
+	}
+
+	if _, err := clf.CrossValidate(cfg, 1, 1); err == nil {
+		t.Error("CrossValidate() expected an error with 1 fold")
+	}
+}
+
+func TestLoadTestSet(t *testing.T) {
+	classification := dataset.DataSet{
+		{Command: "turn_on", Texts: []string{"turn on"}},
+		{Command: "turn_off", Texts: []string{"turn off"}},
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    dataset.DataSet
+		wantErr bool
+	}{
+		{
+			name:    "valid test set",
+			content: "classification:\n  - command: turn_on\n    texts:\n      - lights on\n",
+			want:    dataset.DataSet{{Command: "turn_on", Texts: []string{"lights on"}}},
+		},
+		{
+			name:    "unknown command",
+			content: "classification:\n  - command: dim\n    texts:\n      - dim the lights\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "test.yml")
+			if err := os.WriteFile(file, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := clf.LoadTestSet(file, classification)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadTestSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadTestSet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
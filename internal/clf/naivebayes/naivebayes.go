@@ -7,6 +7,8 @@ import (
 
 	"github.com/jaimeteb/chatto/internal/clf/dataset"
 	"github.com/jaimeteb/chatto/internal/clf/pipeline"
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
+	"github.com/jaimeteb/chatto/internal/logger"
 	"github.com/mitchellh/mapstructure"
 	"github.com/navossoc/bayesian"
 	log "github.com/sirupsen/logrus"
@@ -125,7 +127,7 @@ func (c *Classifier) Predict(text string, pipe *pipeline.Config) (predictedClass
 	class := string(c.Classes[likely])
 	prob := probs[likely]
 
-	log.Debugf("CLF | Text '%s' classified as command '%s' with a probability of %.2f", text, class, prob)
+	log.Debugf("CLF | Text '%s' classified as command '%s' with a probability of %.2f", logger.Redact(text), class, prob)
 	if prob < pipe.Threshold {
 		return "", -1.0
 	}
@@ -133,6 +135,21 @@ func (c *Classifier) Predict(text string, pipe *pipeline.Config) (predictedClass
 	return class, float32(prob)
 }
 
+// Rank returns every class ranked by its probability for a given text
+func (c *Classifier) Rank(text string, pipe *pipeline.Config) prediction.Ranking {
+	probs, _, _ := c.Model.ProbScores(pipeline.Pipeline(text, pipe))
+
+	candidates := make([]prediction.Candidate, len(c.Classes))
+	for i, class := range c.Classes {
+		candidates[i] = prediction.Candidate{
+			Command:     string(class),
+			Probability: float32(probs[i]),
+		}
+	}
+
+	return prediction.NewRanking(candidates)
+}
+
 // Save persists the model to a file
 func (c *Classifier) Save(directory string) error {
 	// save Classifier
@@ -11,8 +11,7 @@ import (
 )
 
 func TestClfPredictions(t *testing.T) {
-	classifReloadChan := make(chan clf.Config)
-	classifConfig, err := clf.LoadConfig("../../"+testutils.Examples00TestPath, classifReloadChan)
+	classifConfig, err := clf.LoadConfig("../../" + testutils.Examples00TestPath)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -28,13 +27,21 @@ func TestClfPredictions(t *testing.T) {
 	if pred2 != "" {
 		t.Errorf("pred is incorrect, got: %v, want: %v.", pred2, "")
 	}
+
+	ranking := classif.Model.Rank("on", &classifConfig.Pipeline)
+	if len(ranking) != len(classifConfig.Classification) {
+		t.Errorf("ranking length is incorrect, got: %v, want: %v.", len(ranking), len(classifConfig.Classification))
+	}
+	if best := ranking.Best().Command; best != "turn_on" {
+		t.Errorf("ranking is incorrect, got: %v, want: %v.", best, "turn_on")
+	}
 	t.Cleanup(func() {
 		testutils.RemoveFiles("gob")
 	})
 }
 
 func TestSaveAndLoad(t *testing.T) {
-	classifConfig, err := clf.LoadConfig("../../"+testutils.Examples00TestPath, nil)
+	classifConfig, err := clf.LoadConfig("../../" + testutils.Examples00TestPath)
 	if err != nil {
 		t.Fatal(err)
 	}
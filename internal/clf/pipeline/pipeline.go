@@ -7,11 +7,14 @@ import (
 
 var removeSymbolRe = regexp.MustCompile(`\W+`)
 
-// Config defines a Pipeline configuration
+// Config defines a Pipeline configuration. When the probabilities of
+// the two most probable commands are closer than the Margin, the bot
+// asks the user which one they meant, a Margin of 0 disables it
 type Config struct {
 	RemoveSymbols bool    `mapstructure:"remove_symbols"`
 	Lower         bool    `mapstructure:"lower"`
 	Threshold     float64 `mapstructure:"threshold"`
+	Margin        float64 `mapstructure:"margin"`
 }
 
 // Pipeline performs steps to convert a string into a CLF input
@@ -23,7 +23,7 @@ func TestPipeline(t *testing.T) {
 			name: "no remove_symbols no lower",
 			args: args{
 				text: "I don't know...",
-				pipe: &pipeline.Config{false, false, 0},
+				pipe: &pipeline.Config{false, false, 0, 0},
 			},
 			want: []string{"I", "don't", "know..."},
 		},
@@ -31,7 +31,7 @@ func TestPipeline(t *testing.T) {
 			name: "remove_symbols lower",
 			args: args{
 				text: "I don't know...",
-				pipe: &pipeline.Config{true, true, 0},
+				pipe: &pipeline.Config{true, true, 0, 0},
 			},
 			want: []string{"i", "don", "t", "know"},
 		},
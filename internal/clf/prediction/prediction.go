@@ -0,0 +1,42 @@
+// Package prediction contains the ranked predictions of the classifier models
+package prediction
+
+import "sort"
+
+// Candidate is a command and the probability a model gives it
+type Candidate struct {
+	Command     string  `json:"command"`
+	Probability float32 `json:"probability"`
+}
+
+// Ranking is a list of candidates sorted from the most to the least probable
+type Ranking []Candidate
+
+// NewRanking sorts the candidates by probability, candidates with the
+// same probability keep their order
+func NewRanking(candidates []Candidate) Ranking {
+	ranking := make(Ranking, len(candidates))
+	copy(ranking, candidates)
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		return ranking[i].Probability > ranking[j].Probability
+	})
+
+	return ranking
+}
+
+// Top returns the n most probable candidates, or all of them if n <= 0
+func (r Ranking) Top(n int) Ranking {
+	if n <= 0 || n >= len(r) {
+		return r
+	}
+	return r[:n]
+}
+
+// Best returns the most probable candidate, or an empty one if there are none
+func (r Ranking) Best() Candidate {
+	if len(r) == 0 {
+		return Candidate{}
+	}
+	return r[0]
+}
@@ -0,0 +1,68 @@
+package prediction_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jaimeteb/chatto/internal/clf/prediction"
+)
+
+func TestRanking(t *testing.T) {
+	ranking := prediction.NewRanking([]prediction.Candidate{
+		{Command: "turn_off", Probability: 0.2},
+		{Command: "turn_on", Probability: 0.6},
+		{Command: "hello", Probability: 0.2},
+	})
+
+	tests := []struct {
+		name     string
+		n        int
+		want     prediction.Ranking
+		wantBest prediction.Candidate
+	}{
+		{
+			name: "all",
+			n:    0,
+			want: prediction.Ranking{
+				{Command: "turn_on", Probability: 0.6},
+				{Command: "turn_off", Probability: 0.2},
+				{Command: "hello", Probability: 0.2},
+			},
+			wantBest: prediction.Candidate{Command: "turn_on", Probability: 0.6},
+		},
+		{
+			name: "top 2",
+			n:    2,
+			want: prediction.Ranking{
+				{Command: "turn_on", Probability: 0.6},
+				{Command: "turn_off", Probability: 0.2},
+			},
+			wantBest: prediction.Candidate{Command: "turn_on", Probability: 0.6},
+		},
+		{
+			name: "more than candidates",
+			n:    5,
+			want: prediction.Ranking{
+				{Command: "turn_on", Probability: 0.6},
+				{Command: "turn_off", Probability: 0.2},
+				{Command: "hello", Probability: 0.2},
+			},
+			wantBest: prediction.Candidate{Command: "turn_on", Probability: 0.6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ranking.Top(tt.n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Ranking.Top() = %v, want %v", got, tt.want)
+			}
+			if best := got.Best(); best != tt.wantBest {
+				t.Errorf("Ranking.Best() = %v, want %v", best, tt.wantBest)
+			}
+		})
+	}
+
+	if best := (prediction.Ranking{}).Best(); best != (prediction.Candidate{}) {
+		t.Errorf("Ranking.Best() = %v, want an empty candidate", best)
+	}
+}
@@ -0,0 +1,296 @@
+package clf
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jaimeteb/chatto/internal/clf/dataset"
+)
+
+// Output formats of the classification reports
+const (
+	OutputText     = "text"
+	OutputJSON     = "json"
+	OutputCSV      = "csv"
+	OutputMarkdown = "markdown"
+)
+
+// Report is the evaluation of a classifier, with its confusion matrix,
+// scores and misclassified texts. The rows of the confusion matrix are the
+// Commands and its columns the Columns, the commands and Unsure
+type Report struct {
+	Name            string              `json:"name"`
+	Commands        []string            `json:"commands"`
+	ConfusionMatrix [][]int             `json:"confusion_matrix"`
+	Support         []int               `json:"support"`
+	Total           int                 `json:"total"`
+	Scores          Scores              `json:"scores"`
+	Folds           []FoldReport        `json:"folds,omitempty"`
+	Misclassified   []Misclassification `json:"misclassified"`
+}
+
+// FoldReport contains the scores of a cross-validation fold
+type FoldReport struct {
+	Fold    int    `json:"fold"`
+	Support int    `json:"support"`
+	Scores  Scores `json:"scores"`
+}
+
+// NewReport builds the Report of an Evaluation, the confusion
+// matrix follows the commands of the classification
+func NewReport(name string, classification dataset.DataSet, e *Evaluation) *Report {
+	sumI, sumJ, sumIJ, sumTrue := GetSums(e.ConfusionMatrix)
+
+	return &Report{
+		Name:            name,
+		Commands:        commands(classification),
+		ConfusionMatrix: e.ConfusionMatrix,
+		Support:         sumI,
+		Total:           sumIJ,
+		Scores:          GetScores(e.ConfusionMatrix, sumI, sumJ, sumIJ, sumTrue),
+		Misclassified:   e.Misclassified,
+	}
+}
+
+// NewCrossValidationReport builds the Report of a cross-validation, with the
+// confusion matrices and misclassified texts of all the folds together and
+// the scores averaged across the folds
+func NewCrossValidationReport(name string, classification dataset.DataSet, folds []FoldResult) *Report {
+	numClasses := len(classification)
+	confusionMatrix := make([][]int, numClasses)
+	for i := range confusionMatrix {
+		confusionMatrix[i] = make([]int, numClasses+1)
+	}
+
+	r := &Report{
+		Name:          name,
+		Commands:      commands(classification),
+		Folds:         make([]FoldReport, len(folds)),
+		Misclassified: []Misclassification{},
+	}
+
+	scores := make([]Scores, len(folds))
+	for f, fold := range folds {
+		for i := range fold.ConfusionMatrix {
+			for j := range fold.ConfusionMatrix[i] {
+				confusionMatrix[i][j] += fold.ConfusionMatrix[i][j]
+			}
+		}
+		r.Misclassified = append(r.Misclassified, fold.Misclassified...)
+		r.Folds[f] = FoldReport{Fold: f + 1, Support: fold.Support, Scores: fold.Scores}
+		scores[f] = fold.Scores
+	}
+
+	r.ConfusionMatrix = confusionMatrix
+	r.Support, _, r.Total, _ = GetSums(confusionMatrix)
+	r.Scores = AverageScores(scores)
+
+	return r
+}
+
+// Columns returns the names of the columns of the confusion matrix:
+// the commands and Unsure
+func (r *Report) Columns() []string {
+	return append(append([]string{}, r.Commands...), Unsure)
+}
+
+func commands(classification dataset.DataSet) []string {
+	names := make([]string, len(classification))
+	for i, class := range classification {
+		names[i] = class.Command
+	}
+	return names
+}
+
+// MarshalJSON encodes the Scores with snake case keys, scores
+// that could not be calculated (NaN) are encoded as null
+func (s Scores) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Precision            []*float64 `json:"precision"`
+		Recall               []*float64 `json:"recall"`
+		F1score              []*float64 `json:"f1_score"`
+		PrecisionAvg         *float64   `json:"precision_avg"`
+		RecallAvg            *float64   `json:"recall_avg"`
+		F1scoreAvg           *float64   `json:"f1_score_avg"`
+		PrecisionWeightedAvg *float64   `json:"precision_weighted_avg"`
+		RecallWeightedAvg    *float64   `json:"recall_weighted_avg"`
+		F1scoreWeightedAvg   *float64   `json:"f1_score_weighted_avg"`
+		Accuracy             *float64   `json:"accuracy"`
+	}{
+		Precision:            jsonFloats(s.Precision),
+		Recall:               jsonFloats(s.Recall),
+		F1score:              jsonFloats(s.F1score),
+		PrecisionAvg:         jsonFloat(s.PrecisionAvg),
+		RecallAvg:            jsonFloat(s.RecallAvg),
+		F1scoreAvg:           jsonFloat(s.F1scoreAvg),
+		PrecisionWeightedAvg: jsonFloat(s.PrecisionWeightedAvg),
+		RecallWeightedAvg:    jsonFloat(s.RecallWeightedAvg),
+		F1scoreWeightedAvg:   jsonFloat(s.F1scoreWeightedAvg),
+		Accuracy:             jsonFloat(s.Accuracy),
+	})
+}
+
+func jsonFloat(f float64) *float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return &f
+}
+
+func jsonFloats(fs []float64) []*float64 {
+	out := make([]*float64, len(fs))
+	for i, f := range fs {
+		out[i] = jsonFloat(f)
+	}
+	return out
+}
+
+// WriteReports writes the reports in the JSON, CSV or Markdown format
+func WriteReports(w io.Writer, format string, reports []*Report) error {
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	case OutputCSV:
+		return writeCSV(w, reports)
+	case OutputMarkdown:
+		return writeMarkdown(w, reports)
+	default:
+		return fmt.Errorf("unknown output format '%s', use %s, %s, %s or %s", format, OutputText, OutputJSON, OutputCSV, OutputMarkdown)
+	}
+}
+
+// scoreRows returns the rows of the classification report of the Report:
+// name, precision, recall, f1-score and support
+func (r *Report) scoreRows() [][]string {
+	s := r.Scores
+	rows := make([][]string, 0, len(r.Commands)+3)
+	for i, command := range r.Commands {
+		rows = append(rows, []string{command, formatScore(s.Precision[i]), formatScore(s.Recall[i]), formatScore(s.F1score[i]), strconv.Itoa(r.Support[i])})
+	}
+	total := strconv.Itoa(r.Total)
+	rows = append(rows,
+		[]string{"accuracy", "", "", formatScore(s.Accuracy), total},
+		[]string{"macro_avg", formatScore(s.PrecisionAvg), formatScore(s.RecallAvg), formatScore(s.F1scoreAvg), total},
+		[]string{"weighted_avg", formatScore(s.PrecisionWeightedAvg), formatScore(s.RecallWeightedAvg), formatScore(s.F1scoreWeightedAvg), total},
+	)
+	return rows
+}
+
+func formatScore(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
+
+func formatProbability(p float32) string {
+	return strconv.FormatFloat(float64(p), 'f', 4, 32)
+}
+
+// writeCSV writes the reports as one long-format table, with a row for every
+// value: the scores of every command and fold, the counts of the confusion
+// matrix as "predicted:<command>" and the probabilities of the misclassified
+// texts as "misclassified:<predicted>". The text column is only set for the
+// misclassified texts, the fold column only for the scores of a fold
+func writeCSV(w io.Writer, reports []*Report) error {
+	rows := [][]string{{"report", "fold", "command", "metric", "value", "text"}}
+	scoreMetrics := []string{"precision", "recall", "f1_score", "support"}
+	foldMetrics := []string{"accuracy", "precision_avg", "recall_avg", "f1_score_avg", "support"}
+
+	for _, r := range reports {
+		for _, row := range foldRows(r.Folds) {
+			for i, metric := range foldMetrics {
+				rows = append(rows, []string{r.Name, row[0], "", metric, row[i+1], ""})
+			}
+		}
+		for _, row := range r.scoreRows() {
+			if row[0] == "accuracy" {
+				rows = append(rows, []string{r.Name, "", "", "accuracy", row[3], ""}, []string{r.Name, "", "", "support", row[4], ""})
+				continue
+			}
+			for i, metric := range scoreMetrics {
+				rows = append(rows, []string{r.Name, "", row[0], metric, row[i+1], ""})
+			}
+		}
+		columns := r.Columns()
+		for i, command := range r.Commands {
+			for j, n := range r.ConfusionMatrix[i] {
+				rows = append(rows, []string{r.Name, "", command, "predicted:" + columns[j], strconv.Itoa(n), ""})
+			}
+		}
+		for _, m := range r.Misclassified {
+			rows = append(rows, []string{r.Name, "", m.Command, "misclassified:" + m.Predicted, formatProbability(m.Probability), m.Text})
+		}
+	}
+
+	return csv.NewWriter(w).WriteAll(rows)
+}
+
+// writeMarkdown writes every report as a section with tables
+func writeMarkdown(w io.Writer, reports []*Report) error {
+	var b strings.Builder
+
+	for _, r := range reports {
+		fmt.Fprintf(&b, "## %s\n\n", r.Name)
+
+		if len(r.Folds) > 0 {
+			b.WriteString("### Folds\n\n")
+			writeMarkdownTable(&b, []string{"Fold", "Accuracy", "Precision", "Recall", "F1-Score", "Support"}, foldRows(r.Folds))
+		}
+
+		b.WriteString("### Classification report\n\n")
+		writeMarkdownTable(&b, []string{"", "Precision", "Recall", "F1-Score", "Support"}, r.scoreRows())
+
+		b.WriteString("### Confusion matrix\n\n")
+		rows := make([][]string, len(r.Commands))
+		for i, command := range r.Commands {
+			rows[i] = []string{command}
+			for _, n := range r.ConfusionMatrix[i] {
+				rows[i] = append(rows[i], strconv.Itoa(n))
+			}
+		}
+		writeMarkdownTable(&b, append([]string{""}, r.Columns()...), rows)
+
+		b.WriteString("### Misclassified\n\n")
+		if len(r.Misclassified) == 0 {
+			b.WriteString("None.\n\n")
+			continue
+		}
+		rows = make([][]string, len(r.Misclassified))
+		for i, m := range r.Misclassified {
+			rows[i] = []string{m.Text, m.Command, m.Predicted, formatProbability(m.Probability)}
+		}
+		writeMarkdownTable(&b, []string{"Text", "Command", "Predicted", "Probability"}, rows)
+	}
+
+	_, err := io.WriteString(w, strings.TrimRight(b.String(), "\n")+"\n")
+	return err
+}
+
+func foldRows(folds []FoldReport) [][]string {
+	rows := make([][]string, len(folds))
+	for i, f := range folds {
+		s := f.Scores
+		rows[i] = []string{strconv.Itoa(f.Fold), formatScore(s.Accuracy), formatScore(s.PrecisionAvg), formatScore(s.RecallAvg), formatScore(s.F1scoreAvg), strconv.Itoa(f.Support)}
+	}
+	return rows
+}
+
+func writeMarkdownTable(b *strings.Builder, header []string, rows [][]string) {
+	escape := strings.NewReplacer("|", "\\|", "\n", " ")
+
+	b.WriteString("| " + strings.Join(header, " | ") + " |\n")
+	b.WriteString("|" + strings.Repeat(" --- |", len(header)) + "\n")
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = escape.Replace(cell)
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	b.WriteString("\n")
+}
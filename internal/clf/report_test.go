@@ -0,0 +1,217 @@
+package clf_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+
+	"github.com/jaimeteb/chatto/internal/clf"
+	"github.com/jaimeteb/chatto/internal/clf/dataset"
+)
+
+func TestWriteReports(t *testing.T) {
+	classification := dataset.DataSet{
+		{Command: "turn_on", Texts: []string{"turn on"}},
+		{Command: "turn_off", Texts: []string{"turn off"}},
+	}
+	e := &clf.Evaluation{
+		ConfusionMatrix: [][]int{{1, 0, 0}, {1, 0, 0}},
+		Misclassified: []clf.Misclassification{
+			{Text: "turn off", Command: "turn_off", Predicted: "turn_on", Probability: 0.75},
+		},
+	}
+	reports := []*clf.Report{clf.NewReport("test_set", classification, e)}
+
+	tests := []struct {
+		name    string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "json",
+			format: clf.OutputJSON,
+			want: `[
+  {
+    "name": "test_set",
+    "commands": [
+      "turn_on",
+      "turn_off"
+    ],
+    "confusion_matrix": [
+      [
+        1,
+        0,
+        0
+      ],
+      [
+        1,
+        0,
+        0
+      ]
+    ],
+    "support": [
+      1,
+      1
+    ],
+    "total": 2,
+    "scores": {
+      "precision": [
+        0.5,
+        null
+      ],
+      "recall": [
+        1,
+        0
+      ],
+      "f1_score": [
+        0.6666666666666666,
+        null
+      ],
+      "precision_avg": 0.5,
+      "recall_avg": 0.5,
+      "f1_score_avg": 0.6666666666666666,
+      "precision_weighted_avg": 0.5,
+      "recall_weighted_avg": 0.5,
+      "f1_score_weighted_avg": 0.6666666666666666,
+      "accuracy": 0.5
+    },
+    "misclassified": [
+      {
+        "text": "turn off",
+        "command": "turn_off",
+        "predicted": "turn_on",
+        "probability": 0.75
+      }
+    ]
+  }
+]
+`,
+		},
+		{
+			name:   "csv",
+			format: clf.OutputCSV,
+			want: `report,fold,command,metric,value,text
+test_set,,turn_on,precision,0.5000,
+test_set,,turn_on,recall,1.0000,
+test_set,,turn_on,f1_score,0.6667,
+test_set,,turn_on,support,1,
+test_set,,turn_off,precision,NaN,
+test_set,,turn_off,recall,0.0000,
+test_set,,turn_off,f1_score,NaN,
+test_set,,turn_off,support,1,
+test_set,,,accuracy,0.5000,
+test_set,,,support,2,
+test_set,,macro_avg,precision,0.5000,
+test_set,,macro_avg,recall,0.5000,
+test_set,,macro_avg,f1_score,0.6667,
+test_set,,macro_avg,support,2,
+test_set,,weighted_avg,precision,0.5000,
+test_set,,weighted_avg,recall,0.5000,
+test_set,,weighted_avg,f1_score,0.6667,
+test_set,,weighted_avg,support,2,
+test_set,,turn_on,predicted:turn_on,1,
+test_set,,turn_on,predicted:turn_off,0,
+test_set,,turn_on,predicted:unsure,0,
+test_set,,turn_off,predicted:turn_on,1,
+test_set,,turn_off,predicted:turn_off,0,
+test_set,,turn_off,predicted:unsure,0,
+test_set,,turn_off,misclassified:turn_on,0.7500,turn off
+`,
+		},
+		{
+			name:   "markdown",
+			format: clf.OutputMarkdown,
+			want: `## test_set
+
+### Classification report
+
+|  | Precision | Recall | F1-Score | Support |
+| --- | --- | --- | --- | --- |
+| turn_on | 0.5000 | 1.0000 | 0.6667 | 1 |
+| turn_off | NaN | 0.0000 | NaN | 1 |
+| accuracy |  |  | 0.5000 | 2 |
+| macro_avg | 0.5000 | 0.5000 | 0.6667 | 2 |
+| weighted_avg | 0.5000 | 0.5000 | 0.6667 | 2 |
+
+### Confusion matrix
+
+|  | turn_on | turn_off | unsure |
+| --- | --- | --- | --- |
+| turn_on | 1 | 0 | 0 |
+| turn_off | 1 | 0 | 0 |
+
+### Misclassified
+
+| Text | Command | Predicted | Probability |
+| --- | --- | --- | --- |
+| turn off | turn_off | turn_on | 0.7500 |
+`,
+		},
+		{
+			name:    "unknown format",
+			format:  "xml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := clf.WriteReports(&buf, tt.format, reports); (err != nil) != tt.wantErr {
+				t.Fatalf("WriteReports() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("WriteReports() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteReports_CSVFolds(t *testing.T) {
+	classification := dataset.DataSet{
+		{Command: "turn_on", Texts: []string{"turn on"}},
+		{Command: "turn_off", Texts: []string{"turn off"}},
+	}
+	confusionMatrix := [][]int{{1, 0, 0}, {0, 1, 0}}
+	sumI, sumJ, sumIJ, sumTrue := clf.GetSums(confusionMatrix)
+	folds := []clf.FoldResult{{
+		Evaluation: &clf.Evaluation{ConfusionMatrix: confusionMatrix},
+		Scores:     clf.GetScores(confusionMatrix, sumI, sumJ, sumIJ, sumTrue),
+		Support:    sumIJ,
+	}}
+	reports := []*clf.Report{
+		clf.NewCrossValidationReport("cross_validation", classification, folds),
+		clf.NewReport("test_set", classification, &clf.Evaluation{ConfusionMatrix: confusionMatrix}),
+	}
+
+	var buf bytes.Buffer
+	if err := clf.WriteReports(&buf, clf.OutputCSV, reports); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every row has the same columns, so the reports can be read as one table
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("csv.ReadAll() error = %v", err)
+	}
+
+	want := [][]string{
+		{"cross_validation", "1", "", "accuracy", "1.0000", ""},
+		{"cross_validation", "1", "", "support", "2", ""},
+		{"cross_validation", "", "turn_on", "predicted:unsure", "0", ""},
+		{"test_set", "", "turn_off", "predicted:turn_off", "1", ""},
+	}
+	for _, w := range want {
+		found := false
+		for _, row := range rows {
+			if reflect.DeepEqual(row, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("WriteReports() has no row %v", w)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package configdir loads a configuration split across several files: the
+// name.yml file of a path and every file of its name.d directory, so that
+// each part of a large bot can be kept in its own file
+package configdir
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// exts are the extensions of the configuration files
+var exts = []string{".yml", ".yaml"}
+
+// Dir returns the directory with the configuration files of name in path
+func Dir(path, name string) string {
+	return filepath.Join(path, name+".d")
+}
+
+// Files returns the configuration files of name in path, in the order they
+// are merged: name.yml (or name.yaml) first, then the files of the name.d
+// directory sorted by name. It fails if there are no files at all
+func Files(path, name string) ([]string, error) {
+	files := make([]string, 0)
+	for _, ext := range exts {
+		file := filepath.Join(path, name+ext)
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+			break
+		}
+	}
+
+	matches := make([]string, 0)
+	for _, ext := range exts {
+		found, err := filepath.Glob(filepath.Join(Dir(path, name), "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, found...)
+	}
+	sort.Strings(matches)
+	files = append(files, matches...)
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no %s configuration found in %s, add %s.yml or %s/*.yml", name, path, name, Dir(path, name))
+	}
+
+	return files, nil
+}
+
+// Origins are the files the items of the lists of a configuration were
+// loaded from, by key. Keys of nested lists are joined with dots
+type Origins map[string][]string
+
+// File returns the file item n of the list key was loaded from
+func (o Origins) File(key string, n int) string {
+	if n < 0 || n >= len(o[key]) {
+		return ""
+	}
+	return o[key][n]
+}
+
+// Read merges the files into the config, see Files. Lists are appended
+// in the order of the files, maps are merged, and a value set to two
+// different values in two files is an error naming both files
+func Read(config *viper.Viper, files []string) (Origins, error) {
+	m := &merger{
+		settings: make(map[string]interface{}),
+		origins:  make(Origins),
+		setBy:    make(map[string]string),
+	}
+
+	for _, file := range files {
+		v := viper.New()
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if err := m.merge(m.settings, v.AllSettings(), file, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.MergeConfigMap(m.settings); err != nil {
+		return nil, err
+	}
+
+	return m.origins, nil
+}
+
+// merger merges the settings of several files
+type merger struct {
+	settings map[string]interface{}
+	origins  Origins
+	setBy    map[string]string
+}
+
+// merge merges the settings of a file into dst, prefix is the key of dst
+func (m *merger) merge(dst, src map[string]interface{}, file, prefix string) error {
+	keys := make([]string, 0, len(src))
+	for key := range src {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := src[key]
+		if value == nil {
+			continue
+		}
+
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		current, ok := dst[key]
+
+		switch v := value.(type) {
+		case []interface{}:
+			list, isList := current.([]interface{})
+			if ok && !isList {
+				return m.conflict(name, file)
+			}
+			dst[key] = append(list, v...)
+			for range v {
+				m.origins[name] = append(m.origins[name], file)
+			}
+			m.setBy[name] = file
+		case map[string]interface{}:
+			sub, isMap := current.(map[string]interface{})
+			if ok && !isMap {
+				return m.conflict(name, file)
+			}
+			if !ok {
+				sub = make(map[string]interface{}, len(v))
+				dst[key] = sub
+			}
+			if err := m.merge(sub, v, file, name); err != nil {
+				return err
+			}
+			m.setBy[name] = file
+		default:
+			if ok && !reflect.DeepEqual(current, value) {
+				return m.conflict(name, file)
+			}
+			dst[key] = value
+			m.setBy[name] = file
+		}
+	}
+
+	return nil
+}
+
+// conflict returns the error of a key set to different values in two files
+func (m *merger) conflict(name, file string) error {
+	return fmt.Errorf("%s is set to different values in %s and %s", name, m.setBy[name], file)
+}
+
+// debounce is how long Watch waits for the files to stop changing before
+// calling onChange, so editors and tools that write several files, or a
+// file in several writes, only cause one reload
+const debounce = 300 * time.Millisecond
+
+// Watch calls onChange every time a configuration file of name in path,
+// or a file in one of the extra directories, is created, written, removed
+// or renamed, until the context is done. Changes that happen within the
+// debounce interval of each other call onChange once. Directories that do
+// not exist yet are not watched
+func Watch(ctx context.Context, path, name string, onChange func(), extra ...string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := append([]string{path, Dir(path, name)}, extra...)
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	match := func(file string) bool {
+		ext := filepath.Ext(file)
+		if ext != exts[0] && ext != exts[1] {
+			return false
+		}
+		if filepath.Clean(filepath.Dir(file)) != filepath.Clean(path) {
+			return true
+		}
+		return strings.TrimSuffix(filepath.Base(file), ext) == name
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		// changed fires once the files stopped changing
+		var changed <-chan time.Time
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				changed = nil
+				onChange()
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 && match(event.Name) {
+					changed = time.After(debounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error(err)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package configdir_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaimeteb/chatto/internal/configdir"
+	"github.com/spf13/viper"
+)
+
+func writeFiles(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	path := t.TempDir()
+	if _, err := configdir.Files(path, "fsm"); err == nil {
+		t.Error("Files() error = nil, want an error")
+	}
+
+	writeFiles(t, path, map[string]string{
+		"fsm.yml":        "",
+		"fsm.d/b.yaml":   "",
+		"fsm.d/a.yml":    "",
+		"fsm.d/notes.md": "",
+		"clf.yml":        "",
+	})
+
+	got, err := configdir.Files(path, "fsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(path, "fsm.yml"),
+		filepath.Join(path, "fsm.d", "a.yml"),
+		filepath.Join(path, "fsm.d", "b.yaml"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Files() = %v, want %v", got, want)
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantItems   []string
+		wantOrigins []string
+		wantErr     []string
+	}{
+		{
+			name: "merge",
+			files: map[string]string{
+				"fsm.yml":       "items: [a]\ndefaults:\n  unknown: what?\n",
+				"fsm.d/one.yml": "items: [b, c]\ndefaults:\n  error: oops\n",
+				"fsm.d/two.yml": "defaults:\n  unknown: what?\n",
+			},
+			wantItems:   []string{"a", "b", "c"},
+			wantOrigins: []string{"fsm.yml", "one.yml", "one.yml"},
+		},
+		{
+			name: "conflict",
+			files: map[string]string{
+				"fsm.yml":       "defaults:\n  unknown: what?\n",
+				"fsm.d/one.yml": "defaults:\n  unknown: huh?\n",
+			},
+			wantErr: []string{"defaults.unknown", "fsm.yml", "one.yml"},
+		},
+		{
+			name: "list and value",
+			files: map[string]string{
+				"fsm.yml":       "items: [a]\n",
+				"fsm.d/one.yml": "items: b\n",
+			},
+			wantErr: []string{"items", "fsm.yml", "one.yml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir()
+			writeFiles(t, path, tt.files)
+
+			files, err := configdir.Files(path, "fsm")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := viper.New()
+			config.SetDefault("defaults.error", "error")
+			origins, err := configdir.Read(config, files)
+			if tt.wantErr != nil {
+				for _, want := range tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("Read() error = %v, want an error with %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := config.GetStringSlice("items"); !reflect.DeepEqual(got, tt.wantItems) {
+				t.Errorf("Read() items = %v, want %v", got, tt.wantItems)
+			}
+			if got := config.GetString("defaults.error"); got != "oops" {
+				t.Errorf("Read() defaults.error = %v, want %v", got, "oops")
+			}
+			for n, want := range tt.wantOrigins {
+				if got := filepath.Base(origins.File("items", n)); got != want {
+					t.Errorf("Origins.File(items, %d) = %v, want %v", n, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := t.TempDir()
+	writeFiles(t, path, map[string]string{"fsm.yml": "", "fsm.d/one.yml": ""})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 10)
+	if err := configdir.Watch(ctx, path, "fsm", func() { changed <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other configurations of the path are ignored
+	writeFiles(t, path, map[string]string{"clf.yml": "classification: []\n"})
+	writeFiles(t, path, map[string]string{"fsm.d/two.yml": "transitions: []\n"})
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch() did not notice the new file in fsm.d")
+	}
+
+	// Nothing is watched once the context is done
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	for len(changed) > 0 {
+		<-changed
+	}
+
+	writeFiles(t, path, map[string]string{"fsm.d/three.yml": "transitions: []\n"})
+
+	select {
+	case <-changed:
+		t.Fatal("Watch() noticed a new file after the context was done")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestWatch_Debounce(t *testing.T) {
+	path := t.TempDir()
+	writeFiles(t, path, map[string]string{"fsm.yml": "", "fsm.d/base.yml": ""})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 10)
+	if err := configdir.Watch(ctx, path, "fsm", func() { changed <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files written one after the other are reloaded once
+	for i := 0; i < 5; i++ {
+		writeFiles(t, path, map[string]string{fmt.Sprintf("fsm.d/%d.yml", i): "transitions: []\n"})
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch() did not notice the new files")
+	}
+	select {
+	case <-changed:
+		t.Fatal("Watch() noticed the new files more than once")
+	case <-time.After(time.Second):
+	}
+}
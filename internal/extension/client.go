@@ -2,16 +2,24 @@ package extension
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/rpc"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/jaimeteb/chatto/extensions"
 	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/logger"
+	"github.com/jaimeteb/chatto/internal/tracing"
 	"github.com/jaimeteb/chatto/query"
+	"github.com/jaimeteb/chatto/version"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // RPC is an RPC Client for extension command functions
@@ -19,25 +27,33 @@ type RPC struct {
 	Client *rpc.Client
 }
 
-// ExecuteExtension runs the requested command function and returns the response
-func (e *RPC) ExecuteExtension(question *query.Question, ext, chn, cmd string, fsmDomain *fsm.Domain, machine *fsm.FSM) ([]query.Answer, error) {
+// ExecuteExtension runs the requested command function and returns the response,
+// the trace context and the turn ID are sent in the fields of the request
+func (e *RPC) ExecuteExtension(ctx context.Context, question *query.Question, ext, chn, cmd string, fsmDomain *fsm.Domain, machine *fsm.FSM) ([]query.Answer, error) {
+	ctx, span := startSpan(ctx, "RPC", ext)
+
 	req := extensions.ExecuteExtensionRequest{
-		FSM:       machine,
-		Extension: ext,
-		Question:  question,
-		Domain:    fsmDomain.NoFuncs(),
-		Channel:   chn,
-		Command:   cmd,
+		FSM:          machine,
+		Extension:    ext,
+		Question:     question,
+		Domain:       fsmDomain.NoFuncs(),
+		Channel:      chn,
+		Command:      cmd,
+		TraceContext: make(map[string]string),
+		TurnID:       logger.TurnID(ctx),
 	}
+	tracing.Propagator.Inject(ctx, propagation.MapCarrier(req.TraceContext))
 
 	res := extensions.ExecuteExtensionResponse{}
 
 	err := e.Client.Call("ListenerRPC.ExecuteExtension", &req, &res)
+	tracing.End(span, err)
 	if err != nil {
+		logger.Entry(ctx).Errorf("Error executing RPC extension %s: %v", ext, err)
 		return nil, errors.New(fsmDomain.DefaultMessages.Error)
 	}
 
-	*machine = *res.FSM
+	machine.MergeExtension(res.FSM)
 
 	return res.Answers, nil
 }
@@ -54,6 +70,28 @@ func (e *RPC) GetAllExtensions() ([]string, error) {
 	return res.Extensions, nil
 }
 
+// GetBuildVersion returns the build version of the extension server,
+// or the error of ctx if it is done before the server answers
+func (e *RPC) GetBuildVersion(ctx context.Context) (*version.BuildResponse, error) {
+	res := new(version.BuildResponse)
+	call := e.Client.Go("ListenerRPC.GetBuildVersion", &extensions.GetBuildVersionRequest{}, res, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return nil, call.Error
+		}
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the connection to the extension server
+func (e *RPC) Close() error {
+	return e.Client.Close()
+}
+
 // REST is a REST Client for extension command functions
 type REST struct {
 	URL   string
@@ -61,8 +99,23 @@ type REST struct {
 	token string
 }
 
-// ExecuteExtension runs the requested command function and returns the response
-func (e *REST) ExecuteExtension(question *query.Question, ext, chn, cmd string, fsmDomain *fsm.Domain, machine *fsm.FSM) ([]query.Answer, error) {
+// ExecuteExtension runs the requested command function and returns the response,
+// the trace context and the turn ID are sent in the headers of the request
+func (e *REST) ExecuteExtension(ctx context.Context, question *query.Question, ext, chn, cmd string, fsmDomain *fsm.Domain, machine *fsm.FSM) ([]query.Answer, error) {
+	ctx, span := startSpan(ctx, "REST", ext)
+
+	answers, err := e.execute(ctx, question, ext, chn, cmd, fsmDomain, machine)
+	tracing.End(span, err)
+	if err != nil {
+		logger.Entry(ctx).Errorf("Error executing REST extension %s: %v", ext, err)
+		return nil, errors.New(fsmDomain.DefaultMessages.Error)
+	}
+
+	return answers, nil
+}
+
+// execute calls the extension and returns the error of the call, if any
+func (e *REST) execute(ctx context.Context, question *query.Question, ext, chn, cmd string, fsmDomain *fsm.Domain, machine *fsm.FSM) ([]query.Answer, error) {
 	req := extensions.ExecuteExtensionRequest{
 		FSM:       machine,
 		Extension: ext,
@@ -70,42 +123,48 @@ func (e *REST) ExecuteExtension(question *query.Question, ext, chn, cmd string,
 		Domain:    fsmDomain.NoFuncs(),
 		Channel:   chn,
 		Command:   cmd,
+		TurnID:    logger.TurnID(ctx),
 	}
 
 	jsonReq, err := json.Marshal(req)
 	if err != nil {
-		return nil, errors.New(fsmDomain.DefaultMessages.Error)
+		return nil, err
 	}
 
 	// TODO: if fail -> don't change states
 
 	request, err := retryablehttp.NewRequest("POST", fmt.Sprintf("%s/extension", e.URL), bytes.NewBuffer(jsonReq))
 	if err != nil {
-		return nil, errors.New(fsmDomain.DefaultMessages.Error)
+		return nil, err
 	}
+	request = request.WithContext(ctx)
 	request.Header.Set("Content-Type", "application/json")
 	if e.token != "" {
 		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.token))
 	}
+	if req.TurnID != "" {
+		request.Header.Set("X-Request-ID", req.TurnID)
+	}
+	tracing.Propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
 	resp, err := e.http.Do(request)
 
 	if err != nil {
-		return nil, errors.New(fsmDomain.DefaultMessages.Error)
+		return nil, err
 	}
 
 	defer func() {
 		err = resp.Body.Close()
 		if err != nil {
-			log.Error(err)
+			logger.Entry(ctx).Error(err)
 		}
 	}()
 
 	res := extensions.ExecuteExtensionResponse{}
 	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return nil, errors.New(fsmDomain.DefaultMessages.Error)
+		return nil, err
 	}
 
-	*machine = *res.FSM
+	machine.MergeExtension(res.FSM)
 
 	return res.Answers, nil
 }
@@ -139,3 +198,53 @@ func (e *REST) GetAllExtensions() ([]string, error) {
 
 	return res, nil
 }
+
+// GetBuildVersion returns the build version of the extension server
+func (e *REST) GetBuildVersion(ctx context.Context) (*version.BuildResponse, error) {
+	request, err := retryablehttp.NewRequest("GET", fmt.Sprintf("%s/version", e.URL), nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	if e.token != "" {
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.token))
+	}
+	resp, err := e.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status getting the version: %s", resp.Status)
+	}
+
+	res := new(version.BuildResponse)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Close closes the idle connections to the extension server
+func (e *REST) Close() error {
+	e.http.HTTPClient.CloseIdleConnections()
+	return nil
+}
+
+// startSpan starts the client span of an extension call
+func startSpan(ctx context.Context, kind, ext string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "Extension.ExecuteExtension",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("chatto.extension.type", kind),
+			attribute.String("chatto.extension.name", ext),
+		),
+	)
+}
@@ -1,15 +1,24 @@
 package extension_test
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"reflect"
 	"strconv"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/jaimeteb/chatto/extensions"
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/extension"
 	"github.com/jaimeteb/chatto/internal/testutils"
 	"github.com/jaimeteb/chatto/query"
+	"github.com/jaimeteb/chatto/version"
 )
 
 func TestExtensionRESTError(t *testing.T) {
@@ -47,7 +56,7 @@ func TestExtensionREST(t *testing.T) {
 		t.Errorf("extension.New() = %v, want %v.", err, nil)
 	}
 
-	resp, err := extensions["test"].ExecuteExtension(&query.Question{Text: "hello"}, "any", "", "", &fsm.Domain{}, &fsm.FSM{})
+	resp, err := extensions["test"].ExecuteExtension(context.Background(), &query.Question{Text: "hello"}, "any", "", "", &fsm.Domain{}, &fsm.FSM{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,6 +66,57 @@ func TestExtensionREST(t *testing.T) {
 	if len(resp) == 1 && resp[0].Text != want {
 		t.Errorf("extension.ExecuteExtension() = %v, want %v.", resp[0].Text, want)
 	}
+
+	build, err := extensions["test"].GetBuildVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *build != version.Build() {
+		t.Errorf("extension.GetBuildVersion() = %v, want %v.", *build, version.Build())
+	}
+}
+
+func TestExtensionREST_PartialFSM(t *testing.T) {
+	// An extension that only sends back the state and the slots
+	mux := http.NewServeMux()
+	mux.HandleFunc("/extensions", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `["partial"]`)
+	})
+	mux.HandleFunc("/extension", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"fsm":{"state":2,"slots":{"name":"Ann"}},"answers":[{"text":"Hi Ann"}]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	exts, err := extension.New(extension.ConfigMap{"test": {Type: "REST", URL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine := &fsm.FSM{
+		State:     1,
+		Slots:     map[string]string{"name": "Bob"},
+		Rotations: map[string]int{"greet": 1},
+		Pending:   &fsm.Disambiguation{Text: "hi", Commands: []string{"a", "b"}},
+		Form:      "signup",
+		Calls:     []int{3},
+	}
+	if _, err := exts["test"].ExecuteExtension(context.Background(), &query.Question{Text: "hi"}, "partial", "", "", &fsm.Domain{}, machine); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &fsm.FSM{
+		State:       2,
+		Slots:       map[string]string{"name": "Ann"},
+		Rotations:   map[string]int{"greet": 1},
+		Pending:     &fsm.Disambiguation{Text: "hi", Commands: []string{"a", "b"}},
+		Form:        "signup",
+		Calls:       []int{3},
+		SlotHistory: map[string][]json.RawMessage{"name": {json.RawMessage(`"Bob"`)}},
+	}
+	if !reflect.DeepEqual(machine, want) {
+		t.Errorf("extension.ExecuteExtension() FSM = %v, want %v", spew.Sprint(machine), spew.Sprint(want))
+	}
 }
 
 func TestExtensionRPCPokemon(t *testing.T) {
@@ -97,7 +157,7 @@ func TestExtensionRPCPokemon(t *testing.T) {
 		},
 	}
 
-	resp, err := extensions["pokemon"].ExecuteExtension(&query.Question{Text: "pikachu"}, "search_pokemon", "", "", fsmDomain, &testFSM)
+	resp, err := extensions["pokemon"].ExecuteExtension(context.Background(), &query.Question{Text: "pikachu"}, "search_pokemon", "", "", fsmDomain, &testFSM)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -136,3 +196,43 @@ func TestExtensionRPCError(t *testing.T) {
 		t.Errorf("extension.New() = %v, want %v.", spew.Sprint(extensions), "map[]")
 	}
 }
+
+func TestExtensionRPCGetBuildVersion(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.Register(&extensions.ListenerRPC{}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(listener)
+
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext := &extension.RPC{Client: client}
+
+	build, err := ext.GetBuildVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *build != version.Build() {
+		t.Errorf("extension.GetBuildVersion() = %v, want %v.", *build, version.Build())
+	}
+
+	// The version is not read once the server is closed
+	listener.Close()
+	ext.Close()
+	if _, err := ext.GetBuildVersion(context.Background()); err == nil {
+		t.Error("extension.GetBuildVersion() after Close() = nil, want an error.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ext.GetBuildVersion(ctx); err == nil {
+		t.Error("extension.GetBuildVersion() with a done context = nil, want an error.")
+	}
+}
@@ -1,10 +1,13 @@
 package extension
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/query"
+	"github.com/jaimeteb/chatto/version"
+	log "github.com/sirupsen/logrus"
 )
 
 // ServerMap of extension server names to their clients
@@ -23,10 +26,26 @@ func (m *ServerMap) Add(server string, client Extension) error {
 	return nil
 }
 
+// Close closes the clients of every extension server, returning the first error
+func (m ServerMap) Close() error {
+	var firstErr error
+	for server, client := range m {
+		if err := client.Close(); err != nil {
+			log.Errorf("Error closing extension server %s: %v", server, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
 // Extension is a service (REST or RPC) that executes commands and returns
 // an answer to the Chatto bot. Extensions are written in any language and
 // do whatever you want.
 type Extension interface {
 	GetAllExtensions() ([]string, error)
-	ExecuteExtension(question *query.Question, extensionName, channel, command string, fsmDomain *fsm.Domain, machine *fsm.FSM) ([]query.Answer, error)
+	ExecuteExtension(ctx context.Context, question *query.Question, extensionName, channel, command string, fsmDomain *fsm.Domain, machine *fsm.FSM) ([]query.Answer, error)
+	GetBuildVersion(ctx context.Context) (*version.BuildResponse, error)
+	Close() error
 }
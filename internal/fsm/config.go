@@ -1,49 +1,122 @@
 package fsm
 
 import (
-	"github.com/fsnotify/fsnotify"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
 	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/configdir"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
 // Config contains the states, commands, functions and
-// default messages of the FSM
+// default messages of the FSM. It can be split across fsm.yml and the
+// files of the fsm.d directory, and flows can be declared in their own
+// files in the flows directory
 type Config struct {
 	Transitions []fsm.Transition `yaml:"transitions" mapstructure:"transitions"`
+	Forms       []fsm.Form       `yaml:"forms" mapstructure:"forms"`
+	Flows       []fsm.Flow       `yaml:"flows" mapstructure:"flows"`
 	Defaults    fsm.Defaults     `yaml:"defaults" mapstructure:"defaults"`
 }
 
-// LoadConfig loads the FSM configuration from yaml
-func LoadConfig(path string, reloadChan chan Config) (*Config, error) {
-	config := viper.New()
-	config.SetConfigName("fsm")
-	config.AddConfigPath(path)
-	config.SetDefault("defaults.unknown", "Unknown command, try something different.")
-	config.SetDefault("defaults.unsure", "Not sure I understood, try something different.")
-	config.SetDefault("defaults.error", "There was an error, try again later.")
+// AllTransitions returns the transitions followed by the transitions of the
+// forms and the flows, see fsm.FormTransitions and fsm.FlowTransitions
+func (c *Config) AllTransitions() []fsm.Transition {
+	transitions := make([]fsm.Transition, 0, len(c.Transitions)+len(c.Forms)*2)
+	transitions = append(transitions, c.Transitions...)
+	transitions = append(transitions, fsm.FormTransitions(c.Forms)...)
+	return append(transitions, fsm.FlowTransitions(c.Flows)...)
+}
+
+// FlowFiles returns the files of the flows directory in path,
+// in the order their flows are loaded
+func FlowFiles(path string) ([]string, error) {
+	files := make([]string, 0)
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(path, "flows", pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
 
-	config.WatchConfig()
-	config.OnConfigChange(func(in fsnotify.Event) {
-		if in.Op == fsnotify.Create || in.Op == fsnotify.Write {
-			log.Info("Reloading FSM configuration.")
+// loadFlows loads the flows of the flows directory, one per file,
+// see FlowFiles. A flow without a name is named after its file
+func loadFlows(files []string) ([]fsm.Flow, error) {
+	flows := make([]fsm.Flow, 0, len(files))
+	for _, file := range files {
+		config := viper.New()
+		config.SetConfigFile(file)
+		if err := config.ReadInConfig(); err != nil {
+			return nil, err
+		}
 
-			if err := config.ReadInConfig(); err != nil {
-				log.Error(err)
-				return
-			}
+		var flow fsm.Flow
+		if err := config.Unmarshal(&flow); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(flow.Name) == "" {
+			flow.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
 
-			var fsmConfig Config
-			if err := config.Unmarshal(&fsmConfig); err != nil {
-				log.Error(err)
-				return
-			}
+		flows = append(flows, flow)
+	}
 
-			reloadChan <- fsmConfig
+	return flows, nil
+}
+
+// LoadConfig loads the FSM configuration from fsm.yml and the files of
+// the fsm.d directory, see configdir.Files, and the flows directory
+func LoadConfig(path string) (*Config, error) {
+	return loadConfig(path)
+}
+
+// WatchConfig loads the FSM configuration into reloadChan every time one
+// of its files changes, until the context is done. A configuration that
+// cannot be loaded is logged and not sent
+func WatchConfig(ctx context.Context, path string, reloadChan chan<- Config) error {
+	reload := func() {
+		log.Info("Reloading FSM configuration.")
+
+		fsmConfig, err := loadConfig(path)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		select {
+		case reloadChan <- *fsmConfig:
+		case <-ctx.Done():
 		}
-	})
+	}
 
-	if err := config.ReadInConfig(); err != nil {
+	return configdir.Watch(ctx, path, "fsm", reload, filepath.Join(path, "flows"))
+}
+
+// loadConfig merges the FSM configuration files and checks
+// that they do not conflict with each other
+func loadConfig(path string) (*Config, error) {
+	files, err := configdir.Files(path, "fsm")
+	if err != nil {
+		return nil, err
+	}
+
+	config := viper.New()
+	config.SetDefault("defaults.unknown", "Unknown command, try something different.")
+	config.SetDefault("defaults.unsure", "Not sure I understood, try something different.")
+	config.SetDefault("defaults.error", "There was an error, try again later.")
+	config.SetDefault("defaults.disambiguation", fsm.DefaultDisambiguation)
+
+	origins, err := configdir.Read(config, files)
+	if err != nil {
 		return nil, err
 	}
 
@@ -52,17 +125,93 @@ func LoadConfig(path string, reloadChan chan Config) (*Config, error) {
 		return nil, err
 	}
 
+	flowFiles, err := FlowFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	flows, err := loadFlows(flowFiles)
+	if err != nil {
+		return nil, err
+	}
+	fsmConfig.Flows = append(fsmConfig.Flows, flows...)
+	origins["flows"] = append(origins["flows"], flowFiles...)
+
+	if err := checkConflicts(&fsmConfig, origins); err != nil {
+		return nil, err
+	}
+
 	return &fsmConfig, nil
 }
 
+// checkConflicts checks that transitions of different files do not go
+// from the same state with the same command without conditions, and that
+// forms and flows are not declared twice
+func checkConflicts(fsmConfig *Config, origins configdir.Origins) error {
+	type key struct{ from, command string }
+	declared := make(map[key]string)
+	for n, transition := range fsmConfig.Transitions {
+		if len(transition.Conditions) > 0 {
+			continue
+		}
+		file := origins.File("transitions", n)
+		command := strings.TrimSpace(transition.Command)
+		for _, from := range transition.From {
+			k := key{strings.TrimSpace(from), command}
+			if other, ok := declared[k]; ok && other != file {
+				return fmt.Errorf("transitions from state '%s' with command '%s' conflict in %s and %s", k.from, k.command, other, file)
+			}
+			declared[k] = file
+		}
+	}
+
+	forms := make(map[string]string, len(fsmConfig.Forms))
+	for n, form := range fsmConfig.Forms {
+		name := strings.TrimSpace(form.Name)
+		file := origins.File("forms", n)
+		if other, ok := forms[name]; ok {
+			return fmt.Errorf("form '%s' is declared in %s and %s", name, other, file)
+		}
+		forms[name] = file
+	}
+
+	flows := make(map[string]string, len(fsmConfig.Flows))
+	for n, flow := range fsmConfig.Flows {
+		name := strings.TrimSpace(flow.Name)
+		file := origins.File("flows", n)
+		if other, ok := flows[name]; ok {
+			return fmt.Errorf("flow '%s' is declared in %s and %s", name, other, file)
+		}
+		flows[name] = file
+	}
+
+	return nil
+}
+
 // NewDomainFromConfig initializes a FSM Domain from the FSM Config
-func NewDomainFromConfig(fsmConfig *Config) *fsm.Domain {
-	fsmDomain := fsm.NewDomain(fsmConfig.Transitions, fsmConfig.Defaults)
+func NewDomainFromConfig(fsmConfig *Config) (*fsm.Domain, error) {
+	fsmDomain, err := fsm.NewDomainWithFlows(fsmConfig.Transitions, fsmConfig.Forms, fsmConfig.Flows, fsmConfig.Defaults)
+	if err != nil {
+		return nil, err
+	}
 
 	log.Info("Loaded states:")
 	for stateName, stateID := range fsmDomain.StateTable {
 		log.Infof("%2d %v", stateID, stateName)
 	}
 
-	return fsmDomain
+	if len(fsmConfig.Forms) > 0 {
+		log.Info("Loaded forms:")
+		for _, form := range fsmConfig.Forms {
+			log.Infof("* %v (%d slots)", form.Name, len(form.Slots))
+		}
+	}
+
+	if len(fsmConfig.Flows) > 0 {
+		log.Info("Loaded flows:")
+		for _, flow := range fsmConfig.Flows {
+			log.Infof("* %v (%d transitions)", flow.Name, len(flow.Transitions))
+		}
+	}
+
+	return fsmDomain, nil
 }
@@ -1,7 +1,10 @@
 package fsm_test
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/jaimeteb/chatto/fsm"
@@ -56,9 +59,10 @@ func TestLoadConfig(t *testing.T) {
 					},
 				},
 				Defaults: fsm.Defaults{
-					Unknown: "Can't do that.",
-					Unsure:  "???",
-					Error:   "Error",
+					Unknown:        "Can't do that.",
+					Unsure:         "???",
+					Error:          "Error",
+					Disambiguation: fsm.DefaultDisambiguation,
 				},
 			},
 			wantErr: false,
@@ -72,8 +76,7 @@ func TestLoadConfig(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			fsmReloadChan := make(chan fsmint.Config)
-			got, err := fsmint.LoadConfig(tt.args.path, fsmReloadChan)
+			got, err := fsmint.LoadConfig(tt.args.path)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -84,3 +87,177 @@ func TestLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadConfig_Flows(t *testing.T) {
+	path := t.TempDir()
+	files := map[string]string{
+		"fsm.yml": `transitions:
+  - from: [initial]
+    into: confirm
+    command: order
+    call: collect_address
+flows:
+  - name: inline
+    transitions:
+      - from: [initial]
+        into: return
+        command: any
+`,
+		"flows/collect_address.yml": `transitions:
+  - from: [initial]
+    into: confirm
+    command: any
+  - from: [confirm]
+    into: return
+    command: "yes"
+`,
+		"flows/phone.yaml": `name: collect_phone
+transitions:
+  - from: [any]
+    into: return
+    command: any
+`,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := fsmint.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, len(got.Flows))
+	for i, flow := range got.Flows {
+		names[i] = flow.Name
+	}
+	if want := []string{"inline", "collect_address", "collect_phone"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("LoadConfig() flows = %v, want %v", names, want)
+	}
+
+	fsmDomain, err := fsmint.NewDomainFromConfig(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []string{"confirm", "collect_address.initial", "collect_address.confirm", "collect_phone.initial", "inline.initial"} {
+		if _, ok := fsmDomain.StateTable[state]; !ok {
+			t.Errorf("NewDomainFromConfig() has no state %v", state)
+		}
+	}
+}
+
+func TestLoadConfig_Directory(t *testing.T) {
+	const greet = `transitions:
+  - from: [initial]
+    into: greeted
+    command: greet
+`
+	tests := []struct {
+		name            string
+		files           map[string]string
+		wantTransitions int
+		wantErr         []string
+	}{
+		{
+			name: "merged",
+			files: map[string]string{
+				"fsm.yml": greet + "defaults:\n  unknown: What?\n",
+				"fsm.d/bye.yml": `transitions:
+  - from: [greeted]
+    into: initial
+    command: bye
+`,
+				"fsm.d/guarded.yaml": `transitions:
+  - from: [initial]
+    into: vip
+    command: greet
+    conditions:
+      - slot: vip
+        operator: equals
+        value: "yes"
+`,
+			},
+			wantTransitions: 3,
+		},
+		{
+			name:            "only the directory",
+			files:           map[string]string{"fsm.d/greet.yml": greet},
+			wantTransitions: 1,
+		},
+		{
+			name:    "conflicting transitions",
+			files:   map[string]string{"fsm.yml": greet, "fsm.d/greet.yml": greet},
+			wantErr: []string{"'initial'", "'greet'", "fsm.yml", "greet.yml"},
+		},
+		{
+			name: "conflicting defaults",
+			files: map[string]string{
+				"fsm.yml":         greet + "defaults:\n  unknown: What?\n",
+				"fsm.d/other.yml": "defaults:\n  unknown: Huh?\n",
+			},
+			wantErr: []string{"defaults.unknown", "fsm.yml", "other.yml"},
+		},
+		{
+			name: "duplicate form",
+			files: map[string]string{
+				"fsm.yml":         greet + "forms:\n  - name: signup\n    from: [initial]\n    into: initial\n    command: bye\n",
+				"fsm.d/forms.yml": "forms:\n  - name: signup\n    from: [greeted]\n    into: initial\n    command: bye\n",
+			},
+			wantErr: []string{"form 'signup'", "fsm.yml", "forms.yml"},
+		},
+		{
+			name: "duplicate flow",
+			files: map[string]string{
+				"fsm.yml":           greet + "flows:\n  - name: address\n    transitions:\n      - from: [initial]\n        into: return\n        command: any\n",
+				"flows/address.yml": "transitions:\n  - from: [initial]\n    into: return\n    command: any\n",
+			},
+			wantErr: []string{"flow 'address'", "fsm.yml", "address.yml"},
+		},
+		{
+			name:    "no configuration",
+			files:   map[string]string{"clf.yml": ""},
+			wantErr: []string{"fsm.yml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(path, name), []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := fsmint.LoadConfig(path)
+			if tt.wantErr != nil {
+				for _, want := range tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("LoadConfig() error = %v, want an error with %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Transitions) != tt.wantTransitions {
+				t.Errorf("LoadConfig() transitions = %v, want %v", len(got.Transitions), tt.wantTransitions)
+			}
+			if got.Defaults.Error != "There was an error, try again later." {
+				t.Errorf("LoadConfig() defaults.error = %v, want the default", got.Defaults.Error)
+			}
+			if _, err := fsmint.NewDomainFromConfig(got); err != nil {
+				t.Errorf("NewDomainFromConfig() error = %v", err)
+			}
+		})
+	}
+}
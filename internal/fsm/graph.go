@@ -0,0 +1,211 @@
+package fsm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jaimeteb/chatto/fsm"
+)
+
+// Graph formats supported by WriteGraph
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// GraphEdge is a transition from one state into another
+type GraphEdge struct {
+	From      int
+	Into      int
+	Command   string
+	Slot      string
+	Extension string
+	// Call is the flow the transition calls, Into is then the
+	// initial state of the flow and Return the state to return to
+	Call   string
+	Return string
+}
+
+// Label describes the command, slot, call and extension of the edge in one line each
+func (e *GraphEdge) Label() []string {
+	label := []string{e.Command}
+	if e.Slot != "" {
+		label = append(label, "slot: "+e.Slot)
+	}
+	if e.Call != "" {
+		label = append(label, "call: "+e.Call+", return: "+e.Return)
+	}
+	if e.Extension != "" {
+		label = append(label, "extension: "+e.Extension)
+	}
+	return label
+}
+
+// Graph contains the states of a Domain and the transitions between them
+type Graph struct {
+	StateTable fsm.StateTable
+	Edges      []GraphEdge
+}
+
+// NewGraph builds the Graph of a Domain from the transitions it was created with
+func NewGraph(fsmDomain *fsm.Domain, transitions []fsm.Transition) *Graph {
+	graph := &Graph{StateTable: fsmDomain.StateTable}
+
+	for n := range transitions {
+		transition := transitions[n]
+
+		extension := ""
+		if transition.Extension != (fsm.Extension{}) {
+			extension = transition.Extension.Server + "." + transition.Extension.Name
+		}
+
+		into := fsmDomain.StateTable[strings.TrimSpace(transition.Into)]
+		call, ret := strings.TrimSpace(transition.Call), ""
+		if call != "" {
+			into, ret = fsmDomain.StateTable[fsm.FlowState(call, "initial")], strings.TrimSpace(transition.Into)
+		}
+
+		for _, from := range transition.From {
+			graph.Edges = append(graph.Edges, GraphEdge{
+				From:      fsmDomain.StateTable[strings.TrimSpace(from)],
+				Into:      into,
+				Command:   strings.TrimSpace(transition.Command),
+				Slot:      strings.TrimSpace(transition.Slot.Name),
+				Extension: extension,
+				Call:      call,
+				Return:    ret,
+			})
+		}
+	}
+
+	return graph
+}
+
+// States returns the ids of the states in the Graph, sorted. State any
+// is only included if a transition goes from it, and state return if a
+// transition goes into it
+func (g *Graph) States() []int {
+	states := make([]int, 0, len(g.StateTable))
+	for _, id := range g.StateTable {
+		if id == fsm.StateAny && !g.fromAny() {
+			continue
+		}
+		if id == fsm.StateReturn && !g.intoReturn() {
+			continue
+		}
+		states = append(states, id)
+	}
+	sort.Ints(states)
+	return states
+}
+
+func (g *Graph) fromAny() bool {
+	for i := range g.Edges {
+		if g.Edges[i].From == fsm.StateAny {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Graph) intoReturn() bool {
+	for i := range g.Edges {
+		if g.Edges[i].Into == fsm.StateReturn {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteGraph writes the Graph in the given format
+func (g *Graph) WriteGraph(w io.Writer, format string) error {
+	switch format {
+	case GraphDOT:
+		return g.WriteDOT(w)
+	case GraphMermaid:
+		return g.WriteMermaid(w)
+	default:
+		return fmt.Errorf("unknown graph format '%s', use %s or %s", format, GraphDOT, GraphMermaid)
+	}
+}
+
+// WriteDOT writes the Graph in the Graphviz DOT language. State initial
+// is drawn as a double circle, state any as a dashed box and transitions
+// with command any as dashed edges
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("digraph fsm {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=circle];\n")
+
+	for _, id := range g.States() {
+		name := strconv.Quote(g.StateTable.Name(id))
+		switch id {
+		case fsm.StateInitial:
+			fmt.Fprintf(&b, "  %s [shape=doublecircle, style=filled, fillcolor=lightgrey];\n", name)
+		case fsm.StateAny:
+			fmt.Fprintf(&b, "  %s [shape=box, style=dashed];\n", name)
+		default:
+			fmt.Fprintf(&b, "  %s;\n", name)
+		}
+	}
+
+	for i := range g.Edges {
+		edge := &g.Edges[i]
+		attrs := "label=" + strconv.Quote(strings.Join(edge.Label(), "\n"))
+		if edge.Command == "any" {
+			attrs += ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  %s -> %s [%s];\n", strconv.Quote(g.StateTable.Name(edge.From)), strconv.Quote(g.StateTable.Name(edge.Into)), attrs)
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid writes the Graph as a Mermaid state diagram. State initial
+// is the start of the diagram and is filled, state any is dashed
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("stateDiagram-v2\n")
+
+	for _, id := range g.States() {
+		fmt.Fprintf(&b, "    state %s as %s\n", strconv.Quote(g.StateTable.Name(id)), mermaidID(id))
+	}
+
+	fmt.Fprintf(&b, "    [*] --> %s\n", mermaidID(fsm.StateInitial))
+
+	for i := range g.Edges {
+		edge := &g.Edges[i]
+		label := strings.ReplaceAll(strings.Join(edge.Label(), ", "), "\n", " ")
+		fmt.Fprintf(&b, "    %s --> %s: %s\n", mermaidID(edge.From), mermaidID(edge.Into), label)
+	}
+
+	b.WriteString("    classDef initialState fill:#ddd,font-weight:bold\n")
+	fmt.Fprintf(&b, "    class %s initialState\n", mermaidID(fsm.StateInitial))
+	if g.fromAny() {
+		b.WriteString("    classDef anyState stroke-dasharray:5 5\n")
+		fmt.Fprintf(&b, "    class %s anyState\n", mermaidID(fsm.StateAny))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidID returns an identifier for the state that Mermaid accepts
+func mermaidID(id int) string {
+	if id == fsm.StateAny {
+		return "state_any"
+	}
+	if id == fsm.StateReturn {
+		return "state_return"
+	}
+	return fmt.Sprintf("state_%d", id)
+}
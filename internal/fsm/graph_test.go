@@ -0,0 +1,132 @@
+package fsm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jaimeteb/chatto/fsm"
+	fsmint "github.com/jaimeteb/chatto/internal/fsm"
+)
+
+func TestGraph_WriteGraph(t *testing.T) {
+	transitions := []fsm.Transition{
+		{
+			From:    []string{"initial"},
+			Into:    "ask",
+			Command: "hello",
+		},
+		{
+			From:      []string{"ask"},
+			Into:      "initial",
+			Command:   "any",
+			Slot:      fsm.Slot{Name: "name"},
+			Extension: fsm.Extension{Server: "ext", Name: "save"},
+		},
+		{
+			From:    []string{"any"},
+			Into:    "initial",
+			Command: "bye",
+		},
+	}
+
+	fsmDomain, err := fsm.NewDomain(transitions, fsm.Defaults{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph := fsmint.NewGraph(fsmDomain, transitions)
+
+	tests := []struct {
+		name    string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "dot",
+			format: fsmint.GraphDOT,
+			want: `digraph fsm {
+  rankdir=LR;
+  node [shape=circle];
+  "any" [shape=box, style=dashed];
+  "initial" [shape=doublecircle, style=filled, fillcolor=lightgrey];
+  "ask";
+  "initial" -> "ask" [label="hello"];
+  "ask" -> "initial" [label="any\nslot: name\nextension: ext.save", style=dashed];
+  "any" -> "initial" [label="bye"];
+}
+`,
+		},
+		{
+			name:   "mermaid",
+			format: fsmint.GraphMermaid,
+			want: `stateDiagram-v2
+    state "any" as state_any
+    state "initial" as state_0
+    state "ask" as state_1
+    [*] --> state_0
+    state_0 --> state_1: hello
+    state_1 --> state_0: any, slot: name, extension: ext.save
+    state_any --> state_0: bye
+    classDef initialState fill:#ddd,font-weight:bold
+    class state_0 initialState
+    classDef anyState stroke-dasharray:5 5
+    class state_any anyState
+`,
+		},
+		{
+			name:    "unknown format",
+			format:  "svg",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := graph.WriteGraph(&buf, tt.format); (err != nil) != tt.wantErr {
+				t.Fatalf("Graph.WriteGraph() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Graph.WriteGraph() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraph_WriteGraphFlows(t *testing.T) {
+	fsmConfig := &fsmint.Config{
+		Transitions: []fsm.Transition{
+			{From: []string{"initial"}, Into: "confirm", Command: "order", Call: "address"},
+		},
+		Flows: []fsm.Flow{{
+			Name: "address",
+			Transitions: []fsm.Transition{
+				{From: []string{"initial"}, Into: "return", Command: "any"},
+			},
+		}},
+	}
+
+	fsmDomain, err := fsmint.NewDomainFromConfig(fsmConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := fsmint.NewGraph(fsmDomain, fsmConfig.AllTransitions()).WriteGraph(&buf, fsmint.GraphMermaid); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `stateDiagram-v2
+    state "return" as state_return
+    state "initial" as state_0
+    state "confirm" as state_1
+    state "address.initial" as state_2
+    [*] --> state_0
+    state_0 --> state_2: order, call: address, return: confirm
+    state_2 --> state_return: any
+    classDef initialState fill:#ddd,font-weight:bold
+    class state_0 initialState
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Graph.WriteGraph() = %v, want %v", got, want)
+	}
+}
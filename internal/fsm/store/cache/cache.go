@@ -1,8 +1,13 @@
 package cache
 
 import (
+	"context"
+	"sort"
+	"sync"
+
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/fsm/store/config"
+	"github.com/jaimeteb/chatto/internal/history"
 	"github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
 )
@@ -10,6 +15,17 @@ import (
 // Store struct models an FSM sotred in Cache
 type Store struct {
 	C *cache.Cache
+	H *cache.Cache
+
+	historySize int
+	historyMu   sync.Mutex
+	machinesMu  sync.Mutex
+}
+
+// entry is an FSM saved in the cache with its version
+type entry struct {
+	machine *fsm.FSM
+	version int64
 }
 
 func NewStore(cfg *config.StoreConfig) *Store {
@@ -20,22 +36,124 @@ func NewStore(cfg *config.StoreConfig) *Store {
 			cfg.TTL,
 			cfg.Purge,
 		),
+		H: cache.New(
+			cfg.TTL,
+			cfg.Purge,
+		),
+		historySize: cfg.History,
 	}
 }
 
 // Exists for Store
-func (s *Store) Exists(user string) (e bool) {
+func (s *Store) Exists(_ context.Context, user string) (bool, error) {
 	_, ok := s.C.Get(user)
-	return ok
+	return ok, nil
 }
 
 // Get method for Store
-func (s *Store) Get(user string) *fsm.FSM {
-	v, _ := s.C.Get(user)
-	return v.(*fsm.FSM)
+func (s *Store) Get(ctx context.Context, user string) (*fsm.FSM, error) {
+	m, _, err := s.GetVersion(ctx, user)
+	return m, err
 }
 
 // Set method for Store
-func (s *Store) Set(user string, m *fsm.FSM) {
-	s.C.Set(user, m, 0)
+func (s *Store) Set(_ context.Context, user string, m *fsm.FSM) error {
+	s.machinesMu.Lock()
+	defer s.machinesMu.Unlock()
+
+	s.C.Set(user, &entry{machine: m.Clone(), version: s.version(user) + 1}, 0)
+	return nil
+}
+
+// GetVersion returns a copy of the FSM of the user and its version
+func (s *Store) GetVersion(_ context.Context, user string) (*fsm.FSM, int64, error) {
+	v, ok := s.C.Get(user)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	e := v.(*entry)
+	return e.machine.Clone(), e.version, nil
+}
+
+// CompareAndSet saves the FSM of the user if its version is still version
+func (s *Store) CompareAndSet(_ context.Context, user string, m *fsm.FSM, version int64) (bool, error) {
+	s.machinesMu.Lock()
+	defer s.machinesMu.Unlock()
+
+	if s.version(user) != version {
+		return false, nil
+	}
+
+	s.C.Set(user, &entry{machine: m.Clone(), version: version + 1}, 0)
+	return true, nil
+}
+
+// version returns the version of the FSM of the user, 0 if it has none
+func (s *Store) version(user string) int64 {
+	if v, ok := s.C.Get(user); ok {
+		return v.(*entry).version
+	}
+	return 0
+}
+
+// Delete deletes the FSM and the history of the user
+func (s *Store) Delete(_ context.Context, user string) error {
+	s.machinesMu.Lock()
+	s.C.Delete(user)
+	s.machinesMu.Unlock()
+
+	s.historyMu.Lock()
+	s.H.Delete(user)
+	s.historyMu.Unlock()
+
+	return nil
+}
+
+// List returns the users with an FSM sorted by name, the
+// cursor is the last user of the previous page
+func (s *Store) List(_ context.Context, cursor string, limit int) ([]string, string, error) {
+	users := make([]string, 0)
+	for user := range s.C.Items() {
+		if user > cursor {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+
+	if limit <= 0 || len(users) <= limit {
+		return users, "", nil
+	}
+	return users[:limit], users[limit-1], nil
+}
+
+// Ping for Store, the FSMs are in memory
+func (s *Store) Ping(_ context.Context) error {
+	return nil
+}
+
+// Close for Store, the expired FSMs are purged until the Store is garbage collected
+func (s *Store) Close() error {
+	return nil
+}
+
+// AppendTurn adds a conversation turn to the user's history
+func (s *Store) AppendTurn(ctx context.Context, user string, turn *history.Turn) error {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	turns, _ := s.GetHistory(ctx, user)
+	s.H.Set(user, history.Trim(append(turns, *turn), s.historySize), 0)
+	return nil
+}
+
+// GetHistory returns the conversation turns of the user, oldest first
+func (s *Store) GetHistory(_ context.Context, user string) ([]history.Turn, error) {
+	v, ok := s.H.Get(user)
+	if !ok {
+		return []history.Turn{}, nil
+	}
+
+	turns := v.([]history.Turn)
+	return turns[:len(turns):len(turns)], nil
 }
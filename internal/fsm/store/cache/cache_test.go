@@ -1,29 +1,45 @@
 package cache_test
 
 import (
+	"context"
+	"encoding/json"
+	"reflect"
 	"testing"
 
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/fsm/store"
 	"github.com/jaimeteb/chatto/internal/fsm/store/config"
+	"github.com/jaimeteb/chatto/internal/history"
 )
 
+var ctx = context.Background()
+
 func TestCacheStore(t *testing.T) {
-	machines := store.New(&config.StoreConfig{Type: "CACHE"})
+	machines, err := store.New(&config.StoreConfig{Type: "CACHE"})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	if resp1 := machines.Exists("foo"); resp1 != false {
-		t.Errorf("incorrect, got: %v, want: %v.", resp1, "false")
+	if resp1, err := machines.Exists(ctx, "foo"); err != nil || resp1 != false {
+		t.Errorf("incorrect, got: %v %v, want: %v.", resp1, err, "false")
+	}
+	if resp1, err := machines.Get(ctx, "foo"); err != nil || resp1 != nil {
+		t.Errorf("incorrect, got: %v %v, want: %v.", resp1, err, nil)
 	}
 
-	machines.Set(
+	err = machines.Set(
+		ctx,
 		"foo",
 		&fsm.FSM{
 			State: fsm.StateInitial,
 			Slots: make(map[string]string),
 		},
 	)
-	if resp2 := machines.Get("foo"); resp2.State != fsm.StateInitial {
-		t.Errorf("incorrect, got: %v, want: %v.", resp2, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp2, err := machines.Get(ctx, "foo"); err != nil || resp2.State != fsm.StateInitial {
+		t.Errorf("incorrect, got: %v %v, want: %v.", resp2, err, "0")
 	}
 
 	newFsm := &fsm.FSM{
@@ -32,8 +48,154 @@ func TestCacheStore(t *testing.T) {
 			"abc": "xyz",
 		},
 	}
-	machines.Set("foo", newFsm)
-	if resp3 := machines.Get("foo"); resp3.State != 1 {
-		t.Errorf("incorrect, got: %v, want: %v.", resp3, "1")
+	if err := machines.Set(ctx, "foo", newFsm); err != nil {
+		t.Fatal(err)
+	}
+	if resp3, err := machines.Get(ctx, "foo"); err != nil || resp3.State != 1 {
+		t.Errorf("incorrect, got: %v %v, want: %v.", resp3, err, "1")
+	}
+}
+
+func TestCacheStoreRoundTrip(t *testing.T) {
+	machines, err := store.New(&config.StoreConfig{Type: "CACHE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &fsm.FSM{
+		State:       2,
+		Slots:       map[string]string{"guests": "3", "name": "Ann"},
+		Rotations:   map[string]int{"greet": 1},
+		Pending:     &fsm.Disambiguation{Text: "hi", Commands: []string{"a", "b"}, Labels: []string{"A", "B"}},
+		Form:        "signup",
+		Values:      map[string]json.RawMessage{"guests": json.RawMessage(`3`)},
+		SlotHistory: map[string][]json.RawMessage{"name": {json.RawMessage(`"Bob"`)}},
+		Calls:       []int{1, 3},
+	}
+	if err := machines.Set(ctx, "foo", want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := machines.Get(ctx, "foo")
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("incorrect, got: %v %v, want: %v.", got, err, want)
+	}
+
+	// The saved FSM does not change with the FSM it was saved from
+	want.Values["guests"] = json.RawMessage(`4`)
+	want.SlotHistory["name"][0] = json.RawMessage(`"Carl"`)
+	want.Calls[0] = 2
+	if saved, err := machines.Get(ctx, "foo"); err != nil || !reflect.DeepEqual(saved, got) {
+		t.Errorf("incorrect, got: %v %v, want: %v.", saved, err, got)
+	}
+}
+
+func TestCacheStoreHistory(t *testing.T) {
+	machines, err := store.New(&config.StoreConfig{Type: "CACHE", History: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp1, err := machines.GetHistory(ctx, "foo"); err != nil || len(resp1) != 0 {
+		t.Errorf("incorrect, got: %v %v, want: %v.", len(resp1), err, 0)
+	}
+
+	for _, text := range []string{"one", "two", "three"} {
+		if err := machines.AppendTurn(ctx, "foo", &history.Turn{Sender: "foo", Question: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp2, err := machines.GetHistory(ctx, "foo")
+	if err != nil || len(resp2) != 2 {
+		t.Fatalf("incorrect, got: %v %v, want: %v.", len(resp2), err, 2)
+	}
+	if resp2[0].Question != "two" || resp2[1].Question != "three" {
+		t.Errorf("incorrect, got: %v, want: %v.", resp2, "[two three]")
+	}
+}
+
+func TestCacheStoreCompareAndSet(t *testing.T) {
+	machines, err := store.New(&config.StoreConfig{Type: "CACHE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m, version, err := machines.GetVersion(ctx, "foo"); err != nil || m != nil || version != 0 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, nil, 0)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 1}, 0); err != nil || !ok {
+		t.Fatalf("incorrect, want the new FSM saved: %v", err)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 2}, 0); err != nil || ok {
+		t.Fatalf("incorrect, want the second new FSM rejected: %v", err)
+	}
+
+	m, version, err := machines.GetVersion(ctx, "foo")
+	if err != nil || m.State != 1 || version != 1 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 1, 1)
+	}
+
+	m.State = 3
+	if err := machines.Set(ctx, "foo", &fsm.FSM{State: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", m, version); err != nil || ok {
+		t.Fatalf("incorrect, want a stale FSM rejected: %v", err)
+	}
+	if m, version, err = machines.GetVersion(ctx, "foo"); err != nil || m.State != 2 || version != 2 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 2, 2)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 3}, version); err != nil || !ok {
+		t.Fatalf("incorrect, want the FSM saved: %v", err)
+	}
+}
+
+func TestCacheStoreDeleteAndList(t *testing.T) {
+	machines, err := store.New(&config.StoreConfig{Type: "CACHE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []string{"carl", "alice", "dave", "bob"} {
+		if err := machines.Set(ctx, user, fsm.NewFSM()); err != nil {
+			t.Fatal(err)
+		}
+		if err := machines.AppendTurn(ctx, user, &history.Turn{Sender: user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := machines.Delete(ctx, "carl"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := machines.Exists(ctx, "carl"); err != nil || exists {
+		t.Errorf("incorrect, got: %v %v, want: %v.", exists, err, false)
+	}
+	if turns, err := machines.GetHistory(ctx, "carl"); err != nil || len(turns) != 0 {
+		t.Errorf("incorrect, got: %v %v, want: %v.", len(turns), err, 0)
+	}
+
+	got := make([]string, 0)
+	cursor := ""
+	for pages := 1; ; pages++ {
+		users, next, err := machines.List(ctx, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) > 2 || pages > 2 {
+			t.Fatalf("incorrect, got page %d: %v, want at most 2 pages of 2 users", pages, users)
+		}
+		got = append(got, users...)
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+	if want := []string{"alice", "bob", "dave"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect, got: %v, want: %v.", got, want)
+	}
+
+	if all, next, err := machines.List(ctx, "", 0); err != nil || len(all) != 3 || next != "" {
+		t.Errorf("incorrect, got: %v %q %v, want: %v.", all, next, err, 3)
 	}
 }
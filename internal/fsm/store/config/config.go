@@ -14,4 +14,7 @@ type StoreConfig struct {
 	Database string        `mapstructure:"database"`
 	RDBMS    string        `mapstructure:"rdbms"`
 	TLS      bool          `mapstructure:"tls"`
+	// History is the maximum number of conversation turns kept
+	// per sender, a value less than or equal to zero keeps all
+	History int `mapstructure:"history"`
 }
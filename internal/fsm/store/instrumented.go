@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/history"
+	"github.com/jaimeteb/chatto/internal/metrics"
+	"github.com/jaimeteb/chatto/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instrumented is a Store that traces every operation of the Store it
+// wraps, and records its duration in metrics.StoreDuration
+type Instrumented struct {
+	Store
+}
+
+// Instrument wraps a Store to trace its operations and record their duration
+func Instrument(s Store) *Instrumented {
+	return &Instrumented{Store: s}
+}
+
+// start starts the span of a store operation, the returned
+// function ends it and records the duration of the operation
+func start(ctx context.Context, method, operation string) (context.Context, func(error)) {
+	begin := time.Now()
+	ctx, span := tracing.Start(ctx, "Store."+method, trace.WithAttributes(attribute.String("chatto.store.operation", operation)))
+
+	return ctx, func(err error) {
+		metrics.ObserveStore(operation, begin)
+		tracing.End(span, err)
+	}
+}
+
+// Exists for Instrumented
+func (s *Instrumented) Exists(ctx context.Context, user string) (exists bool, err error) {
+	ctx, end := start(ctx, "Exists", "exists")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.Exists(ctx, user)
+}
+
+// Get for Instrumented
+func (s *Instrumented) Get(ctx context.Context, user string) (machine *fsm.FSM, err error) {
+	ctx, end := start(ctx, "Get", "get")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.Get(ctx, user)
+}
+
+// Set for Instrumented
+func (s *Instrumented) Set(ctx context.Context, user string, m *fsm.FSM) (err error) {
+	ctx, end := start(ctx, "Set", "set")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.Set(ctx, user, m)
+}
+
+// AppendTurn for Instrumented
+func (s *Instrumented) AppendTurn(ctx context.Context, user string, turn *history.Turn) (err error) {
+	ctx, end := start(ctx, "AppendTurn", "append_turn")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.AppendTurn(ctx, user, turn)
+}
+
+// GetHistory for Instrumented
+func (s *Instrumented) GetHistory(ctx context.Context, user string) (turns []history.Turn, err error) {
+	ctx, end := start(ctx, "GetHistory", "get_history")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.GetHistory(ctx, user)
+}
+
+// Delete for Instrumented
+func (s *Instrumented) Delete(ctx context.Context, user string) (err error) {
+	ctx, end := start(ctx, "Delete", "delete")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.Delete(ctx, user)
+}
+
+// List for Instrumented
+func (s *Instrumented) List(ctx context.Context, cursor string, limit int) (users []string, next string, err error) {
+	ctx, end := start(ctx, "List", "list")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.List(ctx, cursor, limit)
+}
+
+// GetVersion for Instrumented
+func (s *Instrumented) GetVersion(ctx context.Context, user string) (machine *fsm.FSM, version int64, err error) {
+	ctx, end := start(ctx, "GetVersion", "get_version")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.GetVersion(ctx, user)
+}
+
+// CompareAndSet for Instrumented
+func (s *Instrumented) CompareAndSet(ctx context.Context, user string, m *fsm.FSM, version int64) (saved bool, err error) {
+	ctx, end := start(ctx, "CompareAndSet", "compare_and_set")
+	defer func() {
+		end(err)
+	}()
+	return s.Store.CompareAndSet(ctx, user, m, version)
+}
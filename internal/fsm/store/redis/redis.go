@@ -3,22 +3,26 @@ package redis
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/fsm/store/config"
+	"github.com/jaimeteb/chatto/internal/history"
+	"github.com/jaimeteb/chatto/internal/logger"
 	log "github.com/sirupsen/logrus"
 )
 
-var ctx = context.Background()
-
 // Store struct models an FSM sotred on Redis
 type Store struct {
-	R   Client
-	TTL time.Duration
+	R       Client
+	TTL     time.Duration
+	History int
 }
 
 type Client interface {
@@ -27,6 +31,16 @@ type Client interface {
 	Set(context.Context, string, interface{}, time.Duration) *redis.StatusCmd
 	HSet(context.Context, string, ...interface{}) *redis.IntCmd
 	Expire(context.Context, string, time.Duration) *redis.BoolCmd
+	RPush(context.Context, string, ...interface{}) *redis.IntCmd
+	LTrim(context.Context, string, int64, int64) *redis.StatusCmd
+	LRange(context.Context, string, int64, int64) *redis.StringSliceCmd
+	Del(context.Context, ...string) *redis.IntCmd
+	Exists(context.Context, ...string) *redis.IntCmd
+	Scan(context.Context, uint64, string, int64) *redis.ScanCmd
+	TxPipelined(context.Context, func(redis.Pipeliner) error) ([]redis.Cmder, error)
+	Watch(context.Context, func(*redis.Tx) error, ...string) error
+	Ping(context.Context) *redis.StatusCmd
+	Close() error
 }
 
 func NewStore(cfg *config.StoreConfig) (*Store, error) {
@@ -50,57 +64,338 @@ func NewStore(cfg *config.StoreConfig) (*Store, error) {
 		return nil, err
 	}
 	log.Infof("* TTL:    %v", cfg.TTL)
-	return &Store{R: RDB, TTL: cfg.TTL}, nil
+	return &Store{R: RDB, TTL: cfg.TTL, History: cfg.History}, nil
 }
 
 // Exists for Store
-func (s *Store) Exists(user string) (e bool) {
-	_, err := s.R.Get(ctx, user+":state").Result()
-	if err == redis.Nil || err != nil {
-		return false
+func (s *Store) Exists(ctx context.Context, user string) (bool, error) {
+	n, err := s.R.Exists(ctx, user+":state").Result()
+	if err != nil {
+		return false, err
 	}
-	return true
+	return n > 0, nil
 }
 
 // Get method for Store
-func (s *Store) Get(user string) *fsm.FSM {
+func (s *Store) Get(ctx context.Context, user string) (*fsm.FSM, error) {
 	m := &fsm.FSM{}
 
 	state, err := s.R.Get(ctx, user+":state").Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if m.State, err = strconv.Atoi(state); err != nil {
+		return nil, fmt.Errorf("invalid state of %s: %w", user, err)
+	}
+
+	if m.Slots, err = s.R.HGetAll(ctx, user+":slots").Result(); err != nil {
+		return nil, err
+	}
+
+	values, err := s.R.HGetAll(ctx, user+":values").Result()
 	if err != nil {
-		log.Error(err)
+		return nil, err
 	}
-	i, err := strconv.Atoi(state)
+	for k, v := range values {
+		if m.Values == nil {
+			m.Values = make(map[string]json.RawMessage, len(values))
+		}
+		m.Values[k] = json.RawMessage(v)
+	}
+
+	slotHistory, err := s.R.HGetAll(ctx, user+":slot_history").Result()
 	if err != nil {
-		log.Error(err)
+		return nil, err
+	}
+	for k, v := range slotHistory {
+		var previous []json.RawMessage
+		if err := json.Unmarshal([]byte(v), &previous); err != nil {
+			logger.Entry(ctx).Error(err)
+			continue
+		}
+		if m.SlotHistory == nil {
+			m.SlotHistory = make(map[string][]json.RawMessage, len(slotHistory))
+		}
+		m.SlotHistory[k] = previous
 	}
-	m.State = i
 
-	slots, err := s.R.HGetAll(ctx, user+":slots").Result()
+	rotations, err := s.R.HGetAll(ctx, user+":rotations").Result()
 	if err != nil {
-		log.Error(err)
+		return nil, err
+	}
+	for k, v := range rotations {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			logger.Entry(ctx).Error(err)
+			continue
+		}
+		if m.Rotations == nil {
+			m.Rotations = make(map[string]int, len(rotations))
+		}
+		m.Rotations[k] = n
+	}
+
+	calls, err := s.R.Get(ctx, user+":calls").Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if calls != "" {
+		if err := json.Unmarshal([]byte(calls), &m.Calls); err != nil {
+			logger.Entry(ctx).Error(err)
+			m.Calls = nil
+		}
 	}
-	m.Slots = slots
 
-	return m
+	if m.Form, err = s.R.Get(ctx, user+":form").Result(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	pending, err := s.R.Get(ctx, user+":pending").Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if pending != "" {
+		m.Pending = &fsm.Disambiguation{}
+		if err := json.Unmarshal([]byte(pending), m.Pending); err != nil {
+			logger.Entry(ctx).Error(err)
+			m.Pending = nil
+		}
+	}
+
+	return m, nil
 }
 
 // Set method for Store
-func (s *Store) Set(user string, m *fsm.FSM) {
-	if err := s.R.Set(ctx, user+":state", m.State, s.TTL).Err(); err != nil {
-		log.Error("Error setting state:", err)
+func (s *Store) Set(ctx context.Context, user string, m *fsm.FSM) error {
+	_, err := s.R.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := s.write(ctx, pipe, user, m); err != nil {
+			return err
+		}
+		pipe.Incr(ctx, user+":version")
+		s.expire(ctx, pipe, user+":version")
+		return nil
+	})
+	return err
+}
+
+// GetVersion returns the FSM of the user and its version
+func (s *Store) GetVersion(ctx context.Context, user string) (*fsm.FSM, int64, error) {
+	version, err := s.R.Get(ctx, user+":version").Int64()
+	if err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+
+	m, err := s.Get(ctx, user)
+	if err != nil {
+		return nil, 0, err
 	}
-	if len(m.Slots) > 0 {
-		kvs := make([]string, 0)
-		for k, v := range m.Slots {
-			kvs = append(kvs, k, v)
+
+	return m, version, nil
+}
+
+// errVersionChanged is returned in CompareAndSet if the FSM was saved since it was read
+var errVersionChanged = errors.New("the FSM version changed")
+
+// CompareAndSet saves the FSM of the user if its version is still version.
+// The version is watched, so the FSM is not saved if another client saves
+// it at the same time
+func (s *Store) CompareAndSet(ctx context.Context, user string, m *fsm.FSM, version int64) (bool, error) {
+	key := user + ":version"
+	err := s.R.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if current != version {
+			return errVersionChanged
 		}
 
-		if err := s.R.HSet(ctx, user+":slots", kvs).Err(); err != nil {
-			log.Error("Error setting slots:", err)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := s.write(ctx, pipe, user, m); err != nil {
+				return err
+			}
+			pipe.Set(ctx, key, version+1, s.TTL)
+			return nil
+		})
+		return err
+	}, key)
+
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, errVersionChanged), errors.Is(err, redis.TxFailedErr):
+		logger.Entry(ctx).Debugf("FSM of %s changed since version %d", user, version)
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// write queues the commands that save the FSM in the pipeline
+func (s *Store) write(ctx context.Context, pipe redis.Pipeliner, user string, m *fsm.FSM) error {
+	pipe.Set(ctx, user+":state", m.State, s.TTL)
+
+	kvs := make([]string, 0, len(m.Slots)*2)
+	for k, v := range m.Slots {
+		kvs = append(kvs, k, v)
+	}
+	s.replaceHash(ctx, pipe, user+":slots", kvs)
+
+	kvs = make([]string, 0, len(m.Values)*2)
+	for k, v := range m.Values {
+		kvs = append(kvs, k, string(v))
+	}
+	s.replaceHash(ctx, pipe, user+":values", kvs)
+
+	kvs = make([]string, 0, len(m.SlotHistory)*2)
+	for k, v := range m.SlotHistory {
+		js, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("cannot encode the slot history: %w", err)
 		}
-		if err := s.R.Expire(ctx, user+":slots", s.TTL).Err(); err != nil {
-			log.Error("Error expiring slots:", err)
+		kvs = append(kvs, k, string(js))
+	}
+	s.replaceHash(ctx, pipe, user+":slot_history", kvs)
+
+	kvs = make([]string, 0, len(m.Rotations)*2)
+	for k, v := range m.Rotations {
+		kvs = append(kvs, k, strconv.Itoa(v))
+	}
+	s.replaceHash(ctx, pipe, user+":rotations", kvs)
+
+	if len(m.Calls) == 0 {
+		pipe.Del(ctx, user+":calls")
+	} else if js, err := json.Marshal(m.Calls); err != nil {
+		return fmt.Errorf("cannot encode the calls: %w", err)
+	} else {
+		pipe.Set(ctx, user+":calls", js, s.TTL)
+	}
+	if m.Form == "" {
+		pipe.Del(ctx, user+":form")
+	} else {
+		pipe.Set(ctx, user+":form", m.Form, s.TTL)
+	}
+	if m.Pending == nil {
+		pipe.Del(ctx, user+":pending")
+	} else if js, err := json.Marshal(m.Pending); err != nil {
+		return fmt.Errorf("cannot encode the pending disambiguation: %w", err)
+	} else {
+		pipe.Set(ctx, user+":pending", js, s.TTL)
+	}
+
+	return nil
+}
+
+// replaceHash replaces the fields of a hash, the hash is deleted if there are none
+func (s *Store) replaceHash(ctx context.Context, pipe redis.Pipeliner, key string, kvs []string) {
+	pipe.Del(ctx, key)
+	if len(kvs) == 0 {
+		return
+	}
+	pipe.HSet(ctx, key, kvs)
+	s.expire(ctx, pipe, key)
+}
+
+// expire sets the TTL of the key, if the store has one
+func (s *Store) expire(ctx context.Context, pipe redis.Pipeliner, key string) {
+	if s.TTL > 0 {
+		pipe.Expire(ctx, key, s.TTL)
+	}
+}
+
+// keys are the suffixes of the keys of a user
+var keys = []string{
+	":state", ":slots", ":values", ":slot_history", ":rotations",
+	":calls", ":form", ":pending", ":version", ":history",
+}
+
+// Delete deletes the FSM and the history of the user
+func (s *Store) Delete(ctx context.Context, user string) error {
+	userKeys := make([]string, len(keys))
+	for i, key := range keys {
+		userKeys[i] = user + key
+	}
+	return s.R.Del(ctx, userKeys...).Err()
+}
+
+// List returns the users with an FSM, the cursor is the one of a
+// SCAN, so a page may have more or less than limit users
+func (s *Store) List(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	var start uint64
+	if cursor != "" {
+		var err error
+		if start, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", fmt.Errorf("invalid cursor '%s'", cursor)
 		}
 	}
+
+	users := make([]string, 0)
+	for {
+		found, next, err := s.R.Scan(ctx, start, "*:state", int64(limit)).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, key := range found {
+			users = append(users, strings.TrimSuffix(key, ":state"))
+		}
+
+		start = next
+		if start == 0 {
+			return users, "", nil
+		}
+		// Without a limit, scan until the end
+		if limit > 0 && len(users) >= limit {
+			return users, strconv.FormatUint(start, 10), nil
+		}
+	}
+}
+
+// Ping checks the connection to Redis
+func (s *Store) Ping(ctx context.Context) error {
+	return s.R.Ping(ctx).Err()
+}
+
+// Close closes the connections to Redis
+func (s *Store) Close() error {
+	return s.R.Close()
+}
+
+// AppendTurn adds a conversation turn to the user's history
+func (s *Store) AppendTurn(ctx context.Context, user string, turn *history.Turn) error {
+	js, err := json.Marshal(turn)
+	if err != nil {
+		return fmt.Errorf("cannot encode the turn: %w", err)
+	}
+
+	_, err = s.R.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, user+":history", js)
+		if s.History > 0 {
+			pipe.LTrim(ctx, user+":history", int64(-s.History), -1)
+		}
+		s.expire(ctx, pipe, user+":history")
+		return nil
+	})
+	return err
+}
+
+// GetHistory returns the conversation turns of the user, oldest first
+func (s *Store) GetHistory(ctx context.Context, user string) ([]history.Turn, error) {
+	values, err := s.R.LRange(ctx, user+":history", 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	turns := make([]history.Turn, 0, len(values))
+	for _, v := range values {
+		var turn history.Turn
+		if err := json.Unmarshal([]byte(v), &turn); err != nil {
+			logger.Entry(ctx).Error(err)
+			continue
+		}
+		turns = append(turns, turn)
+	}
+
+	return turns, nil
 }
@@ -1,17 +1,24 @@
 package redis_test
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/alicebob/miniredis"
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/fsm/store"
-	"github.com/jaimeteb/chatto/internal/fsm/store/cache"
 	"github.com/jaimeteb/chatto/internal/fsm/store/config"
 	"github.com/jaimeteb/chatto/internal/fsm/store/redis"
+	"github.com/jaimeteb/chatto/internal/history"
+	"github.com/jaimeteb/chatto/query"
 )
 
+var ctx = context.Background()
+
 var redisServer *miniredis.Miniredis = miniredis.NewMiniRedis()
 
 func startRedisServer(pw string) (host, port string) {
@@ -32,12 +39,15 @@ func TestRedisStore(t *testing.T) {
 
 	fmt.Println(redisServer.Addr())
 
-	machines := store.New(&config.StoreConfig{
+	machines, err := store.New(&config.StoreConfig{
 		Type:     "REDIS",
 		Host:     redisHost,
 		Port:     redisPort,
 		Password: "pass",
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	switch machines.(type) {
 	case *redis.Store:
@@ -46,18 +56,25 @@ func TestRedisStore(t *testing.T) {
 		t.Error("incorrect, want: *redis.Store")
 	}
 
-	if resp1 := machines.Exists("foo"); resp1 != false {
-		t.Errorf("incorrect, got: %v, want: %v.", resp1, "false")
+	if resp1, err := machines.Exists(ctx, "foo"); err != nil || resp1 != false {
+		t.Errorf("incorrect, got: %v %v, want: %v.", resp1, err, "false")
+	}
+	if resp1, err := machines.Get(ctx, "foo"); err != nil || resp1 != nil {
+		t.Errorf("incorrect, got: %v %v, want: %v.", resp1, err, nil)
 	}
 
-	machines.Set(
+	err = machines.Set(
+		ctx,
 		"foo",
 		&fsm.FSM{
 			State: fsm.StateInitial,
 			Slots: make(map[string]string),
 		},
 	)
-	if resp2 := machines.Get("foo"); resp2.State != fsm.StateInitial {
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp2 := get(t, machines, "foo"); resp2.State != fsm.StateInitial {
 		t.Errorf("incorrect, got: %v, want: %v.", resp2, "0")
 	}
 
@@ -66,23 +83,260 @@ func TestRedisStore(t *testing.T) {
 		Slots: map[string]string{
 			"abc": "xyz",
 		},
+		Rotations: map[string]int{
+			"0:greet": 2,
+		},
 	}
-	machines.Set("foo", newFsm)
-	if resp3 := machines.Get("foo"); resp3.State != 1 {
+	set(t, machines, "foo", newFsm)
+	if resp3 := get(t, machines, "foo"); resp3.State != 1 {
 		t.Errorf("incorrect, got: %v, want: %v.", resp3, "1")
 	}
+	if resp4 := get(t, machines, "foo"); resp4.Rotations["0:greet"] != 2 {
+		t.Errorf("incorrect, got: %v, want: %v.", resp4.Rotations, "map[0:greet:2]")
+	}
+
+	newFsm.Pending = &fsm.Disambiguation{Text: "hi", Commands: []string{"greet", "bye"}, Labels: []string{"Greet", "Bye"}}
+	set(t, machines, "foo", newFsm)
+	if resp5 := get(t, machines, "foo"); !reflect.DeepEqual(resp5.Pending, newFsm.Pending) {
+		t.Errorf("incorrect, got: %v, want: %v.", resp5.Pending, newFsm.Pending)
+	}
+
+	newFsm.Pending = nil
+	newFsm.Form = "signup"
+	set(t, machines, "foo", newFsm)
+	if resp6 := get(t, machines, "foo"); resp6.Pending != nil || resp6.Form != "signup" {
+		t.Errorf("incorrect, got: %v %v, want: %v %v.", resp6.Pending, resp6.Form, nil, "signup")
+	}
+
+	newFsm.Form = ""
+	newFsm.Calls = []int{3, 1}
+	set(t, machines, "foo", newFsm)
+	if resp7 := get(t, machines, "foo"); resp7.Form != "" || !reflect.DeepEqual(resp7.Calls, newFsm.Calls) {
+		t.Errorf("incorrect, got: %v %v, want: %v %v.", resp7.Form, resp7.Calls, "", newFsm.Calls)
+	}
+
+	if err := newFsm.SetSlot("toppings", []string{"ham", "pineapple"}); err != nil {
+		t.Fatal(err)
+	}
+	newFsm.ClearSlot("abc")
+	set(t, machines, "foo", newFsm)
+	resp8 := get(t, machines, "foo")
+	if !reflect.DeepEqual(resp8.Slots, newFsm.Slots) {
+		t.Errorf("incorrect, got: %v, want: %v.", resp8.Slots, newFsm.Slots)
+	}
+	if value, _ := resp8.SlotValue("toppings"); !reflect.DeepEqual(value, []interface{}{"ham", "pineapple"}) {
+		t.Errorf("incorrect, got: %v, want: %v.", value, "[ham pineapple]")
+	}
+	if previous := resp8.PreviousSlotValues("abc"); !reflect.DeepEqual(previous, []interface{}{"xyz"}) {
+		t.Errorf("incorrect, got: %v, want: %v.", previous, "[xyz]")
+	}
 }
 
 func TestRedisStoreFail(t *testing.T) {
-	machines := store.New(&config.StoreConfig{
+	machines, err := store.New(&config.StoreConfig{
 		Type:     "REDIS",
 		Host:     "localhost",
 		Password: "foo",
 	})
-	switch machines.(type) {
-	case *cache.Store:
-		break
-	default:
-		t.Error("incorrect, want: *cache.Store")
+	if err == nil || machines != nil {
+		t.Errorf("incorrect, got: %v %v, want an error.", machines, err)
+	}
+}
+
+func TestRedisStoreHistory(t *testing.T) {
+	redisHost, redisPort := startRedisServer("pass")
+	defer closeRedisServer()
+
+	machines, err := store.New(&config.StoreConfig{
+		Type:     "REDIS",
+		Host:     redisHost,
+		Port:     redisPort,
+		Password: "pass",
+		History:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp1, err := machines.GetHistory(ctx, "foo"); err != nil || len(resp1) != 0 {
+		t.Errorf("incorrect, got: %v %v, want: %v.", len(resp1), err, 0)
+	}
+
+	for _, text := range []string{"one", "two", "three"} {
+		err := machines.AppendTurn(ctx, "foo", &history.Turn{
+			Sender:    "foo",
+			Question:  text,
+			Answers:   []query.Answer{{Text: "ok"}},
+			Extension: &fsm.Extension{Server: "ext", Name: "name"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp2, err := machines.GetHistory(ctx, "foo")
+	if err != nil || len(resp2) != 2 {
+		t.Fatalf("incorrect, got: %v %v, want: %v.", len(resp2), err, 2)
+	}
+	if resp2[0].Question != "two" || resp2[1].Question != "three" {
+		t.Errorf("incorrect, got: %v, want: %v.", resp2, "[two three]")
+	}
+	if resp2[1].Extension == nil || resp2[1].Extension.Name != "name" {
+		t.Errorf("incorrect, got: %v, want: %v.", resp2[1].Extension, "name")
+	}
+}
+
+func TestRedisStoreCompareAndSet(t *testing.T) {
+	machines, _ := newRedisStore(t)
+
+	if m, version, err := machines.GetVersion(ctx, "foo"); err != nil || m != nil || version != 0 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, nil, 0)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 1, Slots: map[string]string{"abc": "xyz"}}, 0); err != nil || !ok {
+		t.Fatalf("incorrect, want the new FSM saved: %v", err)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 2}, 0); err != nil || ok {
+		t.Fatalf("incorrect, want the second new FSM rejected: %v", err)
+	}
+
+	m, version, err := machines.GetVersion(ctx, "foo")
+	if err != nil || m.State != 1 || m.Slots["abc"] != "xyz" || version != 1 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 1, 1)
+	}
+
+	m.State = 3
+	set(t, machines, "foo", &fsm.FSM{State: 2})
+	if ok, err := machines.CompareAndSet(ctx, "foo", m, version); err != nil || ok {
+		t.Fatalf("incorrect, want a stale FSM rejected: %v", err)
+	}
+	if m, version, err = machines.GetVersion(ctx, "foo"); err != nil || m.State != 2 || len(m.Slots) != 0 || version != 2 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 2, 2)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 3, Rotations: map[string]int{"0:greet": 1}}, version); err != nil || !ok {
+		t.Fatalf("incorrect, want the FSM saved: %v", err)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 3, Rotations: map[string]int{"0:bye": 2}}, version+1); err != nil || !ok {
+		t.Fatalf("incorrect, want the FSM saved: %v", err)
+	}
+	if m = get(t, machines, "foo"); !reflect.DeepEqual(m.Rotations, map[string]int{"0:bye": 2}) {
+		t.Errorf("incorrect, got: %v, want: %v.", m.Rotations, "map[0:bye:2]")
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 3}, version+2); err != nil || !ok {
+		t.Fatalf("incorrect, want the FSM saved: %v", err)
+	}
+	if m = get(t, machines, "foo"); len(m.Rotations) != 0 {
+		t.Errorf("incorrect, got: %v, want: %v.", m.Rotations, "map[]")
+	}
+}
+
+func TestRedisStoreDeleteAndList(t *testing.T) {
+	machines, server := newRedisStore(t)
+
+	for _, user := range []string{"carl", "alice", "dave", "bob"} {
+		set(t, machines, user, &fsm.FSM{State: 1, Rotations: map[string]int{"1:greet": 1}})
+		if err := machines.AppendTurn(ctx, user, &history.Turn{Sender: user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := machines.Delete(ctx, "carl"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := machines.Exists(ctx, "carl"); err != nil || exists {
+		t.Errorf("incorrect, got: %v %v, want: %v.", exists, err, false)
+	}
+	if turns, err := machines.GetHistory(ctx, "carl"); err != nil || len(turns) != 0 {
+		t.Errorf("incorrect, got: %v %v, want: %v.", len(turns), err, 0)
+	}
+	for _, key := range server.Keys() {
+		if strings.HasPrefix(key, "carl:") {
+			t.Errorf("incorrect, got the key %s, want all the keys of carl deleted.", key)
+		}
+	}
+
+	got := make([]string, 0)
+	cursor := ""
+	for pages := 1; pages <= 10; pages++ {
+		users, next, err := machines.List(ctx, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, users...)
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+	sort.Strings(got)
+	if want := []string{"alice", "bob", "dave"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect, got: %v, want: %v.", got, want)
+	}
+
+	if _, _, err := machines.List(ctx, "not-a-cursor", 2); err == nil {
+		t.Error("incorrect, want an invalid cursor error")
+	}
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machines, err := store.New(&config.StoreConfig{Type: "REDIS", Host: server.Host(), Port: server.Port()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := machines.Ping(ctx); err != nil {
+		t.Errorf("incorrect, want no error pinging Redis: %v", err)
+	}
+	server.Close()
+
+	if err := machines.Ping(ctx); err == nil {
+		t.Error("incorrect, want an error pinging Redis")
+	}
+
+	if _, err := machines.Get(ctx, "foo"); err == nil {
+		t.Error("incorrect, want an error getting the FSM")
+	}
+	if err := machines.Set(ctx, "foo", fsm.NewFSM()); err == nil {
+		t.Error("incorrect, want an error setting the FSM")
+	}
+	if _, _, err := machines.GetVersion(ctx, "foo"); err == nil {
+		t.Error("incorrect, want an error getting the FSM version")
+	}
+}
+
+// newRedisStore returns a Store on a new Redis server
+func newRedisStore(t *testing.T) (store.Store, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	machines, err := store.New(&config.StoreConfig{
+		Type: "REDIS",
+		Host: server.Host(),
+		Port: server.Port(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return machines, server
+}
+
+func get(t *testing.T, machines store.Store, user string) *fsm.FSM {
+	t.Helper()
+	m, err := machines.Get(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func set(t *testing.T, machines store.Store, user string, m *fsm.FSM) {
+	t.Helper()
+	if err := machines.Set(ctx, user, m); err != nil {
+		t.Fatal(err)
 	}
 }
@@ -34,6 +34,39 @@ func (m *MockDBClient) EXPECT() *MockDBClientMockRecorder {
 	return m.recorder
 }
 
+// Create mocks base method.
+func (m *MockDBClient) Create(arg0 interface{}) *gorm.DB {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", arg0)
+	ret0, _ := ret[0].(*gorm.DB)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockDBClientMockRecorder) Create(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockDBClient)(nil).Create), arg0)
+}
+
+// Find mocks base method.
+func (m *MockDBClient) Find(arg0 interface{}, arg1 ...interface{}) *gorm.DB {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Find", varargs...)
+	ret0, _ := ret[0].(*gorm.DB)
+	return ret0
+}
+
+// Find indicates an expected call of Find.
+func (mr *MockDBClientMockRecorder) Find(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockDBClient)(nil).Find), varargs...)
+}
+
 // First mocks base method.
 func (m *MockDBClient) First(arg0 interface{}, arg1 ...interface{}) *gorm.DB {
 	m.ctrl.T.Helper()
@@ -3,20 +3,25 @@ package sql
 //go:generate mockgen -source=sql.go -destination=mocksql/mocksql.go -package=mocksql
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jaimeteb/chatto/fsm"
 	"github.com/jaimeteb/chatto/internal/fsm/store/config"
+	"github.com/jaimeteb/chatto/internal/history"
+	"github.com/jaimeteb/chatto/internal/logger"
+	"github.com/jaimeteb/chatto/query"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	gormlogger "gorm.io/gorm/logger"
 )
 
 var userCol = "user"
@@ -24,41 +29,130 @@ var userCol = "user"
 // FSMORM models a Finite State Machine with a gorm.Model
 type FSMORM struct {
 	gorm.Model
-	User  string
-	State int
-	Slots string
+	User        string `gorm:"size:191;uniqueIndex"`
+	Version     int64  `gorm:"not null;default:0"`
+	State       int
+	Slots       string
+	Rotations   string
+	Pending     string
+	Form        string
+	Values      string
+	SlotHistory string
+	Calls       string
 }
 
 func (*FSMORM) TableName() string {
 	return "fsms"
 }
 
-func slotsToJSONString(slots map[string]string) string {
-	bytes, err := json.Marshal(slots)
+// TurnORM models a conversation turn with a gorm.Model
+type TurnORM struct {
+	gorm.Model
+	Sender        string `gorm:"index"`
+	Channel       string
+	Question      string
+	Command       string
+	Probability   float32
+	PreviousState string
+	NextState     string
+	Answers       string
+	Extension     string
+	ReceivedAt    time.Time
+	AnsweredAt    time.Time
+}
+
+func (*TurnORM) TableName() string {
+	return "turns"
+}
+
+func newTurnORM(user string, turn *history.Turn) *TurnORM {
+	t := &TurnORM{
+		Sender:        user,
+		Channel:       turn.Channel,
+		Question:      turn.Question,
+		Command:       turn.Command,
+		Probability:   turn.Probability,
+		PreviousState: turn.PreviousState,
+		NextState:     turn.NextState,
+		Answers:       toJSONColumn(turn.Answers),
+		Extension:     toJSONColumn(turn.Extension),
+		ReceivedAt:    turn.ReceivedAt,
+		AnsweredAt:    turn.AnsweredAt,
+	}
+
+	if t.Answers == "" {
+		t.Answers = "[]"
+	}
+
+	return t
+}
+
+func (t *TurnORM) toTurn() history.Turn {
+	turn := history.Turn{
+		Sender:        t.Sender,
+		Channel:       t.Channel,
+		Question:      t.Question,
+		Command:       t.Command,
+		Probability:   t.Probability,
+		PreviousState: t.PreviousState,
+		NextState:     t.NextState,
+		Answers:       fromJSONColumn[[]query.Answer](t.Answers),
+		Extension:     fromJSONColumn[*fsm.Extension](t.Extension),
+		ReceivedAt:    t.ReceivedAt,
+		AnsweredAt:    t.AnsweredAt,
+	}
+
+	if turn.Answers == nil {
+		turn.Answers = make([]query.Answer, 0)
+	}
+
+	return turn
+}
+
+// toJSONColumn returns the JSON of the value of a column,
+// empty if the value is nil or has no elements
+func toJSONColumn[T any](value T) string {
+	bytes, err := json.Marshal(value)
 	if err != nil {
 		log.Error(err)
-		return "{}"
+		return ""
+	}
+	switch string(bytes) {
+	case "null", "{}", "[]":
+		return ""
 	}
 	return string(bytes)
 }
 
-func jsonStringToSlots(jsonStr string) map[string]string {
-	var slots map[string]string
-	if err := json.Unmarshal([]byte(jsonStr), &slots); err != nil {
+// fromJSONColumn returns the value of the JSON of a column,
+// the zero value if the column is empty or invalid
+func fromJSONColumn[T any](column string) T {
+	var value T
+	if column == "" {
+		return value
+	}
+	if err := json.Unmarshal([]byte(column), &value); err != nil {
 		log.Error(err)
-		return make(map[string]string)
+		var zero T
+		return zero
 	}
-	return slots
+	return value
 }
 
 // Store models a SQL store for FSM
 type Store struct {
-	DB DBClient
+	DB      DBClient
+	History int
+
+	stopPurge chan struct{}
+	purgeWG   sync.WaitGroup
 }
 
 type DBClient interface {
 	First(interface{}, ...interface{}) *gorm.DB
+	Find(interface{}, ...interface{}) *gorm.DB
 	Where(interface{}, ...interface{}) *gorm.DB
+	Create(interface{}) *gorm.DB
 	Save(interface{}) *gorm.DB
 }
 
@@ -85,7 +179,7 @@ func NewStore(cfg *config.StoreConfig) (*Store, error) {
 			cfg.Database,
 		)
 		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
-			Logger: logger.Default.LogMode(logger.Silent),
+			Logger: gormlogger.Default.LogMode(gormlogger.Silent),
 		})
 		if err != nil {
 			return nil, err
@@ -105,14 +199,14 @@ func NewStore(cfg *config.StoreConfig) (*Store, error) {
 			cfg.Port,
 		)
 		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
-			Logger: logger.Default.LogMode(logger.Silent),
+			Logger: gormlogger.Default.LogMode(gormlogger.Silent),
 		})
 		if err != nil {
 			return nil, err
 		}
 	case "sqlite":
 		db, err = gorm.Open(sqlite.Open(cfg.Database), &gorm.Config{
-			Logger: logger.Default.LogMode(logger.Silent),
+			Logger: gormlogger.Default.LogMode(gormlogger.Silent),
 		})
 		if err != nil {
 			return nil, err
@@ -121,61 +215,341 @@ func NewStore(cfg *config.StoreConfig) (*Store, error) {
 		return nil, errors.New("no RDBMS specified for SQL connection")
 	}
 
-	if err := db.AutoMigrate(&FSMORM{}); err != nil {
-		log.Error(err)
+	if err := migrate(db); err != nil {
+		return nil, err
 	}
 
-	sqlStore := &Store{db}
+	sqlStore := &Store{DB: db, History: cfg.History}
 	sqlStore.runPurge(cfg.TTL, cfg.Purge)
 
 	return sqlStore, nil
 }
 
+// migrate creates or updates the tables. FSMs that were soft deleted before
+// the users had a unique index are deleted for good, as they would break it,
+// and so are all but the newest FSM of the users that have several
+func migrate(db *gorm.DB) error {
+	if db.Migrator().HasTable(&FSMORM{}) {
+		if res := db.Unscoped().Where("deleted_at IS NOT NULL").Delete(&FSMORM{}); res.Error != nil {
+			return res.Error
+		}
+		if err := deleteDuplicates(db); err != nil {
+			return err
+		}
+	}
+	return db.AutoMigrate(&FSMORM{}, &TurnORM{})
+}
+
+// deleteDuplicates keeps the newest FSM of every user, by update time and
+// ID. Older versions of the store could save a new FSM on every message
+func deleteDuplicates(db *gorm.DB) error {
+	var users []string
+	res := db.Model(&FSMORM{}).
+		Group(userCol).
+		Having("COUNT(*) > 1").
+		Pluck(userCol, &users)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	for _, user := range users {
+		var ids []uint
+		res := db.Model(&FSMORM{}).
+			Where(fmt.Sprintf("%s = ?", userCol), user).
+			Order("updated_at desc").
+			Order("id desc").
+			Pluck("id", &ids)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res := db.Unscoped().Delete(&FSMORM{}, ids[1:]); res.Error != nil {
+			return res.Error
+		}
+		log.Warnf("Deleted %d older FSMs of the user %s", len(ids)-1, user)
+	}
+
+	return nil
+}
+
+// contextDB is a DBClient that can run its queries with a context
+type contextDB interface {
+	WithContext(context.Context) *gorm.DB
+}
+
+// db returns the client that runs the queries with the context
+func (s *Store) db(ctx context.Context) DBClient {
+	if db, ok := s.DB.(contextDB); ok {
+		return db.WithContext(ctx)
+	}
+	return s.DB
+}
+
 // Exists for Store
-func (s *Store) Exists(user string) (e bool) {
+func (s *Store) Exists(ctx context.Context, user string) (bool, error) {
 	machine := FSMORM{}
-	if res := s.DB.First(&machine, fmt.Sprintf("%s = ?", userCol), user); errors.Is(res.Error, gorm.ErrRecordNotFound) {
-		log.Debug(res.Error)
-		return false
+	res := s.db(ctx).First(&machine, fmt.Sprintf("%s = ?", userCol), user)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		logger.Entry(ctx).Debug(res.Error)
+		return false, nil
 	}
-	return true
+	return res.Error == nil, res.Error
 }
 
 // Get method for Store
-func (s *Store) Get(user string) *fsm.FSM {
+func (s *Store) Get(ctx context.Context, user string) (*fsm.FSM, error) {
+	m, _, err := s.GetVersion(ctx, user)
+	return m, err
+}
+
+// Set method for Store
+func (s *Store) Set(ctx context.Context, user string, m *fsm.FSM) error {
+	db := s.db(ctx)
+
 	machine := FSMORM{}
-	if res := s.DB.First(&machine, fmt.Sprintf("%s = ?", userCol), user); errors.Is(res.Error, gorm.ErrRecordNotFound) {
-		log.Debug(res.Error)
-		return nil
+	if res := db.First(&machine, fmt.Sprintf("%s = ?", userCol), user); res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return res.Error
 	}
-	return &fsm.FSM{
-		State: machine.State,
-		Slots: jsonStringToSlots(machine.Slots),
+	machine.User = user
+	machine.Version++
+	machine.setFSM(m)
+
+	return db.Save(&machine).Error
+}
+
+// GetVersion returns the FSM of the user and its version
+func (s *Store) GetVersion(ctx context.Context, user string) (*fsm.FSM, int64, error) {
+	machine := FSMORM{}
+	res := s.db(ctx).First(&machine, fmt.Sprintf("%s = ?", userCol), user)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		logger.Entry(ctx).Debug(res.Error)
+		return nil, 0, nil
+	} else if res.Error != nil {
+		return nil, 0, res.Error
 	}
+	return machine.toFSM(), machine.Version, nil
 }
 
-// Set method for Store
-func (s *Store) Set(user string, m *fsm.FSM) {
+// CompareAndSet saves the FSM of the user if its version is still version.
+// The row is only updated if it has that version, and a new row for a user
+// that already has one is rejected by the unique index on the user. A soft
+// deleted row of the user is reused for the new FSM
+func (s *Store) CompareAndSet(ctx context.Context, user string, m *fsm.FSM, version int64) (bool, error) {
+	db := s.db(ctx)
+
 	machine := FSMORM{}
-	s.DB.First(&machine, "user = ?", user)
+	machine.setFSM(m)
+
+	res := db.Where(fmt.Sprintf("%s = ? AND version = ?", userCol), user, version).
+		Model(&FSMORM{}).
+		Updates(machine.columns(version + 1))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return true, nil
+	}
+	if version != 0 {
+		return false, nil
+	}
+
+	// A soft deleted row of the user would reject a new row, so it is restored
+	columns := machine.columns(1)
+	columns["deleted_at"] = nil
+	res = db.Where(fmt.Sprintf("%s = ? AND deleted_at IS NOT NULL", userCol), user).
+		Unscoped().
+		Model(&FSMORM{}).
+		Updates(columns)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return true, nil
+	}
+
 	machine.User = user
+	machine.Version = 1
+	if res := db.Create(&machine); res.Error != nil {
+		// The FSM was created since it was read if the user exists now
+		if exists, err := s.Exists(ctx, user); err != nil || !exists {
+			return false, res.Error
+		}
+		logger.Entry(ctx).Debugf("FSM of %s was created concurrently: %v", user, res.Error)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Delete deletes the FSM and the history of the user
+func (s *Store) Delete(ctx context.Context, user string) error {
+	db := s.db(ctx)
+
+	if res := db.Where(fmt.Sprintf("%s = ?", userCol), user).Unscoped().Delete(&FSMORM{}); res.Error != nil {
+		return res.Error
+	}
+	return db.Where("sender = ?", user).Unscoped().Delete(&TurnORM{}).Error
+}
+
+// List returns the users with an FSM sorted by name, the
+// cursor is the last user of the previous page
+func (s *Store) List(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	query := s.db(ctx).Where(fmt.Sprintf("%s > ?", userCol), cursor).Model(&FSMORM{}).Order(userCol)
+	if limit > 0 {
+		query = query.Limit(limit + 1)
+	}
+
+	users := make([]string, 0)
+	if res := query.Pluck(userCol, &users); res.Error != nil {
+		return nil, "", res.Error
+	}
+
+	if limit <= 0 || len(users) <= limit {
+		return users, "", nil
+	}
+	return users[:limit], users[limit-1], nil
+}
+
+// setFSM sets the columns of the FSM
+func (machine *FSMORM) setFSM(m *fsm.FSM) {
 	machine.State = m.State
-	machine.Slots = slotsToJSONString(m.Slots)
-	if res := s.DB.Save(&machine); res.Error != nil {
-		log.Error(res.Error)
+	machine.Slots = toJSONColumn(m.Slots)
+	machine.Rotations = toJSONColumn(m.Rotations)
+	machine.Pending = toJSONColumn(m.Pending)
+	machine.Form = m.Form
+	machine.Values = toJSONColumn(m.Values)
+	machine.SlotHistory = toJSONColumn(m.SlotHistory)
+	machine.Calls = toJSONColumn(m.Calls)
+}
+
+// columns returns the columns of the FSM to update, with the version
+func (machine *FSMORM) columns(version int64) map[string]interface{} {
+	return map[string]interface{}{
+		"version":      version,
+		"state":        machine.State,
+		"slots":        machine.Slots,
+		"rotations":    machine.Rotations,
+		"pending":      machine.Pending,
+		"form":         machine.Form,
+		"values":       machine.Values,
+		"slot_history": machine.SlotHistory,
+		"calls":        machine.Calls,
+	}
+}
+
+// toFSM returns the FSM of the columns
+func (machine *FSMORM) toFSM() *fsm.FSM {
+	m := &fsm.FSM{
+		State:       machine.State,
+		Slots:       fromJSONColumn[map[string]string](machine.Slots),
+		Rotations:   fromJSONColumn[map[string]int](machine.Rotations),
+		Pending:     fromJSONColumn[*fsm.Disambiguation](machine.Pending),
+		Form:        machine.Form,
+		Values:      fromJSONColumn[map[string]json.RawMessage](machine.Values),
+		SlotHistory: fromJSONColumn[map[string][]json.RawMessage](machine.SlotHistory),
+		Calls:       fromJSONColumn[[]int](machine.Calls),
 	}
+	if m.Slots == nil {
+		m.Slots = make(map[string]string)
+	}
+	return m
 }
 
+// AppendTurn adds a conversation turn to the user's history,
+// the turns past the size of the history are deleted
+func (s *Store) AppendTurn(ctx context.Context, user string, turn *history.Turn) error {
+	db := s.db(ctx)
+
+	if res := db.Create(newTurnORM(user, turn)); res.Error != nil {
+		return res.Error
+	}
+	if s.History <= 0 {
+		return nil
+	}
+
+	// The oldest turn that is kept
+	oldest := TurnORM{}
+	res := db.Where("sender = ?", user).Order("id desc").Offset(s.History - 1).Limit(1).Find(&oldest)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	return db.Where("sender = ? AND id < ?", user, oldest.ID).Unscoped().Delete(&TurnORM{}).Error
+}
+
+// GetHistory returns the conversation turns of the user, oldest first
+func (s *Store) GetHistory(ctx context.Context, user string) ([]history.Turn, error) {
+	query := s.db(ctx).Where("sender = ?", user).Order("id desc")
+	if s.History > 0 {
+		query = query.Limit(s.History)
+	}
+
+	rows := make([]TurnORM, 0)
+	if res := query.Find(&rows); res.Error != nil {
+		return nil, res.Error
+	}
+
+	turns := make([]history.Turn, len(rows))
+	for i := range rows {
+		turns[len(rows)-1-i] = rows[i].toTurn()
+	}
+
+	return turns, nil
+}
+
+// Ping checks the connection to the database
+func (s *Store) Ping(ctx context.Context) error {
+	if db, ok := s.DB.(*gorm.DB); ok {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+	return nil
+}
+
+// Close stops the purge and closes the connections to the database
+func (s *Store) Close() error {
+	if s.stopPurge != nil {
+		close(s.stopPurge)
+		s.purgeWG.Wait()
+		s.stopPurge = nil
+	}
+
+	if db, ok := s.DB.(*gorm.DB); ok {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.Close()
+	}
+	return nil
+}
+
+// runPurge deletes the expired FSMs and turns every purge interval until the Store is closed
 func (s *Store) runPurge(ttl, purge time.Duration) {
-	if ttl > 0 && purge > 0 {
-		go func() {
-			ticker := time.NewTicker(purge)
-			for {
-				for range ticker.C {
-					expired := time.Now().Add(-ttl)
-					s.DB.Where("updated_at < ?", expired).Delete(&FSMORM{})
-				}
-			}
-		}()
+	if ttl <= 0 || purge <= 0 {
+		return
 	}
+
+	s.stopPurge = make(chan struct{})
+	s.purgeWG.Add(1)
+	go func(stop <-chan struct{}) {
+		defer s.purgeWG.Done()
+
+		ticker := time.NewTicker(purge)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				expired := time.Now().Add(-ttl)
+				// Deleted for good, a new FSM of the user would break the unique index
+				// and the soft deleted turns would still take up space
+				s.DB.Where("updated_at < ?", expired).Unscoped().Delete(&FSMORM{})
+				s.DB.Where("updated_at < ?", expired).Unscoped().Delete(&TurnORM{})
+			}
+		}
+	}(s.stopPurge)
 }
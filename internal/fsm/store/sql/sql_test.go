@@ -1,18 +1,29 @@
 package sql_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/golang/mock/gomock"
 	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/fsm/store/config"
 	"github.com/jaimeteb/chatto/internal/fsm/store/sql"
 	"github.com/jaimeteb/chatto/internal/fsm/store/sql/mocksql"
+	"github.com/jaimeteb/chatto/internal/history"
+	"github.com/jaimeteb/chatto/query"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 var (
+	ctx = context.Background()
+
 	gormFound    = &gorm.DB{}
 	gormNotFound = &gorm.DB{Error: gorm.ErrRecordNotFound}
 )
@@ -61,8 +72,8 @@ func TestStore_Exists(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &sql.Store{tt.fields.dbClient}
-			if got := s.Exists(tt.args.user); got != tt.want {
+			s := &sql.Store{DB: tt.fields.dbClient}
+			if got, err := s.Exists(ctx, tt.args.user); err != nil || got != tt.want {
 				t.Errorf("Store.Exists() = %v, want %v", got, tt.want)
 			}
 		})
@@ -113,8 +124,8 @@ func TestStore_Get(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &sql.Store{tt.fields.dbClient}
-			if got := s.Get(tt.args.user); !reflect.DeepEqual(got, tt.want) {
+			s := &sql.Store{DB: tt.fields.dbClient}
+			if got, err := s.Get(ctx, tt.args.user); err != nil || !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Store.Get() = %v, want %v", spew.Sprint(got), spew.Sprint(tt.want))
 			}
 		})
@@ -168,8 +179,408 @@ func TestStore_Set(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &sql.Store{tt.fields.dbClient}
-			s.Set(tt.args.user, tt.args.fsm)
+			s := &sql.Store{DB: tt.fields.dbClient}
+			if err := s.Set(ctx, tt.args.user, tt.args.fsm); err != nil {
+				t.Error(err)
+			}
 		})
 	}
 }
+
+func TestStore_AppendTurn(t *testing.T) {
+	machines := newSQLiteStore(t)
+	machines.History = 2
+
+	for _, question := range []string{"one", "two", "three"} {
+		if err := machines.AppendTurn(ctx, "user-1", &history.Turn{Sender: "user-1", Question: question, Answers: []query.Answer{{Text: "hi"}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := machines.AppendTurn(ctx, "user-2", &history.Turn{Sender: "user-2", Question: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([]sql.TurnORM, 0)
+	if err := machines.DB.(*gorm.DB).Unscoped().Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0].Question != "two" || rows[1].Question != "three" || rows[2].Question != "hello" {
+		t.Fatalf("Store.AppendTurn() kept %v", spew.Sprint(rows))
+	}
+	if rows[0].Answers != `[{"text":"hi","image":""}]` || rows[2].Answers != "[]" {
+		t.Errorf("Store.AppendTurn() answers = %v %v", rows[0].Answers, rows[2].Answers)
+	}
+}
+
+func TestStore_GetHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		history int
+		want    []string
+	}{
+		{
+			name:    "last turns",
+			history: 2,
+			want:    []string{"two", "three"},
+		},
+		{
+			name:    "every turn",
+			history: 0,
+			want:    []string{"one", "two", "three"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machines := newSQLiteStore(t)
+			machines.History = tt.history
+
+			for _, turn := range []*history.Turn{
+				{Sender: "user-1", Question: "one"},
+				{Sender: "user-1", Question: "two"},
+				{Sender: "user-1", Question: "three", Extension: &fsm.Extension{Server: "ext", Name: "name"}},
+			} {
+				if err := machines.AppendTurn(ctx, "user-1", turn); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := machines.GetHistory(ctx, "user-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			questions := make([]string, len(got))
+			for i := range got {
+				questions[i] = got[i].Question
+			}
+			if !reflect.DeepEqual(questions, tt.want) {
+				t.Fatalf("Store.GetHistory() = %v, want %v", questions, tt.want)
+			}
+			if last := got[len(got)-1]; !reflect.DeepEqual(last.Extension, &fsm.Extension{Server: "ext", Name: "name"}) {
+				t.Errorf("Store.GetHistory() extension = %v", spew.Sprint(last.Extension))
+			}
+		})
+	}
+}
+
+func TestStore_CompareAndSet(t *testing.T) {
+	machines := newSQLiteStore(t)
+
+	if m, version, err := machines.GetVersion(ctx, "foo"); err != nil || m != nil || version != 0 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, nil, 0)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 1, Slots: map[string]string{"abc": "xyz"}}, 0); err != nil || !ok {
+		t.Fatalf("incorrect, want the new FSM saved: %v", err)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 2}, 0); err != nil || ok {
+		t.Fatalf("incorrect, want the second new FSM rejected: %v", err)
+	}
+
+	m, version, err := machines.GetVersion(ctx, "foo")
+	if err != nil || m.State != 1 || m.Slots["abc"] != "xyz" || version != 1 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 1, 1)
+	}
+
+	m.State = 3
+	if err := machines.Set(ctx, "foo", &fsm.FSM{State: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", m, version); err != nil || ok {
+		t.Fatalf("incorrect, want a stale FSM rejected: %v", err)
+	}
+	if m, version, err = machines.GetVersion(ctx, "foo"); err != nil || m.State != 2 || len(m.Slots) != 0 || version != 2 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 2, 2)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 3}, version); err != nil || !ok {
+		t.Fatalf("incorrect, want the FSM saved: %v", err)
+	}
+	if m, version, err = machines.GetVersion(ctx, "foo"); err != nil || m.State != 3 || version != 3 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 3, 3)
+	}
+}
+
+// legacyFSMORM is an FSM row from before the users had a unique index
+type legacyFSMORM struct {
+	gorm.Model
+	User  string
+	State int
+}
+
+func (*legacyFSMORM) TableName() string {
+	return "fsms"
+}
+
+func TestNewStore_SoftDeleted(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "chatto.db")
+
+	db, err := gorm.Open(sqlite.Open(database), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&legacyFSMORM{}); err != nil {
+		t.Fatal(err)
+	}
+	deleted := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	for _, row := range []*legacyFSMORM{
+		{User: "foo", State: 1, Model: gorm.Model{DeletedAt: deleted}},
+		{User: "foo", State: 2},
+		{User: "bar", State: 3, Model: gorm.Model{DeletedAt: deleted}},
+	} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	machines, err := sql.NewStore(&config.StoreConfig{
+		Type:     "SQL",
+		RDBMS:    "sqlite",
+		Database: database,
+	})
+	if err != nil {
+		t.Fatalf("sql.NewStore() error = %v", err)
+	}
+	defer machines.Close()
+
+	if !machines.DB.(*gorm.DB).Migrator().HasIndex(&sql.FSMORM{}, "User") {
+		t.Fatal("sql.NewStore() did not create the unique index on the users")
+	}
+	if m, version, err := machines.GetVersion(ctx, "foo"); err != nil || m == nil || m.State != 2 || version != 0 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 2, 0)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", &fsm.FSM{State: 4}, 0); err != nil || !ok {
+		t.Fatalf("incorrect, want the FSM saved: %v", err)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "bar", &fsm.FSM{State: 5}, 0); err != nil || !ok {
+		t.Fatalf("incorrect, want the new FSM saved: %v", err)
+	}
+
+	// A row soft deleted after the migration is restored by a new FSM
+	if err := machines.DB.(*gorm.DB).Where("user = ?", "bar").Delete(&sql.FSMORM{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if m, version, err := machines.GetVersion(ctx, "bar"); err != nil || m != nil || version != 0 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, nil, 0)
+	}
+	if ok, err := machines.CompareAndSet(ctx, "bar", &fsm.FSM{State: 6}, 0); err != nil || !ok {
+		t.Fatalf("incorrect, want the new FSM saved: %v", err)
+	}
+	if m, version, err := machines.GetVersion(ctx, "bar"); err != nil || m == nil || m.State != 6 || version != 1 {
+		t.Fatalf("incorrect, got: %v %v %v, want: %v %v.", m, version, err, 6, 1)
+	}
+}
+
+func TestNewStore_Duplicates(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "chatto.db")
+
+	db, err := gorm.Open(sqlite.Open(database), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&legacyFSMORM{}); err != nil {
+		t.Fatal(err)
+	}
+	saved := time.Now().Add(-time.Hour)
+	for _, row := range []*legacyFSMORM{
+		{User: "foo", State: 1, Model: gorm.Model{UpdatedAt: saved.Add(time.Minute)}},
+		{User: "foo", State: 2, Model: gorm.Model{UpdatedAt: saved}},
+		{User: "bar", State: 3, Model: gorm.Model{UpdatedAt: saved}},
+		{User: "bar", State: 4, Model: gorm.Model{UpdatedAt: saved}},
+		{User: "baz", State: 5},
+	} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	machines, err := sql.NewStore(&config.StoreConfig{
+		Type:     "SQL",
+		RDBMS:    "sqlite",
+		Database: database,
+	})
+	if err != nil {
+		t.Fatalf("sql.NewStore() error = %v", err)
+	}
+	defer machines.Close()
+
+	if !machines.DB.(*gorm.DB).Migrator().HasIndex(&sql.FSMORM{}, "User") {
+		t.Fatal("sql.NewStore() did not create the unique index on the users")
+	}
+
+	// The newest FSM of every user is kept, the last one saved if they were saved at once
+	for user, state := range map[string]int{"foo": 1, "bar": 4, "baz": 5} {
+		if m, _, err := machines.GetVersion(ctx, user); err != nil || m == nil || m.State != state {
+			t.Errorf("incorrect %s, got: %v %v, want: %v.", user, m, err, state)
+		}
+	}
+	var count int64
+	if err := machines.DB.(*gorm.DB).Unscoped().Model(&sql.FSMORM{}).Count(&count).Error; err != nil || count != 3 {
+		t.Errorf("incorrect, got %v FSMs: %v, want: %v.", count, err, 3)
+	}
+}
+
+func TestStore_RoundTrip(t *testing.T) {
+	machines := newSQLiteStore(t)
+
+	want := &fsm.FSM{
+		State:       2,
+		Slots:       map[string]string{"guests": "3", "name": "Ann"},
+		Rotations:   map[string]int{"greet": 1},
+		Pending:     &fsm.Disambiguation{Text: "hi", Commands: []string{"a", "b"}, Labels: []string{"A", "B"}},
+		Form:        "signup",
+		Values:      map[string]json.RawMessage{"guests": json.RawMessage(`3`)},
+		SlotHistory: map[string][]json.RawMessage{"name": {json.RawMessage(`"Bob"`)}},
+		Calls:       []int{1, 3},
+	}
+	if ok, err := machines.CompareAndSet(ctx, "foo", want, 0); err != nil || !ok {
+		t.Fatalf("incorrect, want the new FSM saved: %v", err)
+	}
+	if got, err := machines.Get(ctx, "foo"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Store.Get() = %v %v, want %v", spew.Sprint(got), err, spew.Sprint(want))
+	}
+
+	if err := machines.Set(ctx, "foo", fsm.NewFSM()); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := machines.Get(ctx, "foo"); err != nil || !reflect.DeepEqual(got, fsm.NewFSM()) {
+		t.Errorf("Store.Get() = %v %v, want %v", spew.Sprint(got), err, spew.Sprint(fsm.NewFSM()))
+	}
+}
+
+func TestStore_DeleteAndList(t *testing.T) {
+	machines := newSQLiteStore(t)
+
+	for _, user := range []string{"carl", "alice", "dave", "bob"} {
+		if err := machines.Set(ctx, user, fsm.NewFSM()); err != nil {
+			t.Fatal(err)
+		}
+		if err := machines.AppendTurn(ctx, user, &history.Turn{Sender: user}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := machines.Delete(ctx, "carl"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := machines.Exists(ctx, "carl"); err != nil || exists {
+		t.Errorf("incorrect, got: %v %v, want: %v.", exists, err, false)
+	}
+	if turns, err := machines.GetHistory(ctx, "carl"); err != nil || len(turns) != 0 {
+		t.Errorf("incorrect, got: %v %v, want: %v.", len(turns), err, 0)
+	}
+	// The user can start a new conversation
+	if ok, err := machines.CompareAndSet(ctx, "carl", fsm.NewFSM(), 0); err != nil || !ok {
+		t.Errorf("incorrect, want the new FSM saved: %v", err)
+	}
+
+	users, next, err := machines.List(ctx, "", 2)
+	if err != nil || !reflect.DeepEqual(users, []string{"alice", "bob"}) || next != "bob" {
+		t.Fatalf("incorrect, got: %v %q %v, want: %v %q.", users, next, err, "[alice bob]", "bob")
+	}
+	users, next, err = machines.List(ctx, next, 2)
+	if err != nil || !reflect.DeepEqual(users, []string{"carl", "dave"}) || next != "" {
+		t.Fatalf("incorrect, got: %v %q %v, want: %v %q.", users, next, err, "[carl dave]", "")
+	}
+	if users, _, err = machines.List(ctx, "", 0); err != nil || len(users) != 4 {
+		t.Fatalf("incorrect, got: %v %v, want: %v.", users, err, 4)
+	}
+}
+
+func TestStore_GetError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dbClient := mocksql.NewMockDBClient(ctrl)
+	dbClient.EXPECT().First(gomock.Any(), "user = ?", "user-1").Return(&gorm.DB{Error: errors.New("connection refused")}).Times(2)
+
+	s := &sql.Store{DB: dbClient}
+	if got, err := s.Get(ctx, "user-1"); err == nil || got != nil {
+		t.Errorf("Store.Get() = %v %v, want an error", got, err)
+	}
+	if got, err := s.Exists(ctx, "user-1"); err == nil || got {
+		t.Errorf("Store.Exists() = %v %v, want an error", got, err)
+	}
+}
+
+func TestStore_Purge(t *testing.T) {
+	machines, err := sql.NewStore(&config.StoreConfig{
+		Type:     "SQL",
+		RDBMS:    "sqlite",
+		Database: filepath.Join(t.TempDir(), "chatto.db"),
+		TTL:      time.Millisecond,
+		Purge:    10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer machines.Close()
+
+	if err := machines.Set(ctx, "foo", fsm.NewFSM()); err != nil {
+		t.Fatal(err)
+	}
+	if err := machines.AppendTurn(ctx, "foo", &history.Turn{Sender: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	var fsms, turns int64
+	db := machines.DB.(*gorm.DB).Unscoped()
+	if err := db.Model(&sql.FSMORM{}).Count(&fsms).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&sql.TurnORM{}).Count(&turns).Error; err != nil {
+		t.Fatal(err)
+	}
+	if fsms != 0 || turns != 0 {
+		t.Errorf("the purge kept %v FSMs and %v turns, want %v", fsms, turns, 0)
+	}
+}
+
+func TestStore_Close(t *testing.T) {
+	machines, err := sql.NewStore(&config.StoreConfig{
+		Type:     "SQL",
+		RDBMS:    "sqlite",
+		Database: filepath.Join(t.TempDir(), "chatto.db"),
+		TTL:      time.Minute,
+		Purge:    10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := machines.Set(ctx, "foo", fsm.NewFSM()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if err := machines.Ping(ctx); err != nil {
+		t.Fatalf("Store.Ping() = %v, want nil", err)
+	}
+	if err := machines.Close(); err != nil {
+		t.Fatalf("Store.Close() = %v, want nil", err)
+	}
+	if _, err := machines.Get(ctx, "foo"); err == nil {
+		t.Error("Store.Get() after Store.Close() = nil, want an error")
+	}
+	if err := machines.Ping(ctx); err == nil {
+		t.Error("Store.Ping() after Store.Close() = nil, want an error")
+	}
+}
+
+// newSQLiteStore returns a Store on a new SQLite database
+func newSQLiteStore(t *testing.T) *sql.Store {
+	machines, err := sql.NewStore(&config.StoreConfig{
+		Type:     "SQL",
+		RDBMS:    "sqlite",
+		Database: filepath.Join(t.TempDir(), "chatto.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return machines
+}
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"time"
 
@@ -9,22 +11,74 @@ import (
 	"github.com/jaimeteb/chatto/internal/fsm/store/config"
 	"github.com/jaimeteb/chatto/internal/fsm/store/redis"
 	"github.com/jaimeteb/chatto/internal/fsm/store/sql"
+	"github.com/jaimeteb/chatto/internal/history"
 	log "github.com/sirupsen/logrus"
 )
 
 var defaultDuration = -1 * time.Second
 
-// Store interface for FSM Store modes
+// Store interface for FSM Store modes. A sender without an FSM is not an
+// error: Get and GetVersion return nil, errors are failures of the store
 type Store interface {
-	Exists(string) bool
-	Get(string) *fsm.FSM
-	Set(string, *fsm.FSM)
+	Exists(context.Context, string) (bool, error)
+	Get(context.Context, string) (*fsm.FSM, error)
+	Set(context.Context, string, *fsm.FSM) error
+	AppendTurn(context.Context, string, *history.Turn) error
+	GetHistory(context.Context, string) ([]history.Turn, error)
+
+	// Delete deletes the FSM and the history of the user
+	Delete(context.Context, string) error
+
+	// List returns up to limit users with an FSM, starting at the cursor
+	// returned by the previous call ("" for the first call), and the
+	// cursor of the next page, which is "" after the last page. A limit
+	// of 0 returns every user
+	List(ctx context.Context, cursor string, limit int) ([]string, string, error)
+
+	// GetVersion returns the FSM of the user and its version, which
+	// changes every time the FSM is saved. If the user has no FSM,
+	// it returns nil and version 0
+	GetVersion(context.Context, string) (*fsm.FSM, int64, error)
+
+	// CompareAndSet saves the FSM of the user only if its version is
+	// still the one it was read with, and reports whether it was saved
+	CompareAndSet(context.Context, string, *fsm.FSM, int64) (bool, error)
+
+	// Ping checks that the store can be reached
+	Ping(context.Context) error
+
+	// Close stops the background work of the store and closes its connections
+	Close() error
 }
 
-// New loads a Store according to the configuration
-func New(cfg *config.StoreConfig) Store {
-	var machines Store
+// Store types
+const (
+	TypeCache = "cache"
+	TypeRedis = "redis"
+	TypeSQL   = "sql"
+)
+
+// Type returns the type of the Store
+func Type(s Store) string {
+	if instrumented, ok := s.(*Instrumented); ok {
+		s = instrumented.Store
+	}
+
+	switch s.(type) {
+	case *redis.Store:
+		return TypeRedis
+	case *sql.Store:
+		return TypeSQL
+	case *cache.Store:
+		return TypeCache
+	default:
+		return ""
+	}
+}
 
+// New loads a Store according to the configuration, it returns an error
+// if the configured Redis or SQL store cannot be connected to or migrated
+func New(cfg *config.StoreConfig) (Store, error) {
 	if cfg.Purge == defaultDuration && cfg.TTL != defaultDuration {
 		cfg.Purge = cfg.TTL
 	}
@@ -33,26 +87,19 @@ func New(cfg *config.StoreConfig) Store {
 	case "redis":
 		redisStore, err := redis.NewStore(cfg)
 		if err != nil {
-			log.Errorf("Error: %v", err)
-			log.Warn("Couldn't connect to Redis, using CacheStoreFSM instead")
-			machines = cache.NewStore(cfg)
-		} else {
-			log.Info("Connected to RedisStoreFSM")
-			machines = redisStore
+			return nil, fmt.Errorf("couldn't connect to Redis: %w", err)
 		}
+		log.Info("Connected to RedisStoreFSM")
+		return redisStore, nil
 	case "sql":
 		sqlStore, err := sql.NewStore(cfg)
 		if err != nil {
-			log.Errorf("Error: %v", err)
-			log.Warn("Couldn't connect to SQL database, using CacheStoreFSM instead")
-			machines = cache.NewStore(cfg)
-		} else {
-			log.Info("Connected to SQLStoreFSM")
-			machines = sqlStore
+			return nil, fmt.Errorf("couldn't connect to the SQL database: %w", err)
 		}
+		log.Info("Connected to SQLStoreFSM")
+		return sqlStore, nil
 	default:
 		log.Info("Connected to CacheStoreFSM")
-		machines = cache.NewStore(cfg)
+		return cache.NewStore(cfg), nil
 	}
-	return machines
 }
@@ -35,16 +35,19 @@ func TestNew(t *testing.T) {
 		cfg *config.StoreConfig
 	}
 	tests := []struct {
-		name string
-		args args
-		want reflect.Type
+		name     string
+		args     args
+		want     reflect.Type
+		wantType string
+		wantErr  bool
 	}{
 		{
 			name: "cache 1",
 			args: args{
 				cfg: &config.StoreConfig{},
 			},
-			want: reflect.TypeOf(&cache.Store{}),
+			want:     reflect.TypeOf(&cache.Store{}),
+			wantType: store.TypeCache,
 		},
 		{
 			name: "cache 2",
@@ -53,7 +56,8 @@ func TestNew(t *testing.T) {
 					TTL: 10,
 				},
 			},
-			want: reflect.TypeOf(&cache.Store{}),
+			want:     reflect.TypeOf(&cache.Store{}),
+			wantType: store.TypeCache,
 		},
 		{
 			name: "redis success",
@@ -65,7 +69,8 @@ func TestNew(t *testing.T) {
 					Password: "pass",
 				},
 			},
-			want: reflect.TypeOf(&redis.Store{}),
+			want:     reflect.TypeOf(&redis.Store{}),
+			wantType: store.TypeRedis,
 		},
 		{
 			name: "redis fail",
@@ -77,7 +82,7 @@ func TestNew(t *testing.T) {
 					Password: "passss",
 				},
 			},
-			want: reflect.TypeOf(&cache.Store{}),
+			wantErr: true,
 		},
 		{
 			name: "sql success",
@@ -88,7 +93,8 @@ func TestNew(t *testing.T) {
 					Database: "test.db",
 				},
 			},
-			want: reflect.TypeOf(&sql.Store{}),
+			want:     reflect.TypeOf(&sql.Store{}),
+			wantType: store.TypeSQL,
 		},
 		{
 			name: "sql fail mysql",
@@ -98,7 +104,7 @@ func TestNew(t *testing.T) {
 					RDBMS: "mysql",
 				},
 			},
-			want: reflect.TypeOf(&cache.Store{}),
+			wantErr: true,
 		},
 		{
 			name: "sql fail postgresql",
@@ -108,15 +114,25 @@ func TestNew(t *testing.T) {
 					RDBMS: "postgresql",
 				},
 			},
-			want: reflect.TypeOf(&cache.Store{}),
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := reflect.TypeOf(store.New(tt.args.cfg))
+			machines, err := store.New(tt.args.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := reflect.TypeOf(machines)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("New() = %v, want %v", got, tt.want)
 			}
+			if got := store.Type(store.Instrument(machines)); got != tt.wantType {
+				t.Errorf("Type() = %v, want %v", got, tt.wantType)
+			}
 		})
 	}
 	t.Cleanup(func() {
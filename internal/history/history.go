@@ -0,0 +1,44 @@
+package history
+
+import (
+	"time"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/query"
+)
+
+// Turn models a single exchange between a sender and the bot,
+// from the question received to the answers sent back
+type Turn struct {
+	Sender        string         `json:"sender"`
+	Channel       string         `json:"channel"`
+	Question      string         `json:"question"`
+	Command       string         `json:"command"`
+	Probability   float32        `json:"probability"`
+	PreviousState string         `json:"previous_state"`
+	NextState     string         `json:"next_state"`
+	Answers       []query.Answer `json:"answers"`
+	Extension     *fsm.Extension `json:"extension,omitempty"`
+	ReceivedAt    time.Time      `json:"received_at"`
+	AnsweredAt    time.Time      `json:"answered_at"`
+}
+
+// NewTurn starts a new Turn for the question received from a sender
+func NewTurn(sender, channel string, question *query.Question) *Turn {
+	return &Turn{
+		Sender:     sender,
+		Channel:    channel,
+		Question:   question.Text,
+		ReceivedAt: time.Now(),
+	}
+}
+
+// Trim returns the last size turns of the history, a
+// size less than or equal to zero keeps every turn
+func Trim(turns []Turn, size int) []Turn {
+	if size <= 0 || len(turns) <= size {
+		return turns
+	}
+
+	return turns[len(turns)-size:]
+}
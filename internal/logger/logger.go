@@ -1,30 +1,200 @@
 package logger
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	runtime "github.com/banzaicloud/logrus-runtime-formatter"
+	"github.com/jaimeteb/chatto/query"
+	"go.opentelemetry.io/otel/trace"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces the message texts in the logs when they are redacted
+const Redacted = "[REDACTED]"
+
+// Fields of the log lines of a turn
+const (
+	FieldTurnID  = "turn_id"
+	FieldSender  = "sender"
+	FieldChannel = "channel"
+	FieldTraceID = "trace_id"
+)
+
+const timestampFormat = "2006-01-02 15:04:05.000000"
+
+// Config for the logs in bot.yml
+type Config struct {
+	// Format is "text" or "json", defaults to "text"
+	Format string `mapstructure:"format"`
+	// RedactText replaces the texts of the messages in the logs
+	RedactText bool `mapstructure:"redact_text"`
+}
+
+var (
+	mu     sync.Mutex
+	debug  bool
+	format = FormatText
+	redact atomic.Bool
+)
+
 // SetLogger configures the logrus logger and sets the log level
-func SetLogger(debug bool) {
-	if debug || os.Getenv("DEBUG") == "true" {
+func SetLogger(dbg bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	debug = dbg || os.Getenv("DEBUG") == "true"
+	if debug {
 		log.SetLevel(log.DebugLevel)
-		log.SetFormatter(&runtime.Formatter{
-			ChildFormatter: &log.TextFormatter{
-				TimestampFormat: "2006-01-02 15:04:05.000000",
-				FullTimestamp:   true,
-			},
-			File: true,
-			Line: true,
-		})
 	} else {
 		log.SetLevel(log.InfoLevel)
-		log.SetFormatter(&log.TextFormatter{
-			TimestampFormat: "2006-01-02 15:04:05.000000",
+	}
+	setFormatter()
+}
+
+// SetFormat sets the format of the logs, "text" or "json"
+func SetFormat(f string) error {
+	f = strings.ToLower(f)
+	switch f {
+	case "":
+		f = FormatText
+	case FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("unknown log format: %s", f)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	format = f
+	setFormatter()
+
+	return nil
+}
+
+// SetRedactText sets whether the texts of the messages are redacted in the logs
+func SetRedactText(r bool) {
+	redact.Store(r)
+}
+
+// Redacting reports whether the texts of the messages are redacted in the logs
+func Redacting() bool {
+	return redact.Load()
+}
+
+// Configure sets the format and the redaction of the logs
+func Configure(cfg Config) error {
+	if err := SetFormat(cfg.Format); err != nil {
+		return err
+	}
+	SetRedactText(cfg.RedactText)
+
+	return nil
+}
+
+// setFormatter sets the formatter of the format, debug logs have the caller
+func setFormatter() {
+	var formatter log.Formatter
+	if format == FormatJSON {
+		formatter = &log.JSONFormatter{TimestampFormat: timestampFormat}
+	} else {
+		formatter = &log.TextFormatter{
+			TimestampFormat: timestampFormat,
 			FullTimestamp:   true,
-		})
+		}
+	}
+
+	if debug {
+		formatter = &runtime.Formatter{
+			ChildFormatter: formatter,
+			File:           true,
+			Line:           true,
+		}
+	}
+
+	log.SetFormatter(formatter)
+}
+
+// Redact returns the text, or Redacted if the texts are redacted
+func Redact(text string) string {
+	if redact.Load() && text != "" {
+		return Redacted
+	}
+	return text
+}
+
+type entryKey struct{}
+
+type turnIDKey struct{}
+
+// NewTurnID generates a random ID for a turn
+func NewTurnID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithTurnID returns a copy of ctx with the ID of the turn,
+// which is added to the log lines of the Entry of ctx
+func WithTurnID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, turnIDKey{}, id)
+	return WithFields(ctx, log.Fields{FieldTurnID: id})
+}
+
+// TurnID returns the ID of the turn of ctx, empty if it has none
+func TurnID(ctx context.Context) string {
+	id, _ := ctx.Value(turnIDKey{}).(string)
+	return id
+}
+
+// WithFields returns a copy of ctx whose Entry has the fields
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	entry := fromContext(ctx).WithFields(fields)
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// Entry returns the log entry of ctx, with the fields of the turn
+// and the ID of the trace of ctx, if it has one
+func Entry(ctx context.Context) *log.Entry {
+	entry := fromContext(ctx).WithContext(ctx)
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		entry = entry.WithField(FieldTraceID, sc.TraceID().String())
+	}
+	return entry
+}
+
+func fromContext(ctx context.Context) *log.Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// RedactAnswers returns a copy of the answers with their texts redacted,
+// or the answers themselves if the texts are not redacted
+func RedactAnswers(answers []query.Answer) []query.Answer {
+	if !redact.Load() {
+		return answers
+	}
+
+	redacted := make([]query.Answer, len(answers))
+	for i, answer := range answers {
+		redacted[i] = query.Answer{Text: Redact(answer.Text), Image: answer.Image}
 	}
+	return redacted
 }
@@ -1,12 +1,15 @@
 package logger_test
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/jaimeteb/chatto/internal/logger"
+	"github.com/jaimeteb/chatto/query"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestLogger_SetLogger(t *testing.T) {
@@ -37,3 +40,144 @@ func TestLogger_SetLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestLogger_SetFormat(t *testing.T) {
+	t.Cleanup(func() {
+		_ = logger.SetFormat(logger.FormatText)
+	})
+
+	tests := []struct {
+		name    string
+		format  string
+		want    logrus.Formatter
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			format: "",
+			want:   &logrus.TextFormatter{},
+		},
+		{
+			name:   "text",
+			format: "text",
+			want:   &logrus.TextFormatter{},
+		},
+		{
+			name:   "json",
+			format: "JSON",
+			want:   &logrus.JSONFormatter{},
+		},
+		{
+			name:    "unknown",
+			format:  "xml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger.SetLogger(false)
+			if err := logger.SetFormat(tt.format); (err != nil) != tt.wantErr {
+				t.Fatalf("Logger.SetFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := logrus.StandardLogger().Formatter; reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("Logger.SetFormat() formatter = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogger_Redact(t *testing.T) {
+	t.Cleanup(func() {
+		logger.SetRedactText(false)
+	})
+
+	tests := []struct {
+		name   string
+		redact bool
+		text   string
+		want   string
+	}{
+		{
+			name: "not redacted",
+			text: "my name is Ana",
+			want: "my name is Ana",
+		},
+		{
+			name:   "redacted",
+			redact: true,
+			text:   "my name is Ana",
+			want:   logger.Redacted,
+		},
+		{
+			name:   "empty text",
+			redact: true,
+			text:   "",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger.SetRedactText(tt.redact)
+			if got := logger.Redact(tt.text); got != tt.want {
+				t.Errorf("Logger.Redact() = %q, want %q", got, tt.want)
+			}
+
+			answers := []query.Answer{{Text: tt.text, Image: "image.png"}}
+			want := []query.Answer{{Text: tt.want, Image: "image.png"}}
+			if got := logger.RedactAnswers(answers); !reflect.DeepEqual(got, want) {
+				t.Errorf("Logger.RedactAnswers() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLogger_Entry(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		wantTurnID string
+		want       logrus.Fields
+	}{
+		{
+			name: "no turn",
+			ctx:  context.Background(),
+			want: logrus.Fields{},
+		},
+		{
+			name:       "turn",
+			ctx:        logger.WithFields(logger.WithTurnID(context.Background(), "turn-1"), logrus.Fields{logger.FieldSender: "ana", logger.FieldChannel: "rest"}),
+			wantTurnID: "turn-1",
+			want:       logrus.Fields{logger.FieldTurnID: "turn-1", logger.FieldSender: "ana", logger.FieldChannel: "rest"},
+		},
+		{
+			name:       "turn in a trace",
+			ctx:        trace.ContextWithSpanContext(logger.WithTurnID(context.Background(), "turn-2"), spanCtx),
+			wantTurnID: "turn-2",
+			want:       logrus.Fields{logger.FieldTurnID: "turn-2", logger.FieldTraceID: traceID.String()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logger.TurnID(tt.ctx); got != tt.wantTurnID {
+				t.Errorf("Logger.TurnID() = %q, want %q", got, tt.wantTurnID)
+			}
+			if got := logger.Entry(tt.ctx).Data; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Logger.Entry() fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogger_NewTurnID(t *testing.T) {
+	first, second := logger.NewTurnID(), logger.NewTurnID()
+	if len(first) != 32 || first == second {
+		t.Errorf("Logger.NewTurnID() = %q, %q, want two different 32 character IDs", first, second)
+	}
+}
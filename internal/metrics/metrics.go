@@ -0,0 +1,126 @@
+// Package metrics has the Prometheus metrics of the bot, which are
+// served by the /bot/metrics endpoint
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "chatto"
+
+// Fallbacks answered instead of a transition
+const (
+	FallbackUnsure  = "unsure"
+	FallbackUnknown = "unknown"
+)
+
+// Registry has the metrics of the bot and of the Go runtime and process
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// Messages received, by channel
+	Messages = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "bot",
+		Name:      "messages_total",
+		Help:      "Messages received by the bot, by channel.",
+	}, []string{"channel"})
+
+	// Predictions of the classifier, by predicted command
+	Predictions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "classifier",
+		Name:      "predictions_total",
+		Help:      "Commands predicted by the classifier.",
+	}, []string{"command"})
+
+	// PredictionProbability of the predictions, by predicted command
+	PredictionProbability = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "classifier",
+		Name:      "prediction_probability",
+		Help:      "Probability of the commands predicted by the classifier.",
+		Buckets:   []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+	}, []string{"command"})
+
+	// Fallbacks answered because the command was unsure or unknown
+	Fallbacks = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "fsm",
+		Name:      "fallbacks_total",
+		Help:      "Messages whose command was unsure or unknown, by reason.",
+	}, []string{"reason"})
+
+	// Transitions of the FSMs, by state before and after the transition
+	Transitions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "fsm",
+		Name:      "transitions_total",
+		Help:      "State transitions executed by the FSMs.",
+	}, []string{"from", "into"})
+
+	// ExtensionDuration of the extension calls, by server and extension
+	ExtensionDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "extension",
+		Name:      "call_duration_seconds",
+		Help:      "Duration of the calls to the extensions.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"server", "extension"})
+
+	// ExtensionErrors of the extension calls, by server and extension
+	ExtensionErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "extension",
+		Name:      "call_errors_total",
+		Help:      "Calls to the extensions that failed.",
+	}, []string{"server", "extension"})
+
+	// StoreDuration of the store operations, by operation
+	StoreDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the operations of the FSM store.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObservePrediction counts a prediction of the classifier and its probability
+func ObservePrediction(command string, probability float32) {
+	Predictions.WithLabelValues(command).Inc()
+	PredictionProbability.WithLabelValues(command).Observe(float64(probability))
+}
+
+// ObserveExtension records the duration of an extension call since start and whether it failed
+func ObserveExtension(server, extension string, start time.Time, err error) {
+	ExtensionDuration.WithLabelValues(server, extension).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ExtensionErrors.WithLabelValues(server, extension).Inc()
+	}
+}
+
+// ObserveStore records the duration of a store operation since start
+func ObserveStore(operation string, start time.Time) {
+	StoreDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics of the Registry in the Prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
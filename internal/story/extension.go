@@ -0,0 +1,87 @@
+package story
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/extension"
+	"github.com/jaimeteb/chatto/query"
+	"github.com/jaimeteb/chatto/version"
+)
+
+// Stub is the response of a stubbed extension. Like a real extension it
+// can change the state and slots of the FSM, or fail with an error
+type Stub struct {
+	Answers []query.Answer    `yaml:"answers"`
+	State   string            `yaml:"state"`
+	Slots   map[string]string `yaml:"slots"`
+	Error   string            `yaml:"error"`
+}
+
+// StubServer is an extension server that answers with Stubs
+// instead of calling a REST or RPC service
+type StubServer struct {
+	Stubs map[string]Stub
+}
+
+// ServerMap returns the stubbed extension servers of the File
+func (f *File) ServerMap() extension.ServerMap {
+	serverMap := make(extension.ServerMap, len(f.Extensions))
+	for server, stubs := range f.Extensions {
+		serverMap[server] = &StubServer{Stubs: stubs}
+	}
+	return serverMap
+}
+
+// GetAllExtensions returns the names of the stubbed extensions
+func (s *StubServer) GetAllExtensions() ([]string, error) {
+	names := make([]string, 0, len(s.Stubs))
+	for name := range s.Stubs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetBuildVersion returns the build version of the bot, the stubs run in it
+func (s *StubServer) GetBuildVersion(_ context.Context) (*version.BuildResponse, error) {
+	build := version.Build()
+	return &build, nil
+}
+
+// Close for StubServer, the stubs have no connections
+func (s *StubServer) Close() error {
+	return nil
+}
+
+// ExecuteExtension returns the answers of the stubbed extension and applies its state and slots
+func (s *StubServer) ExecuteExtension(_ context.Context, question *query.Question, extensionName, channel, command string, fsmDomain *fsm.Domain, machine *fsm.FSM) ([]query.Answer, error) {
+	stub, ok := s.Stubs[extensionName]
+	if !ok {
+		return nil, fmt.Errorf("extension '%s' is not stubbed", extensionName)
+	}
+
+	if stub.Error != "" {
+		return nil, errors.New(stub.Error)
+	}
+
+	if stub.State != "" {
+		state, ok := fsmDomain.StateTable[stub.State]
+		if !ok {
+			return nil, fmt.Errorf("extension '%s' moves into unknown state '%s'", extensionName, stub.State)
+		}
+		machine.State = state
+	}
+
+	for slot, value := range stub.Slots {
+		if machine.Slots == nil {
+			machine.Slots = make(map[string]string)
+		}
+		machine.Slots[slot] = value
+	}
+
+	return stub.Answers, nil
+}
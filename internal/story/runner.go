@@ -0,0 +1,212 @@
+package story
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/bot"
+	"github.com/jaimeteb/chatto/internal/channels/messages"
+	"github.com/jaimeteb/chatto/internal/clf"
+	fsmint "github.com/jaimeteb/chatto/internal/fsm"
+	"github.com/jaimeteb/chatto/internal/fsm/store/cache"
+	"github.com/jaimeteb/chatto/internal/fsm/store/config"
+	"github.com/jaimeteb/chatto/query"
+)
+
+// Failure is a difference between what a Turn expected and what the bot did
+type Failure struct {
+	Turn  int
+	User  string
+	Field string
+	Want  string
+	Got   string
+}
+
+// String returns the Failure as a diff of the wanted and the actual values
+func (f Failure) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "turn %d %q: %s\n", f.Turn, f.User, f.Field)
+	for _, line := range strings.Split(f.Want, "\n") {
+		fmt.Fprintf(&b, "  - %s\n", line)
+	}
+	for _, line := range strings.Split(f.Got, "\n") {
+		fmt.Fprintf(&b, "  + %s\n", line)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Result of running a Story, it passed if there are no failures
+type Result struct {
+	Story    string
+	File     string
+	Failures []Failure
+}
+
+// Passed reports whether the Story passed
+func (r *Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Runner runs stories against a bot built from the configuration files,
+// every Story starts with an empty store
+type Runner struct {
+	Bot *bot.Bot
+}
+
+// NewRunner loads the bot, FSM and classifier configurations found in
+// path. Extensions are not loaded, each story File provides stubs for them
+func NewRunner(path string) (*Runner, error) {
+	botConfig, err := bot.LoadConfig(path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fsmConfig, err := fsmint.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	classifConfig, err := clf.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := bot.NewBundle(fsmConfig, classifConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bot.Bot{
+		Name:   botConfig.Name,
+		Config: botConfig,
+	}
+	b.SetBundle(bundle)
+
+	return &Runner{Bot: b}, nil
+}
+
+// Run runs every Story of the File
+func (r *Runner) Run(file *File) []Result {
+	results := make([]Result, 0, len(file.Stories))
+
+	for n := range file.Stories {
+		b := &bot.Bot{
+			Name:       r.Bot.Name,
+			Store:      cache.NewStore(&config.StoreConfig{TTL: -time.Second, Purge: -time.Second, History: 1}),
+			Extensions: file.ServerMap(),
+			Config:     r.Bot.Config,
+		}
+		b.SetBundle(r.Bot.Bundle())
+
+		result := runStory(b, &file.Stories[n])
+		result.File = file.Path
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// runStory answers the turns of the Story until one of them fails
+func runStory(b *bot.Bot, story *Story) Result {
+	ctx := context.Background()
+	result := Result{Story: story.Name}
+
+	for n := range story.Turns {
+		turn := &story.Turns[n]
+		receiveMsg := &messages.Receive{
+			Question: &query.Question{Sender: story.Sender, Text: turn.User},
+			Channel:  story.Channel,
+		}
+
+		answers, err := b.Answer(ctx, receiveMsg)
+		if err != nil {
+			result.Failures = append(result.Failures, Failure{Turn: n + 1, User: turn.User, Field: "error", Want: "(no error)", Got: err.Error()})
+			break
+		}
+
+		result.Failures = append(result.Failures, checkTurn(ctx, b, receiveMsg.Conversation(), n+1, turn, answers)...)
+		if !result.Passed() {
+			break
+		}
+	}
+
+	return result
+}
+
+// checkTurn compares what the Turn expected with the answers, state and slots of the bot
+func checkTurn(ctx context.Context, b *bot.Bot, sender string, n int, turn *Turn, answers []query.Answer) []Failure {
+	var failures []Failure
+
+	fail := func(field, want, got string) {
+		failures = append(failures, Failure{Turn: n, User: turn.User, Field: field, Want: want, Got: got})
+	}
+
+	if turn.Command != "" {
+		command := ""
+		if turns, _ := b.Store.GetHistory(ctx, sender); len(turns) > 0 {
+			command = turns[len(turns)-1].Command
+		}
+		if command != turn.Command {
+			fail("command", turn.Command, command)
+		}
+	}
+
+	if turn.Answers != nil && !equalAnswers(turn.Answers, answers) {
+		fail("answers", formatAnswers(turn.Answers), formatAnswers(answers))
+	}
+
+	// The stories run on a cache store, which does not fail
+	machine, _ := b.Store.Get(ctx, sender)
+	if machine == nil {
+		machine = fsm.NewFSM()
+	}
+
+	if turn.State != "" {
+		if state := b.Bundle().Domain.StateTable.Name(machine.State); state != turn.State {
+			fail("state", turn.State, state)
+		}
+	}
+
+	slots := make([]string, 0, len(turn.Slots))
+	for slot := range turn.Slots {
+		slots = append(slots, slot)
+	}
+	sort.Strings(slots)
+
+	for _, slot := range slots {
+		if got := machine.Slots[slot]; got != turn.Slots[slot] {
+			fail("slot "+slot, turn.Slots[slot], got)
+		}
+	}
+
+	return failures
+}
+
+func equalAnswers(want, got []query.Answer) bool {
+	if len(want) == 0 && len(got) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+// formatAnswers returns the answers one per line, with their image if they have one
+func formatAnswers(answers []query.Answer) string {
+	if len(answers) == 0 {
+		return "(no answers)"
+	}
+
+	lines := make([]string, len(answers))
+	for i, answer := range answers {
+		lines[i] = answer.Text
+		if answer.Image != "" {
+			lines[i] += " [image: " + answer.Image + "]"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,112 @@
+// Package story runs conversations written in YAML against a bot, checking
+// the answers, states and slots after every user turn. Extensions are
+// replaced by stubs, so stories run in-process without any live service
+package story
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jaimeteb/chatto/query"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultSender  = "story"
+	defaultChannel = "rest"
+)
+
+// File contains stories and the extension stubs used by them
+type File struct {
+	Path string `yaml:"-"`
+
+	// Extensions maps extension servers to the responses of their extensions
+	Extensions map[string]map[string]Stub `yaml:"extensions"`
+	Stories    []Story                    `yaml:"stories"`
+}
+
+// Story is a conversation between a user and the bot
+type Story struct {
+	Name    string `yaml:"name"`
+	Sender  string `yaml:"sender"`
+	Channel string `yaml:"channel"`
+	Turns   []Turn `yaml:"turns"`
+}
+
+// Turn is a message of the user and what is expected from the bot
+// after answering it. Fields left empty are not checked
+type Turn struct {
+	User    string            `yaml:"user"`
+	Command string            `yaml:"command"`
+	Answers []query.Answer    `yaml:"answers"`
+	State   string            `yaml:"state"`
+	Slots   map[string]string `yaml:"slots"`
+}
+
+// Load reads a story file, or every .yml and .yaml file of a directory
+func Load(path string) ([]*File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{path}
+	if info.IsDir() {
+		paths = nil
+		for _, ext := range []string{"*.yml", "*.yaml"} {
+			matches, err := filepath.Glob(filepath.Join(path, ext))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
+		}
+		sort.Strings(paths)
+	}
+
+	files := make([]*File, 0, len(paths))
+	for _, p := range paths {
+		file, err := loadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func loadFile(path string) (*File, error) {
+	content, err := os.ReadFile(path) //nolint:gosec // the path is provided by the user on purpose
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	file := &File{Path: path}
+	if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for n := range file.Stories {
+		story := &file.Stories[n]
+		if strings.TrimSpace(story.Name) == "" {
+			story.Name = fmt.Sprintf("story %d", n+1)
+		}
+		if story.Sender == "" {
+			story.Sender = defaultSender
+		}
+		if story.Channel == "" {
+			story.Channel = defaultChannel
+		}
+	}
+
+	return file, nil
+}
@@ -0,0 +1,99 @@
+package story_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jaimeteb/chatto/internal/story"
+	"github.com/jaimeteb/chatto/internal/testutils"
+)
+
+func TestRunner_Run(t *testing.T) {
+	runner, err := story.NewRunner("../" + testutils.Examples00TestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storiesPath := t.TempDir()
+	failing := `extensions:
+  test:
+    any:
+      error: "service unavailable"
+stories:
+  - name: wrong answer
+    turns:
+      - user: "turn on"
+        answers:
+          - text: "Turning off."
+        state: initial
+      - user: "turn off"
+  - name: extension error
+    turns:
+      - user: "turn on"
+        slots:
+          name: ""
+      - user: "hello"
+        answers:
+          - text: "Hello"
+`
+	if err := os.WriteFile(filepath.Join(storiesPath, "failing.yml"), []byte(failing), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want [][]story.Failure
+	}{
+		{
+			name: "passing stories",
+			path: "../" + testutils.Examples00TestPath + "stories",
+			want: [][]story.Failure{nil, nil, nil},
+		},
+		{
+			name: "failing stories",
+			path: storiesPath,
+			want: [][]story.Failure{
+				{
+					{Turn: 1, User: "turn on", Field: "answers", Want: "Turning off.", Got: "Turning on."},
+					{Turn: 1, User: "turn on", Field: "state", Want: "initial", Got: "on"},
+				},
+				{
+					{Turn: 2, User: "hello", Field: "answers", Want: "Hello", Got: "Error"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := story.Load(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("story.Load() = %d files, want 1", len(files))
+			}
+
+			got := [][]story.Failure{}
+			for _, result := range runner.Run(files[0]) {
+				got = append(got, result.Failures)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Runner.Run() failures = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_UnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stories.yml")
+	if err := os.WriteFile(path, []byte("stories:\n  - name: typo\n    turn: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := story.Load(path); err == nil {
+		t.Error("story.Load() expected an error for an unknown field")
+	}
+}
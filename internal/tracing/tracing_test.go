@@ -0,0 +1,58 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jaimeteb/chatto/internal/tracing"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	tests := []struct {
+		name    string
+		cfg     tracing.Config
+		wantErr bool
+	}{
+		{
+			name: "no exporter",
+			cfg:  tracing.Config{},
+		},
+		{
+			name: "stdout exporter",
+			cfg:  tracing.Config{Exporter: "stdout", SampleRatio: 1},
+		},
+		{
+			name: "otlp exporter",
+			cfg:  tracing.Config{Exporter: "OTLP", Endpoint: "localhost:4318", Insecure: true, SampleRatio: 0.5},
+		},
+		{
+			name:    "unknown exporter",
+			cfg:     tracing.Config{Exporter: "zipkin"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := tracing.Setup(context.Background(), tt.cfg, "chatto")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Setup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			_, span := tracing.Start(context.Background(), "test")
+			tracing.End(span, nil)
+
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("Setup() shutdown error = %v", err)
+			}
+		})
+	}
+}
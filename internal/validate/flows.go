@@ -0,0 +1,103 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/bot"
+	"github.com/jaimeteb/chatto/internal/clf"
+	fsmint "github.com/jaimeteb/chatto/internal/fsm"
+)
+
+// flowSource is where a flow is declared, in the FSM
+// files or in its own file of the flows directory
+type flowSource struct {
+	src  *source
+	path []interface{}
+}
+
+// line returns the line of the node found by following key from the flow
+func (f *flowSource) line(key ...interface{}) int {
+	return f.src.Line(append(append([]interface{}{}, f.path...), key...)...)
+}
+
+// flowSources returns where every flow of the configuration is declared.
+// The flows of the FSM files are loaded first, then the files of the flows
+// directory
+func flowSources(path string, fsmSrcs *sources, fsmConfig *fsmint.Config) []flowSource {
+	files, err := fsmint.FlowFiles(path)
+	if err != nil {
+		files = nil
+	}
+
+	inline := len(fsmConfig.Flows) - len(files)
+	sources := make([]flowSource, len(fsmConfig.Flows))
+	for n := range fsmConfig.Flows {
+		if n < inline {
+			src, i := fsmSrcs.item("flows", n)
+			sources[n] = flowSource{src: src, path: []interface{}{"flows", i}}
+		} else {
+			sources[n] = flowSource{src: loadSourceFile(files[n-inline])}
+		}
+	}
+
+	return sources
+}
+
+// flowNames returns the names of the flows of the configuration
+func flowNames(fsmConfig *fsmint.Config) map[string]bool {
+	names := make(map[string]bool, len(fsmConfig.Flows))
+	for _, flow := range fsmConfig.Flows {
+		names[strings.TrimSpace(flow.Name)] = true
+	}
+	return names
+}
+
+// checkFlows checks the transitions of every flow, see checkTransitions, that
+// the flow can be added to a Domain, and warns about flows no transition calls
+func checkFlows(report *Report, sources []flowSource, fsmConfig *fsmint.Config, botConfig *bot.Config, classifConfig *clf.Config) {
+	called := make(map[string]bool)
+	for _, transition := range fsmConfig.AllTransitions() {
+		called[strings.TrimSpace(transition.Call)] = true
+	}
+
+	declared := make(map[string]bool, len(fsmConfig.Flows))
+	for n := range fsmConfig.Flows {
+		flow := fsmConfig.Flows[n]
+		src := &sources[n]
+		name := strings.TrimSpace(flow.Name)
+
+		if declared[name] {
+			report.errorf(src.src, src.line("name"), "flow '%s' is declared more than once", name)
+		}
+		declared[name] = true
+
+		checkTransitionList(report, src.src, flow.Transitions, fsmConfig, botConfig, classifConfig, append(src.path, "transitions")...)
+
+		// The transitions were checked on their own, calls included
+		transitions := make([]fsm.Transition, len(flow.Transitions))
+		for i := range flow.Transitions {
+			transitions[i] = flow.Transitions[i]
+			transitions[i].Call = ""
+		}
+		flow.Transitions = transitions
+
+		if _, err := fsm.NewDomainWithFlows(nil, nil, []fsm.Flow{flow}, fsmConfig.Defaults); err != nil {
+			var transitionErr *fsm.ErrInvalidTransition
+			if errors.As(err, &transitionErr) {
+				continue
+			}
+			var invalidErr *fsm.ErrInvalidFlow
+			if errors.As(err, &invalidErr) {
+				err = invalidErr.Err
+			}
+			report.errorf(src.src, src.line(), "invalid flow: %v", err)
+			continue
+		}
+
+		if !called[name] {
+			report.warnf(src.src, src.line(), "flow '%s' is not called by any transition", name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/bot"
+	"github.com/jaimeteb/chatto/internal/clf"
+	fsmint "github.com/jaimeteb/chatto/internal/fsm"
+)
+
+// checkForms checks every form on its own: its states, commands, slots,
+// extension, and that it can be added to a Domain. The bot and classifier
+// configurations are nil if they could not be loaded
+func checkForms(report *Report, srcs *sources, fsmConfig *fsmint.Config, botConfig *bot.Config, classifConfig *clf.Config) {
+	commands, entities := classifierNames(classifConfig)
+
+	checkCommand := func(src *source, n int, command string, path ...interface{}) {
+		switch {
+		case command == "":
+			report.errorf(src, src.Line(path...), "form %d has no command", n)
+		case command == "any":
+			report.errorf(src, src.Line(path...), "form %d cannot use the command 'any'", n)
+		case classifConfig != nil && !commands[command]:
+			report.errorf(src, src.Line(path...), "command '%s' is not a command of the classifier", command)
+		}
+	}
+
+	for m := range fsmConfig.Forms {
+		form := &fsmConfig.Forms[m]
+		src, n := srcs.item("forms", m)
+
+		if len(form.From) == 0 {
+			report.errorf(src, src.Line("forms", n), "form %d has no from states", n)
+		}
+		if into := strings.TrimSpace(form.Into); into == "" || into == "any" {
+			report.errorf(src, src.Line("forms", n, "into"), "form %d needs an into state other than 'any'", n)
+		}
+
+		if usesReturn(fsmConfig, form.From, form.Into, form.Cancel.Into) {
+			report.errorf(src, src.Line("forms", n), "form %d uses the state 'return', which is reserved for the flows", n)
+		}
+
+		checkCommand(src, n, strings.TrimSpace(form.Command), "forms", n, "command")
+		if cancel := strings.TrimSpace(form.Cancel.Command); cancel != "" {
+			checkCommand(src, n, cancel, "forms", n, "cancel", "command")
+		}
+
+		for i := range form.Slots {
+			checkSlot(report, src, form.Slots[i].Slot(), entities, "forms", n, "slots", i)
+		}
+
+		if form.Extension != (fsm.Extension{}) && botConfig != nil {
+			if _, ok := botConfig.Extensions[form.Extension.Server]; !ok {
+				report.errorf(src, src.Line("forms", n, "extension", "server"), "extension server '%s' is not configured in the bot", form.Extension.Server)
+			}
+		}
+
+		if _, err := fsm.NewDomainWithForms(nil, []fsm.Form{*form}, fsmConfig.Defaults); err != nil {
+			var invalidErr *fsm.ErrInvalidForm
+			if errors.As(err, &invalidErr) {
+				err = invalidErr.Err
+			}
+			report.errorf(src, src.Line("forms", n), "invalid form: %v", err)
+		}
+	}
+}
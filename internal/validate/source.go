@@ -0,0 +1,159 @@
+package validate
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/jaimeteb/chatto/internal/configdir"
+	"gopkg.in/yaml.v3"
+)
+
+// configExts are the extensions tried when looking for a configuration file
+var configExts = []string{"yml", "yaml"}
+
+// source is a parsed YAML configuration file, used to point
+// problems to the line where they were found
+type source struct {
+	File string
+	root *yaml.Node
+}
+
+// loadSource looks for the configuration file name in path and parses it,
+// if it does not exist or cannot be parsed every line lookup returns 0
+func loadSource(path, name string) *source {
+	src := &source{File: filepath.Join(path, name+"."+configExts[0])}
+
+	for _, ext := range configExts {
+		file := filepath.Join(path, name+"."+ext)
+		if _, err := os.Stat(file); err == nil {
+			return loadSourceFile(file)
+		}
+	}
+
+	return src
+}
+
+// loadSourceFile parses the configuration file, if it
+// cannot be read or parsed every line lookup returns 0
+func loadSourceFile(file string) *source {
+	src := &source{File: file}
+
+	content, err := os.ReadFile(file) //nolint:gosec // the path is provided by the user on purpose
+	if err != nil {
+		return src
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err == nil && len(doc.Content) > 0 {
+		src.root = doc.Content[0]
+	}
+
+	return src
+}
+
+// sources are the files a configuration is merged from, see configdir.Files
+type sources struct {
+	main  *source
+	files []*source
+}
+
+// loadSources parses the configuration files of name in path, main is
+// name.yml even if it does not exist, to report problems of the whole
+// configuration
+func loadSources(path, name string) *sources {
+	srcs := &sources{main: loadSource(path, name)}
+
+	files, err := configdir.Files(path, name)
+	if err != nil {
+		return srcs
+	}
+	for _, file := range files {
+		if file == srcs.main.File {
+			srcs.files = append(srcs.files, srcs.main)
+			continue
+		}
+		srcs.files = append(srcs.files, loadSourceFile(file))
+	}
+
+	return srcs
+}
+
+// item returns the file item n of the list key was merged from, and the
+// index of the item in that file. The lists of the files are appended in
+// the order of the files
+func (s *sources) item(key string, n int) (*source, int) {
+	for _, src := range s.files {
+		count := src.Len(key)
+		if n < count {
+			return src, n
+		}
+		n -= count
+	}
+	return s.main, n
+}
+
+// Exists reports whether the configuration file was found
+func (s *source) Exists() bool {
+	_, err := os.Stat(s.File)
+	return err == nil
+}
+
+// Line returns the line of the node found by following the path, made
+// of mapping keys (string) and sequence indexes (int). If the path can't
+// be followed to the end, the line of the deepest node found is returned
+func (s *source) Line(path ...interface{}) int {
+	node := s.root
+	if node == nil {
+		return 0
+	}
+
+	line := node.Line
+	for _, p := range path {
+		next := child(node, p)
+		if next == nil {
+			break
+		}
+		node = next
+		line = node.Line
+	}
+
+	return line
+}
+
+// Len returns the number of items of the sequence found by following
+// the path, or 0 if there is no sequence at the end of the path
+func (s *source) Len(path ...interface{}) int {
+	node := s.root
+	for _, p := range path {
+		if node == nil {
+			return 0
+		}
+		node = child(node, p)
+	}
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return 0
+	}
+	return len(node.Content)
+}
+
+// child returns the value of a mapping key or the item at a sequence index
+func child(node *yaml.Node, p interface{}) *yaml.Node {
+	switch key := p.(type) {
+	case string:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+	case int:
+		if node.Kind != yaml.SequenceNode || key < 0 || key >= len(node.Content) {
+			return nil
+		}
+		return node.Content[key]
+	}
+
+	return nil
+}
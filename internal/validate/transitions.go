@@ -0,0 +1,321 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jaimeteb/chatto/fsm"
+	"github.com/jaimeteb/chatto/internal/bot"
+	"github.com/jaimeteb/chatto/internal/clf"
+	fsmint "github.com/jaimeteb/chatto/internal/fsm"
+)
+
+// checkTransitions checks every transition on its own: its states, command,
+// slot, extension, and that it can be added to a Domain. The bot and
+// classifier configurations are nil if they could not be loaded
+func checkTransitions(report *Report, srcs *sources, fsmConfig *fsmint.Config, botConfig *bot.Config, classifConfig *clf.Config) {
+	for n := 0; n < len(fsmConfig.Transitions); {
+		src, i := srcs.item("transitions", n)
+		count := src.Len("transitions") - i
+		if count <= 0 || n+count > len(fsmConfig.Transitions) {
+			count = len(fsmConfig.Transitions) - n
+		}
+		checkTransitionList(report, src, fsmConfig.Transitions[n:n+count], fsmConfig, botConfig, classifConfig, "transitions")
+		n += count
+	}
+
+	for n, transition := range fsmConfig.Transitions {
+		if usesReturn(fsmConfig, transition.From, transition.Into) {
+			src, i := srcs.item("transitions", n)
+			report.errorf(src, src.Line("transitions", i), "transition %d uses the state 'return', which is reserved for the flows", i)
+		}
+	}
+}
+
+// usesReturn reports whether the states are the return state of the flows,
+// a transition or form of fsm.yml cannot use it if there are flows
+func usesReturn(fsmConfig *fsmint.Config, from []string, into ...string) bool {
+	if len(fsmConfig.Flows) == 0 {
+		return false
+	}
+	for _, state := range append(append([]string{}, from...), into...) {
+		if strings.TrimSpace(state) == "return" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransitionList checks a list of transitions, see checkTransitions.
+// The list is found by following path in the source
+func checkTransitionList(report *Report, src *source, transitions []fsm.Transition, fsmConfig *fsmint.Config, botConfig *bot.Config, classifConfig *clf.Config, path ...interface{}) {
+	commands, entities := classifierNames(classifConfig)
+	flows := flowNames(fsmConfig)
+
+	pathOf := func(n int, key ...interface{}) []interface{} {
+		return append(append(append([]interface{}{}, path...), n), key...)
+	}
+
+	for n, transition := range transitions {
+		line := src.Line(pathOf(n)...)
+
+		if len(transition.From) == 0 {
+			report.errorf(src, line, "transition %d has no from states", n)
+		}
+		for i, from := range transition.From {
+			if strings.TrimSpace(from) == "" {
+				report.errorf(src, src.Line(pathOf(n, "from", i)...), "transition %d has an empty from state", n)
+			}
+		}
+		if into := strings.TrimSpace(transition.Into); into == "" || into == "any" {
+			report.errorf(src, src.Line(pathOf(n, "into")...), "transition %d needs an into state other than 'any'", n)
+		}
+
+		command := strings.TrimSpace(transition.Command)
+		switch {
+		case command == "":
+			report.errorf(src, line, "transition %d has no command", n)
+		case command != "any" && classifConfig != nil && !commands[command]:
+			report.errorf(src, src.Line(pathOf(n, "command")...), "command '%s' is not a command of the classifier", command)
+		}
+
+		// Calls are checked here, the Domain below has no flows
+		if call := strings.TrimSpace(transition.Call); call != "" && !flows[call] {
+			report.errorf(src, src.Line(pathOf(n, "call")...), "flow '%s' is not declared", call)
+		}
+		transition.Call = ""
+
+		checkSlot(report, src, transition.Slot, entities, pathOf(n, "slot")...)
+		checkExtension(report, src, transition.Extension, botConfig, pathOf(n)...)
+
+		if _, err := fsm.NewGuard(transition.Conditions); err != nil {
+			report.errorf(src, src.Line(pathOf(n, "conditions")...), "invalid conditions: %v", err)
+		} else if _, err := fsm.NewDomain([]fsm.Transition{transition}, fsmConfig.Defaults); err != nil {
+			var invalidErr *fsm.ErrInvalidTransition
+			if errors.As(err, &invalidErr) {
+				err = invalidErr.Err
+			}
+			report.errorf(src, line, "invalid transition: %v", err)
+		}
+	}
+}
+
+// classifierNames returns the commands and entities of the classifier,
+// the entities are nil if the classifier configuration is nil
+func classifierNames(classifConfig *clf.Config) (commands, entities map[string]bool) {
+	commands = make(map[string]bool)
+	if classifConfig == nil {
+		return commands, nil
+	}
+
+	for _, class := range classifConfig.Classification {
+		commands[strings.TrimSpace(class.Command)] = true
+	}
+	entities = make(map[string]bool, len(classifConfig.Entities))
+	for _, entity := range classifConfig.Entities {
+		entities[strings.TrimSpace(entity.Name)] = true
+	}
+
+	return commands, entities
+}
+
+// checkSlot checks the mode of a slot and the regex or entity the mode
+// needs, path is the path of the slot in the source
+func checkSlot(report *Report, src *source, slot fsm.Slot, entities map[string]bool, path ...interface{}) {
+	line := func(key ...interface{}) int {
+		return src.Line(append(append([]interface{}{}, path...), key...)...)
+	}
+
+	if strings.TrimSpace(slot.Name) == "" {
+		if slot.Mode != "" || slot.Regex != "" || slot.Entity != "" || len(slot.Reprompt) > 0 {
+			report.warnf(src, line(), "slot has no name, nothing will be saved")
+		}
+		return
+	}
+
+	switch mode := strings.TrimSpace(slot.Mode); mode {
+	case "", fsm.SlotModeWholeText, fsm.SlotModeNumber, fsm.SlotModeDate, fsm.SlotModeEmail, fsm.SlotModePhone, fsm.SlotModeURL:
+	case fsm.SlotModeRegex:
+		regex := strings.TrimSpace(slot.Regex)
+		if regex == "" {
+			report.errorf(src, line(), "slot '%s' has regex mode but no regex", slot.Name)
+		} else if _, err := regexp.Compile(regex); err != nil {
+			report.errorf(src, line("regex"), "slot '%s' has an invalid regex: %v", slot.Name, err)
+		}
+	case fsm.SlotModeEnum:
+		entity := strings.TrimSpace(slot.Entity)
+		switch {
+		case entity == "":
+			report.errorf(src, line(), "slot '%s' has enum mode but no entity", slot.Name)
+		case entities != nil && !entities[entity]:
+			report.errorf(src, line("entity"), "entity '%s' of slot '%s' is not an entity of the classifier", entity, slot.Name)
+		}
+	default:
+		report.errorf(src, line("mode"), "slot '%s' has unknown mode '%s', use whole_text, regex, number, date, email, phone, url or enum", slot.Name, mode)
+	}
+}
+
+// checkExtension checks that the extension server of a transition is
+// configured in the bot, unless the bot configuration couldn't be loaded.
+// path is the path of the transition in the source
+func checkExtension(report *Report, src *source, extension fsm.Extension, botConfig *bot.Config, path ...interface{}) {
+	if extension == (fsm.Extension{}) {
+		return
+	}
+
+	line := src.Line(append(append([]interface{}{}, path...), "extension")...)
+
+	if strings.TrimSpace(extension.Name) == "" {
+		report.errorf(src, line, "extension has no name")
+	}
+
+	if strings.TrimSpace(extension.Server) == "" {
+		report.errorf(src, line, "extension '%s' has no server", extension.Name)
+		return
+	}
+
+	if botConfig == nil {
+		return
+	}
+
+	if _, ok := botConfig.Extensions[extension.Server]; !ok {
+		report.errorf(src, src.Line(append(append([]interface{}{}, path...), "extension", "server")...), "extension server '%s' is not configured in the bot", extension.Server)
+	}
+}
+
+// cmdState is a command and a from state of a transition
+type cmdState struct {
+	cmd   string
+	state string
+}
+
+// checkShadowed reports transitions that are never executed because a
+// transition without conditions always runs before them. Transitions from
+// state 'any' run first, then the ones with command 'any' and then the rest,
+// in the order they were declared
+func checkShadowed(report *Report, srcs *sources, fsmConfig *fsmint.Config) {
+	unguarded := make(map[cmdState]int)
+	for n, transition := range fsmConfig.Transitions {
+		if len(transition.Conditions) > 0 {
+			continue
+		}
+		for _, from := range transition.From {
+			key := cmdState{strings.TrimSpace(transition.Command), strings.TrimSpace(from)}
+			if _, ok := unguarded[key]; !ok {
+				unguarded[key] = n
+			}
+		}
+	}
+
+	for n, transition := range fsmConfig.Transitions {
+		command := strings.TrimSpace(transition.Command)
+
+		for i, from := range transition.From {
+			from = strings.TrimSpace(from)
+
+			self := cmdState{command, from}
+			before := []cmdState{self}
+			if command != "any" && from != "any" {
+				before = []cmdState{{command, "any"}, {"any", from}, self}
+			}
+
+			for _, key := range before {
+				m, ok := unguarded[key]
+				if !ok || m == n || key == self && m > n {
+					continue
+				}
+				src, j := srcs.item("transitions", n)
+				other, k := srcs.item("transitions", m)
+				where := fmt.Sprintf("line %d", other.Line("transitions", k))
+				if other != src {
+					where = fmt.Sprintf("%s:%d", filepath.Base(other.File), other.Line("transitions", k))
+				}
+				report.errorf(src, src.Line("transitions", j, "from", i),
+					"transition %d from '%s' with command '%s' is never executed, transition %d (%s) has no conditions and runs first",
+					j, from, command, k, where)
+				break
+			}
+		}
+	}
+}
+
+// checkReachable reports states that no transition or form leads into,
+// a transition that calls a flow leads into the initial state of the flow.
+// The states of flows that are never called are not reported. Extensions
+// can change the state of the FSM, so if any transition or form calls one
+// it is only a warning
+func checkReachable(report *Report, srcs *sources, fsmConfig *fsmint.Config, flows []flowSource) {
+	transitions := fsmConfig.AllTransitions()
+
+	problem := report.errorf
+	for _, transition := range transitions {
+		if transition.Extension != (fsm.Extension{}) {
+			problem = report.warnf
+			break
+		}
+	}
+
+	reached := map[string]bool{"initial": true}
+	for changed := true; changed; {
+		changed = false
+		for _, transition := range transitions {
+			into := []string{strings.TrimSpace(transition.Into)}
+			if call := strings.TrimSpace(transition.Call); call != "" {
+				into = append(into, fsm.FlowState(call, "initial"))
+			}
+			done := true
+			for _, state := range into {
+				done = done && reached[state]
+			}
+			if done {
+				continue
+			}
+			for _, from := range transition.From {
+				if from = strings.TrimSpace(from); from == "any" || reached[from] {
+					for _, state := range into {
+						reached[state] = true
+					}
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	reported := make(map[string]bool)
+	checkFrom := func(src *source, flow string, states []string, path ...interface{}) {
+		for i, from := range states {
+			from = strings.TrimSpace(from)
+			if from == "" || from == "any" {
+				continue
+			}
+			if flow != "" {
+				from = fsm.FlowState(flow, from)
+			}
+			if reached[from] || reported[from] {
+				continue
+			}
+			reported[from] = true
+			problem(src, src.Line(append(path, "from", i)...), "state '%s' is unreachable, no transition leads into it", from)
+		}
+	}
+	for n, transition := range fsmConfig.Transitions {
+		src, i := srcs.item("transitions", n)
+		checkFrom(src, "", transition.From, "transitions", i)
+	}
+	for n, form := range fsmConfig.Forms {
+		src, i := srcs.item("forms", n)
+		checkFrom(src, "", form.From, "forms", i)
+	}
+	for n, flow := range fsmConfig.Flows {
+		if !reached[fsm.FlowState(flow.Name, "initial")] {
+			continue
+		}
+		for i, transition := range flow.Transitions {
+			checkFrom(flows[n].src, flow.Name, transition.From, append(append([]interface{}{}, flows[n].path...), "transitions", i)...)
+		}
+	}
+}
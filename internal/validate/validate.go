@@ -0,0 +1,191 @@
+// Package validate checks the configuration files of a bot for mistakes
+// that would otherwise only show up at runtime, like transitions with
+// commands the classifier never predicts or unknown extension servers
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jaimeteb/chatto/internal/bot"
+	"github.com/jaimeteb/chatto/internal/channels"
+	"github.com/jaimeteb/chatto/internal/clf"
+	"github.com/jaimeteb/chatto/internal/fsm"
+)
+
+// Severities of a Problem, only errors make a Report fail
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem is a mistake found in a configuration file
+type Problem struct {
+	File     string
+	Line     int
+	Severity string
+	Message  string
+}
+
+// String returns the Problem as "file:line: severity: message"
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, p.Severity, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.File, p.Severity, p.Message)
+}
+
+// Report contains every Problem found in the configuration files
+type Report struct {
+	Problems []Problem
+}
+
+// Errors returns the number of problems with error severity
+func (r *Report) Errors() int {
+	return r.count(SeverityError)
+}
+
+// Warnings returns the number of problems with warning severity
+func (r *Report) Warnings() int {
+	return r.count(SeverityWarning)
+}
+
+// Failed reports whether any error was found
+func (r *Report) Failed() bool {
+	return r.Errors() > 0
+}
+
+func (r *Report) count(severity string) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) errorf(src *source, line int, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{File: src.File, Line: line, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) warnf(src *source, line int, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{File: src.File, Line: line, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// Path loads the bot, channels, FSM and classifier configurations
+// found in path and returns every problem found in them
+func Path(path string) *Report {
+	report := &Report{}
+
+	botSrc := loadSource(path, "bot")
+	chnSrc := loadSource(path, "chn")
+	fsmSrcs := loadSources(path, "fsm")
+	clfSrcs := loadSources(path, "clf")
+
+	botConfig, err := bot.LoadConfig(path, 0)
+	if err != nil {
+		report.errorf(botSrc, 0, "cannot load the bot configuration: %v", err)
+	} else {
+		checkBot(report, botSrc, botConfig)
+	}
+
+	if _, err := channels.LoadConfig(path); err != nil {
+		report.errorf(chnSrc, 0, "cannot load the channels configuration: %v", err)
+	}
+
+	classifConfig, err := clf.LoadConfig(path)
+	if err != nil {
+		report.errorf(clfSrcs.main, 0, "cannot load the classifier configuration: %v", err)
+		classifConfig = nil
+	} else {
+		checkClassification(report, clfSrcs, classifConfig)
+	}
+
+	fsmConfig, err := fsm.LoadConfig(path)
+	if err != nil {
+		report.errorf(fsmSrcs.main, 0, "cannot load the FSM configuration: %v", err)
+		return report
+	}
+
+	flows := flowSources(path, fsmSrcs, fsmConfig)
+
+	checkTransitions(report, fsmSrcs, fsmConfig, botConfig, classifConfig)
+	checkForms(report, fsmSrcs, fsmConfig, botConfig, classifConfig)
+	checkFlows(report, flows, fsmConfig, botConfig, classifConfig)
+	checkShadowed(report, fsmSrcs, fsmConfig)
+	checkReachable(report, fsmSrcs, fsmConfig, flows)
+
+	if classifConfig != nil {
+		checkUnusedCommands(report, clfSrcs, classifConfig, fsmConfig)
+	}
+
+	return report
+}
+
+// checkBot checks the extensions and store of the bot configuration
+func checkBot(report *Report, src *source, botConfig *bot.Config) {
+	for server, ext := range botConfig.Extensions {
+		line := src.Line("extensions", server)
+
+		switch ext.Type {
+		case "REST":
+			if strings.TrimSpace(ext.URL) == "" {
+				report.errorf(src, line, "REST extension server '%s' has no url", server)
+			}
+		case "RPC":
+			if strings.TrimSpace(ext.Host) == "" || ext.Port == 0 {
+				report.errorf(src, line, "RPC extension server '%s' needs a host and a port", server)
+			}
+		default:
+			report.errorf(src, src.Line("extensions", server, "type"), "extension server '%s' has invalid type '%s', use REST or RPC", server, ext.Type)
+		}
+	}
+
+	switch strings.ToLower(botConfig.Store.Type) {
+	case "", "cache", "redis", "sql":
+	default:
+		report.warnf(src, src.Line("store", "type"), "unknown store type '%s', the CACHE store will be used", botConfig.Store.Type)
+	}
+}
+
+// checkClassification checks the classes of the classifier configuration
+func checkClassification(report *Report, srcs *sources, classifConfig *clf.Config) {
+	seen := make(map[string]bool, len(classifConfig.Classification))
+
+	for n, class := range classifConfig.Classification {
+		src, i := srcs.item("classification", n)
+		line := src.Line("classification", i, "command")
+		command := strings.TrimSpace(class.Command)
+
+		switch {
+		case command == "":
+			report.errorf(src, line, "class %d has no command", i)
+		case command == "any":
+			report.errorf(src, line, "command 'any' is reserved for transitions and cannot be a class")
+		case seen[command]:
+			report.errorf(src, line, "command '%s' is defined more than once", command)
+		}
+		seen[command] = true
+
+		if len(class.Texts) == 0 {
+			report.errorf(src, src.Line("classification", i), "command '%s' has no texts", command)
+		}
+	}
+}
+
+// checkUnusedCommands warns about commands that no transition uses
+func checkUnusedCommands(report *Report, srcs *sources, classifConfig *clf.Config, fsmConfig *fsm.Config) {
+	transitions := fsmConfig.AllTransitions()
+	used := make(map[string]bool, len(transitions))
+	for _, transition := range transitions {
+		used[strings.TrimSpace(transition.Command)] = true
+	}
+
+	for n, class := range classifConfig.Classification {
+		if command := strings.TrimSpace(class.Command); command != "" && !used[command] {
+			src, i := srcs.item("classification", n)
+			report.warnf(src, src.Line("classification", i, "command"), "command '%s' is not used by any transition", command)
+		}
+	}
+}
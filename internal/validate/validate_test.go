@@ -0,0 +1,327 @@
+package validate_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jaimeteb/chatto/internal/validate"
+)
+
+const testCLF = `classification:
+  - command: greet
+    texts:
+      - hello
+  - command: bye
+    texts:
+      - bye
+`
+
+func writeConfigs(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	path := t.TempDir()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(path, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+func problems(report *validate.Report) []string {
+	got := make([]string, 0, len(report.Problems))
+	for _, p := range report.Problems {
+		p.File = filepath.Base(p.File)
+		got = append(got, p.String())
+	}
+	return got
+}
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		want       []string
+		wantFailed bool
+	}{
+		{
+			name: "valid",
+			files: map[string]string{
+				"clf.yml": testCLF,
+				"fsm.yml": `transitions:
+  - from: [initial]
+    into: greeted
+    command: greet
+  - from: [greeted]
+    into: initial
+    command: bye
+`,
+			},
+			want: []string{},
+		},
+		{
+			name: "unknown command and extension server",
+			files: map[string]string{
+				"clf.yml": testCLF,
+				"bot.yml": `extensions:
+  ext:
+    type: REST
+    url: http://localhost:8770
+`,
+				"fsm.yml": `transitions:
+  - from: [initial]
+    into: greeted
+    command: greet
+    extension:
+      server: other
+      name: greet
+  - from: [greeted]
+    into: initial
+    command: goodbye
+`,
+			},
+			want: []string{
+				"fsm.yml:6: error: extension server 'other' is not configured in the bot",
+				"fsm.yml:10: error: command 'goodbye' is not a command of the classifier",
+				"clf.yml:5: warning: command 'bye' is not used by any transition",
+			},
+			wantFailed: true,
+		},
+		{
+			name: "invalid slot, conditions and template",
+			files: map[string]string{
+				"clf.yml": testCLF,
+				"fsm.yml": `transitions:
+  - from: [initial]
+    into: greeted
+    command: greet
+    slot:
+      name: name
+      mode: regex
+      regex: "[0-9"
+  - from: [greeted]
+    into: initial
+    command: bye
+    conditions:
+      - slot: name
+        operator: gt
+        value: abc
+  - from: [greeted]
+    into: initial
+    command: greet
+    answers:
+      - text: "{{ .Slots.name"
+`,
+			},
+			want: []string{
+				"fsm.yml:8: error: slot 'name' has an invalid regex: error parsing regexp: missing closing ]: `[0-9`",
+				"fsm.yml:13: error: invalid conditions: condition on slot 'name': value 'abc' is not a number",
+				"fsm.yml:16: error: invalid transition: invalid text template '{{ .Slots.name' (write {{\"{{\"}} for a literal {{): template: answer:1: unclosed action",
+			},
+			wantFailed: true,
+		},
+		{
+			name: "typed slots",
+			files: map[string]string{
+				"clf.yml": testCLF + `entities:
+  - name: size
+    values:
+      - value: small
+`,
+				"fsm.yml": `transitions:
+  - from: [initial]
+    into: greeted
+    command: greet
+    slot:
+      name: size
+      mode: enum
+      entity: size
+  - from: [greeted]
+    into: initial
+    command: bye
+    slot:
+      name: color
+      mode: enum
+      entity: color
+  - from: [greeted]
+    into: initial
+    command: greet
+    slot:
+      name: when
+      mode: time
+`,
+			},
+			want: []string{
+				"fsm.yml:15: error: entity 'color' of slot 'color' is not an entity of the classifier",
+				"fsm.yml:21: error: slot 'when' has unknown mode 'time', use whole_text, regex, number, date, email, phone, url or enum",
+			},
+			wantFailed: true,
+		},
+		{
+			name: "forms",
+			files: map[string]string{
+				"clf.yml": testCLF,
+				"fsm.yml": `transitions:
+  - from: [signed_up]
+    into: initial
+    command: bye
+forms:
+  - name: signup
+    from: [initial]
+    into: signed_up
+    command: greet
+    slots:
+      - name: email
+        mode: email
+        prompt:
+          - text: "Email?"
+  - name: broken
+    from: [initial]
+    into: signed_up
+    command: signup
+    cancel:
+      command: bye
+    slots:
+      - name: when
+        mode: time
+`,
+			},
+			want: []string{
+				"fsm.yml:18: error: command 'signup' is not a command of the classifier",
+				"fsm.yml:23: error: slot 'when' has unknown mode 'time', use whole_text, regex, number, date, email, phone, url or enum",
+				"fsm.yml:15: error: invalid form: slot 'when' has no prompt",
+			},
+			wantFailed: true,
+		},
+		{
+			name: "flows",
+			files: map[string]string{
+				"clf.yml": testCLF,
+				"fsm.yml": `transitions:
+  - from: [initial]
+    into: confirm
+    command: greet
+    call: address
+  - from: [confirm]
+    into: initial
+    command: bye
+    call: missing
+  - from: [confirm]
+    into: return
+    command: greet
+flows:
+  - name: unused
+    transitions:
+      - from: [initial]
+        into: return
+        command: greet
+`,
+				"flows/address.yml": `transitions:
+  - from: [initial]
+    into: street
+    command: any
+  - from: [nowhere]
+    into: return
+    command: hello
+`,
+			},
+			want: []string{
+				"fsm.yml:9: error: flow 'missing' is not declared",
+				"fsm.yml:10: error: transition 2 uses the state 'return', which is reserved for the flows",
+				"fsm.yml:14: warning: flow 'unused' is not called by any transition",
+				"address.yml:7: error: command 'hello' is not a command of the classifier",
+				"address.yml:5: error: state 'address.nowhere' is unreachable, no transition leads into it",
+			},
+			wantFailed: true,
+		},
+		{
+			name: "directories",
+			files: map[string]string{
+				"clf.yml": testCLF,
+				"clf.d/thanks.yml": `classification:
+  - command: thanks
+`,
+				"fsm.yml": `transitions:
+  - from: [any]
+    into: initial
+    command: bye
+`,
+				"fsm.d/greet.yml": `transitions:
+  - from: [initial]
+    into: greeted
+    command: greet
+  - from: [greeted]
+    into: initial
+    command: bye
+  - from: [greeted]
+    into: initial
+    command: hello
+`,
+			},
+			want: []string{
+				"thanks.yml:2: error: command 'thanks' has no texts",
+				"greet.yml:10: error: command 'hello' is not a command of the classifier",
+				"greet.yml:5: error: transition 1 from 'greeted' with command 'bye' is never executed, transition 0 (fsm.yml:2) has no conditions and runs first",
+				"thanks.yml:2: warning: command 'thanks' is not used by any transition",
+			},
+			wantFailed: true,
+		},
+		{
+			name: "shadowed and unreachable",
+			files: map[string]string{
+				"clf.yml": testCLF,
+				"fsm.yml": `transitions:
+  - from: [initial]
+    into: greeted
+    command: greet
+  - from: [initial]
+    into: lost
+    command: greet
+  - from: [any]
+    into: initial
+    command: bye
+  - from: [greeted]
+    into: initial
+    command: bye
+  - from: [nowhere]
+    into: initial
+    command: greet
+`,
+			},
+			want: []string{
+				"fsm.yml:5: error: transition 1 from 'initial' with command 'greet' is never executed, transition 0 (line 2) has no conditions and runs first",
+				"fsm.yml:11: error: transition 3 from 'greeted' with command 'bye' is never executed, transition 2 (line 8) has no conditions and runs first",
+				"fsm.yml:14: error: state 'nowhere' is unreachable, no transition leads into it",
+			},
+			wantFailed: true,
+		},
+		{
+			name: "missing fsm",
+			files: map[string]string{
+				"clf.yml": testCLF,
+			},
+			wantFailed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := validate.Path(writeConfigs(t, tt.files))
+
+			if got := report.Failed(); got != tt.wantFailed {
+				t.Errorf("Report.Failed() = %v, want %v: %v", got, tt.wantFailed, problems(report))
+			}
+			if tt.want == nil {
+				return
+			}
+			if got := problems(report); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Path() problems = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}